@@ -11,6 +11,8 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // Where the node starts in the source
+	End() token.Position // Just past where the node ends in the source
 }
 
 // All statement nodes implement this
@@ -37,11 +39,38 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 && p.Statements[0] != nil {
+		return p.Statements[0].Pos()
+	} else {
+		return token.Position{}
+	}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 && p.Statements[len(p.Statements)-1] != nil {
+		return p.Statements[len(p.Statements)-1].End()
+	} else {
+		return token.Position{}
+	}
+}
+
+// Source that parses back to the same program
 func (p *Program) String() string {
+	return statementsString(p.Statements)
+}
+
+// Expression statements are terminated so the next statement can't continue
+// them, like a parenthesized expression becoming a call
+func statementsString(statements []Statement) string {
 	var out bytes.Buffer
 
-	for _, s := range p.Statements {
+	for i, s := range statements {
 		out.WriteString(s.String())
+
+		if _, ok := s.(*ExpressionStatement); ok && i < len(statements)-1 {
+			out.WriteString(";")
+		}
 	}
 
 	return out.String()
@@ -56,6 +85,13 @@ type LetStatement struct {
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Position }
+func (ls *LetStatement) End() token.Position {
+	if ls.Value != nil {
+		return ls.Value.End()
+	}
+	return ls.Token.End()
+}
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -79,6 +115,13 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Position }
+func (rs *ReturnStatement) End() token.Position {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.End()
+}
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -100,6 +143,13 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Position }
+func (es *ExpressionStatement) End() token.Position {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.End()
+}
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -110,18 +160,17 @@ func (es *ExpressionStatement) String() string {
 type BlockStatement struct {
 	Token      token.Token // the { token
 	Statements []Statement
+	EndToken   token.Token // the } token
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
-func (bs *BlockStatement) String() string {
-	var out bytes.Buffer
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Position }
+func (bs *BlockStatement) End() token.Position  { return bs.EndToken.End() }
 
-	for _, s := range bs.Statements {
-		out.WriteString(s.String())
-	}
-
-	return out.String()
+// Without the braces
+func (bs *BlockStatement) String() string {
+	return statementsString(bs.Statements)
 }
 
 // Expressions
@@ -132,6 +181,8 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Position }
+func (i *Identifier) End() token.Position  { return i.Token.End() }
 func (i *Identifier) String() string       { return i.Value }
 
 type Boolean struct {
@@ -141,6 +192,8 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Position }
+func (b *Boolean) End() token.Position  { return b.Token.End() }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 type IntegerLiteral struct {
@@ -150,6 +203,8 @@ type IntegerLiteral struct {
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Position }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End() }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
 type PrefixExpression struct {
@@ -160,6 +215,13 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Position }
+func (pe *PrefixExpression) End() token.Position {
+	if pe.Right != nil {
+		return pe.Right.End()
+	}
+	return pe.Token.End()
+}
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -180,6 +242,18 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Position
+}
+func (ie *InfixExpression) End() token.Position {
+	if ie.Right != nil {
+		return ie.Right.End()
+	}
+	return ie.Token.End()
+}
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -201,17 +275,29 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Position }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	if ie.Consequence != nil {
+		return ie.Consequence.End()
+	}
+	return ie.Token.End()
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
-	out.WriteString("if")
+	out.WriteString("if (")
 	out.WriteString(ie.Condition.String())
-	out.WriteString(" ")
+	out.WriteString(") { ")
 	out.WriteString(ie.Consequence.String())
+	out.WriteString(" }")
 
 	if ie.Alternative != nil {
-		out.WriteString("else ")
+		out.WriteString(" else { ")
 		out.WriteString(ie.Alternative.String())
+		out.WriteString(" }")
 	}
 
 	return out.String()
@@ -226,6 +312,13 @@ type FunctionLiteral struct {
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Position }
+func (fl *FunctionLiteral) End() token.Position {
+	if fl.Body != nil {
+		return fl.Body.End()
+	}
+	return fl.Token.End()
+}
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -234,14 +327,13 @@ func (fl *FunctionLiteral) String() string {
 		params = append(params, p.String())
 	}
 
+	// The name comes from the let binding the function, so isn't written
 	out.WriteString(fl.TokenLiteral())
-	if fl.Name != nil {
-		out.WriteString(fmt.Sprintf("<%s>", *fl.Name))
-	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") ")
+	out.WriteString(") { ")
 	out.WriteString(fl.Body.String())
+	out.WriteString(" }")
 
 	return out.String()
 }
@@ -250,10 +342,18 @@ type CallExpression struct {
 	Token     token.Token // The '(' token
 	Function  Expression  // Identifier or FunctionLiteral
 	Arguments []Expression
+	EndToken  token.Token // The ')' token
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position {
+	if ce.Function != nil {
+		return ce.Function.Pos()
+	}
+	return ce.Token.Position
+}
+func (ce *CallExpression) End() token.Position { return ce.EndToken.End() }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -277,15 +377,20 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
-func (sl *StringLiteral) String() string       { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Position }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End() }
+func (sl *StringLiteral) String() string       { return `"` + sl.Value + `"` }
 
 type ArrayLiteral struct {
 	Token    token.Token // the '[' token
 	Elements []Expression
+	EndToken token.Token // the ']' token
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Position }
+func (al *ArrayLiteral) End() token.Position  { return al.EndToken.End() }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -302,13 +407,21 @@ func (al *ArrayLiteral) String() string {
 }
 
 type IndexExpression struct {
-	Token token.Token // The [ token
-	Left  Expression
-	Index Expression
+	Token    token.Token // The [ token
+	Left     Expression
+	Index    Expression
+	EndToken token.Token // The ] token
 }
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Position
+}
+func (ie *IndexExpression) End() token.Position { return ie.EndToken.End() }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -322,18 +435,26 @@ func (ie *IndexExpression) String() string {
 }
 
 type HashLiteral struct {
-	Token token.Token // the '{' token
-	Pairs map[Expression]Expression
+	Token    token.Token // the '{' token
+	Pairs    []HashPair  // As written, which is the order of the hash's pairs
+	EndToken token.Token // the '}' token
+}
+
+type HashPair struct {
+	Key   Expression
+	Value Expression
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Position }
+func (hl *HashLiteral) End() token.Position  { return hl.EndToken.End() }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
 	}
 
 	out.WriteString("{")
@@ -342,3 +463,97 @@ func (hl *HashLiteral) String() string {
 
 	return out.String()
 }
+
+type MacroLiteral struct {
+	Token      token.Token // The 'macro' token
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (ml *MacroLiteral) expressionNode()      {}
+func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MacroLiteral) Pos() token.Position  { return ml.Token.Position }
+func (ml *MacroLiteral) End() token.Position {
+	if ml.Body != nil {
+		return ml.Body.End()
+	}
+	return ml.Token.End()
+}
+func (ml *MacroLiteral) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range ml.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString(ml.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") { ")
+	out.WriteString(ml.Body.String())
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+type ImportStatement struct {
+	Token token.Token // The 'import' token
+	Path  *StringLiteral
+	Name  *Identifier // What the module is called in the importing file
+}
+
+func (is *ImportStatement) statementNode()       {}
+func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *ImportStatement) Pos() token.Position  { return is.Token.Position }
+func (is *ImportStatement) End() token.Position {
+	if is.Name != nil {
+		return is.Name.End()
+	}
+	return is.Token.End()
+}
+func (is *ImportStatement) String() string {
+	return fmt.Sprintf("import %s as %s;", is.Path.String(), is.Name.String())
+}
+
+type ExportStatement struct {
+	Token     token.Token // The 'export' token
+	Statement *LetStatement
+}
+
+func (es *ExportStatement) statementNode()       {}
+func (es *ExportStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExportStatement) Pos() token.Position  { return es.Token.Position }
+func (es *ExportStatement) End() token.Position {
+	if es.Statement != nil {
+		return es.Statement.End()
+	}
+	return es.Token.End()
+}
+func (es *ExportStatement) String() string {
+	return es.TokenLiteral() + " " + es.Statement.String()
+}
+
+type MemberExpression struct {
+	Token  token.Token // The '.' token
+	Module Expression
+	Member *Identifier
+}
+
+func (me *MemberExpression) expressionNode()      {}
+func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MemberExpression) Pos() token.Position {
+	if me.Module != nil {
+		return me.Module.Pos()
+	}
+	return me.Token.Position
+}
+func (me *MemberExpression) End() token.Position {
+	if me.Member != nil {
+		return me.Member.End()
+	}
+	return me.Token.End()
+}
+func (me *MemberExpression) String() string {
+	return "(" + me.Module.String() + "." + me.Member.String() + ")"
+}
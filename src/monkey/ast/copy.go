@@ -0,0 +1,192 @@
+package ast
+
+// Returns a deep copy of node, tokens and all, so the copy can be modified
+// without touching the original.
+func Copy(node Node) Node {
+	if isNil(node) {
+		return node
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		return &Program{Statements: copyStatements(node.Statements)}
+
+	case *LetStatement:
+		copied := *node
+		copied.Name = copyIdentifier(node.Name)
+		copied.Value = copyExpression(node.Value)
+		return &copied
+
+	case *ReturnStatement:
+		copied := *node
+		copied.ReturnValue = copyExpression(node.ReturnValue)
+		return &copied
+
+	case *ExpressionStatement:
+		copied := *node
+		copied.Expression = copyExpression(node.Expression)
+		return &copied
+
+	case *BlockStatement:
+		copied := *node
+		copied.Statements = copyStatements(node.Statements)
+		return &copied
+
+	case *Identifier:
+		copied := *node
+		return &copied
+
+	case *Boolean:
+		copied := *node
+		return &copied
+
+	case *IntegerLiteral:
+		copied := *node
+		return &copied
+
+	case *StringLiteral:
+		copied := *node
+		return &copied
+
+	case *PrefixExpression:
+		copied := *node
+		copied.Right = copyExpression(node.Right)
+		return &copied
+
+	case *InfixExpression:
+		copied := *node
+		copied.Left = copyExpression(node.Left)
+		copied.Right = copyExpression(node.Right)
+		return &copied
+
+	case *IfExpression:
+		copied := *node
+		copied.Condition = copyExpression(node.Condition)
+		copied.Consequence = copyBlock(node.Consequence)
+		copied.Alternative = copyBlock(node.Alternative)
+		return &copied
+
+	case *FunctionLiteral:
+		copied := *node
+		copied.Parameters = copyIdentifiers(node.Parameters)
+		copied.Body = copyBlock(node.Body)
+		if node.Name != nil {
+			name := *node.Name
+			copied.Name = &name
+		}
+		return &copied
+
+	case *MacroLiteral:
+		copied := *node
+		copied.Parameters = copyIdentifiers(node.Parameters)
+		copied.Body = copyBlock(node.Body)
+		return &copied
+
+	case *CallExpression:
+		copied := *node
+		copied.Function = copyExpression(node.Function)
+		copied.Arguments = copyExpressions(node.Arguments)
+		return &copied
+
+	case *ArrayLiteral:
+		copied := *node
+		copied.Elements = copyExpressions(node.Elements)
+		return &copied
+
+	case *IndexExpression:
+		copied := *node
+		copied.Left = copyExpression(node.Left)
+		copied.Index = copyExpression(node.Index)
+		return &copied
+
+	case *HashLiteral:
+		copied := *node
+		copied.Pairs = make([]HashPair, len(node.Pairs))
+		for i, pair := range node.Pairs {
+			copied.Pairs[i] = HashPair{Key: copyExpression(pair.Key), Value: copyExpression(pair.Value)}
+		}
+		return &copied
+
+	case *ImportStatement:
+		copied := *node
+		if node.Path != nil {
+			copied.Path = Copy(node.Path).(*StringLiteral)
+		}
+		copied.Name = copyIdentifier(node.Name)
+		return &copied
+
+	case *ExportStatement:
+		copied := *node
+		if node.Statement != nil {
+			copied.Statement = Copy(node.Statement).(*LetStatement)
+		}
+		return &copied
+
+	case *MemberExpression:
+		copied := *node
+		copied.Module = copyExpression(node.Module)
+		copied.Member = copyIdentifier(node.Member)
+		return &copied
+	}
+
+	return node
+}
+
+func copyStatements(statements []Statement) []Statement {
+	if statements == nil {
+		return nil
+	}
+
+	copied := make([]Statement, len(statements))
+	for i, statement := range statements {
+		if !isNil(statement) {
+			copied[i] = Copy(statement).(Statement)
+		}
+	}
+	return copied
+}
+
+func copyExpressions(expressions []Expression) []Expression {
+	if expressions == nil {
+		return nil
+	}
+
+	copied := make([]Expression, len(expressions))
+	for i, expression := range expressions {
+		copied[i] = copyExpression(expression)
+	}
+	return copied
+}
+
+func copyIdentifiers(identifiers []*Identifier) []*Identifier {
+	if identifiers == nil {
+		return nil
+	}
+
+	copied := make([]*Identifier, len(identifiers))
+	for i, identifier := range identifiers {
+		copied[i] = copyIdentifier(identifier)
+	}
+	return copied
+}
+
+func copyExpression(expression Expression) Expression {
+	if isNil(expression) {
+		return nil
+	}
+	return Copy(expression).(Expression)
+}
+
+func copyBlock(block *BlockStatement) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	return Copy(block).(*BlockStatement)
+}
+
+func copyIdentifier(identifier *Identifier) *Identifier {
+	if identifier == nil {
+		return nil
+	}
+	return Copy(identifier).(*Identifier)
+}
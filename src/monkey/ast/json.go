@@ -0,0 +1,986 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"monkey/token"
+	"slices"
+	"strconv"
+)
+
+// A JSON encoding of the AST for tools outside of Go.
+//
+// Every node is an object with a "kind", the name of its Go type, and a "span" from
+// Pos to End, left out when the node has no position. The rest mirrors the node's
+// fields in camelCase, with missing children as null. Hash pairs are in source order.
+//
+// Decoded tokens get their positions from the spans. The positions of infix
+// operators, of the opening bracket of calls and indexing and of the dot of
+// member expressions aren't kept. Children the parser always fills in, like the
+// operands of an infix expression, can't be null when decoding.
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type jsonSpan struct {
+	Start jsonPosition `json:"start"`
+	End   jsonPosition `json:"end"`
+}
+
+type jsonHeader struct {
+	Kind string    `json:"kind"`
+	Span *jsonSpan `json:"span,omitempty"`
+}
+
+type jsonProgram struct {
+	jsonHeader
+	Statements []json.RawMessage `json:"statements"`
+}
+
+type jsonLetStatement struct {
+	jsonHeader
+	Name  json.RawMessage `json:"name"`
+	Value json.RawMessage `json:"value"`
+}
+
+type jsonReturnStatement struct {
+	jsonHeader
+	ReturnValue json.RawMessage `json:"returnValue"`
+}
+
+type jsonExpressionStatement struct {
+	jsonHeader
+	Expression json.RawMessage `json:"expression"`
+}
+
+type jsonBlockStatement struct {
+	jsonHeader
+	Statements []json.RawMessage `json:"statements"`
+}
+
+type jsonIdentifier struct {
+	jsonHeader
+	Value string `json:"value"`
+}
+
+type jsonBoolean struct {
+	jsonHeader
+	Value bool `json:"value"`
+}
+
+type jsonIntegerLiteral struct {
+	jsonHeader
+	Value int64 `json:"value"`
+}
+
+type jsonStringLiteral struct {
+	jsonHeader
+	Value string `json:"value"`
+}
+
+type jsonPrefixExpression struct {
+	jsonHeader
+	Operator string          `json:"operator"`
+	Right    json.RawMessage `json:"right"`
+}
+
+type jsonInfixExpression struct {
+	jsonHeader
+	Left     json.RawMessage `json:"left"`
+	Operator string          `json:"operator"`
+	Right    json.RawMessage `json:"right"`
+}
+
+type jsonIfExpression struct {
+	jsonHeader
+	Condition   json.RawMessage `json:"condition"`
+	Consequence json.RawMessage `json:"consequence"`
+	Alternative json.RawMessage `json:"alternative"`
+}
+
+type jsonFunctionLiteral struct {
+	jsonHeader
+	Name       *string           `json:"name"`
+	Parameters []json.RawMessage `json:"parameters"`
+	Body       json.RawMessage   `json:"body"`
+}
+
+type jsonMacroLiteral struct {
+	jsonHeader
+	Parameters []json.RawMessage `json:"parameters"`
+	Body       json.RawMessage   `json:"body"`
+}
+
+type jsonCallExpression struct {
+	jsonHeader
+	Function  json.RawMessage   `json:"function"`
+	Arguments []json.RawMessage `json:"arguments"`
+}
+
+type jsonArrayLiteral struct {
+	jsonHeader
+	Elements []json.RawMessage `json:"elements"`
+}
+
+type jsonIndexExpression struct {
+	jsonHeader
+	Left  json.RawMessage `json:"left"`
+	Index json.RawMessage `json:"index"`
+}
+
+type jsonImportStatement struct {
+	jsonHeader
+	Path json.RawMessage `json:"path"`
+	Name json.RawMessage `json:"name"`
+}
+
+type jsonExportStatement struct {
+	jsonHeader
+	Statement json.RawMessage `json:"statement"`
+}
+
+type jsonMemberExpression struct {
+	jsonHeader
+	Module json.RawMessage `json:"module"`
+	Member json.RawMessage `json:"member"`
+}
+
+type jsonHashLiteral struct {
+	jsonHeader
+	Pairs []jsonPair `json:"pairs"`
+}
+
+type jsonPair struct {
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+var jsonNull = json.RawMessage("null")
+
+// Encodes node and everything below it as JSON
+func Encode(node Node) ([]byte, error) {
+	return json.Marshal(encode(node))
+}
+
+func encode(node Node) any {
+	if isNil(node) {
+		return nil
+	}
+
+	header := jsonHeader{Kind: kindOf(node)}
+	if node.Pos().IsValid() {
+		header.Span = &jsonSpan{Start: encodePosition(node.Pos()), End: encodePosition(node.End())}
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		return jsonProgram{header, encodeStatements(node.Statements)}
+
+	case *LetStatement:
+		return jsonLetStatement{header, encodeChild(node.Name), encodeChild(node.Value)}
+
+	case *ReturnStatement:
+		return jsonReturnStatement{header, encodeChild(node.ReturnValue)}
+
+	case *ExpressionStatement:
+		return jsonExpressionStatement{header, encodeChild(node.Expression)}
+
+	case *BlockStatement:
+		return jsonBlockStatement{header, encodeStatements(node.Statements)}
+
+	case *Identifier:
+		return jsonIdentifier{header, node.Value}
+
+	case *Boolean:
+		return jsonBoolean{header, node.Value}
+
+	case *IntegerLiteral:
+		return jsonIntegerLiteral{header, node.Value}
+
+	case *StringLiteral:
+		return jsonStringLiteral{header, node.Value}
+
+	case *PrefixExpression:
+		return jsonPrefixExpression{header, node.Operator, encodeChild(node.Right)}
+
+	case *InfixExpression:
+		return jsonInfixExpression{header, encodeChild(node.Left), node.Operator, encodeChild(node.Right)}
+
+	case *IfExpression:
+		return jsonIfExpression{header, encodeChild(node.Condition), encodeChild(node.Consequence), encodeChild(node.Alternative)}
+
+	case *FunctionLiteral:
+		parameters := []json.RawMessage{}
+		for _, parameter := range node.Parameters {
+			parameters = append(parameters, encodeChild(parameter))
+		}
+
+		return jsonFunctionLiteral{header, node.Name, parameters, encodeChild(node.Body)}
+
+	case *MacroLiteral:
+		parameters := []json.RawMessage{}
+		for _, parameter := range node.Parameters {
+			parameters = append(parameters, encodeChild(parameter))
+		}
+
+		return jsonMacroLiteral{header, parameters, encodeChild(node.Body)}
+
+	case *CallExpression:
+		return jsonCallExpression{header, encodeChild(node.Function), encodeExpressions(node.Arguments)}
+
+	case *ArrayLiteral:
+		return jsonArrayLiteral{header, encodeExpressions(node.Elements)}
+
+	case *IndexExpression:
+		return jsonIndexExpression{header, encodeChild(node.Left), encodeChild(node.Index)}
+
+	case *ImportStatement:
+		return jsonImportStatement{header, encodeChild(node.Path), encodeChild(node.Name)}
+
+	case *ExportStatement:
+		return jsonExportStatement{header, encodeChild(node.Statement)}
+
+	case *MemberExpression:
+		return jsonMemberExpression{header, encodeChild(node.Module), encodeChild(node.Member)}
+
+	case *HashLiteral:
+		pairs := []jsonPair{}
+		for _, pair := range node.Pairs {
+			pairs = append(pairs, jsonPair{encodeChild(pair.Key), encodeChild(pair.Value)})
+		}
+
+		return jsonHashLiteral{header, pairs}
+	}
+
+	panic(fmt.Sprintf("can't encode %T", node))
+}
+
+func encodeChild(node Node) json.RawMessage {
+	if isNil(node) {
+		return jsonNull
+	}
+
+	// Nothing below can fail to marshal
+	result, _ := json.Marshal(encode(node))
+	return result
+}
+
+func encodeStatements(statements []Statement) []json.RawMessage {
+	result := []json.RawMessage{}
+	for _, statement := range statements {
+		result = append(result, encodeChild(statement))
+	}
+
+	return result
+}
+
+func encodeExpressions(expressions []Expression) []json.RawMessage {
+	result := []json.RawMessage{}
+	for _, expression := range expressions {
+		result = append(result, encodeChild(expression))
+	}
+
+	return result
+}
+
+func encodePosition(position token.Position) jsonPosition {
+	return jsonPosition{Line: position.Line, Column: position.Column}
+}
+
+// Partial parses leave nil pointers in interfaces
+func isNil(node Node) bool {
+	switch node := node.(type) {
+	case nil:
+		return true
+	case *Identifier:
+		return node == nil
+	case *BlockStatement:
+		return node == nil
+	case *LetStatement:
+		return node == nil
+	case *StringLiteral:
+		return node == nil
+	default:
+		return false
+	}
+}
+
+func kindOf(node Node) string {
+	return fmt.Sprintf("%T", node)[len("*ast."):]
+}
+
+// Decodes what Encode produced
+func Decode(data []byte) (Node, error) {
+	return decode(data)
+}
+
+func decode(data json.RawMessage) (Node, error) {
+	var header jsonHeader
+	err := json.Unmarshal(data, &header)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Kind == "" {
+		return nil, fmt.Errorf("node without a kind: %s", data)
+	}
+
+	start, end := token.Position{}, token.Position{}
+	if header.Span != nil {
+		start = decodePosition(header.Span.Start)
+		end = decodePosition(header.Span.End)
+	}
+
+	// The closing token of nodes that have one
+	endToken := func(tokenType token.TokenType) token.Token {
+		result := token.Token{Type: tokenType, Literal: string(tokenType)}
+		if end.IsValid() {
+			result.Position = token.Position{Line: end.Line, Column: end.Column - 1}
+		}
+
+		return result
+	}
+
+	// Children a node can't do without, the parser never leaves them out
+	missing := func(child string) error {
+		if start.IsValid() {
+			return fmt.Errorf("%s: %s without %s", start, header.Kind, child)
+		}
+
+		return fmt.Errorf("%s without %s", header.Kind, child)
+	}
+
+	switch header.Kind {
+	case "Program":
+		var node jsonProgram
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		statements, err := decodeStatements(node.Statements)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Program{Statements: statements}, nil
+
+	case "LetStatement":
+		var node jsonLetStatement
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		name, err := decodeIdentifier(node.Name)
+		if err != nil {
+			return nil, err
+		}
+		if name == nil {
+			return nil, missing("a name")
+		}
+
+		value, err := decodeExpression(node.Value)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, missing("a value")
+		}
+
+		return &LetStatement{
+			Token: token.Token{Type: token.LET, Literal: "let", Position: start},
+			Name:  name,
+			Value: value,
+		}, nil
+
+	case "ReturnStatement":
+		var node jsonReturnStatement
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		value, err := decodeExpression(node.ReturnValue)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, missing("a return value")
+		}
+
+		return &ReturnStatement{
+			Token:       token.Token{Type: token.RETURN, Literal: "return", Position: start},
+			ReturnValue: value,
+		}, nil
+
+	case "ExpressionStatement":
+		var node jsonExpressionStatement
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		expression, err := decodeExpression(node.Expression)
+		if err != nil {
+			return nil, err
+		}
+		if expression == nil {
+			return nil, missing("an expression")
+		}
+
+		statement := &ExpressionStatement{Expression: expression, Token: firstToken(expression)}
+		statement.Token.Position = start
+
+		return statement, nil
+
+	case "BlockStatement":
+		var node jsonBlockStatement
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		statements, err := decodeStatements(node.Statements)
+		if err != nil {
+			return nil, err
+		}
+
+		return &BlockStatement{
+			Token:      token.Token{Type: token.LBRACE, Literal: "{", Position: start},
+			Statements: statements,
+			EndToken:   endToken(token.RBRACE),
+		}, nil
+
+	case "Identifier":
+		var node jsonIdentifier
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		return &Identifier{
+			Token: token.Token{Type: token.IDENT, Literal: node.Value, Position: start},
+			Value: node.Value,
+		}, nil
+
+	case "Boolean":
+		var node jsonBoolean
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		literal := strconv.FormatBool(node.Value)
+
+		return &Boolean{
+			Token: token.Token{Type: token.LookupIdent(literal), Literal: literal, Position: start},
+			Value: node.Value,
+		}, nil
+
+	case "IntegerLiteral":
+		var node jsonIntegerLiteral
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		return &IntegerLiteral{
+			Token: token.Token{Type: token.INT, Literal: strconv.FormatInt(node.Value, 10), Position: start},
+			Value: node.Value,
+		}, nil
+
+	case "StringLiteral":
+		var node jsonStringLiteral
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		return &StringLiteral{
+			Token: token.Token{Type: token.STRING, Literal: node.Value, Position: start},
+			Value: node.Value,
+		}, nil
+
+	case "PrefixExpression":
+		var node jsonPrefixExpression
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		right, err := decodeExpression(node.Right)
+		if err != nil {
+			return nil, err
+		}
+		if right == nil {
+			return nil, missing("an operand")
+		}
+
+		return &PrefixExpression{
+			Token:    token.Token{Type: token.TokenType(node.Operator), Literal: node.Operator, Position: start},
+			Operator: node.Operator,
+			Right:    right,
+		}, nil
+
+	case "InfixExpression":
+		var node jsonInfixExpression
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		left, err := decodeExpression(node.Left)
+		if err != nil {
+			return nil, err
+		}
+		if left == nil {
+			return nil, missing("a left operand")
+		}
+
+		right, err := decodeExpression(node.Right)
+		if err != nil {
+			return nil, err
+		}
+		if right == nil {
+			return nil, missing("a right operand")
+		}
+
+		return &InfixExpression{
+			Token:    token.Token{Type: token.TokenType(node.Operator), Literal: node.Operator},
+			Left:     left,
+			Operator: node.Operator,
+			Right:    right,
+		}, nil
+
+	case "IfExpression":
+		var node jsonIfExpression
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		condition, err := decodeExpression(node.Condition)
+		if err != nil {
+			return nil, err
+		}
+		if condition == nil {
+			return nil, missing("a condition")
+		}
+
+		consequence, err := decodeBlock(node.Consequence)
+		if err != nil {
+			return nil, err
+		}
+		if consequence == nil {
+			return nil, missing("a consequence")
+		}
+
+		alternative, err := decodeBlock(node.Alternative)
+		if err != nil {
+			return nil, err
+		}
+
+		return &IfExpression{
+			Token:       token.Token{Type: token.IF, Literal: "if", Position: start},
+			Condition:   condition,
+			Consequence: consequence,
+			Alternative: alternative,
+		}, nil
+
+	case "FunctionLiteral":
+		var node jsonFunctionLiteral
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		parameters := []*Identifier{}
+		for _, data := range node.Parameters {
+			parameter, err := decodeIdentifier(data)
+			if err != nil {
+				return nil, err
+			}
+			if parameter == nil {
+				return nil, missing("a parameter's name")
+			}
+
+			parameters = append(parameters, parameter)
+		}
+
+		body, err := decodeBlock(node.Body)
+		if err != nil {
+			return nil, err
+		}
+		if body == nil {
+			return nil, missing("a body")
+		}
+
+		return &FunctionLiteral{
+			Token:      token.Token{Type: token.FUNCTION, Literal: "fn", Position: start},
+			Parameters: parameters,
+			Body:       body,
+			Name:       node.Name,
+		}, nil
+
+	case "MacroLiteral":
+		var node jsonMacroLiteral
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		parameters := []*Identifier{}
+		for _, data := range node.Parameters {
+			parameter, err := decodeIdentifier(data)
+			if err != nil {
+				return nil, err
+			}
+			if parameter == nil {
+				return nil, missing("a parameter's name")
+			}
+
+			parameters = append(parameters, parameter)
+		}
+
+		body, err := decodeBlock(node.Body)
+		if err != nil {
+			return nil, err
+		}
+		if body == nil {
+			return nil, missing("a body")
+		}
+
+		return &MacroLiteral{
+			Token:      token.Token{Type: token.MACRO, Literal: "macro", Position: start},
+			Parameters: parameters,
+			Body:       body,
+		}, nil
+
+	case "CallExpression":
+		var node jsonCallExpression
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		function, err := decodeExpression(node.Function)
+		if err != nil {
+			return nil, err
+		}
+		if function == nil {
+			return nil, missing("a function")
+		}
+
+		arguments, err := decodeExpressions(node.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(arguments, nil) {
+			return nil, missing("an argument")
+		}
+
+		return &CallExpression{
+			Token:     token.Token{Type: token.LPAREN, Literal: "("},
+			Function:  function,
+			Arguments: arguments,
+			EndToken:  endToken(token.RPAREN),
+		}, nil
+
+	case "ArrayLiteral":
+		var node jsonArrayLiteral
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		elements, err := decodeExpressions(node.Elements)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(elements, nil) {
+			return nil, missing("an element")
+		}
+
+		return &ArrayLiteral{
+			Token:    token.Token{Type: token.LBRACKET, Literal: "[", Position: start},
+			Elements: elements,
+			EndToken: endToken(token.RBRACKET),
+		}, nil
+
+	case "IndexExpression":
+		var node jsonIndexExpression
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		left, err := decodeExpression(node.Left)
+		if err != nil {
+			return nil, err
+		}
+		if left == nil {
+			return nil, missing("a left operand")
+		}
+
+		index, err := decodeExpression(node.Index)
+		if err != nil {
+			return nil, err
+		}
+		if index == nil {
+			return nil, missing("an index")
+		}
+
+		return &IndexExpression{
+			Token:    token.Token{Type: token.LBRACKET, Literal: "["},
+			Left:     left,
+			Index:    index,
+			EndToken: endToken(token.RBRACKET),
+		}, nil
+
+	case "ImportStatement":
+		var node jsonImportStatement
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		path, err := decodeExpression(node.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		if path == nil {
+			return nil, missing("a path")
+		}
+
+		literal, ok := path.(*StringLiteral)
+		if !ok {
+			return nil, fmt.Errorf("expected a string literal, got %s", kindOf(path))
+		}
+
+		name, err := decodeIdentifier(node.Name)
+		if err != nil {
+			return nil, err
+		}
+		if name == nil {
+			return nil, missing("a name")
+		}
+
+		return &ImportStatement{
+			Token: token.Token{Type: token.IMPORT, Literal: "import", Position: start},
+			Path:  literal,
+			Name:  name,
+		}, nil
+
+	case "ExportStatement":
+		var node jsonExportStatement
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		if isNull(node.Statement) {
+			return nil, missing("a statement")
+		}
+
+		decoded, err := decode(node.Statement)
+		if err != nil {
+			return nil, err
+		}
+
+		statement, ok := decoded.(*LetStatement)
+		if !ok {
+			return nil, fmt.Errorf("expected a let statement, got %s", kindOf(decoded))
+		}
+
+		return &ExportStatement{
+			Token:     token.Token{Type: token.EXPORT, Literal: "export", Position: start},
+			Statement: statement,
+		}, nil
+
+	case "MemberExpression":
+		var node jsonMemberExpression
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		module, err := decodeExpression(node.Module)
+		if err != nil {
+			return nil, err
+		}
+		if module == nil {
+			return nil, missing("a module")
+		}
+
+		member, err := decodeIdentifier(node.Member)
+		if err != nil {
+			return nil, err
+		}
+		if member == nil {
+			return nil, missing("a member")
+		}
+
+		return &MemberExpression{
+			Token:  token.Token{Type: token.DOT, Literal: "."},
+			Module: module,
+			Member: member,
+		}, nil
+
+	case "HashLiteral":
+		var node jsonHashLiteral
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+
+		pairs := []HashPair{}
+		for _, pair := range node.Pairs {
+			key, err := decodeExpression(pair.Key)
+			if err != nil {
+				return nil, err
+			}
+			if key == nil {
+				return nil, missing("a pair's key")
+			}
+
+			value, err := decodeExpression(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			if value == nil {
+				return nil, missing("a pair's value")
+			}
+
+			pairs = append(pairs, HashPair{Key: key, Value: value})
+		}
+
+		return &HashLiteral{
+			Token:    token.Token{Type: token.LBRACE, Literal: "{", Position: start},
+			Pairs:    pairs,
+			EndToken: endToken(token.RBRACE),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown node kind %q", header.Kind)
+}
+
+func decodePosition(position jsonPosition) token.Position {
+	return token.Position{Line: position.Line, Column: position.Column}
+}
+
+func isNull(data json.RawMessage) bool {
+	return len(data) == 0 || string(data) == "null"
+}
+
+func decodeStatements(data []json.RawMessage) ([]Statement, error) {
+	result := []Statement{}
+
+	for _, data := range data {
+		node, err := decode(data)
+		if err != nil {
+			return nil, err
+		}
+
+		statement, ok := node.(Statement)
+		if !ok {
+			return nil, fmt.Errorf("expected a statement, got %s", kindOf(node))
+		}
+
+		result = append(result, statement)
+	}
+
+	return result, nil
+}
+
+// Returns an untyped nil for null
+func decodeExpression(data json.RawMessage) (Expression, error) {
+	if isNull(data) {
+		return nil, nil
+	}
+
+	node, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	expression, ok := node.(Expression)
+	if !ok {
+		return nil, fmt.Errorf("expected an expression, got %s", kindOf(node))
+	}
+
+	return expression, nil
+}
+
+func decodeExpressions(data []json.RawMessage) ([]Expression, error) {
+	result := []Expression{}
+
+	for _, data := range data {
+		expression, err := decodeExpression(data)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, expression)
+	}
+
+	return result, nil
+}
+
+func decodeIdentifier(data json.RawMessage) (*Identifier, error) {
+	expression, err := decodeExpression(data)
+	if err != nil || expression == nil {
+		return nil, err
+	}
+
+	identifier, ok := expression.(*Identifier)
+	if !ok {
+		return nil, fmt.Errorf("expected an identifier, got %s", kindOf(expression))
+	}
+
+	return identifier, nil
+}
+
+func decodeBlock(data json.RawMessage) (*BlockStatement, error) {
+	if isNull(data) {
+		return nil, nil
+	}
+
+	node, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	block, ok := node.(*BlockStatement)
+	if !ok {
+		return nil, fmt.Errorf("expected a block, got %s", kindOf(node))
+	}
+
+	return block, nil
+}
+
+// The token an expression statement starting with expression starts with, give or take parentheses
+func firstToken(expression Expression) token.Token {
+	switch expression := expression.(type) {
+	case *InfixExpression:
+		if expression.Left != nil {
+			return firstToken(expression.Left)
+		}
+	case *CallExpression:
+		if expression.Function != nil {
+			return firstToken(expression.Function)
+		}
+	case *IndexExpression:
+		if expression.Left != nil {
+			return firstToken(expression.Left)
+		}
+	case *MemberExpression:
+		if expression.Module != nil {
+			return firstToken(expression.Module)
+		}
+	case *Identifier:
+		return expression.Token
+	case *Boolean:
+		return expression.Token
+	case *IntegerLiteral:
+		return expression.Token
+	case *StringLiteral:
+		return expression.Token
+	case *PrefixExpression:
+		return expression.Token
+	case *IfExpression:
+		return expression.Token
+	case *FunctionLiteral:
+		return expression.Token
+	case *MacroLiteral:
+		return expression.Token
+	case *ArrayLiteral:
+		return expression.Token
+	case *HashLiteral:
+		return expression.Token
+	}
+
+	return token.Token{}
+}
@@ -0,0 +1,140 @@
+package ast
+
+import (
+	"monkey/token"
+	"strings"
+	"testing"
+)
+
+func TestEncodeWithoutPositions(t *testing.T) {
+	name := "f"
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{
+				Expression: &FunctionLiteral{
+					Name:       &name,
+					Parameters: []*Identifier{{Value: "x"}},
+					Body:       &BlockStatement{Statements: []Statement{}},
+				},
+			},
+			&ReturnStatement{},
+		},
+	}
+
+	encoded, err := Encode(program)
+	if err != nil {
+		t.Fatalf("could not encode: %s", err)
+	}
+
+	expected := `{"kind":"Program","statements":[` +
+		`{"kind":"ExpressionStatement","expression":{"kind":"FunctionLiteral","name":"f",` +
+		`"parameters":[{"kind":"Identifier","value":"x"}],"body":{"kind":"BlockStatement","statements":[]}}},` +
+		`{"kind":"ReturnStatement","returnValue":null}]}`
+
+	if string(encoded) != expected {
+		t.Errorf("encoding wrong.\nexpected: %s\ngot:      %s", expected, encoded)
+	}
+
+	// A return always has a value once parsed, so one without isn't decoded
+	_, err = Decode(encoded)
+	if err == nil || err.Error() != "ReturnStatement without a return value" {
+		t.Errorf("null return value decoded, error %v", err)
+	}
+
+	program.Statements = program.Statements[:1]
+	encoded, err = Encode(program)
+	if err != nil {
+		t.Fatalf("could not encode: %s", err)
+	}
+
+	decoded, err := Decode(encoded)
+	if err != nil {
+		t.Fatalf("could not decode: %s", err)
+	}
+
+	function := decoded.(*Program).Statements[0].(*ExpressionStatement).Expression.(*FunctionLiteral)
+	if function.Name == nil || *function.Name != "f" || function.Parameters[0].Value != "x" {
+		t.Errorf("function decoded wrong: %s", function)
+	}
+}
+
+func TestHashPairOrder(t *testing.T) {
+	key := func(value string, column int) Expression {
+		return &StringLiteral{
+			Token: token.Token{Type: token.STRING, Literal: value, Position: token.Position{Line: 1, Column: column}},
+			Value: value,
+		}
+	}
+
+	// Positions don't count, keys made by macros have none that mean anything
+	c, a, b := key("c", 22), key("a", 12), key("b", 2)
+	hash := &HashLiteral{
+		Pairs: []HashPair{{c, key("3", 27)}, {a, key("1", 17)}, {b, key("2", 7)}},
+	}
+
+	for i := 0; i < 10; i++ {
+		encoded, _ := Encode(hash)
+
+		c, a, b := strings.Index(string(encoded), `"c"`), strings.Index(string(encoded), `"a"`), strings.Index(string(encoded), `"b"`)
+		if !(c < a && a < b) {
+			t.Fatalf("pairs not in written order: %s", encoded)
+		}
+
+		decoded, err := Decode(encoded)
+		if err != nil {
+			t.Fatalf("decoding failed: %s", err)
+		}
+
+		if decoded.String() != `{"c":"3", "a":"1", "b":"2"}` {
+			t.Fatalf("pairs decoded as %s", decoded)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"value": 1}`, "node without a kind"},
+		{`{"kind": "Nonsense"}`, `unknown node kind "Nonsense"`},
+		{`{"kind": "Program", "statements": [{"kind": "Identifier", "value": "x"}]}`, "expected a statement, got Identifier"},
+		{`{"kind": "LetStatement", "name": {"kind": "IntegerLiteral", "value": 1}}`, "expected an identifier, got IntegerLiteral"},
+		{`{"kind": "IfExpression", "condition": {"kind": "Boolean", "value": true}, "consequence": {"kind": "Identifier", "value": "x"}}`, "expected a block, got Identifier"},
+		{`{"kind": "InfixExpression", "operator": "+", "left": {"kind": "IntegerLiteral", "value": 1}}`, "InfixExpression without a right operand"},
+		{`{"kind": "InfixExpression", "operator": "+", "span": {"start": {"line": 2, "column": 3}, "end": {"line": 2, "column": 8}}}`, "2:3: InfixExpression without a left operand"},
+		{`{"kind": "PrefixExpression", "operator": "-"}`, "PrefixExpression without an operand"},
+		{`{"kind": "LetStatement", "name": {"kind": "Identifier", "value": "x"}}`, "LetStatement without a value"},
+		{`{"kind": "LetStatement", "value": {"kind": "IntegerLiteral", "value": 1}}`, "LetStatement without a name"},
+		{`{"kind": "ExpressionStatement", "expression": null}`, "ExpressionStatement without an expression"},
+		{`{"kind": "ReturnStatement", "returnValue": null}`, "ReturnStatement without a return value"},
+		{`{"kind": "ReturnStatement"}`, "ReturnStatement without a return value"},
+		{`{"kind": "IfExpression", "condition": {"kind": "Boolean", "value": true}}`, "IfExpression without a consequence"},
+		{`{"kind": "IfExpression"}`, "IfExpression without a condition"},
+		{`{"kind": "CallExpression", "arguments": []}`, "CallExpression without a function"},
+		{`{"kind": "CallExpression", "function": {"kind": "Identifier", "value": "f"}, "arguments": [null]}`, "CallExpression without an argument"},
+		{`{"kind": "ArrayLiteral", "elements": [null]}`, "ArrayLiteral without an element"},
+		{`{"kind": "IndexExpression", "left": {"kind": "Identifier", "value": "a"}}`, "IndexExpression without an index"},
+		{`{"kind": "FunctionLiteral", "parameters": []}`, "FunctionLiteral without a body"},
+		{`{"kind": "FunctionLiteral", "parameters": [null], "body": {"kind": "BlockStatement", "statements": []}}`, "FunctionLiteral without a parameter's name"},
+		{`{"kind": "MacroLiteral", "parameters": []}`, "MacroLiteral without a body"},
+		{`{"kind": "HashLiteral", "pairs": [{}]}`, "HashLiteral without a pair's key"},
+		{`{"kind": "HashLiteral", "pairs": [{"key": {"kind": "IntegerLiteral", "value": 1}}]}`, "HashLiteral without a pair's value"},
+		{`{"kind": "ImportStatement", "name": {"kind": "Identifier", "value": "m"}}`, "ImportStatement without a path"},
+		{`{"kind": "ExportStatement"}`, "ExportStatement without a statement"},
+		{`{"kind": "MemberExpression", "module": {"kind": "Identifier", "value": "m"}}`, "MemberExpression without a member"},
+		{`[`, "unexpected end of JSON input"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode([]byte(tt.input))
+		if err == nil {
+			t.Errorf("no error decoding %s", tt.input)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error decoding %s: %q, expected %q", tt.input, err, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package ast
+
+import "fmt"
+
+type ModifierFunc func(Node) Node
+
+// Replaces every node with what modifier returns for it, children before their
+// parents, and returns the replacement for node itself. Children are replaced in
+// place. Replacing a node with one that can't go where it is, like a statement
+// where an expression should be, panics.
+func Modify(node Node, modifier ModifierFunc) Node {
+	if isNil(node) {
+		return node
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		for i, statement := range node.Statements {
+			node.Statements[i] = modifyStatement(statement, modifier)
+		}
+
+	case *LetStatement:
+		node.Name = modifyIdentifier(node.Name, modifier)
+		node.Value = modifyExpression(node.Value, modifier)
+
+	case *ReturnStatement:
+		node.ReturnValue = modifyExpression(node.ReturnValue, modifier)
+
+	case *ExpressionStatement:
+		node.Expression = modifyExpression(node.Expression, modifier)
+
+	case *BlockStatement:
+		for i, statement := range node.Statements {
+			node.Statements[i] = modifyStatement(statement, modifier)
+		}
+
+	case *PrefixExpression:
+		node.Right = modifyExpression(node.Right, modifier)
+
+	case *InfixExpression:
+		node.Left = modifyExpression(node.Left, modifier)
+		node.Right = modifyExpression(node.Right, modifier)
+
+	case *IfExpression:
+		node.Condition = modifyExpression(node.Condition, modifier)
+		node.Consequence = modifyBlock(node.Consequence, modifier)
+		node.Alternative = modifyBlock(node.Alternative, modifier)
+
+	case *FunctionLiteral:
+		for i, parameter := range node.Parameters {
+			node.Parameters[i] = modifyIdentifier(parameter, modifier)
+		}
+		node.Body = modifyBlock(node.Body, modifier)
+
+	case *MacroLiteral:
+		for i, parameter := range node.Parameters {
+			node.Parameters[i] = modifyIdentifier(parameter, modifier)
+		}
+		node.Body = modifyBlock(node.Body, modifier)
+
+	case *CallExpression:
+		node.Function = modifyExpression(node.Function, modifier)
+		for i, argument := range node.Arguments {
+			node.Arguments[i] = modifyExpression(argument, modifier)
+		}
+
+	case *ArrayLiteral:
+		for i, element := range node.Elements {
+			node.Elements[i] = modifyExpression(element, modifier)
+		}
+
+	case *IndexExpression:
+		node.Left = modifyExpression(node.Left, modifier)
+		node.Index = modifyExpression(node.Index, modifier)
+
+	case *HashLiteral:
+		for i := range node.Pairs {
+			node.Pairs[i].Key = modifyExpression(node.Pairs[i].Key, modifier)
+			node.Pairs[i].Value = modifyExpression(node.Pairs[i].Value, modifier)
+		}
+
+	case *ImportStatement:
+		node.Path = modifyStringLiteral(node.Path, modifier)
+		node.Name = modifyIdentifier(node.Name, modifier)
+
+	case *ExportStatement:
+		node.Statement = modifyLet(node.Statement, modifier)
+
+	case *MemberExpression:
+		node.Module = modifyExpression(node.Module, modifier)
+		node.Member = modifyIdentifier(node.Member, modifier)
+	}
+
+	return modifier(node)
+}
+
+func modifyStatement(statement Statement, modifier ModifierFunc) Statement {
+	result := Modify(statement, modifier)
+	if result == nil {
+		return nil
+	}
+
+	replacement, ok := result.(Statement)
+	if !ok {
+		panic(fmt.Sprintf("ast.Modify: can't replace statement %s with %T", statement, result))
+	}
+
+	return replacement
+}
+
+func modifyExpression(expression Expression, modifier ModifierFunc) Expression {
+	result := Modify(expression, modifier)
+	if result == nil {
+		return nil
+	}
+
+	replacement, ok := result.(Expression)
+	if !ok {
+		panic(fmt.Sprintf("ast.Modify: can't replace expression %s with %T", expression, result))
+	}
+
+	return replacement
+}
+
+func modifyBlock(block *BlockStatement, modifier ModifierFunc) *BlockStatement {
+	result := Modify(block, modifier)
+	if result == nil {
+		return nil
+	}
+
+	replacement, ok := result.(*BlockStatement)
+	if !ok {
+		panic(fmt.Sprintf("ast.Modify: can't replace a block with %T", result))
+	}
+
+	return replacement
+}
+
+func modifyIdentifier(identifier *Identifier, modifier ModifierFunc) *Identifier {
+	result := Modify(identifier, modifier)
+	if result == nil {
+		return nil
+	}
+
+	replacement, ok := result.(*Identifier)
+	if !ok {
+		panic(fmt.Sprintf("ast.Modify: can't replace identifier %s with %T", identifier, result))
+	}
+
+	return replacement
+}
+
+func modifyStringLiteral(literal *StringLiteral, modifier ModifierFunc) *StringLiteral {
+	result := Modify(literal, modifier)
+	if result == nil {
+		return nil
+	}
+
+	replacement, ok := result.(*StringLiteral)
+	if !ok {
+		panic(fmt.Sprintf("ast.Modify: can't replace string %s with %T", literal, result))
+	}
+
+	return replacement
+}
+
+func modifyLet(statement *LetStatement, modifier ModifierFunc) *LetStatement {
+	result := Modify(statement, modifier)
+	if result == nil {
+		return nil
+	}
+
+	replacement, ok := result.(*LetStatement)
+	if !ok {
+		panic(fmt.Sprintf("ast.Modify: can't replace let statement %s with %T", statement, result))
+	}
+
+	return replacement
+}
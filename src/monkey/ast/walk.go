@@ -0,0 +1,115 @@
+package ast
+
+// Visit is called for every node Walk comes across. If the visitor it returns
+// isn't nil, that one walks the node's children, followed by a Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walks the tree depth first, children in source order. Missing children of
+// partially parsed programs are skipped.
+func Walk(v Visitor, node Node) {
+	if isNil(node) {
+		return
+	}
+
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		for _, statement := range node.Statements {
+			Walk(v, statement)
+		}
+
+	case *LetStatement:
+		Walk(v, node.Name)
+		Walk(v, node.Value)
+
+	case *ReturnStatement:
+		Walk(v, node.ReturnValue)
+
+	case *ExpressionStatement:
+		Walk(v, node.Expression)
+
+	case *BlockStatement:
+		for _, statement := range node.Statements {
+			Walk(v, statement)
+		}
+
+	case *PrefixExpression:
+		Walk(v, node.Right)
+
+	case *InfixExpression:
+		Walk(v, node.Left)
+		Walk(v, node.Right)
+
+	case *IfExpression:
+		Walk(v, node.Condition)
+		Walk(v, node.Consequence)
+		Walk(v, node.Alternative)
+
+	case *FunctionLiteral:
+		for _, parameter := range node.Parameters {
+			Walk(v, parameter)
+		}
+		Walk(v, node.Body)
+
+	case *MacroLiteral:
+		for _, parameter := range node.Parameters {
+			Walk(v, parameter)
+		}
+		Walk(v, node.Body)
+
+	case *CallExpression:
+		Walk(v, node.Function)
+		for _, argument := range node.Arguments {
+			Walk(v, argument)
+		}
+
+	case *ArrayLiteral:
+		for _, element := range node.Elements {
+			Walk(v, element)
+		}
+
+	case *IndexExpression:
+		Walk(v, node.Left)
+		Walk(v, node.Index)
+
+	case *HashLiteral:
+		for _, pair := range node.Pairs {
+			Walk(v, pair.Key)
+			Walk(v, pair.Value)
+		}
+
+	case *ImportStatement:
+		Walk(v, node.Path)
+		Walk(v, node.Name)
+
+	case *ExportStatement:
+		Walk(v, node.Statement)
+
+	case *MemberExpression:
+		Walk(v, node.Module)
+		Walk(v, node.Member)
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+
+	return nil
+}
+
+// Calls f for every node, depth first. The children of a node are only visited if
+// f returns true for it. After them f is called with nil.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
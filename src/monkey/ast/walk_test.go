@@ -0,0 +1,92 @@
+package ast
+
+import (
+	"monkey/token"
+	"strings"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	at := func(column int) token.Token {
+		return token.Token{Position: token.Position{Line: 1, Column: column}}
+	}
+
+	k1, k2 := &StringLiteral{Token: at(2), Value: "k1"}, &StringLiteral{Token: at(9), Value: "k2"}
+
+	program := &Program{Statements: []Statement{
+		&LetStatement{
+			Name: &Identifier{Value: "f"},
+			Value: &FunctionLiteral{
+				Parameters: []*Identifier{{Value: "a"}},
+				Body: &BlockStatement{Statements: []Statement{
+					&ExpressionStatement{Expression: &IfExpression{
+						Condition:   &Identifier{Value: "a"},
+						Consequence: &BlockStatement{Statements: []Statement{&ReturnStatement{ReturnValue: &IntegerLiteral{Value: 1}}}},
+					}},
+				}},
+			},
+		},
+		&ExpressionStatement{Expression: &HashLiteral{
+			Pairs: []HashPair{
+				{k1, &PrefixExpression{Operator: "-", Right: &IntegerLiteral{Value: 2}}},
+				{k2, &Boolean{Value: false}},
+			},
+		}},
+		&ExpressionStatement{Expression: &IndexExpression{
+			Left:  &CallExpression{Function: &Identifier{Value: "f"}, Arguments: []Expression{&ArrayLiteral{}}},
+			Index: &InfixExpression{Left: &IntegerLiteral{Value: 3}, Operator: "+", Right: &IntegerLiteral{Value: 4}},
+		}},
+	}}
+
+	visited := []string{}
+	Inspect(program, func(node Node) bool {
+		if node == nil {
+			return true
+		}
+
+		visited = append(visited, kindOf(node))
+
+		// Don't go into function bodies
+		_, isBlock := node.(*BlockStatement)
+		return !isBlock
+	})
+
+	expected := []string{
+		"Program", "LetStatement", "Identifier", "FunctionLiteral", "Identifier", "BlockStatement",
+		"ExpressionStatement", "HashLiteral", "StringLiteral", "PrefixExpression", "IntegerLiteral",
+		"StringLiteral", "Boolean",
+		"ExpressionStatement", "IndexExpression", "CallExpression", "Identifier", "ArrayLiteral",
+		"InfixExpression", "IntegerLiteral", "IntegerLiteral",
+	}
+
+	if strings.Join(visited, " ") != strings.Join(expected, " ") {
+		t.Errorf("wrong nodes visited.\nexpected: %v\ngot:      %v", expected, visited)
+	}
+}
+
+type counter struct {
+	visits int
+	ends   int
+}
+
+func (c *counter) Visit(node Node) Visitor {
+	if node == nil {
+		c.ends++
+	} else {
+		c.visits++
+	}
+
+	return c
+}
+
+func TestWalkSkipsMissingNodes(t *testing.T) {
+	// Like what's left of "if (x) { y" after a parser error
+	partial := &IfExpression{Condition: &Identifier{Value: "x"}}
+
+	c := &counter{}
+	Walk(c, partial)
+
+	if c.visits != 2 || c.ends != 2 {
+		t.Errorf("wrong number of visits %d and ends %d, expected 2 and 2", c.visits, c.ends)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import "testing"
+
+func TestWorkloadsAgree(t *testing.T) {
+	workloads, err := loadWorkloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workload := range workloads {
+		vmResult, instructions, err := runVM(workload)
+		if err != nil {
+			t.Fatalf("%s on the vm: %s", workload.Name, err)
+		}
+
+		if instructions == 0 {
+			t.Errorf("%s on the vm executed no instructions", workload.Name)
+		}
+
+		registerResult, registerInstructions, err := runRegister(workload)
+		if err != nil {
+			t.Fatalf("%s on the register vm: %s", workload.Name, err)
+		}
+
+		if registerInstructions == 0 {
+			t.Errorf("%s on the register vm executed no instructions", workload.Name)
+		}
+
+		if vmResult.Inspect() != registerResult.Inspect() {
+			t.Errorf("%s gives %s on the vm but %s on the register vm",
+				workload.Name, vmResult.Inspect(), registerResult.Inspect())
+		}
+
+		evalResult, _, err := runEvaluator(workload)
+		if err != nil {
+			t.Fatalf("%s on the evaluator: %s", workload.Name, err)
+		}
+
+		if vmResult.Inspect() != evalResult.Inspect() {
+			t.Errorf("%s gives %s on the vm but %s on the evaluator",
+				workload.Name, vmResult.Inspect(), evalResult.Inspect())
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	baseline := []Result{
+		{Workload: "recursion", Engine: "vm", NsPerOp: 100, AllocsPerOp: 10},
+		{Workload: "recursion", Engine: "vm", NsPerOp: 200, AllocsPerOp: 10},
+		{Workload: "strings", Engine: "vm", NsPerOp: 100, AllocsPerOp: 10},
+		{Workload: "hashes", Engine: "vm", NsPerOp: 100, AllocsPerOp: 10},
+	}
+
+	results := []Result{
+		{Workload: "recursion", Engine: "vm", NsPerOp: 160, AllocsPerOp: 10},
+		{Workload: "strings", Engine: "vm", NsPerOp: 100, AllocsPerOp: 12},
+		{Workload: "arrays", Engine: "vm", NsPerOp: 100, AllocsPerOp: 10},
+	}
+
+	changes := compare(baseline, results, 0.1)
+
+	expected := []Change{
+		{"BenchmarkRecursion/engine=vm", 150, 160, 10, 10, false},
+		{"BenchmarkStrings/engine=vm", 100, 100, 10, 12, true},
+	}
+
+	if len(changes) != len(expected) {
+		t.Fatalf("wrong number of changes. expected=%d, got=%d: %+v", len(expected), len(changes), changes)
+	}
+
+	for i, change := range changes {
+		if change != expected[i] {
+			t.Errorf("wrong change %d. expected=%+v, got=%+v", i, expected[i], change)
+		}
+	}
+}
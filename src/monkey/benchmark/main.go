@@ -1,74 +1,143 @@
+// Times the workloads in workloads/ on each engine.
+//
+// Results are printed like go test -bench prints them, for benchstat, or as
+// JSON with -json. A file saved with -json can be given to -baseline, which
+// compares against it and fails if any benchmark got slower or allocates
+// more, by more than -threshold.
 package main
 
 import (
 	"flag"
 	"fmt"
-	"monkey/compiler"
-	"monkey/evaluator"
-	"monkey/lexer"
-	"monkey/object"
-	"monkey/parser"
-	"monkey/vm"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 )
 
-var engine = flag.String("engine", "vm", "use 'vm' or 'eval'")
-var input = `
-let fibonacci = fn(x) {
-if (x == 0) {
-0
-} else {
-if (x == 1) {
-return 1;
-} else {
-fibonacci(x - 1) + fibonacci(x - 2);
-}
-}
-};
-fibonacci(35);
-`
+var engine = flag.String("engine", "all", "use 'vm', 'register', 'eval', several separated by commas, or 'all'")
+var run = flag.String("run", "", "only run workloads whose names match this regular expression")
+var benchtime = flag.Duration("benchtime", time.Second, "how long to keep running each workload")
+var count = flag.Int("count", 1, "how many times to run each benchmark")
+var asJSON = flag.Bool("json", false, "print the results as JSON")
+var baseline = flag.String("baseline", "", "JSON results to compare against")
+var threshold = flag.Float64("threshold", 0.1, "slowdown or extra allocations, as a fraction, that counts as a regression")
 
 func main() {
 	flag.Parse()
 
-	var duration time.Duration
-	var result object.Object
+	selected, err := selectEngines(*engine)
+	if err != nil {
+		fail(err)
+	}
 
-	l := lexer.New(input)
-	p := parser.New(l)
-	program := p.ParseProgram()
+	filter, err := regexp.Compile(*run)
+	if err != nil {
+		fail(err)
+	}
 
-	if *engine == "vm" {
-		comp := compiler.New()
-		err := comp.Compile(program)
-		if err != nil {
-			fmt.Printf("compiler error: %s", err)
-			return
+	workloads, err := loadWorkloads()
+	if err != nil {
+		fail(err)
+	}
+
+	results := []Result{}
+	for _, workload := range workloads {
+		if !filter.MatchString(workload.Name) {
+			continue
 		}
 
-		machine := vm.New(comp.Bytecode())
+		for _, engine := range selected {
+			for range *count {
+				result, err := measure(workload, engine, *benchtime)
+				if err != nil {
+					fail(fmt.Errorf("%s on %s: %s", workload.Name, engine.Name, err))
+				}
 
-		start := time.Now()
+				results = append(results, result)
+			}
+		}
 
-		err = machine.Execute()
+		err := checkAgreement(results)
 		if err != nil {
-			fmt.Printf("vm error: %s", err)
-			return
+			fail(err)
 		}
+	}
 
-		duration = time.Since(start)
-
-		result = machine.LastStackTop()
+	if *asJSON {
+		err = writeJSON(os.Stdout, results)
+		if err != nil {
+			fail(err)
+		}
 	} else {
-		env := object.NewEnvironment()
-		start := time.Now()
-		result = evaluator.Eval(program, env)
-		duration = time.Since(start)
+		writeBenchstat(os.Stdout, results)
+	}
+
+	if *baseline == "" {
+		return
+	}
+
+	old, err := readBaseline(*baseline)
+	if err != nil {
+		fail(err)
 	}
 
-	fmt.Printf(
-		"engine=%s, result=%s, duration=%s\n",
-		*engine,
-		result.Inspect(),
-		duration)
+	changes := compare(old, results, *threshold)
+
+	// Keeps stdout valid JSON
+	comparison := os.Stdout
+	if *asJSON {
+		comparison = os.Stderr
+	}
+	writeComparison(comparison, *baseline, changes)
+
+	for _, change := range changes {
+		if change.Regressed {
+			os.Exit(1)
+		}
+	}
+}
+
+func selectEngines(names string) ([]Engine, error) {
+	if names == "all" {
+		return engines, nil
+	}
+
+	selected := []Engine{}
+	for _, name := range strings.Split(names, ",") {
+		index := slices.IndexFunc(engines, func(engine Engine) bool { return engine.Name == name })
+		if index < 0 {
+			return nil, fmt.Errorf("unknown engine %q, use 'vm', 'register', 'eval' or 'all'", name)
+		}
+
+		selected = append(selected, engines[index])
+	}
+
+	return selected, nil
+}
+
+// A benchmark of a wrong result measures nothing
+func checkAgreement(results []Result) error {
+	expected := map[string]Result{}
+
+	for _, result := range results {
+		first, ok := expected[result.Workload]
+		if !ok {
+			expected[result.Workload] = result
+			continue
+		}
+
+		if result.Result != first.Result {
+			return fmt.Errorf("%s gives %s on %s but %s on %s",
+				result.Workload, first.Result, first.Engine, result.Result, result.Engine)
+		}
+	}
+
+	return nil
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "benchmark: %s\n", err)
+	os.Exit(1)
 }
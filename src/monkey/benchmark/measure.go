@@ -0,0 +1,67 @@
+package main
+
+import (
+	"runtime"
+	"time"
+)
+
+// What one benchmark run measured, per run of the workload
+type Result struct {
+	Workload string `json:"workload"`
+	Engine   string `json:"engine"`
+
+	Runs              int     `json:"runs"`
+	NsPerOp           float64 `json:"nsPerOp"`
+	OpsPerSec         float64 `json:"opsPerSec"`
+	BytesPerOp        float64 `json:"bytesPerOp"`
+	AllocsPerOp       float64 `json:"allocsPerOp"`
+	InstructionsPerOp float64 `json:"instructionsPerOp,omitempty"` // The evaluator has none
+
+	Result string `json:"result"` // What the workload evaluated to, which the engines agree on
+}
+
+// Runs the workload until at least minimum has passed, at least once
+func measure(workload *Workload, engine Engine, minimum time.Duration) (Result, error) {
+	// Once untimed, so the first timed run doesn't pay for warming up
+	value, _, err := engine.Run(workload)
+	if err != nil {
+		return Result{}, err
+	}
+
+	runtime.GC()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	runs := 0
+	var instructions int64
+	start := time.Now()
+	for runs == 0 || time.Since(start) < minimum {
+		_, executed, err := engine.Run(workload)
+		if err != nil {
+			return Result{}, err
+		}
+
+		runs++
+		instructions += executed
+	}
+	elapsed := time.Since(start)
+
+	runtime.ReadMemStats(&after)
+
+	perRun := func(total float64) float64 { return total / float64(runs) }
+
+	return Result{
+		Workload: workload.Name,
+		Engine:   engine.Name,
+
+		Runs:              runs,
+		NsPerOp:           perRun(float64(elapsed.Nanoseconds())),
+		OpsPerSec:         float64(runs) / elapsed.Seconds(),
+		BytesPerOp:        perRun(float64(after.TotalAlloc - before.TotalAlloc)),
+		AllocsPerOp:       perRun(float64(after.Mallocs - before.Mallocs)),
+		InstructionsPerOp: perRun(float64(instructions)),
+
+		Result: value.Inspect(),
+	}, nil
+}
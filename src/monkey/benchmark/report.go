@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+)
+
+// In the format of go test -bench, which benchstat reads
+func writeBenchstat(w io.Writer, results []Result) {
+	fmt.Fprintf(w, "goos: %s\ngoarch: %s\npkg: monkey/benchmark\n", runtime.GOOS, runtime.GOARCH)
+
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%8d\t%14.0f ns/op\t%12.2f ops/s\t%12.0f B/op\t%10.0f allocs/op",
+			benchmarkName(result), result.Runs, result.NsPerOp, result.OpsPerSec, result.BytesPerOp, result.AllocsPerOp)
+
+		if result.InstructionsPerOp != 0 {
+			fmt.Fprintf(w, "\t%12.0f instructions/op", result.InstructionsPerOp)
+		}
+
+		fmt.Fprintln(w)
+	}
+}
+
+// Like BenchmarkRecursion/engine=vm
+func benchmarkName(result Result) string {
+	return fmt.Sprintf("Benchmark%s/engine=%s",
+		strings.ToUpper(result.Workload[:1])+result.Workload[1:], result.Engine)
+}
+
+func writeJSON(w io.Writer, results []Result) error {
+	encoded, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", encoded)
+	return err
+}
+
+// Saved with -json
+func readBaseline(filename string) ([]Result, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	err = json.Unmarshal(contents, &results)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	return results, nil
+}
+
+// How one benchmark changed since the baseline
+type Change struct {
+	Name string
+
+	OldNsPerOp, NewNsPerOp         float64
+	OldAllocsPerOp, NewAllocsPerOp float64
+
+	Regressed bool
+}
+
+// Runs of the same benchmark, with -count, are averaged. Benchmarks missing
+// from either side are left out.
+func compare(baseline, results []Result, threshold float64) []Change {
+	old := averages(baseline)
+	current := averages(results)
+
+	changes := []Change{}
+	for _, result := range results {
+		name := benchmarkName(result)
+
+		before, ok := old[name]
+		if !ok {
+			continue
+		}
+
+		after, ok := current[name]
+		if !ok {
+			continue
+		}
+		delete(current, name) // Once per benchmark, whatever the count
+
+		changes = append(changes, Change{
+			Name: name,
+
+			OldNsPerOp: before.NsPerOp, NewNsPerOp: after.NsPerOp,
+			OldAllocsPerOp: before.AllocsPerOp, NewAllocsPerOp: after.AllocsPerOp,
+
+			Regressed: after.NsPerOp > before.NsPerOp*(1+threshold) ||
+				after.AllocsPerOp > before.AllocsPerOp*(1+threshold),
+		})
+	}
+
+	return changes
+}
+
+func averages(results []Result) map[string]Result {
+	sums := map[string]Result{}
+	counts := map[string]float64{}
+
+	for _, result := range results {
+		name := benchmarkName(result)
+
+		sum := sums[name]
+		sum.NsPerOp += result.NsPerOp
+		sum.AllocsPerOp += result.AllocsPerOp
+		sums[name] = sum
+
+		counts[name]++
+	}
+
+	for name, sum := range sums {
+		sum.NsPerOp /= counts[name]
+		sum.AllocsPerOp /= counts[name]
+		sums[name] = sum
+	}
+
+	return sums
+}
+
+func writeComparison(w io.Writer, baseline string, changes []Change) {
+	fmt.Fprintf(w, "\ncompared to %s:\n", baseline)
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "\told ns/op\tnew ns/op\tdelta\told allocs/op\tnew allocs/op\tdelta\t\t")
+
+	for _, change := range changes {
+		verdict := ""
+		if change.Regressed {
+			verdict = "REGRESSED"
+		}
+
+		fmt.Fprintf(table, "%s\t%.0f\t%.0f\t%s\t%.0f\t%.0f\t%s\t%s\t\n",
+			change.Name,
+			change.OldNsPerOp, change.NewNsPerOp, delta(change.OldNsPerOp, change.NewNsPerOp),
+			change.OldAllocsPerOp, change.NewAllocsPerOp, delta(change.OldAllocsPerOp, change.NewAllocsPerOp),
+			verdict)
+	}
+
+	table.Flush()
+}
+
+func delta(old, new float64) string {
+	if old == 0 {
+		return "~"
+	}
+
+	return fmt.Sprintf("%+.1f%%", (new-old)/old*100)
+}
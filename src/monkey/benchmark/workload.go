@@ -0,0 +1,135 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/regvm"
+	"monkey/vm"
+	"path"
+	"strings"
+)
+
+//go:embed workloads/*.mk
+var workloadFiles embed.FS
+
+// A program to time, parsed and compiled ahead so only running it is measured
+type Workload struct {
+	Name string
+
+	program   *ast.Program
+	bytecode  *compiler.Bytecode
+	registers *regvm.Bytecode
+}
+
+// In the order of their file names
+func loadWorkloads() ([]*Workload, error) {
+	entries, err := workloadFiles.ReadDir("workloads")
+	if err != nil {
+		return nil, err
+	}
+
+	workloads := []*Workload{}
+	for _, entry := range entries {
+		filename := path.Join("workloads", entry.Name())
+
+		source, err := workloadFiles.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		p := parser.New(lexer.New(string(source)))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			return nil, fmt.Errorf("%s: %s", filename, strings.Join(p.Errors(), ", "))
+		}
+
+		c := compiler.New()
+		err = c.Compile(program)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+
+		r := regvm.NewCompiler()
+		err = r.Compile(program)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+
+		workloads = append(workloads, &Workload{
+			Name:      strings.TrimSuffix(entry.Name(), ".mk"),
+			program:   program,
+			bytecode:  c.Bytecode(),
+			registers: r.Bytecode(),
+		})
+	}
+
+	return workloads, nil
+}
+
+// Builtins that print have nowhere to print to
+func discardingContext() *object.Context {
+	return &object.Context{Stdout: io.Discard, Stderr: io.Discard}
+}
+
+// Runs the workload once, giving its result and how many instructions the VM
+// executed for it
+func runVM(workload *Workload) (object.Object, int64, error) {
+	machine := vm.New(workload.bytecode)
+	machine.SetContext(discardingContext())
+
+	// Stepping is what Execute does, counting the steps costs next to nothing
+	var instructions int64
+	for !machine.Finished() {
+		err := machine.Step()
+		if err != nil {
+			return nil, instructions, err
+		}
+		instructions++
+	}
+
+	return machine.LastStackTop(), instructions, nil
+}
+
+// The register VM counts its instructions itself, stepping would slow it down
+func runRegister(workload *Workload) (object.Object, int64, error) {
+	machine := regvm.New(workload.registers)
+	machine.SetContext(discardingContext())
+
+	err := machine.Execute()
+	if err != nil {
+		return nil, machine.Executed(), err
+	}
+
+	return machine.Result(), machine.Executed(), nil
+}
+
+// The evaluator has no instructions to count
+func runEvaluator(workload *Workload) (object.Object, int64, error) {
+	env := object.NewEnvironment()
+	env.SetContext(discardingContext())
+
+	result := evaluator.Eval(workload.program, env)
+	if err, ok := result.(*object.Error); ok {
+		return nil, 0, fmt.Errorf("%s", err.Message)
+	}
+
+	return result, 0, nil
+}
+
+type Engine struct {
+	Name string
+	Run  func(workload *Workload) (object.Object, int64, error)
+}
+
+var engines = []Engine{
+	{"vm", runVM},
+	{"register", runRegister},
+	{"eval", runEvaluator},
+}
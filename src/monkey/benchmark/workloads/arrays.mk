@@ -0,0 +1,14 @@
+// Growing arrays with push and walking them with rest
+let fill = fn(array, n) {
+  if (n == 0) { array } else { fill(push(array, n), n - 1) }
+};
+
+let sum = fn(array, total) {
+  if (len(array) == 0) { total } else { sum(rest(array), total + first(array)) }
+};
+
+let run = fn(i, total) {
+  if (i == 0) { total } else { run(i - 1, total + sum(fill([], 300), 0)) }
+};
+
+run(20, 0)
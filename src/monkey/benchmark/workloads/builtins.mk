@@ -0,0 +1,18 @@
+// Calling builtins, most of them on strings
+let words = split("the quick brown fox jumps over the lazy dog", " ");
+
+let step = fn(n) {
+  let word = words[n - (n / 9) * 9];
+  len(upper(word)) + index_of(join(words, "-"), word) + parse_int(to_string(n)) +
+    len(substring(word, 0, 1)) + len(json_stringify({"n": n, "word": word})) + len(last(words))
+};
+
+let run = fn(n, total) {
+  if (n == 0) { total } else { run(n - 1, total + step(n)) }
+};
+
+let repeat = fn(i, total) {
+  if (i == 0) { total } else { repeat(i - 1, total + run(500, 0)) }
+};
+
+repeat(10, 0)
@@ -0,0 +1,13 @@
+// Making closures and calling through chains of them
+let compose = fn(f, g) { fn(x) { g(f(x)) } };
+let adder = fn(n) { fn(x) { x + n } };
+
+let chain = fn(f, n) {
+  if (n == 0) { f } else { chain(compose(f, adder(n)), n - 1) }
+};
+
+let run = fn(i, total) {
+  if (i == 0) { total } else { run(i - 1, total + chain(fn(x) { x }, 50)(i)) }
+};
+
+run(200, 0)
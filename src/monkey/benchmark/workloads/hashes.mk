@@ -0,0 +1,20 @@
+// Building hashes and looking up keys of every hashable type
+let table = {
+  "alpha": 1, "beta": 2, "gamma": 3, "delta": 4, "epsilon": 5,
+  1: 6, 2: 7, 3: 8, true: 9, false: 10, [1, "two"]: 11
+};
+
+let lookup = fn(n, total) {
+  if (n == 0) {
+    total
+  } else {
+    let pair = {"key": n, "next": n + 1, [n]: n};
+    lookup(n - 1, total + table["gamma"] + table[2] + table[true] + table[[1, "two"]] + pair["next"] + pair[[n]])
+  }
+};
+
+let run = fn(i, total) {
+  if (i == 0) { total } else { run(i - 1, total + lookup(200, 0)) }
+};
+
+run(50, 0)
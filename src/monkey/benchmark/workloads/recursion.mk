@@ -0,0 +1,6 @@
+// Function calls and integer arithmetic
+let fibonacci = fn(x) {
+  if (x < 2) { x } else { fibonacci(x - 1) + fibonacci(x - 2) }
+};
+
+fibonacci(22)
@@ -0,0 +1,10 @@
+// Building strings by concatenation
+let build = fn(s, n) {
+  if (n == 0) { s } else { build(s + to_string(n) + ",", n - 1) }
+};
+
+let run = fn(i, total) {
+  if (i == 0) { total } else { run(i - 1, total + len(build("", 500))) }
+};
+
+run(20, 0)
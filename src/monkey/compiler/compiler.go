@@ -3,9 +3,17 @@ package compiler
 import (
 	"fmt"
 	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/module"
 	"monkey/object"
 	"monkey/opcode"
-	"sort"
+	"monkey/token"
+)
+
+// Limits from the widths of operands
+const (
+	MaxLocals    = 256 // Per function, OpGetLocal's operand is one byte
+	MaxArguments = 255 // OpCall's operand is one byte
 )
 
 type Compiler struct {
@@ -15,6 +23,19 @@ type Compiler struct {
 
 	scopes     []*CompilationScope
 	scopeIndex int
+
+	// Source position of the statement being compiled
+	position token.Position
+
+	// Reads imported files, relative to the working directory unless replaced
+	Loader *module.Loader
+
+	// Where the macros of imported files print, the process's stdout and
+	// stderr when nil
+	MacroContext *object.Context
+
+	exports map[string]Symbol // What the file being compiled exports
+	file    string            // Path of the imported file being compiled, empty for the main one
 }
 
 type CompilationScope struct {
@@ -22,6 +43,10 @@ type CompilationScope struct {
 
 	lastInstruction     *EmittedInstruction
 	previousInstruction *EmittedInstruction // So we can set lastInstruction after popping off an instruction
+
+	lines            opcode.LineTable
+	imported         map[string]opcode.LineTable // Lines of code from imported files, by path
+	statementPending bool                        // Whether the next emitted instruction starts a statement
 }
 
 type EmittedInstruction struct {
@@ -62,9 +87,25 @@ func (c *Compiler) leaveScope() opcode.Instructions {
 	return *instructions
 }
 
+// A compilation error along with where in the source it occurred
+type Error struct {
+	Position token.Position
+	Message  string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
 type Bytecode struct {
 	Instructions opcode.Instructions
 	Constants    []object.Object
+
+	// Debug information, not needed for execution
+	Lines           opcode.LineTable
+	ImportedLines   map[string]opcode.LineTable // Of code from imported files, by path
+	ImportedSources map[string]string           // Of the files imported, by path
+	GlobalNames     []string                    // Indexed like OpGetGlobal
 }
 
 func New() *Compiler {
@@ -87,6 +128,9 @@ func New() *Compiler {
 
 		scopes:     []*CompilationScope{mainScope},
 		scopeIndex: 0,
+
+		Loader:  module.NewLoader(""),
+		exports: map[string]Symbol{},
 	}
 }
 
@@ -103,19 +147,71 @@ func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: *c.currentInstructions(),
 		Constants:    c.constants,
+
+		Lines:           c.currentScope().lines,
+		ImportedLines:   c.currentScope().imported,
+		ImportedSources: c.Loader.Sources,
+		GlobalNames:     c.symbols.DefinedNames(),
 	}
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	if statement, ok := node.(ast.Statement); ok {
+		if _, isBlock := statement.(*ast.BlockStatement); !isBlock {
+			previous := c.position
+			c.enterStatement(statement.Pos())
+			defer func() { c.position = previous }()
+		}
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, statement := range node.Statements {
-			err := c.Compile(statement)
+			var err error
+
+			// Only allowed at the top level
+			switch statement := statement.(type) {
+			case *ast.ImportStatement:
+				err = c.compileImport(statement)
+			case *ast.ExportStatement:
+				err = c.compileExport(statement)
+			default:
+				err = c.Compile(statement)
+			}
+
 			if err != nil {
 				return err
 			}
 		}
 
+	case *ast.ImportStatement:
+		return &Error{node.Pos(), "imports must be at the top level"}
+
+	case *ast.ExportStatement:
+		return &Error{node.Pos(), "exports must be at the top level"}
+
+	case *ast.MemberExpression:
+		identifier, ok := node.Module.(*ast.Identifier)
+		if !ok {
+			return &Error{node.Module.Pos(), fmt.Sprintf("%s is not a module", node.Module)}
+		}
+
+		symbol, ok := c.symbols.Resolve(identifier.Value)
+		if !ok {
+			return &Error{identifier.Pos(), fmt.Sprintf("Symbol %q not found", identifier.Value)}
+		}
+		if symbol.Scope != ModuleScope {
+			return &Error{identifier.Pos(), fmt.Sprintf("%s is not a module", identifier.Value)}
+		}
+
+		imported := c.symbols.Module(symbol)
+		export, ok := imported.Exports[node.Member.Value]
+		if !ok {
+			return &Error{node.Member.Pos(), fmt.Sprintf("%s doesn't export %s", imported.Path, node.Member.Value)}
+		}
+
+		c.emit(opcode.OpGetGlobal, export.Index)
+
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Expression)
 		if err != nil {
@@ -183,6 +279,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 
+		// A let leaves nothing on the stack, but the block needs a value
+		if _, ok := node.Statements[len(node.Statements)-1].(*ast.LetStatement); ok {
+			c.emit(opcode.OpPushNull)
+		}
+
 	case *ast.LetStatement:
 		symbol := c.symbols.Define(node.Name.Value)
 		err := c.Compile(node.Value)
@@ -200,7 +301,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		symbol, ok := c.symbols.Resolve(node.Value)
 
 		if !ok {
-			return fmt.Errorf("Symbol %q not found", node.Value)
+			return &Error{node.Pos(), fmt.Sprintf("Symbol %q not found", node.Value)}
 		}
 
 		switch symbol.Scope {
@@ -219,32 +320,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case CurrentFunctionScope:
 			c.emit(opcode.OpRecurse)
 
+		case ModuleScope:
+			return &Error{node.Pos(), fmt.Sprintf("module %s can only be used for its members, like %s.name", node.Value, node.Value)}
+
 		default:
-			panic(fmt.Sprintf("Invalid symbol scope: %d", symbol.Scope))
+			return &Error{node.Pos(), fmt.Sprintf("invalid symbol scope: %d", symbol.Scope)}
 		}
 
 	case *ast.InfixExpression:
-		if node.Operator[0] == byte('<') {
-			// Switch order of operands, so we can reuse OpGreater
-			err := c.Compile(node.Right)
-			if err != nil {
-				return err
-			}
-
-			err = c.Compile(node.Left)
-			if err != nil {
-				return err
-			}
-		} else {
-			err := c.Compile(node.Left)
-			if err != nil {
-				return err
-			}
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
 
-			err = c.Compile(node.Right)
-			if err != nil {
-				return err
-			}
+		err = c.Compile(node.Right)
+		if err != nil {
+			return err
 		}
 
 		switch node.Operator {
@@ -264,10 +355,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case ">":
 			c.emit(opcode.OpGreaterThan)
 		case "<":
-			c.emit(opcode.OpGreaterThan)
+			c.emit(opcode.OpLessThan)
 
 		default:
-			panic(fmt.Sprintf("Invalid infix operator: %q", node.Operator))
+			return &Error{node.Pos(), fmt.Sprintf("unknown operator: %s", node.Operator)}
 		}
 
 	case *ast.PrefixExpression:
@@ -284,7 +375,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(opcode.OpLogicalNot)
 
 		default:
-			panic(fmt.Sprintf("Invalid prefix operator: %q", node.Operator))
+			return &Error{node.Pos(), fmt.Sprintf("unknown operator: %s", node.Operator)}
 		}
 
 	case *ast.IntegerLiteral:
@@ -319,23 +410,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(opcode.OpArray, len(node.Elements))
 
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-
-		for key := range node.Pairs {
-			keys = append(keys, key)
-		}
-
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, key := range keys {
-			err := c.Compile(key)
+		for _, pair := range node.Pairs {
+			err := c.Compile(pair.Key)
 			if err != nil {
 				return err
 			}
 
-			err = c.Compile(node.Pairs[key])
+			err = c.Compile(pair.Value)
 			if err != nil {
 				return err
 			}
@@ -356,6 +437,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		c.emit(opcode.OpIndex)
 
+	case *ast.MacroLiteral:
+		// Left over by evaluator.ExpandMacros, which only takes top-level lets
+		return &Error{node.Pos(), "macros can only be defined with a top-level let"}
+
 	case *ast.FunctionLiteral:
 		c.enterScope()
 
@@ -379,21 +464,27 @@ func (c *Compiler) Compile(node ast.Node) error {
 				opcode.MakeInstruction(opcode.OpReturnValue),
 			)
 		}
-		// Empty body
+		// Empty body, or the last statement is a let
 		if c.lastInstructionIs(opcode.OpPushNull) {
 			c.replaceInstruction(
 				len(*c.currentInstructions())-1,
 				opcode.MakeInstruction(opcode.OpReturn),
 			)
 		}
-		// Last statement is a let
-		if c.lastInstructionIs(opcode.OpSetGlobal) {
-			c.emit(opcode.OpReturn)
-		}
 
 		// Capture free symbols and number of locals before leaving scope!
 		freeSymbols := c.symbols.FreeSymbols
 		numberOfLocals := c.symbols.Len()
+
+		// Their indexes are single byte operands
+		if numberOfLocals > MaxLocals {
+			return &Error{node.Pos(), fmt.Sprintf("too many parameters and local bindings, %d is the most", MaxLocals)}
+		}
+		if len(freeSymbols) > MaxLocals {
+			return &Error{node.Pos(), fmt.Sprintf("too many free variables, %d is the most", MaxLocals)}
+		}
+		localNames := c.symbols.DefinedNames()
+		lines, imported := c.currentScope().lines, c.currentScope().imported
 		instructions := c.leaveScope()
 
 		// Load free symbols onto the stack
@@ -406,14 +497,30 @@ func (c *Compiler) Compile(node ast.Node) error {
 				c.emit(opcode.OpGetFree, freeSymbol.Index)
 
 			default:
-				panic(fmt.Sprintf("Loading a free symbol with scope %d, that can't be right", freeSymbol.Scope))
+				return &Error{node.Pos(), fmt.Sprintf("free symbol %s has scope %d, that can't be right", freeSymbol.Name, freeSymbol.Scope)}
 			}
 		}
 
+		freeNames := make([]string, len(freeSymbols))
+		for i, freeSymbol := range freeSymbols {
+			freeNames[i] = freeSymbol.Name
+		}
+
+		name := ""
+		if node.Name != nil {
+			name = *node.Name
+		}
+
 		result := &object.CompiledFunction{
 			Instructions:       instructions,
 			NumberOfLocals:     numberOfLocals,
 			NumberOfParameters: len(node.Parameters),
+
+			Name:          name,
+			Lines:         lines,
+			ImportedLines: imported,
+			LocalNames:    localNames,
+			FreeNames:     freeNames,
 		}
 		index := c.addConstant(result)
 		c.emit(opcode.OpMakeClosure, index, len(freeSymbols))
@@ -427,6 +534,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(opcode.OpReturnValue)
 
 	case *ast.CallExpression:
+		if len(node.Arguments) > MaxArguments {
+			return &Error{node.Pos(), fmt.Sprintf("too many arguments, %d is the most", MaxArguments)}
+		}
+
 		err := c.Compile(node.Function)
 		if err != nil {
 			return err
@@ -442,7 +553,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(opcode.OpCall, len(node.Arguments))
 
 	default:
-		panic(fmt.Sprintf("Invalid node type: %T", node))
+		return &Error{node.Pos(), fmt.Sprintf("can't compile %T", node)}
 	}
 
 	return nil
@@ -456,6 +567,8 @@ func (c *Compiler) emit(op opcode.OpCode, operands ...int) {
 	starting_position := len(*currentInstructions)
 	*currentInstructions = append(*currentInstructions, bytecode...)
 
+	c.addLineEntry(starting_position)
+
 	c.currentScope().previousInstruction = c.currentScope().lastInstruction
 	c.currentScope().lastInstruction = &EmittedInstruction{
 		code:  op,
@@ -482,6 +595,13 @@ func (c *Compiler) removeLastInstruction() {
 
 	c.currentScope().lastInstruction = c.scopes[c.scopeIndex].previousInstruction
 	c.currentScope().previousInstruction = nil
+
+	// Don't leave entries pointing past the end
+	lines := c.lineTable()
+	for len(lines) > 0 && lines[len(lines)-1].Offset >= len(*currentInstructions) {
+		lines = lines[:len(lines)-1]
+	}
+	c.setLineTable(lines)
 }
 
 func (c *Compiler) replaceInstruction(position int, newInstruction []byte) {
@@ -489,3 +609,159 @@ func (c *Compiler) replaceInstruction(position int, newInstruction []byte) {
 		(*c.currentScope().instructions)[position+i] = newInstruction[i]
 	}
 }
+
+// Compiles an imported file into the current instructions, the first time
+// it's imported, and names it
+func (c *Compiler) compileImport(node *ast.ImportStatement) error {
+	c.enterStatement(node.Pos())
+	c.addLineEntry(len(*c.currentInstructions()))
+
+	path := c.Loader.Resolve(node.Path.Value)
+
+	imported := c.symbols.ImportedModule(path)
+	if imported == nil {
+		var err error
+		imported, err = c.compileModule(path)
+
+		switch err := err.(type) {
+		case nil:
+		case *Error:
+			return &Error{node.Pos(), fmt.Sprintf("import %q: %s:%s: %s", node.Path.Value, path, err.Position, err.Message)}
+		default:
+			return &Error{node.Pos(), fmt.Sprintf("import %q: %s", node.Path.Value, err)}
+		}
+	}
+
+	c.symbols.DefineModule(node.Name.Value, imported)
+
+	return nil
+}
+
+func (c *Compiler) compileModule(path string) (*Module, error) {
+	program, err := c.Loader.Enter(path)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Loader.Leave()
+
+	macros := object.NewEnvironment()
+	if c.MacroContext != nil {
+		macros.SetContext(c.MacroContext)
+	}
+	evaluator.DefineMacros(program, macros)
+	expanded, err := evaluator.ExpandMacros(program, macros)
+	switch err := err.(type) {
+	case nil:
+	case *evaluator.MacroError:
+		return nil, &Error{err.Position, err.Message}
+	default:
+		return nil, err
+	}
+
+	symbols, exports, file, position := c.symbols, c.exports, c.file, c.position
+
+	c.symbols = NewModuleSymbolTable(symbols, path+":")
+	for i, value := range object.Builtins {
+		c.symbols.DefineBuiltin(i, value.Name)
+	}
+	c.exports = map[string]Symbol{}
+	c.file = path
+
+	err = c.Compile(expanded)
+	imported := &Module{Path: path, Exports: c.exports}
+
+	// The module's positions don't carry on into the importer's code
+	c.position = token.Position{}
+	c.addLineEntry(len(*c.currentInstructions()))
+
+	c.symbols, c.exports, c.file, c.position = symbols, exports, file, position
+
+	return imported, err
+}
+
+func (c *Compiler) compileExport(node *ast.ExportStatement) error {
+	err := c.Compile(node.Statement)
+	if err != nil {
+		return err
+	}
+
+	symbol, _ := c.symbols.Resolve(node.Statement.Name.Value)
+	c.exports[symbol.Name] = symbol
+
+	return nil
+}
+
+func (c *Compiler) enterStatement(position token.Position) {
+	c.position = position
+	c.currentScope().statementPending = true
+}
+
+// Records the current source position for an instruction emitted at offset,
+// if it differs from that of the preceding instruction. An invalid position
+// only ends the preceding entry, in the table of an imported file.
+func (c *Compiler) addLineEntry(offset int) {
+	scope := c.currentScope()
+
+	if !c.position.IsValid() && c.file == "" {
+		return
+	}
+
+	entry := opcode.LineEntry{
+		Offset:      offset,
+		Position:    c.position,
+		IsStatement: scope.statementPending && c.position.IsValid(),
+	}
+	if c.position.IsValid() {
+		scope.statementPending = false
+	}
+
+	lines := c.lineTable()
+	if len(lines) == 0 && !entry.Position.IsValid() {
+		return
+	}
+	defer func() { c.setLineTable(lines) }()
+
+	if len(lines) == 0 {
+		lines = append(lines, entry)
+		return
+	}
+
+	last := &lines[len(lines)-1]
+
+	if last.Offset == offset {
+		*last = entry
+		return
+	}
+
+	if last.Position == entry.Position && !entry.IsStatement {
+		return
+	}
+
+	lines = append(lines, entry)
+}
+
+// The lines of the file being compiled, in the current scope. Positions in
+// imported files would be taken for ones in the main file, so they're kept
+// apart; in the main file's lines their code runs as part of the import
+// statement.
+func (c *Compiler) lineTable() opcode.LineTable {
+	if c.file == "" {
+		return c.currentScope().lines
+	}
+
+	return c.currentScope().imported[c.file]
+}
+
+func (c *Compiler) setLineTable(lines opcode.LineTable) {
+	scope := c.currentScope()
+
+	if c.file == "" {
+		scope.lines = lines
+		return
+	}
+
+	if scope.imported == nil {
+		scope.imported = map[string]opcode.LineTable{}
+	}
+	scope.imported[c.file] = lines
+}
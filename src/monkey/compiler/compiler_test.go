@@ -7,6 +7,8 @@ import (
 	"monkey/object"
 	"monkey/opcode"
 	"monkey/parser"
+	"monkey/token"
+	"strings"
 	"testing"
 )
 
@@ -116,11 +118,11 @@ func TestBooleanExpressions(t *testing.T) {
 		},
 		{
 			input:             "2 < 1",
-			expectedConstants: []interface{}{1, 2},
+			expectedConstants: []interface{}{2, 1},
 			expectedInstructions: []opcode.Instruction{
 				opcode.MakeInstruction(opcode.OpGetConstant, 0),
 				opcode.MakeInstruction(opcode.OpGetConstant, 1),
-				opcode.MakeInstruction(opcode.OpGreaterThan),
+				opcode.MakeInstruction(opcode.OpLessThan),
 				opcode.MakeInstruction(opcode.OpPop),
 			},
 		},
@@ -298,7 +300,7 @@ func TestHashLiterals(t *testing.T) {
 		},
 		{
 			input:             "{1: 2, 5: 6, 3: 4}",
-			expectedConstants: []interface{}{1, 2, 3, 4, 5, 6},
+			expectedConstants: []interface{}{1, 2, 5, 6, 3, 4},
 			expectedInstructions: []opcode.Instruction{
 				opcode.MakeInstruction(opcode.OpGetConstant, 0),
 				opcode.MakeInstruction(opcode.OpGetConstant, 1),
@@ -1005,3 +1007,218 @@ func parse(input string) *ast.Program {
 	p := parser.New(l)
 	return p.ParseProgram()
 }
+
+func TestDebugInformation(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x) {
+	let y = x +
+		a;
+
+	fn() { y }
+};
+f(2)`
+
+	compiler := New()
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("Compilation failed: %s\n", err)
+	}
+
+	bytecode := compiler.Bytecode()
+
+	expectedLines := []int{1, 2, 8}
+	actualLines := bytecode.Lines.StatementLines()
+	if fmt.Sprint(actualLines) != fmt.Sprint(expectedLines) {
+		t.Errorf("statement lines %v wrong, expected %v", actualLines, expectedLines)
+	}
+
+	if fmt.Sprint(bytecode.GlobalNames) != "[a f]" {
+		t.Errorf("global names %v wrong, expected [a f]", bytecode.GlobalNames)
+	}
+
+	inner, ok := bytecode.Constants[1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 1 not a function, got %T", bytecode.Constants[1])
+	}
+
+	if fmt.Sprint(inner.FreeNames) != "[y]" {
+		t.Errorf("free names %v wrong, expected [y]", inner.FreeNames)
+	}
+
+	outer, ok := bytecode.Constants[2].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 2 not a function, got %T", bytecode.Constants[2])
+	}
+
+	if outer.Name != "f" {
+		t.Errorf("function name %q wrong, expected %q", outer.Name, "f")
+	}
+
+	if fmt.Sprint(outer.LocalNames) != "[x y]" {
+		t.Errorf("local names %v wrong, expected [x y]", outer.LocalNames)
+	}
+
+	expectedLines = []int{3, 6}
+	actualLines = outer.Lines.StatementLines()
+	if fmt.Sprint(actualLines) != fmt.Sprint(expectedLines) {
+		t.Errorf("function statement lines %v wrong, expected %v", actualLines, expectedLines)
+	}
+}
+
+// Stands in for os.ReadFile
+func testFiles(files map[string]string) func(string) ([]byte, error) {
+	return func(name string) ([]byte, error) {
+		source, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("open %s: no such file", name)
+		}
+		return []byte(source), nil
+	}
+}
+
+func TestImports(t *testing.T) {
+	input := `import "lib.mk" as lib;
+import "./lib.mk" as again;
+lib.x;
+again.x`
+
+	compiler := New()
+	compiler.Loader.ReadFile = testFiles(map[string]string{
+		"lib.mk": "let hidden = 1;\nexport let x = hidden;",
+	})
+
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("Compilation failed: %s\n", err)
+	}
+
+	bytecode := compiler.Bytecode()
+
+	expectedInstructions := concatInstructions([]opcode.Instruction{
+		// The module, only once
+		opcode.MakeInstruction(opcode.OpGetConstant, 0),
+		opcode.MakeInstruction(opcode.OpSetGlobal, 0),
+		opcode.MakeInstruction(opcode.OpGetGlobal, 0),
+		opcode.MakeInstruction(opcode.OpSetGlobal, 1),
+		// lib.x and again.x
+		opcode.MakeInstruction(opcode.OpGetGlobal, 1),
+		opcode.MakeInstruction(opcode.OpPop),
+		opcode.MakeInstruction(opcode.OpGetGlobal, 1),
+		opcode.MakeInstruction(opcode.OpPop),
+	})
+
+	err = testInstructions(expectedInstructions, bytecode.Instructions)
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s\n", err)
+	}
+
+	if fmt.Sprint(bytecode.GlobalNames) != "[lib.mk:hidden lib.mk:x]" {
+		t.Errorf("global names %v wrong, expected [lib.mk:hidden lib.mk:x]", bytecode.GlobalNames)
+	}
+
+	// The module's code counts as the first import's
+	expectedLines := []int{1, 3, 4}
+	if fmt.Sprint(bytecode.Lines.StatementLines()) != fmt.Sprint(expectedLines) {
+		t.Errorf("statement lines %v wrong, expected %v", bytecode.Lines.StatementLines(), expectedLines)
+	}
+
+	// And has lines of its own, ending with it
+	imported := bytecode.ImportedLines["lib.mk"]
+	if fmt.Sprint(imported.StatementLines()) != "[1 2]" {
+		t.Errorf("statement lines of lib.mk %v wrong, expected [1 2]", imported.StatementLines())
+	}
+	if imported.PositionFor(6).Line != 2 || imported.PositionFor(12).IsValid() {
+		t.Errorf("wrong lines of lib.mk: %+v", imported)
+	}
+	if bytecode.ImportedSources["lib.mk"] != "let hidden = 1;\nexport let x = hidden;" {
+		t.Errorf("source of lib.mk not kept, got %q", bytecode.ImportedSources["lib.mk"])
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	files := map[string]string{
+		"lib/math.mk":   `let square = fn(x) { x * x }; export let pi = 3;`,
+		"lib/broken.mk": `export let x = y;`,
+		"lib/syntax.mk": `let = 1;`,
+		"cycle/a.mk":    `import "b.mk" as b;`,
+		"cycle/b.mk":    `import "a.mk" as a;`,
+	}
+
+	tests := []struct {
+		input    string
+		position token.Position
+		expected string
+	}{
+		{`import "lib/math.mk" as m; m.square`, token.Position{Line: 1, Column: 30}, "lib/math.mk doesn't export square"},
+		{`import "lib/math.mk" as m; m`, token.Position{Line: 1, Column: 28}, "module m can only be used for its members, like m.name"},
+		{`import "lib/math.mk" as m; fn() { m }`, token.Position{Line: 1, Column: 35}, "module m can only be used for its members, like m.name"},
+		{`let x = 1; x.y`, token.Position{Line: 1, Column: 12}, "x is not a module"},
+		{`[1].y`, token.Position{Line: 1, Column: 1}, "[1] is not a module"},
+		{`if (true) { import "lib/math.mk" as m }`, token.Position{Line: 1, Column: 13}, "imports must be at the top level"},
+		{`fn() { export let x = 1 }`, token.Position{Line: 1, Column: 8}, "exports must be at the top level"},
+		{`import "missing.mk" as m`, token.Position{Line: 1, Column: 1}, `import "missing.mk": open missing.mk: no such file`},
+		{`import "lib/broken.mk" as m`, token.Position{Line: 1, Column: 1}, `import "lib/broken.mk": lib/broken.mk:1:16: Symbol "y" not found`},
+		{`import "lib/syntax.mk" as m`, token.Position{Line: 1, Column: 1}, "import \"lib/syntax.mk\": parser errors:\n\tlib/syntax.mk:1:5: expected next token to be IDENT, got = instead\n\tlib/syntax.mk:1:5: no prefix parse function for = found"},
+		{
+			`import "cycle/a.mk" as a`,
+			token.Position{Line: 1, Column: 1},
+			`import "cycle/a.mk": cycle/a.mk:1:1: import "b.mk": cycle/b.mk:1:1: import "a.mk": import cycle: cycle/a.mk -> cycle/b.mk -> cycle/a.mk`,
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		compiler.Loader.ReadFile = testFiles(files)
+
+		err := compiler.Compile(parse(tt.input))
+
+		compilerErr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("expected a *Error for %q, got %T (%v)", tt.input, err, err)
+			continue
+		}
+
+		if compilerErr.Message != tt.expected || compilerErr.Position != tt.position {
+			t.Errorf("wrong error for %q.\nexpected %s: %q\ngot      %s: %q",
+				tt.input, tt.position, tt.expected, compilerErr.Position, compilerErr.Message)
+		}
+	}
+}
+
+func TestOperandLimits(t *testing.T) {
+	names := make([]string, MaxLocals+1)
+	for i := range names {
+		names[i] = "v" + string(rune('a'+i/26)) + string(rune('a'+i%26))
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"len(" + strings.Repeat("1, ", MaxArguments) + "1)",
+			"too many arguments, 255 is the most",
+		},
+		{
+			"fn(" + strings.Join(names, ", ") + ") { 1 }",
+			"too many parameters and local bindings, 256 is the most",
+		},
+		{
+			"fn() { let " + strings.Join(names, " = 1; let ") + " = 1; }",
+			"too many parameters and local bindings, 256 is the most",
+		},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%v", tt.expected, err)
+		}
+	}
+
+	// Right at the limits is fine
+	err := New().Compile(parse("fn(" + strings.Join(names[:MaxLocals], ", ") + ") { 1 }"))
+	if err != nil {
+		t.Errorf("%d parameters failed to compile: %s", MaxLocals, err)
+	}
+}
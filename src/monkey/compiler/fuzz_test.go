@@ -0,0 +1,28 @@
+package compiler
+
+import (
+	"io/fs"
+	"monkey/lexer"
+	"monkey/parser"
+	"testing"
+)
+
+// Programs that parse either compile or give an error, never a panic
+func FuzzCompile(f *testing.F) {
+	f.Add(`let f = fn(x) { if (x > 0) { f(x - 1) } else { x } }; f(3)`)
+	f.Add(`if (true) { let x = 1 }; x`)
+	f.Add(`import "m.mk" as m; m.x; quote(1); macro(x) { x }`)
+	f.Add(`let a = [1, "two", {true: fn() { a }}]; -a[0] < !a[1]`)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			return
+		}
+
+		c := New()
+		c.Loader.ReadFile = func(name string) ([]byte, error) { return nil, fs.ErrNotExist }
+		c.Compile(program)
+	})
+}
@@ -8,6 +8,7 @@ const (
 	BuiltinScope
 	FreeScope
 	CurrentFunctionScope
+	ModuleScope
 )
 
 type Symbol struct {
@@ -21,8 +22,22 @@ type SymbolTable struct {
 
 	store            map[string]Symbol
 	nonBuiltinsCount int
+	names            []string // Names of defined symbols, indexed by Symbol.Index
 
 	FreeSymbols []Symbol
+
+	// An imported module's globals live in the importing program's table,
+	// named namespace + name there so they don't clash with anyone else's
+	globals   *SymbolTable
+	namespace string
+
+	modules []*Module // Indexed by the Symbol.Index of ModuleScope symbols, only in the outermost table
+}
+
+// An imported file, compiled once however often it's imported
+type Module struct {
+	Path    string
+	Exports map[string]Symbol
 }
 
 // Number of symbols defined (ignoring builtin functions)
@@ -31,14 +46,53 @@ func (st *SymbolTable) Len() int {
 }
 
 func NewSymbolTable() *SymbolTable {
-	return &SymbolTable{nil, make(map[string]Symbol), 0, []Symbol{}}
+	return &SymbolTable{store: make(map[string]Symbol), names: []string{}, FreeSymbols: []Symbol{}}
 }
 
 func NewEnclosedSymbolTable(parent *SymbolTable) *SymbolTable {
-	return &SymbolTable{parent, make(map[string]Symbol), 0, []Symbol{}}
+	return &SymbolTable{Parent: parent, store: make(map[string]Symbol), names: []string{}, FreeSymbols: []Symbol{}}
+}
+
+// The global scope of a module imported by the program whose globals are in globals
+func NewModuleSymbolTable(globals *SymbolTable, namespace string) *SymbolTable {
+	table := NewSymbolTable()
+	table.globals = globals.outermost()
+	table.namespace = namespace
+
+	return table
+}
+
+// The table the program's globals and modules are kept in
+func (st *SymbolTable) outermost() *SymbolTable {
+	for st.Parent != nil {
+		st = st.Parent
+	}
+
+	if st.globals != nil {
+		return st.globals
+	}
+
+	return st
+}
+
+// Names of all symbols defined with Define, indexed by their Symbol.Index.
+// Shadowed names show up multiple times.
+func (st *SymbolTable) DefinedNames() []string {
+	result := make([]string, len(st.names))
+	copy(result, st.names)
+
+	return result
 }
 
 func (st *SymbolTable) Define(name string) Symbol {
+	if st.globals != nil {
+		symbol := st.globals.Define(st.namespace + name)
+		symbol.Name = name
+		st.store[name] = symbol
+
+		return symbol
+	}
+
 	var scope SymbolScope
 
 	if st.Parent == nil {
@@ -55,6 +109,7 @@ func (st *SymbolTable) Define(name string) Symbol {
 
 	st.store[name] = result
 	st.nonBuiltinsCount++
+	st.names = append(st.names, name)
 
 	return result
 }
@@ -81,7 +136,7 @@ func (st *SymbolTable) Resolve(name string) (Symbol, bool) {
 			return result, false
 		}
 
-		if result.Scope == GlobalScope || result.Scope == BuiltinScope {
+		if result.Scope == GlobalScope || result.Scope == BuiltinScope || result.Scope == ModuleScope {
 			return result, true
 		}
 
@@ -118,3 +173,47 @@ func (st *SymbolTable) DefineFunctionName(name string) Symbol {
 
 	return symbol
 }
+
+// Names an imported module. Modules are remembered by the outermost table,
+// so they're only compiled once.
+func (st *SymbolTable) DefineModule(name string, module *Module) Symbol {
+	outermost := st.outermost()
+
+	index := -1
+	for i, imported := range outermost.modules {
+		if imported == module {
+			index = i
+		}
+	}
+
+	if index < 0 {
+		outermost.modules = append(outermost.modules, module)
+		index = len(outermost.modules) - 1
+	}
+
+	symbol := Symbol{
+		Name:  name,
+		Scope: ModuleScope,
+		Index: index,
+	}
+
+	st.store[name] = symbol
+
+	return symbol
+}
+
+// The module a ModuleScope symbol names
+func (st *SymbolTable) Module(symbol Symbol) *Module {
+	return st.outermost().modules[symbol.Index]
+}
+
+// The module compiled from the file at path, nil if it hasn't been imported yet
+func (st *SymbolTable) ImportedModule(path string) *Module {
+	for _, module := range st.outermost().modules {
+		if module.Path == path {
+			return module
+		}
+	}
+
+	return nil
+}
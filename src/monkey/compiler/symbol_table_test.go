@@ -343,3 +343,47 @@ func TestShadowingFunctionName(t *testing.T) {
 			expected.Name, expected, result)
 	}
 }
+
+func TestModuleSymbolTables(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	lib := NewModuleSymbolTable(global, "lib.mk:")
+	if symbol := lib.Define("a"); symbol != (Symbol{Name: "a", Scope: GlobalScope, Index: 1}) {
+		t.Errorf("Wrong module global %+v", symbol)
+	}
+
+	nested := NewModuleSymbolTable(lib, "nested.mk:")
+	if symbol := nested.Define("b"); symbol != (Symbol{Name: "b", Scope: GlobalScope, Index: 2}) {
+		t.Errorf("Wrong nested module global %+v", symbol)
+	}
+
+	if _, ok := lib.Resolve("b"); ok {
+		t.Errorf("Globals of one module visible in another")
+	}
+
+	names := global.DefinedNames()
+	if len(names) != 3 || names[1] != "lib.mk:a" || names[2] != "nested.mk:b" {
+		t.Errorf("Wrong global names %v", names)
+	}
+
+	module := &Module{Path: "nested.mk"}
+	symbol := lib.DefineModule("n", module)
+	if symbol != (Symbol{Name: "n", Scope: ModuleScope, Index: 0}) {
+		t.Errorf("Wrong module symbol %+v", symbol)
+	}
+
+	local := NewEnclosedSymbolTable(lib)
+	resolved, ok := local.Resolve("n")
+	if !ok || resolved != symbol || len(local.FreeSymbols) != 0 {
+		t.Errorf("Module not resolved like a global, got %+v", resolved)
+	}
+
+	if global.ImportedModule("nested.mk") != module || local.Module(resolved) != module {
+		t.Errorf("Module not kept in the outermost table")
+	}
+
+	if again := global.DefineModule("m", module); again.Index != 0 {
+		t.Errorf("Module kept twice, at %d", again.Index)
+	}
+}
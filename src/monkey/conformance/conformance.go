@@ -0,0 +1,137 @@
+// Runs programs on the evaluator and both VMs, so they can be held to the
+// same semantics:
+//
+//   - null, false and 0 are falsy, everything else is truthy
+//   - operands and arguments are evaluated left to right
+//   - a runtime error stops the program, including errors from builtins
+//   - dividing by zero is a runtime error
+//
+// Only undefined names are reported differently: the VMs' compilers reject
+// the whole program, the evaluator fails when it reaches them.
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/module"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/regvm"
+	"monkey/vm"
+	"strings"
+)
+
+// Runs the program in source, imports relative to filename, giving what it
+// printed followed by the error that stopped it if any
+type Engine func(filename, source string) string
+
+func Evaluator(filename, source string) (result string) {
+	program, output, ok := parse(source)
+	if !ok {
+		return output.String()
+	}
+
+	defer recoverPanic(output, &result)
+
+	env := object.NewEnvironment()
+	env.SetLoader(module.NewLoader(filename))
+	env.SetContext(&object.Context{Stdout: output, Stderr: output})
+
+	evaluated := evaluator.Eval(program, env)
+	if err, ok := evaluated.(*object.Error); ok {
+		fmt.Fprintf(output, "ERROR: %s\n", err.Message)
+	}
+
+	return output.String()
+}
+
+func VM(filename, source string) (result string) {
+	program, output, ok := parse(source)
+	if !ok {
+		return output.String()
+	}
+
+	c := compiler.New()
+	c.Loader = module.NewLoader(filename)
+	c.MacroContext = &object.Context{Stdout: output, Stderr: output}
+	err := c.Compile(program)
+	if err != nil {
+		fmt.Fprintf(output, "ERROR: %s\n", err)
+		return output.String()
+	}
+
+	machine := vm.New(c.Bytecode())
+	machine.SetContext(&object.Context{Stdout: output, Stderr: output})
+
+	defer recoverPanic(output, &result)
+
+	err = machine.Execute()
+	if err != nil {
+		fmt.Fprintf(output, "ERROR: %s\n", err)
+	}
+
+	return output.String()
+}
+
+func Register(filename, source string) (result string) {
+	program, output, ok := parse(source)
+	if !ok {
+		return output.String()
+	}
+
+	c := regvm.NewCompiler()
+	c.Loader = module.NewLoader(filename)
+	c.MacroContext = &object.Context{Stdout: output, Stderr: output}
+	err := c.Compile(program)
+	if err != nil {
+		fmt.Fprintf(output, "ERROR: %s\n", err)
+		return output.String()
+	}
+
+	machine := regvm.New(c.Bytecode())
+	machine.SetContext(&object.Context{Stdout: output, Stderr: output})
+
+	defer recoverPanic(output, &result)
+
+	err = machine.Execute()
+	if err != nil {
+		fmt.Fprintf(output, "ERROR: %s\n", err)
+	}
+
+	return output.String()
+}
+
+// Macros are expanded for every engine the same way
+func parse(source string) (*ast.Program, *bytes.Buffer, bool) {
+	var output bytes.Buffer
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		fmt.Fprintf(&output, "PARSE ERROR: %s\n", strings.Join(p.Errors(), "\n"))
+		return nil, &output, false
+	}
+
+	// What macros print comes before what the program does
+	macros := object.NewEnvironment()
+	macros.SetContext(&object.Context{Stdout: &output, Stderr: &output})
+	evaluator.DefineMacros(program, macros)
+	expanded, err := evaluator.ExpandMacros(program, macros)
+	if err != nil {
+		fmt.Fprintf(&output, "ERROR: %s\n", err)
+		return nil, &output, false
+	}
+
+	return expanded.(*ast.Program), &output, true
+}
+
+// No engine should panic, but if one does that's reported like an error
+func recoverPanic(output *bytes.Buffer, result *string) {
+	if r := recover(); r != nil {
+		*result = fmt.Sprintf("%sPANIC: %v\n", output, r)
+	}
+}
@@ -0,0 +1,71 @@
+package conformance
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected output in testdata, if the engines agree")
+
+// Runs each testdata/*.mk on every engine and compares what they print to
+// the .out next to it, and to each other
+func TestConformance(t *testing.T) {
+	sources, err := filepath.Glob(filepath.Join("testdata", "*.mk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engines := []struct {
+		name string
+		run  Engine
+	}{
+		{"evaluator", Evaluator},
+		{"vm", VM},
+		{"register", Register},
+	}
+
+	for _, source := range sources {
+		input, err := os.ReadFile(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results := make([]string, len(engines))
+		for i, engine := range engines {
+			results[i] = engine.run(source, string(input))
+		}
+
+		agree := true
+		for i := 1; i < len(engines); i++ {
+			if results[i] != results[0] {
+				agree = false
+				t.Errorf("%s: engines diverge.\n%s:\n%s%s:\n%s",
+					source, engines[0].name, results[0], engines[i].name, results[i])
+			}
+		}
+
+		expectedFile := strings.TrimSuffix(source, ".mk") + ".out"
+		if *update && agree {
+			err := os.WriteFile(expectedFile, []byte(results[0]), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expected, err := os.ReadFile(expectedFile)
+		if err != nil {
+			t.Errorf("%s: %s, run the tests with -update to create it", source, err)
+			continue
+		}
+
+		for i, engine := range engines {
+			if results[i] != string(expected) {
+				t.Errorf("%s: %s output differs from %s.\nexpected:\n%sgot:\n%s",
+					source, engine.name, expectedFile, expected, results[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,9 @@
+puts(1 + 2 * 3 - 4 / 2);
+puts((1 + 2) * 3);
+puts(-5 + 10);
+puts(--5);
+puts(7 / 2, -7 / 2);
+puts(2 > 1, 1 > 2, 1 < 2, 2 < 1);
+puts(1 == 1, 1 != 1);
+let x = 5;
+puts(-x, x);
@@ -0,0 +1,11 @@
+assert(true);
+assert(1, "one is truthy");
+puts(assert_eq([1, {"a": 2}], [1, {"a": 2}]));
+puts(assert_error(fn() { 1 / 0 }));
+puts(assert_error(fn() { assert_eq("1", 1) }, "expected 1"));
+puts(assert_error(fn() { assert(0, "zero") }));
+puts(assert_error(fn() { assert_error(fn() { 1 }) }));
+let nested = fn(x) { if (x == 0) { assert(false, "bottom") } else { nested(x - 1) } };
+puts(assert_error(fn() { nested(10) }, "bottom"));
+puts(assert_error(len, "wrong number"));
+puts("still running");
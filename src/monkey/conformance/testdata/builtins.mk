@@ -0,0 +1,6 @@
+puts(puts("printed"));
+puts();
+puts(len([]), len(""), len([1, [2, 3]]));
+puts(push([], 1), rest([1]));
+let r = puts("value");
+puts(r == first([]));
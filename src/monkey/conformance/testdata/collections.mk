@@ -0,0 +1,11 @@
+let a = [1, 2 * 2, "three", [4]];
+puts(a, len(a), a[1], a[3][0], a[10], a[-1]);
+puts(first(a), last(a), rest(a), push(a, 5), a);
+puts(first([]), last([]), rest([]));
+let h = {"b": 1, "a": 2, 3: "c", true: [1], [1, 2]: "array"};
+puts(h, h["a"], h[3], h[true], h[[1, 2]], h["missing"]);
+puts(keys(h), values(h));
+puts([1, [2]] == [1, [2]], {"a": 1, "b": 2} == {"b": 2, "a": 1}, [1] == [2]);
+puts(json_stringify({"list": [1, true, first([])], "name": "monkey"}));
+let strings = {"b": [1, {"c": true}], "a": "x"};
+puts(json_parse(json_stringify(strings)) == strings);
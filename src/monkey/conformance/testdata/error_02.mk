@@ -0,0 +1,3 @@
+puts("start");
+let result = true + false;
+puts("unreachable");
@@ -0,0 +1,3 @@
+puts("start");
+let result = 5(1);
+puts("unreachable");
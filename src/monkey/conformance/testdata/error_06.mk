@@ -0,0 +1,3 @@
+puts("start");
+let result = {"a": 1}[fn(x) { x }];
+puts("unreachable");
@@ -0,0 +1,3 @@
+puts("start");
+let result = [1]["a"];
+puts("unreachable");
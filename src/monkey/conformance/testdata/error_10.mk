@@ -0,0 +1,3 @@
+puts("start");
+let result = fn(a) { a }();
+puts("unreachable");
@@ -0,0 +1,3 @@
+puts("start");
+let result = fn() { 1 }(2);
+puts("unreachable");
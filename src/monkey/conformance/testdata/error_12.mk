@@ -0,0 +1,3 @@
+puts("start");
+let result = len(1);
+puts("unreachable");
@@ -0,0 +1,3 @@
+puts("start");
+let result = push(1, 2);
+puts("unreachable");
@@ -0,0 +1,3 @@
+puts("start");
+let result = "a" < "b";
+puts("unreachable");
@@ -0,0 +1,3 @@
+puts("start");
+let result = {[fn() { 1 }]: 1};
+puts("unreachable");
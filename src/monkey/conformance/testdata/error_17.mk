@@ -0,0 +1,3 @@
+puts("start");
+let result = substring("abc", 5);
+puts("unreachable");
@@ -0,0 +1,3 @@
+puts("start");
+assert_eq(len("abc"), 4, "length");
+puts("unreachable");
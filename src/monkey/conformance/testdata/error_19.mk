@@ -0,0 +1 @@
+assert_error(fn() { "fine" }, "boom");
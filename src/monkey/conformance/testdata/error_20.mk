@@ -0,0 +1,3 @@
+puts("start");
+let line = repeat("ab", 9223372036854775807);
+puts("unreachable");
@@ -0,0 +1,29 @@
+let add = fn(a, b) { a + b };
+let twice = fn(f, x) { f(f(x)) };
+puts(add(1, 2), twice(fn(x) { x * 2 }, 3));
+
+let counter = fn(start) {
+  let next = fn(step) { start + step };
+  next
+};
+puts(counter(10)(5));
+
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+puts(fib(15));
+
+let early = fn(x) {
+  if (x > 0) { return "positive"; }
+  "not positive"
+};
+puts(early(1), early(0));
+
+let nothing = fn() { };
+puts(nothing());
+
+let map = fn(array, f) {
+  let iter = fn(remaining, result) {
+    if (len(remaining) == 0) { result } else { iter(rest(remaining), push(result, f(first(remaining)))) }
+  };
+  iter(array, [])
+};
+puts(map([1, 2, 3], fn(x) { x * x }));
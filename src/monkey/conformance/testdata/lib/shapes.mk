@@ -0,0 +1,5 @@
+let pi = 3;
+let traced = macro(x) { puts("expanding shapes"); x };
+puts("loading shapes");
+export let area = fn(r) { pi * r * r };
+export let name = traced("shapes");
@@ -0,0 +1,10 @@
+let unless = macro(condition, consequence, alternative) {
+  quote(if (!(unquote(condition))) { unquote(consequence) } else { unquote(alternative) })
+};
+unless(10 > 5, puts("not greater"), puts("greater"));
+let square = macro(x) { quote(unquote(x) * unquote(x)) };
+puts(square(1 + 2));
+let pairs = macro(a, b) { quote({unquote(b): 1, unquote(a): 2}) };
+puts(pairs("x", "y"));
+let traced = macro(x) { puts("expanding"); x };
+puts(traced("traced"));
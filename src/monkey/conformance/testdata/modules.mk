@@ -0,0 +1,3 @@
+import "lib/shapes.mk" as shapes;
+import "lib/shapes.mk" as again;
+puts(shapes.area(2), shapes.name, again.name);
@@ -0,0 +1,9 @@
+let say = fn(value) { puts(value); value };
+say(1) + say(2);
+say(3) < say(4);
+say(5) > say(6);
+[say(7), say(8)];
+{say("k1"): say(9), say("k2"): say(10)};
+let f = fn(a, b) { a };
+f(say(11), say(12));
+say([13])[say(0)];
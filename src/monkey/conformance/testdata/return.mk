@@ -0,0 +1,3 @@
+puts("before");
+if (true) { return 10; }
+puts("after");
@@ -0,0 +1,8 @@
+let greeting = "Hello" + ", " + "World!";
+puts(greeting);
+puts(len(greeting), len("日本語"));
+puts("a" == "a", "a" == "b", "a" != "b");
+puts(split("a,b,c", ","), join(["x", "y"], "-"));
+puts(upper("monkey"), lower("MONKEY"), trim("  x  "));
+puts(substring("monkey", 3), index_of("monkey", "key"));
+puts(parse_int("42") + 1, to_string(42) + "!");
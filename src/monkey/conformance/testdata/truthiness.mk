@@ -0,0 +1,16 @@
+let check = fn(name, value) {
+  if (value) { puts(name + " is truthy") } else { puts(name + " is falsy") }
+};
+check("true", true);
+check("false", false);
+check("0", 0);
+check("1", 1);
+check("-1", -1);
+check("null", first([]));
+check("empty string", "");
+check("string", "a");
+check("empty array", []);
+check("empty hash", {});
+check("function", fn() { 0 });
+puts(!0, !1, !"", ![], !first([]));
+puts(if (false) { 1 });
@@ -0,0 +1,348 @@
+// Records which lines and branches of a script a VM executes
+package coverage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"monkey/compiler"
+	"monkey/object"
+	"monkey/opcode"
+	"monkey/vm"
+	"sort"
+	"strings"
+)
+
+// Hit counts of one script and the files it imports, added up over every
+// run of its bytecode
+type Profile struct {
+	Files []*File // The script first, then what it imports by path
+
+	bytecode  *compiler.Bytecode
+	functions map[*object.CompiledFunction]*function
+}
+
+type File struct {
+	Filename string
+	Source   string
+
+	lines    map[int]int64 // Times a statement starting on the line was executed
+	branches []*Branch
+}
+
+// The two ways an if expression can go, taking the consequence or not
+type Branch struct {
+	Line  int
+	Block int // Which if on the line, from the first
+
+	Then int64
+	Else int64 // Also counts ifs without an alternative being skipped
+}
+
+// Whether the condition was ever evaluated
+func (b *Branch) Executed() bool {
+	return b.Then+b.Else > 0
+}
+
+type Line struct {
+	Number int
+	Hits   int64
+}
+
+// A function's line tables, each with the file it counts for, and its
+// branches by the offset of their OpJumpNotTruthy
+type function struct {
+	tables   []table
+	branches map[int]*Branch
+}
+
+type table struct {
+	file  *File
+	lines opcode.LineTable
+}
+
+func New(bytecode *compiler.Bytecode, filename string, source string) *Profile {
+	p := &Profile{
+		bytecode:  bytecode,
+		functions: map[*object.CompiledFunction]*function{},
+	}
+
+	p.Files = append(p.Files, newFile(filename, source))
+
+	paths := []string{}
+	for path := range bytecode.ImportedLines {
+		paths = append(paths, path)
+	}
+	for _, constant := range bytecode.Constants {
+		if compiled, ok := constant.(*object.CompiledFunction); ok {
+			for path := range compiled.ImportedLines {
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	files := map[string]*File{}
+	for _, path := range paths {
+		if files[path] == nil {
+			files[path] = newFile(path, bytecode.ImportedSources[path])
+			p.Files = append(p.Files, files[path])
+		}
+	}
+
+	// Every line with a statement is reported, executed or not
+	p.addFunction(nil, bytecode.Instructions, bytecode.Lines, bytecode.ImportedLines, files)
+	for _, constant := range bytecode.Constants {
+		if compiled, ok := constant.(*object.CompiledFunction); ok {
+			p.addFunction(compiled, compiled.Instructions, compiled.Lines, compiled.ImportedLines, files)
+		}
+	}
+
+	for _, file := range p.Files {
+		sort.SliceStable(file.branches, func(i, j int) bool {
+			return file.branches[i].Line < file.branches[j].Line
+		})
+
+		blocks := map[int]int{}
+		for _, branch := range file.branches {
+			branch.Block = blocks[branch.Line]
+			blocks[branch.Line]++
+		}
+	}
+
+	return p
+}
+
+func newFile(filename string, source string) *File {
+	return &File{Filename: filename, Source: source, lines: map[int]int64{}}
+}
+
+// The main program is keyed by nil until a VM gives it a function
+func (p *Profile) addFunction(compiled *object.CompiledFunction, instructions opcode.Instructions, lines opcode.LineTable, imported map[string]opcode.LineTable, files map[string]*File) {
+	f := &function{branches: map[int]*Branch{}}
+	p.functions[compiled] = f
+
+	// Imported code also counts as the import statement's in the script's
+	// lines, so their own come first
+	paths := []string{}
+	for path := range imported {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		f.tables = append(f.tables, table{files[path], imported[path]})
+	}
+	f.tables = append(f.tables, table{p.Files[0], lines})
+
+	for _, t := range f.tables {
+		for _, line := range t.lines.StatementLines() {
+			t.file.lines[line] += 0
+		}
+	}
+
+	for offset := 0; offset < len(instructions); {
+		definition := opcode.Lookup(opcode.OpCode(instructions[offset]))
+
+		if opcode.OpCode(instructions[offset]) == opcode.OpJumpNotTruthy {
+			for _, t := range f.tables {
+				position := t.lines.PositionFor(offset)
+				if position.IsValid() {
+					branch := &Branch{Line: position.Line}
+					f.branches[offset] = branch
+					t.file.branches = append(t.file.branches, branch)
+					break
+				}
+			}
+		}
+
+		_, read := opcode.ReadOperands(definition, instructions[offset+1:])
+		offset += 1 + read
+	}
+}
+
+// Executes the program to the end, or to the runtime error stopping it,
+// which is returned. The VM has to run the bytecode the profile was made for.
+func (p *Profile) Run(machine *vm.VM) error {
+	main := machine.Frames()[0].Function()
+	p.functions[main] = p.functions[nil]
+	defer delete(p.functions, main)
+
+	// Including the functions builtins call
+	machine.SetStepper(func() error { return p.step(machine) })
+	defer machine.SetStepper(nil)
+
+	for !machine.Finished() {
+		err := p.step(machine)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Profile) step(machine *vm.VM) error {
+	frames := machine.Frames()
+	frame := frames[len(frames)-1]
+
+	compiled := frame.Function()
+	offset := frame.InstructionPointer()
+	instructions := *frame.Instructions()
+
+	f, ok := p.functions[compiled]
+	if !ok {
+		return machine.Step()
+	}
+
+	// The first statement of an imported file also starts the import
+	for _, t := range f.tables {
+		if line, ok := t.lines.StatementAt(offset); ok {
+			t.file.lines[line]++
+		}
+	}
+
+	err := machine.Step()
+	if err != nil {
+		return err
+	}
+
+	if opcode.OpCode(instructions[offset]) != opcode.OpJumpNotTruthy {
+		return nil
+	}
+
+	branch, ok := f.branches[offset]
+	if !ok {
+		return nil
+	}
+
+	target := int(binary.BigEndian.Uint16(instructions[offset+1:]))
+	if frame.InstructionPointer() == target {
+		branch.Else++
+	} else {
+		branch.Then++
+	}
+
+	return nil
+}
+
+// Every line with a statement on it, in order
+func (f *File) Lines() []Line {
+	result := []Line{}
+	for number, hits := range f.lines {
+		result = append(result, Line{Number: number, Hits: hits})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Number < result[j].Number
+	})
+
+	return result
+}
+
+// Every if expression, in the order they appear
+func (f *File) Branches() []*Branch {
+	return f.branches
+}
+
+// How many lines were executed, out of how many could have been
+func (f *File) LineCoverage() (hit int, found int) {
+	for _, hits := range f.lines {
+		if hits > 0 {
+			hit++
+		}
+	}
+
+	return hit, len(f.lines)
+}
+
+// How many of the two ways each if can go were taken, out of how many there are
+func (f *File) BranchCoverage() (hit int, found int) {
+	for _, branch := range f.branches {
+		if branch.Then > 0 {
+			hit++
+		}
+		if branch.Else > 0 {
+			hit++
+		}
+	}
+
+	return hit, 2 * len(f.branches)
+}
+
+// Adds up the counts of a profile of the same file
+func (f *File) add(other *File) {
+	for line, hits := range other.lines {
+		f.lines[line] += hits
+	}
+
+	type key struct{ line, block int }
+	branches := map[key]*Branch{}
+	for _, branch := range f.branches {
+		branches[key{branch.Line, branch.Block}] = branch
+	}
+
+	for _, branch := range other.branches {
+		existing, ok := branches[key{branch.Line, branch.Block}]
+		if !ok {
+			existing = &Branch{Line: branch.Line, Block: branch.Block}
+			f.branches = append(f.branches, existing)
+		}
+
+		existing.Then += branch.Then
+		existing.Else += branch.Else
+	}
+}
+
+// The files of the profiles, those several scripts import added up once
+func merge(profiles []*Profile) []*File {
+	result := []*File{}
+	files := map[string]*File{}
+
+	for _, p := range profiles {
+		for _, file := range p.Files {
+			merged, ok := files[file.Filename]
+			if !ok {
+				merged = newFile(file.Filename, file.Source)
+				files[file.Filename] = merged
+				result = append(result, merged)
+			}
+
+			merged.add(file)
+		}
+	}
+
+	return result
+}
+
+// How many lines of every file were executed, out of how many could have been
+func (p *Profile) LineCoverage() (hit int, found int) {
+	for _, file := range p.Files {
+		fileHit, fileFound := file.LineCoverage()
+		hit, found = hit+fileHit, found+fileFound
+	}
+
+	return hit, found
+}
+
+// Like LineCoverage, for the ways each if can go
+func (p *Profile) BranchCoverage() (hit int, found int) {
+	for _, file := range p.Files {
+		fileHit, fileFound := file.BranchCoverage()
+		hit, found = hit+fileHit, found+fileFound
+	}
+
+	return hit, found
+}
+
+// Like "75% of lines, 50% of branches"
+func (p *Profile) Summary() string {
+	return fmt.Sprintf("%s of lines, %s of branches", percent(p.LineCoverage()), percent(p.BranchCoverage()))
+}
+
+func percent(hit int, found int) string {
+	if found == 0 {
+		return "-"
+	}
+
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(hit)/float64(found)*100), ".0") + "%"
+}
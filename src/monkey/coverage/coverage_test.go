@@ -0,0 +1,254 @@
+package coverage
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/vm"
+	"strings"
+	"testing"
+)
+
+const sign = `let sign = fn(x) {
+  if (x < 0) { -1 } else { if (x == 0) { 0 } else { 1 } }
+};
+let unused = fn() {
+  puts("never");
+};
+sign(5);
+sign(-3);
+if (sign(1) > 5) { puts("big") };
+`
+
+func profile(t *testing.T, input string, runs int) *Profile {
+	t.Helper()
+
+	return profileImporting(t, "sign.mk", input, nil, runs)
+}
+
+func profileImporting(t *testing.T, filename string, input string, files map[string]string, runs int) *Profile {
+	t.Helper()
+
+	c := compiler.New()
+	c.Loader.ReadFile = func(name string) ([]byte, error) {
+		source, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("open %s: no such file", name)
+		}
+		return []byte(source), nil
+	}
+
+	err := c.Compile(parser.New(lexer.New(input)).ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := c.Bytecode()
+	p := New(bytecode, filename, input)
+
+	for i := 0; i < runs; i++ {
+		machine := vm.New(bytecode)
+		err = p.Run(&machine)
+		if err != nil {
+			t.Fatalf("run failed: %s", err)
+		}
+	}
+
+	return p
+}
+
+func TestLines(t *testing.T) {
+	p := profile(t, sign, 2)
+
+	expected := []Line{{1, 2}, {2, 16}, {4, 2}, {5, 0}, {7, 2}, {8, 2}, {9, 2}}
+	lines := p.Files[0].Lines()
+
+	if len(lines) != len(expected) {
+		t.Fatalf("wrong lines. expected=%v, got=%v", expected, lines)
+	}
+
+	for i, line := range lines {
+		if line != expected[i] {
+			t.Errorf("wrong line %d. expected=%v, got=%v", i, expected[i], line)
+		}
+	}
+
+	hit, found := p.LineCoverage()
+	if hit != 6 || found != 7 {
+		t.Errorf("wrong line coverage, got %d/%d", hit, found)
+	}
+}
+
+func TestBranches(t *testing.T) {
+	p := profile(t, sign, 2)
+
+	expected := []Branch{
+		{Line: 2, Block: 0, Then: 2, Else: 4},
+		{Line: 2, Block: 1, Then: 0, Else: 4},
+		{Line: 9, Block: 0, Then: 0, Else: 2},
+	}
+	branches := p.Files[0].Branches()
+
+	if len(branches) != len(expected) {
+		t.Fatalf("wrong number of branches. expected=%d, got=%d", len(expected), len(branches))
+	}
+
+	for i, branch := range branches {
+		if *branch != expected[i] {
+			t.Errorf("wrong branch %d. expected=%+v, got=%+v", i, expected[i], *branch)
+		}
+	}
+
+	hit, found := p.BranchCoverage()
+	if hit != 4 || found != 6 {
+		t.Errorf("wrong branch coverage, got %d/%d", hit, found)
+	}
+}
+
+func TestImportedFiles(t *testing.T) {
+	lib := map[string]string{"lib.mk": `let two = 2;
+export let sign = fn(x) {
+  if (x < 0) { -1 } else { 1 }
+};
+`}
+	p := profileImporting(t, "main.mk", "import \"lib.mk\" as lib;\nlib.sign(3);\n", lib, 1)
+
+	if len(p.Files) != 2 || p.Files[0].Filename != "main.mk" || p.Files[1].Filename != "lib.mk" {
+		t.Fatalf("wrong files %v", p.Files)
+	}
+
+	// The module's code doesn't count for the import statement's line again
+	if fmt.Sprint(p.Files[0].Lines()) != "[{1 1} {2 1}]" || len(p.Files[0].Branches()) != 0 {
+		t.Errorf("wrong coverage of main.mk, lines %v, branches %v", p.Files[0].Lines(), p.Files[0].Branches())
+	}
+
+	if fmt.Sprint(p.Files[1].Lines()) != "[{1 1} {2 1} {3 2}]" {
+		t.Errorf("wrong lines of lib.mk %v", p.Files[1].Lines())
+	}
+	branches := p.Files[1].Branches()
+	if len(branches) != 1 || *branches[0] != (Branch{Line: 3, Block: 0, Then: 0, Else: 1}) {
+		t.Errorf("wrong branches of lib.mk %v", branches)
+	}
+
+	if p.Files[1].Source != lib["lib.mk"] {
+		t.Errorf("wrong source of lib.mk %q", p.Files[1].Source)
+	}
+}
+
+func TestWriteLCOVOfImportedFiles(t *testing.T) {
+	lib := map[string]string{"lib.mk": "export let f = fn(x) { if (x) { 1 } };\n"}
+	first := profileImporting(t, "a_test.mk", "import \"lib.mk\" as lib;\nlib.f(true);\n", lib, 1)
+	second := profileImporting(t, "b_test.mk", "import \"lib.mk\" as lib;\nlib.f(false);\n", lib, 1)
+
+	var out bytes.Buffer
+	err := WriteLCOV(&out, first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One record for the library, with the runs of both scripts
+	expected := `TN:
+SF:a_test.mk
+BRF:0
+BRH:0
+DA:1,1
+DA:2,1
+LF:2
+LH:2
+end_of_record
+TN:
+SF:lib.mk
+BRDA:1,0,0,1
+BRDA:1,0,1,1
+BRF:2
+BRH:2
+DA:1,5
+LF:1
+LH:1
+end_of_record
+TN:
+SF:b_test.mk
+BRF:0
+BRH:0
+DA:1,1
+DA:2,1
+LF:2
+LH:2
+end_of_record
+`
+	if out.String() != expected {
+		t.Errorf("wrong LCOV. expected=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestWriteLCOV(t *testing.T) {
+	p := profile(t, `let f = fn(x) { if (x) { 1 } }; 2;`, 0)
+
+	var out bytes.Buffer
+	err := WriteLCOV(&out, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `TN:
+SF:sign.mk
+BRDA:1,0,0,-
+BRDA:1,0,1,-
+BRF:2
+BRH:0
+DA:1,0
+LF:1
+LH:0
+end_of_record
+`
+	if out.String() != expected {
+		t.Errorf("wrong LCOV. expected=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestWriteHTMLOfImportedFiles(t *testing.T) {
+	lib := map[string]string{"lib.mk": "export let f = fn(x) {\n  x * 2\n};\n"}
+	p := profileImporting(t, "main.mk", "import \"lib.mk\" as lib;\n", lib, 1)
+
+	var out bytes.Buffer
+	err := WriteHTML(&out, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`<td><a href="#file1">lib.mk</a></td><td>1/2 (50.0%)</td>`,
+		`<tr class="uncovered"><td class="number">2</td><td class="hits">0</td><td class="text">  x * 2</td>`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("page doesn't contain %s:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	p := profile(t, sign, 1)
+
+	var out bytes.Buffer
+	err := WriteHTML(&out, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`<td><a href="#file0">sign.mk</a></td><td>6/7 (85.7%)</td><td>4/6 (66.7%)</td>`,
+		`<tr class="partial"><td class="number">2</td><td class="hits">8</td>`,
+		`<tr class="uncovered"><td class="number">5</td><td class="hits">0</td><td class="text">  puts(&#34;never&#34;);</td>`,
+		`then 1, else 2; then 0, else 2`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("page doesn't contain %s:\n%s", expected, out.String())
+		}
+	}
+
+	if strings.Contains(out.String(), "http") {
+		t.Errorf("page refers to something online")
+	}
+}
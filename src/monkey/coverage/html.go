@@ -0,0 +1,120 @@
+package coverage
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// Writes a single page with the source of every script and imported file,
+// each line marked with whether it was executed and how its ifs went. Styles
+// are inline, so the page can be opened without a network connection.
+func WriteHTML(w io.Writer, profiles ...*Profile) error {
+	files := []htmlFile{}
+	for _, file := range merge(profiles) {
+		files = append(files, newHTMLFile(file))
+	}
+
+	return page.Execute(w, files)
+}
+
+type htmlFile struct {
+	Filename string
+	Lines    string // Line coverage as a summary, "3/4 (75.0%)"
+	Branches string
+	Source   []htmlLine
+}
+
+type htmlLine struct {
+	Number   int
+	Text     string
+	Class    string // covered, uncovered, partial or empty for lines without statements
+	Hits     string
+	Branches string // How each if on the line went, "then 2, else 0"
+}
+
+func newHTMLFile(f *File) htmlFile {
+	hits := map[int]int64{}
+	for _, line := range f.Lines() {
+		hits[line.Number] = line.Hits
+	}
+
+	branches := map[int][]*Branch{}
+	for _, branch := range f.Branches() {
+		branches[branch.Line] = append(branches[branch.Line], branch)
+	}
+
+	file := htmlFile{
+		Filename: f.Filename,
+		Lines:    summary(f.LineCoverage()),
+		Branches: summary(f.BranchCoverage()),
+	}
+
+	for i, text := range strings.Split(strings.TrimSuffix(f.Source, "\n"), "\n") {
+		line := htmlLine{Number: i + 1, Text: text}
+
+		count, ok := hits[line.Number]
+		if ok {
+			line.Hits = fmt.Sprint(count)
+			line.Class = "uncovered"
+			if count > 0 {
+				line.Class = "covered"
+			}
+		}
+
+		described := []string{}
+		for _, branch := range branches[line.Number] {
+			described = append(described, fmt.Sprintf("then %d, else %d", branch.Then, branch.Else))
+
+			if line.Class == "covered" && (branch.Then == 0 || branch.Else == 0) {
+				line.Class = "partial"
+			}
+		}
+		line.Branches = strings.Join(described, "; ")
+
+		file.Source = append(file.Source, line)
+	}
+
+	return file
+}
+
+func summary(hit int, found int) string {
+	if found == 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d/%d (%.1f%%)", hit, found, float64(hit)/float64(found)*100)
+}
+
+var page = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table.summary td, table.summary th { padding: 0.2em 1em; text-align: left; }
+table.source { border-collapse: collapse; font-family: monospace; white-space: pre; }
+table.source td { padding: 0 0.5em; }
+td.number, td.hits { color: #888; text-align: right; }
+td.branches { color: #888; font-family: sans-serif; font-size: 0.8em; }
+tr.covered td.text { background: #dfd; }
+tr.uncovered td.text { background: #fdd; }
+tr.partial td.text { background: #ffd; }
+</style>
+</head>
+<body>
+<table class="summary">
+<tr><th>File</th><th>Lines</th><th>Branches</th></tr>
+{{range $i, $file := .}}<tr><td><a href="#file{{$i}}">{{.Filename}}</a></td><td>{{.Lines}}</td><td>{{.Branches}}</td></tr>
+{{end}}</table>
+{{range $i, $file := .}}
+<h2 id="file{{$i}}">{{.Filename}}</h2>
+<table class="source">
+{{range .Source}}<tr class="{{.Class}}"><td class="number">{{.Number}}</td><td class="hits">{{.Hits}}</td><td class="text">{{.Text}}</td><td class="branches">{{.Branches}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
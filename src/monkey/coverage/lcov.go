@@ -0,0 +1,46 @@
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Writes the profiles as an LCOV tracefile, one record per script and
+// imported file, for genhtml, editors and CI services
+func WriteLCOV(w io.Writer, profiles ...*Profile) error {
+	out := bufio.NewWriter(w)
+
+	for _, file := range merge(profiles) {
+		fmt.Fprintln(out, "TN:")
+		fmt.Fprintf(out, "SF:%s\n", file.Filename)
+
+		for _, branch := range file.Branches() {
+			fmt.Fprintf(out, "BRDA:%d,%d,0,%s\n", branch.Line, branch.Block, taken(branch, branch.Then))
+			fmt.Fprintf(out, "BRDA:%d,%d,1,%s\n", branch.Line, branch.Block, taken(branch, branch.Else))
+		}
+
+		hit, found := file.BranchCoverage()
+		fmt.Fprintf(out, "BRF:%d\nBRH:%d\n", found, hit)
+
+		for _, line := range file.Lines() {
+			fmt.Fprintf(out, "DA:%d,%d\n", line.Number, line.Hits)
+		}
+
+		hit, found = file.LineCoverage()
+		fmt.Fprintf(out, "LF:%d\nLH:%d\n", found, hit)
+
+		fmt.Fprintln(out, "end_of_record")
+	}
+
+	return out.Flush()
+}
+
+// LCOV tells a branch that wasn't taken apart from one never reached
+func taken(branch *Branch, count int64) string {
+	if !branch.Executed() {
+		return "-"
+	}
+
+	return fmt.Sprint(count)
+}
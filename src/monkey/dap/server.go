@@ -0,0 +1,549 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"monkey/debugger"
+	"monkey/object"
+	"monkey/wire"
+	"os"
+	"path/filepath"
+)
+
+// Only a single thread ever runs
+const threadId = 1
+
+// Serves a single debugging session of the VM over the Debug Adapter Protocol
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+	seq int // Of the last message sent, program output included
+
+	debugger *debugger.Debugger
+
+	stopOnEntry  bool
+	lineOffset   int              // Subtracted from our one-based lines when talking to the client
+	columnOffset int              // Same, for columns
+	breakpoints  map[string][]int // Lines requested by source path, before the program was loaded
+
+	// Sources for variablesReference, which is an index into this plus one.
+	// They are only valid while execution is stopped.
+	handles []func() []Variable
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:  bufio.NewReader(in),
+		out: out,
+
+		breakpoints: map[string][]int{},
+	}
+}
+
+// Handles requests until the client disconnects or closes the input
+func (s *Server) Run() error {
+	for {
+		content, err := wire.ReadMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var request Request
+		err = json.Unmarshal(content, &request)
+		if err != nil {
+			return fmt.Errorf("invalid message: %s", err)
+		}
+
+		if request.Type != "request" {
+			continue
+		}
+
+		done := s.handle(&request)
+		if done {
+			return nil
+		}
+	}
+}
+
+// Program output of a category, forwarded to the client as output events
+type output struct {
+	server   *Server
+	category string
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.server.sendEvent("output", map[string]any{
+		"category": o.category,
+		"output":   string(p),
+	})
+
+	return len(p), nil
+}
+
+func (s *Server) send(message any) {
+	s.seq++
+	switch message := message.(type) {
+	case *Response:
+		message.Seq = s.seq
+	case *Event:
+		message.Seq = s.seq
+	}
+
+	wire.WriteMessage(s.out, message)
+}
+
+func (s *Server) respond(request *Request, body any) {
+	s.send(&Response{
+		Type:       "response",
+		RequestSeq: request.Seq,
+		Success:    true,
+		Command:    request.Command,
+		Body:       body,
+	})
+}
+
+func (s *Server) respondError(request *Request, format string, a ...any) {
+	s.send(&Response{
+		Type:       "response",
+		RequestSeq: request.Seq,
+		Success:    false,
+		Command:    request.Command,
+		Message:    fmt.Sprintf(format, a...),
+	})
+}
+
+func (s *Server) sendEvent(name string, body any) {
+	s.send(&Event{
+		Type:  "event",
+		Event: name,
+		Body:  body,
+	})
+}
+
+// Returns whether the session is over
+func (s *Server) handle(request *Request) bool {
+	switch request.Command {
+	case "initialize":
+		s.initialize(request)
+
+	case "launch":
+		s.launch(request)
+
+	case "setBreakpoints":
+		s.setBreakpoints(request)
+
+	case "setExceptionBreakpoints":
+		s.respond(request, map[string]any{"breakpoints": []Breakpoint{}})
+
+	case "configurationDone":
+		if !s.requireProgram(request) {
+			return false
+		}
+
+		s.respond(request, nil)
+
+		if s.stopOnEntry {
+			s.sendStopped(debugger.Entry)
+		} else {
+			s.resume(s.debugger.Continue)
+		}
+
+	case "threads":
+		s.respond(request, map[string]any{
+			"threads": []Thread{{Id: threadId, Name: "main"}},
+		})
+
+	case "stackTrace":
+		s.stackTrace(request)
+
+	case "scopes":
+		s.scopes(request)
+
+	case "variables":
+		s.variables(request)
+
+	case "evaluate":
+		s.evaluate(request)
+
+	case "continue":
+		if !s.requireProgram(request) {
+			return false
+		}
+
+		s.respond(request, map[string]any{"allThreadsContinued": true})
+		s.resume(s.debugger.Continue)
+
+	case "next":
+		if !s.requireProgram(request) {
+			return false
+		}
+
+		s.respond(request, nil)
+		s.resume(s.debugger.StepOver)
+
+	case "stepIn":
+		if !s.requireProgram(request) {
+			return false
+		}
+
+		s.respond(request, nil)
+		s.resume(s.debugger.StepIn)
+
+	case "stepOut":
+		if !s.requireProgram(request) {
+			return false
+		}
+
+		s.respond(request, nil)
+		s.resume(s.debugger.StepOut)
+
+	case "disconnect", "terminate":
+		s.respond(request, nil)
+		return true
+
+	default:
+		s.respondError(request, "unsupported request %q", request.Command)
+	}
+
+	return false
+}
+
+func (s *Server) requireProgram(request *Request) bool {
+	if s.debugger == nil {
+		s.respondError(request, "no program launched")
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) initialize(request *Request) {
+	var arguments struct {
+		LinesStartAt1   *bool `json:"linesStartAt1"`
+		ColumnsStartAt1 *bool `json:"columnsStartAt1"`
+	}
+	json.Unmarshal(request.Arguments, &arguments)
+
+	if arguments.LinesStartAt1 != nil && !*arguments.LinesStartAt1 {
+		s.lineOffset = 1
+	}
+
+	if arguments.ColumnsStartAt1 != nil && !*arguments.ColumnsStartAt1 {
+		s.columnOffset = 1
+	}
+
+	s.respond(request, map[string]any{
+		"supportsConfigurationDoneRequest": true,
+		"supportsEvaluateForHovers":        true,
+		"supportsTerminateRequest":         true,
+	})
+
+	s.sendEvent("initialized", nil)
+}
+
+func (s *Server) launch(request *Request) {
+	var arguments struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+	}
+
+	err := json.Unmarshal(request.Arguments, &arguments)
+	if err != nil || arguments.Program == "" {
+		s.respondError(request, "launch needs a program to debug")
+		return
+	}
+
+	source, err := os.ReadFile(arguments.Program)
+	if err != nil {
+		s.respondError(request, "could not read %s: %s", arguments.Program, err)
+		return
+	}
+
+	// The protocol owns stdout, anything the script prints goes to the client,
+	// its macros included
+	d, err := debugger.Load(arguments.Program, string(source), &object.Context{
+		Stdout: &output{server: s, category: "stdout"},
+		Stderr: &output{server: s, category: "stderr"},
+	})
+	if err != nil {
+		s.respondError(request, "%s", err)
+		return
+	}
+
+	s.debugger = d
+	s.stopOnEntry = arguments.StopOnEntry
+
+	for path, lines := range s.breakpoints {
+		for _, line := range lines {
+			s.debugger.SetBreakpoint(path, line)
+		}
+	}
+
+	s.respond(request, nil)
+}
+
+func (s *Server) setBreakpoints(request *Request) {
+	var arguments struct {
+		Source      Source `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+
+	err := json.Unmarshal(request.Arguments, &arguments)
+	if err != nil {
+		s.respondError(request, "invalid arguments: %s", err)
+		return
+	}
+
+	// The request replaces the breakpoints of its source only
+	path := arguments.Source.Path
+	lines := []int{}
+	for _, breakpoint := range arguments.Breakpoints {
+		lines = append(lines, breakpoint.Line+s.lineOffset)
+	}
+
+	s.breakpoints[path] = lines
+
+	result := []Breakpoint{}
+
+	if s.debugger == nil {
+		for _, line := range lines {
+			result = append(result, Breakpoint{Verified: false, Line: line - s.lineOffset})
+		}
+
+		s.respond(request, map[string]any{"breakpoints": result})
+		return
+	}
+
+	if _, ok := s.debugger.Source(path); !ok {
+		for _, line := range lines {
+			result = append(result, Breakpoint{
+				Verified: false,
+				Line:     line - s.lineOffset,
+				Message:  "not a file of the program",
+			})
+		}
+
+		s.respond(request, map[string]any{"breakpoints": result})
+		return
+	}
+
+	s.debugger.ClearBreakpoints(path)
+
+	for _, line := range lines {
+		actual, ok := s.debugger.SetBreakpoint(path, line)
+		if !ok {
+			result = append(result, Breakpoint{
+				Verified: false,
+				Line:     line - s.lineOffset,
+				Message:  "no statement at or after this line",
+			})
+			continue
+		}
+
+		result = append(result, Breakpoint{Verified: true, Line: actual - s.lineOffset})
+	}
+
+	s.respond(request, map[string]any{"breakpoints": result})
+}
+
+func (s *Server) resume(action func() (debugger.StopReason, error)) {
+	s.handles = nil
+
+	reason, err := action()
+	if err != nil {
+		s.sendEvent("output", map[string]any{
+			"category": "stderr",
+			"output":   fmt.Sprintf("Execution failed:\n%s\n", err),
+		})
+		s.sendEvent("exited", map[string]any{"exitCode": 1})
+		s.sendEvent("terminated", nil)
+		return
+	}
+
+	if reason == debugger.Finished {
+		s.sendEvent("exited", map[string]any{"exitCode": 0})
+		s.sendEvent("terminated", nil)
+		return
+	}
+
+	s.sendStopped(reason)
+}
+
+func (s *Server) sendStopped(reason debugger.StopReason) {
+	s.sendEvent("stopped", map[string]any{
+		"reason":            reason.String(),
+		"threadId":          threadId,
+		"allThreadsStopped": true,
+	})
+}
+
+// Frame ids are indices into the debugger's call stack plus one
+func (s *Server) frameIndex(frameId int) (int, bool) {
+	if s.debugger == nil || frameId < 1 || frameId > len(s.debugger.CallStack()) {
+		return 0, false
+	}
+
+	return frameId - 1, true
+}
+
+func (s *Server) stackTrace(request *Request) {
+	if !s.requireProgram(request) {
+		return
+	}
+
+	frames := []StackFrame{}
+	for i, frame := range s.debugger.CallStack() {
+		frames = append(frames, StackFrame{
+			Id:     i + 1,
+			Name:   frame.Name,
+			Source: Source{Name: filepath.Base(frame.File), Path: frame.File},
+			Line:   frame.Position.Line - s.lineOffset,
+			Column: frame.Position.Column - s.columnOffset,
+		})
+	}
+
+	s.respond(request, map[string]any{
+		"stackFrames": frames,
+		"totalFrames": len(frames),
+	})
+}
+
+func (s *Server) scopes(request *Request) {
+	var arguments struct {
+		FrameId int `json:"frameId"`
+	}
+	json.Unmarshal(request.Arguments, &arguments)
+
+	index, ok := s.frameIndex(arguments.FrameId)
+	if !ok {
+		s.respondError(request, "unknown frame %d", arguments.FrameId)
+		return
+	}
+
+	d := s.debugger
+	scopes := []Scope{
+		{
+			Name:               "Locals",
+			VariablesReference: s.addHandle(s.variablesOf(func() []debugger.Variable { return d.Locals(index) })),
+		},
+		{
+			Name:               "Closure",
+			VariablesReference: s.addHandle(s.variablesOf(func() []debugger.Variable { return d.FreeVariables(index) })),
+		},
+		{
+			Name:               "Globals",
+			VariablesReference: s.addHandle(s.variablesOf(d.Globals)),
+		},
+	}
+
+	s.respond(request, map[string]any{"scopes": scopes})
+}
+
+func (s *Server) variables(request *Request) {
+	var arguments struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	json.Unmarshal(request.Arguments, &arguments)
+
+	reference := arguments.VariablesReference
+	if reference < 1 || reference > len(s.handles) {
+		s.respondError(request, "unknown variables reference %d", reference)
+		return
+	}
+
+	s.respond(request, map[string]any{"variables": s.handles[reference-1]()})
+}
+
+func (s *Server) evaluate(request *Request) {
+	var arguments struct {
+		Expression string `json:"expression"`
+		FrameId    int    `json:"frameId"`
+	}
+	json.Unmarshal(request.Arguments, &arguments)
+
+	index, ok := s.frameIndex(arguments.FrameId)
+	if !ok {
+		index = 0
+	}
+
+	if s.debugger == nil {
+		s.respondError(request, "no program launched")
+		return
+	}
+
+	// Only names can be evaluated, the VM can't run arbitrary code in a frame
+	value, ok := s.debugger.Lookup(index, arguments.Expression)
+	if !ok {
+		s.respondError(request, "%q not found", arguments.Expression)
+		return
+	}
+
+	variable := s.variable(arguments.Expression, value)
+
+	s.respond(request, map[string]any{
+		"result":             variable.Value,
+		"type":               variable.Type,
+		"variablesReference": variable.VariablesReference,
+	})
+}
+
+func (s *Server) addHandle(source func() []Variable) int {
+	s.handles = append(s.handles, source)
+
+	return len(s.handles)
+}
+
+func (s *Server) variablesOf(source func() []debugger.Variable) func() []Variable {
+	return func() []Variable {
+		result := []Variable{}
+		for _, variable := range source() {
+			result = append(result, s.variable(variable.Name, variable.Value))
+		}
+
+		return result
+	}
+}
+
+// Arrays and hashes get a reference so clients can expand them
+func (s *Server) variable(name string, value object.Object) Variable {
+	if value == nil {
+		return Variable{Name: name, Value: "<unset>"}
+	}
+
+	result := Variable{
+		Name:  name,
+		Value: value.Inspect(),
+		Type:  string(value.Type()),
+	}
+
+	switch value := value.(type) {
+	case *object.Array:
+		result.VariablesReference = s.addHandle(func() []Variable {
+			children := []Variable{}
+			for i, element := range value.Elements {
+				children = append(children, s.variable(fmt.Sprintf("[%d]", i), element))
+			}
+
+			return children
+		})
+
+	case *object.Hash:
+		result.VariablesReference = s.addHandle(func() []Variable {
+			children := []Variable{}
+			for _, pair := range value.Pairs() {
+				children = append(children, s.variable(pair.Key.Inspect(), pair.Value))
+			}
+
+			return children
+		})
+	}
+
+	return result
+}
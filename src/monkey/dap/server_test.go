@@ -0,0 +1,390 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"monkey/wire"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const program = `let x = 10;
+let add = fn(a, b) {
+	let c = a + b;
+	c * 2
+};
+let y = add(x, [1, 2][0]);
+y`
+
+type client struct {
+	t   *testing.T
+	in  io.Writer
+	out *bufio.Reader
+	seq int
+}
+
+func startSession(t *testing.T) *client {
+	clientToServer, serverIn := io.Pipe()
+	serverOut, serverToClient := io.Pipe()
+
+	server := NewServer(clientToServer, serverToClient)
+	go func() {
+		err := server.Run()
+		if err != nil {
+			t.Errorf("server failed: %s", err)
+		}
+		serverToClient.Close()
+	}()
+
+	return &client{t: t, in: serverIn, out: bufio.NewReader(serverOut)}
+}
+
+func (c *client) request(command string, arguments any) {
+	c.seq++
+
+	message := map[string]any{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": arguments,
+	}
+
+	err := wire.WriteMessage(c.in, message)
+	if err != nil {
+		c.t.Fatalf("could not send %s: %s", command, err)
+	}
+}
+
+func (c *client) read() map[string]any {
+	content, err := wire.ReadMessage(c.out)
+	if err != nil {
+		c.t.Fatalf("could not read message: %s", err)
+	}
+
+	var result map[string]any
+	err = json.Unmarshal(content, &result)
+	if err != nil {
+		c.t.Fatalf("invalid message %s: %s", content, err)
+	}
+
+	return result
+}
+
+func (c *client) expectResponse(command string) map[string]any {
+	message := c.read()
+
+	if message["type"] != "response" || message["command"] != command {
+		c.t.Fatalf("expected response to %s, got %v", command, message)
+	}
+
+	if message["success"] != true {
+		c.t.Fatalf("request %s failed: %v", command, message["message"])
+	}
+
+	body, _ := message["body"].(map[string]any)
+	return body
+}
+
+func (c *client) expectEvent(event string) map[string]any {
+	message := c.read()
+
+	if message["type"] != "event" || message["event"] != event {
+		c.t.Fatalf("expected %s event, got %v", event, message)
+	}
+
+	body, _ := message["body"].(map[string]any)
+	return body
+}
+
+func (c *client) expectStopped(reason string, line int) {
+	body := c.expectEvent("stopped")
+	if body["reason"] != reason {
+		c.t.Fatalf("stop reason %v wrong, expected %s", body["reason"], reason)
+	}
+
+	c.request("stackTrace", map[string]any{"threadId": threadId})
+	frames := c.expectResponse("stackTrace")["stackFrames"].([]any)
+
+	actual := frames[0].(map[string]any)["line"]
+	if actual != float64(line) {
+		c.t.Fatalf("stopped on line %v, expected %d", actual, line)
+	}
+}
+
+func (c *client) variables(reference any) map[string]map[string]any {
+	c.request("variables", map[string]any{"variablesReference": reference})
+	variables := c.expectResponse("variables")["variables"].([]any)
+
+	result := map[string]map[string]any{}
+	for _, variable := range variables {
+		variable := variable.(map[string]any)
+		result[variable["name"].(string)] = variable
+	}
+
+	return result
+}
+
+func writeProgram(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "program.mk")
+
+	err := os.WriteFile(path, []byte(program), 0644)
+	if err != nil {
+		t.Fatalf("could not write program: %s", err)
+	}
+
+	return path
+}
+
+func TestDebugSession(t *testing.T) {
+	path := writeProgram(t)
+	c := startSession(t)
+
+	c.request("initialize", map[string]any{"adapterID": "monkey"})
+	c.expectResponse("initialize")
+	c.expectEvent("initialized")
+
+	c.request("launch", map[string]any{"program": path})
+	c.expectResponse("launch")
+
+	c.request("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": path},
+		"breakpoints": []any{map[string]any{"line": 3}, map[string]any{"line": 100}},
+	})
+	breakpoints := c.expectResponse("setBreakpoints")["breakpoints"].([]any)
+	if breakpoints[0].(map[string]any)["verified"] != true {
+		t.Errorf("breakpoint on line 3 not verified")
+	}
+	if breakpoints[1].(map[string]any)["verified"] != false {
+		t.Errorf("breakpoint on line 100 verified")
+	}
+
+	c.request("configurationDone", nil)
+	c.expectResponse("configurationDone")
+	c.expectStopped("breakpoint", 3)
+
+	c.request("threads", nil)
+	threads := c.expectResponse("threads")["threads"].([]any)
+	if len(threads) != 1 {
+		t.Errorf("wrong number of threads %d, expected 1", len(threads))
+	}
+
+	c.request("stackTrace", map[string]any{"threadId": threadId})
+	frames := c.expectResponse("stackTrace")["stackFrames"].([]any)
+	if len(frames) != 2 {
+		t.Fatalf("wrong number of frames %d, expected 2", len(frames))
+	}
+
+	innermost := frames[0].(map[string]any)
+	if innermost["name"] != "add" {
+		t.Errorf("innermost frame %v wrong, expected add", innermost["name"])
+	}
+
+	c.request("scopes", map[string]any{"frameId": innermost["id"]})
+	scopes := c.expectResponse("scopes")["scopes"].([]any)
+	if len(scopes) != 3 {
+		t.Fatalf("wrong number of scopes %d, expected 3", len(scopes))
+	}
+
+	locals := c.variables(scopes[0].(map[string]any)["variablesReference"])
+	if locals["a"]["value"] != "10" || locals["b"]["value"] != "1" {
+		t.Errorf("locals %v wrong", locals)
+	}
+	if _, ok := locals["c"]; ok {
+		t.Errorf("unassigned local c shown")
+	}
+
+	globals := c.variables(scopes[2].(map[string]any)["variablesReference"])
+	if globals["x"]["value"] != "10" {
+		t.Errorf("globals %v wrong", globals)
+	}
+
+	c.request("next", map[string]any{"threadId": threadId})
+	c.expectResponse("next")
+	c.expectStopped("step", 4)
+
+	c.request("evaluate", map[string]any{"expression": "c", "frameId": 1})
+	result := c.expectResponse("evaluate")
+	if result["result"] != "11" {
+		t.Errorf("evaluated c to %v, expected 11", result["result"])
+	}
+
+	c.request("stepOut", map[string]any{"threadId": threadId})
+	c.expectResponse("stepOut")
+	c.expectStopped("step", 6)
+
+	c.request("stepIn", map[string]any{"threadId": threadId})
+	c.expectResponse("stepIn")
+	c.expectStopped("step", 7)
+
+	c.request("continue", map[string]any{"threadId": threadId})
+	c.expectResponse("continue")
+	exited := c.expectEvent("exited")
+	if exited["exitCode"] != float64(0) {
+		t.Errorf("exit code %v wrong, expected 0", exited["exitCode"])
+	}
+	c.expectEvent("terminated")
+
+	c.request("disconnect", nil)
+	c.expectResponse("disconnect")
+}
+
+func TestBreakpointOnFirstLine(t *testing.T) {
+	path := writeProgram(t)
+	c := startSession(t)
+
+	c.request("initialize", map[string]any{"adapterID": "monkey"})
+	c.expectResponse("initialize")
+	c.expectEvent("initialized")
+
+	c.request("launch", map[string]any{"program": path})
+	c.expectResponse("launch")
+
+	c.request("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": path},
+		"breakpoints": []any{map[string]any{"line": 1}},
+	})
+	c.expectResponse("setBreakpoints")
+
+	// Nothing has run yet, so the first line is still ahead
+	c.request("configurationDone", nil)
+	c.expectResponse("configurationDone")
+	c.expectStopped("breakpoint", 1)
+
+	c.request("continue", map[string]any{"threadId": threadId})
+	c.expectResponse("continue")
+	c.expectEvent("exited")
+	c.expectEvent("terminated")
+
+	c.request("disconnect", nil)
+	c.expectResponse("disconnect")
+}
+
+func TestStopOnEntryAndStructuredVariables(t *testing.T) {
+	path := writeProgram(t)
+	c := startSession(t)
+
+	c.request("initialize", map[string]any{"linesStartAt1": false})
+	c.expectResponse("initialize")
+	c.expectEvent("initialized")
+
+	c.request("launch", map[string]any{"program": path, "stopOnEntry": true})
+	c.expectResponse("launch")
+
+	c.request("configurationDone", nil)
+	c.expectResponse("configurationDone")
+	c.expectStopped("entry", 0)
+
+	c.request("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": path},
+		"breakpoints": []any{map[string]any{"line": 6}},
+	})
+	c.expectResponse("setBreakpoints")
+
+	c.request("continue", map[string]any{"threadId": threadId})
+	c.expectResponse("continue")
+	c.expectStopped("breakpoint", 6)
+
+	c.request("evaluate", map[string]any{"expression": "add", "frameId": 1})
+	c.expectResponse("evaluate")
+
+	c.request("disconnect", nil)
+	c.expectResponse("disconnect")
+}
+
+func TestImportedFiles(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.mk")
+	lib := filepath.Join(dir, "lib.mk")
+
+	os.WriteFile(main, []byte("import \"lib.mk\" as lib;\nlib.check(1);\nlib.check(2)"), 0644)
+	os.WriteFile(lib, []byte("export let check = fn(x) {\n\tx * 2\n};"), 0644)
+
+	c := startSession(t)
+
+	c.request("initialize", map[string]any{"adapterID": "monkey"})
+	c.expectResponse("initialize")
+	c.expectEvent("initialized")
+
+	c.request("launch", map[string]any{"program": main})
+	c.expectResponse("launch")
+
+	c.request("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": lib},
+		"breakpoints": []any{map[string]any{"line": 2}},
+	})
+	breakpoints := c.expectResponse("setBreakpoints")["breakpoints"].([]any)
+	if breakpoints[0].(map[string]any)["verified"] != true {
+		t.Errorf("breakpoint in the imported file not verified")
+	}
+
+	c.request("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": filepath.Join(dir, "other.mk")},
+		"breakpoints": []any{map[string]any{"line": 2}},
+	})
+	breakpoints = c.expectResponse("setBreakpoints")["breakpoints"].([]any)
+	if breakpoints[0].(map[string]any)["verified"] != false {
+		t.Errorf("breakpoint in a file the program doesn't have verified")
+	}
+
+	c.request("configurationDone", nil)
+	c.expectResponse("configurationDone")
+	c.expectStopped("breakpoint", 2)
+
+	c.request("stackTrace", map[string]any{"threadId": threadId})
+	frames := c.expectResponse("stackTrace")["stackFrames"].([]any)
+	if len(frames) != 2 {
+		t.Fatalf("wrong number of frames %d, expected 2", len(frames))
+	}
+
+	sources := []string{lib, main}
+	for i, frame := range frames {
+		source := frame.(map[string]any)["source"].(map[string]any)
+		if source["path"] != sources[i] {
+			t.Errorf("frame %d in %v, expected %s", i, source["path"], sources[i])
+		}
+	}
+
+	// Clearing the main file's breakpoints leaves the library's
+	c.request("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": main},
+		"breakpoints": []any{},
+	})
+	c.expectResponse("setBreakpoints")
+
+	c.request("continue", map[string]any{"threadId": threadId})
+	c.expectResponse("continue")
+	c.expectStopped("breakpoint", 2)
+
+	c.request("disconnect", nil)
+	c.expectResponse("disconnect")
+}
+
+func TestLaunchFailures(t *testing.T) {
+	c := startSession(t)
+
+	c.request("launch", map[string]any{"program": "/does/not/exist.mk"})
+	message := c.read()
+	if message["success"] != false {
+		t.Errorf("launching a missing file succeeded")
+	}
+
+	path := filepath.Join(t.TempDir(), "broken.mk")
+	os.WriteFile(path, []byte("let = 1;"), 0644)
+
+	c.request("launch", map[string]any{"program": path})
+	message = c.read()
+	if message["success"] != false {
+		t.Errorf("launching a broken program succeeded")
+	}
+
+	c.request("continue", nil)
+	message = c.read()
+	if message["success"] != false {
+		t.Errorf("continuing without a program succeeded")
+	}
+
+	c.request("disconnect", nil)
+	c.expectResponse("disconnect")
+}
@@ -0,0 +1,288 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"monkey/object"
+	"strconv"
+	"strings"
+)
+
+const PROMPT = "(mdb) "
+
+const HELP = `Commands:
+  break [file:]<line>, b   set a breakpoint, in the main file unless one is named
+  delete [file:]<line>     remove a breakpoint
+  breakpoints              list breakpoints
+  continue, c              run until a breakpoint or the end
+  step, s                  step to the next statement, entering calls
+  next, n                  step to the next statement in this function
+  out, o                   run until this function returns
+  stack, bt                show the call stack
+  frame <n>, f <n>         select a frame from the call stack
+  locals                   show local bindings of the selected frame
+  free                     show captured variables of the selected frame
+  globals                  show global bindings
+  vmstack                  show the VM's value stack
+  print <name>, p <name>   show the value of a binding
+  list, l                  show source around the current line
+  quit, q                  exit
+`
+
+// Interactive debugging session for a Monkey source file
+func Start(in io.Reader, out io.Writer, filename string, source string) {
+	// The script's output goes between the debugger's
+	debugger, err := Load(filename, source, &object.Context{Stdout: out, Stderr: out})
+	if err != nil {
+		fmt.Fprintf(out, "%s\n", err)
+		return
+	}
+
+	session := &session{
+		debugger: debugger,
+		out:      out,
+		filename: filename,
+	}
+
+	session.printStop(Entry, nil)
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, PROMPT)
+		if !scanner.Scan() {
+			return
+		}
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "quit" || fields[0] == "q" {
+			return
+		}
+
+		session.execute(fields[0], fields[1:])
+	}
+}
+
+type session struct {
+	debugger *Debugger
+	out      io.Writer
+
+	filename string
+
+	selectedFrame int // Index into the call stack, 0 being the innermost frame
+}
+
+func (s *session) execute(command string, arguments []string) {
+	switch command {
+	case "break", "b":
+		file, line, ok := s.locationArgument(arguments)
+		if !ok {
+			return
+		}
+
+		if _, loaded := s.debugger.Source(file); !loaded {
+			fmt.Fprintf(s.out, "%s isn't part of the program\n", file)
+			return
+		}
+
+		actual, ok := s.debugger.SetBreakpoint(file, line)
+		if !ok {
+			fmt.Fprintf(s.out, "no statement at or after line %d\n", line)
+			return
+		}
+
+		if file == "" {
+			file = s.filename
+		}
+
+		fmt.Fprintf(s.out, "breakpoint set at %s:%d\n", file, actual)
+
+	case "delete":
+		file, line, ok := s.locationArgument(arguments)
+		if !ok {
+			return
+		}
+
+		s.debugger.ClearBreakpoint(file, line)
+
+	case "breakpoints":
+		for _, location := range s.debugger.Breakpoints() {
+			fmt.Fprintf(s.out, "%s:%d\n", location.File, location.Line)
+		}
+
+	case "continue", "c":
+		s.resume(s.debugger.Continue)
+
+	case "step", "s":
+		s.resume(s.debugger.StepIn)
+
+	case "next", "n":
+		s.resume(s.debugger.StepOver)
+
+	case "out", "o":
+		s.resume(s.debugger.StepOut)
+
+	case "stack", "bt":
+		for i, frame := range s.debugger.CallStack() {
+			marker := " "
+			if i == s.selectedFrame {
+				marker = "*"
+			}
+
+			fmt.Fprintf(s.out, "%s #%d %s at %s:%d\n", marker, i, frame.Name, frame.File, frame.Position.Line)
+		}
+
+	case "frame", "f":
+		index, ok := s.numberArgument(arguments)
+		if !ok {
+			return
+		}
+
+		if index < 0 || index >= len(s.debugger.CallStack()) {
+			fmt.Fprintf(s.out, "no frame %d\n", index)
+			return
+		}
+
+		s.selectedFrame = index
+
+	case "locals":
+		s.printVariables(s.debugger.Locals(s.selectedFrame))
+
+	case "free":
+		s.printVariables(s.debugger.FreeVariables(s.selectedFrame))
+
+	case "globals":
+		s.printVariables(s.debugger.Globals())
+
+	case "vmstack":
+		for i, value := range s.debugger.Stack() {
+			fmt.Fprintf(s.out, "%4d %s\n", i, inspect(value))
+		}
+
+	case "print", "p":
+		if len(arguments) != 1 {
+			fmt.Fprintf(s.out, "usage: print <name>\n")
+			return
+		}
+
+		value, ok := s.debugger.Lookup(s.selectedFrame, arguments[0])
+		if !ok {
+			fmt.Fprintf(s.out, "%q not found\n", arguments[0])
+			return
+		}
+
+		fmt.Fprintf(s.out, "%s = %s\n", arguments[0], inspect(value))
+
+	case "list", "l":
+		s.printSource(s.debugger.File(), s.debugger.Position().Line, 5)
+
+	case "help", "h":
+		io.WriteString(s.out, HELP)
+
+	default:
+		fmt.Fprintf(s.out, "unknown command %q, try 'help'\n", command)
+	}
+}
+
+func (s *session) numberArgument(arguments []string) (int, bool) {
+	if len(arguments) != 1 {
+		fmt.Fprintf(s.out, "expected a single number\n")
+		return 0, false
+	}
+
+	result, err := strconv.Atoi(arguments[0])
+	if err != nil {
+		fmt.Fprintf(s.out, "%q is not a number\n", arguments[0])
+		return 0, false
+	}
+
+	return result, true
+}
+
+// A line, or file:line
+func (s *session) locationArgument(arguments []string) (string, int, bool) {
+	if len(arguments) != 1 {
+		fmt.Fprintf(s.out, "expected a line, or a file and a line\n")
+		return "", 0, false
+	}
+
+	file := ""
+	number := arguments[0]
+	if i := strings.LastIndexByte(number, ':'); i >= 0 {
+		file, number = number[:i], number[i+1:]
+	}
+
+	line, err := strconv.Atoi(number)
+	if err != nil {
+		fmt.Fprintf(s.out, "%q is not a number\n", number)
+		return "", 0, false
+	}
+
+	return file, line, true
+}
+
+func (s *session) resume(action func() (StopReason, error)) {
+	if s.debugger.Finished() {
+		fmt.Fprintf(s.out, "program already finished\n")
+		return
+	}
+
+	s.selectedFrame = 0
+
+	reason, err := action()
+	s.printStop(reason, err)
+}
+
+func (s *session) printStop(reason StopReason, err error) {
+	if err != nil {
+		fmt.Fprintf(s.out, "Execution failed at %s:%d:\n%s\n",
+			s.debugger.File(), s.debugger.Position().Line, err)
+		return
+	}
+
+	if reason == Finished {
+		fmt.Fprintf(s.out, "program finished, result: %s\n", inspect(s.debugger.Result()))
+		return
+	}
+
+	file, line := s.debugger.File(), s.debugger.Position().Line
+	fmt.Fprintf(s.out, "stopped (%s) at %s:%d\n", reason, file, line)
+	s.printSource(file, line, 0)
+}
+
+// Prints the given line of file with context lines around it
+func (s *session) printSource(file string, line int, context int) {
+	source, _ := s.debugger.Source(file)
+	lines := strings.Split(source, "\n")
+
+	for i := line - context; i <= line+context; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+
+		fmt.Fprintf(s.out, "%s%4d | %s\n", marker, i, lines[i-1])
+	}
+}
+
+func (s *session) printVariables(variables []Variable) {
+	for _, variable := range variables {
+		fmt.Fprintf(s.out, "%s = %s\n", variable.Name, inspect(variable.Value))
+	}
+}
+
+func inspect(value object.Object) string {
+	if value == nil {
+		return "<unset>"
+	}
+
+	return value.Inspect()
+}
@@ -0,0 +1,521 @@
+package debugger
+
+import (
+	"fmt"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/module"
+	"monkey/object"
+	"monkey/opcode"
+	"monkey/parser"
+	"monkey/token"
+	"monkey/vm"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type StopReason int
+
+const (
+	Entry      StopReason = iota // Nothing executed yet
+	Breakpoint                   // Reached a line with a breakpoint
+	Step                         // A step finished
+	Finished                     // The program ran to completion
+)
+
+func (reason StopReason) String() string {
+	switch reason {
+	case Entry:
+		return "entry"
+	case Breakpoint:
+		return "breakpoint"
+	case Step:
+		return "step"
+	default:
+		return "finished"
+	}
+}
+
+// Drives a VM one instruction at a time, stopping at statement boundaries
+type Debugger struct {
+	machine  *vm.VM
+	bytecode *compiler.Bytecode
+
+	// Of the main file, imported ones are in the bytecode. Imported files are
+	// keyed by their path below, the main one by the empty string like in the
+	// line tables.
+	filename string
+	source   string
+
+	breakpoints    map[Location]bool
+	statementLines map[string][]int // Every line a breakpoint could be put on, by file, sorted
+
+	started bool // Left the entry stop, before which nothing has executed
+	failed  bool // Execution can't continue after a runtime error
+}
+
+// A line in one of the program's files
+type Location struct {
+	File string
+	Line int
+}
+
+type Variable struct {
+	Name  string
+	Value object.Object
+}
+
+type StackFrame struct {
+	Name     string
+	File     string
+	Position token.Position
+
+	frame *vm.Frame
+}
+
+func New(bytecode *compiler.Bytecode) *Debugger {
+	machine := vm.New(bytecode)
+
+	return &Debugger{
+		machine:  &machine,
+		bytecode: bytecode,
+
+		breakpoints:    map[Location]bool{},
+		statementLines: statementLines(bytecode),
+	}
+}
+
+// Where the debugged program's builtins do their IO
+func (d *Debugger) SetContext(context *object.Context) {
+	d.machine.SetContext(context)
+}
+
+// Parses and compiles source, ready to be debugged from its first statement.
+// Imports are relative to filename. The program does its IO in context, from
+// its macros on.
+func Load(filename string, source string, context *object.Context) (*Debugger, error) {
+	l := lexer.New(source)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("parser errors:\n\t%s", strings.Join(p.Errors(), "\n\t"))
+	}
+
+	macroEnv := object.NewEnvironment()
+	macroEnv.SetContext(context)
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, err := evaluator.ExpandMacros(program, macroEnv)
+	if err != nil {
+		return nil, fmt.Errorf("Macro expansion failed:\n%s", err)
+	}
+
+	c := compiler.New()
+	c.Loader = module.NewLoader(filename)
+	c.MacroContext = context
+	err = c.Compile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("Compilation failed:\n%s", err)
+	}
+
+	d := New(c.Bytecode())
+	d.SetContext(context)
+	d.filename = filename
+	d.source = source
+
+	return d, nil
+}
+
+func statementLines(bytecode *compiler.Bytecode) map[string][]int {
+	seen := map[string]map[int]bool{"": {}}
+
+	add := func(file string, lines opcode.LineTable) {
+		if seen[file] == nil {
+			seen[file] = map[int]bool{}
+		}
+
+		for _, line := range lines.StatementLines() {
+			seen[file][line] = true
+		}
+	}
+
+	add("", bytecode.Lines)
+	for path, lines := range bytecode.ImportedLines {
+		add(path, lines)
+	}
+
+	for _, constant := range bytecode.Constants {
+		function, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		add("", function.Lines)
+		for path, lines := range function.ImportedLines {
+			add(path, lines)
+		}
+	}
+
+	result := map[string][]int{}
+	for file, lines := range seen {
+		result[file] = []int{}
+		for line := range lines {
+			result[file] = append(result[file], line)
+		}
+
+		sort.Ints(result[file])
+	}
+
+	return result
+}
+
+// The key of one of the program's files, by any path naming it. The empty
+// path is the main file.
+func (d *Debugger) fileKey(path string) (string, bool) {
+	if path == "" || samePath(path, d.filename) {
+		return "", true
+	}
+
+	for file := range d.statementLines {
+		if file != "" && samePath(path, file) {
+			return file, true
+		}
+	}
+
+	return "", false
+}
+
+// The path of a file keyed like in the line tables
+func (d *Debugger) fileName(file string) string {
+	if file == "" {
+		return d.filename
+	}
+
+	return file
+}
+
+func samePath(a, b string) bool {
+	if a == "" || b == "" {
+		return a == b
+	}
+
+	if filepath.Clean(a) == filepath.Clean(b) {
+		return true
+	}
+
+	absoluteA, errA := filepath.Abs(a)
+	absoluteB, errB := filepath.Abs(b)
+
+	return errA == nil && errB == nil && absoluteA == absoluteB
+}
+
+// Puts a breakpoint on the first line at or after line in file that has a
+// statement on it, the empty file being the main one. Returns the line the
+// breakpoint ended up on, or false if there is no such line or the program
+// doesn't have the file.
+func (d *Debugger) SetBreakpoint(file string, line int) (int, bool) {
+	key, ok := d.fileKey(file)
+	if !ok {
+		return 0, false
+	}
+
+	lines := d.statementLines[key]
+
+	i := sort.SearchInts(lines, line)
+	if i == len(lines) {
+		return 0, false
+	}
+
+	d.breakpoints[Location{key, lines[i]}] = true
+
+	return lines[i], true
+}
+
+func (d *Debugger) ClearBreakpoint(file string, line int) {
+	if key, ok := d.fileKey(file); ok {
+		delete(d.breakpoints, Location{key, line})
+	}
+}
+
+// Clears those in file
+func (d *Debugger) ClearBreakpoints(file string) {
+	key, ok := d.fileKey(file)
+	if !ok {
+		return
+	}
+
+	for location := range d.breakpoints {
+		if location.File == key {
+			delete(d.breakpoints, location)
+		}
+	}
+}
+
+// By file, then line
+func (d *Debugger) Breakpoints() []Location {
+	result := []Location{}
+	for location := range d.breakpoints {
+		result = append(result, Location{d.fileName(location.File), location.Line})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].File != result[j].File {
+			return result[i].File < result[j].File
+		}
+
+		return result[i].Line < result[j].Line
+	})
+
+	return result
+}
+
+// The source of one of the program's files, false if it doesn't have it
+func (d *Debugger) Source(file string) (string, bool) {
+	key, ok := d.fileKey(file)
+	if !ok {
+		return "", false
+	}
+
+	if key == "" {
+		return d.source, true
+	}
+
+	source, ok := d.bytecode.ImportedSources[key]
+	return source, ok
+}
+
+// Runs until a breakpoint is hit or the program finishes
+func (d *Debugger) Continue() (StopReason, error) {
+	return d.run(func(depth int, atStatement bool) bool {
+		return false
+	})
+}
+
+// Runs until the next statement, entering function calls
+func (d *Debugger) StepIn() (StopReason, error) {
+	start := d.depth()
+
+	return d.run(func(depth int, atStatement bool) bool {
+		return atStatement || depth < start
+	})
+}
+
+// Runs until the next statement in the current function, or until it returns
+func (d *Debugger) StepOver() (StopReason, error) {
+	start := d.depth()
+
+	return d.run(func(depth int, atStatement bool) bool {
+		return depth < start || (atStatement && depth <= start)
+	})
+}
+
+// Runs until the current function returns
+func (d *Debugger) StepOut() (StopReason, error) {
+	start := d.depth()
+
+	return d.run(func(depth int, atStatement bool) bool {
+		return depth < start
+	})
+}
+
+// Executes at least one instruction, unless a breakpoint is hit at entry,
+// then checks for breakpoints and shouldStop before every following one.
+// On a runtime error the VM is left as is, so it can still be inspected.
+func (d *Debugger) run(shouldStop func(depth int, atStatement bool) bool) (StopReason, error) {
+	if d.Finished() {
+		return Finished, nil
+	}
+
+	atBreakpoint := d.entryBreakpoint(shouldStop)
+	d.started = true
+
+	if !atBreakpoint {
+		err := d.machine.Step()
+		if err != nil {
+			d.failed = true
+			return Finished, err
+		}
+	}
+
+	for !d.machine.Finished() {
+		location, atStatement := d.statementAt()
+
+		if atStatement && d.breakpoints[location] {
+			return Breakpoint, nil
+		}
+
+		if shouldStop(d.depth(), atStatement) {
+			return Step, nil
+		}
+
+		err := d.machine.Step()
+		if err != nil {
+			d.failed = true
+			return Finished, err
+		}
+	}
+
+	return Finished, nil
+}
+
+// Nothing has executed at the entry stop, so a breakpoint on the first
+// statement is still ahead. A step moves on from there all the same.
+func (d *Debugger) entryBreakpoint(shouldStop func(depth int, atStatement bool) bool) bool {
+	if d.started {
+		return false
+	}
+
+	location, atStatement := d.statementAt()
+	return atStatement && d.breakpoints[location] && !shouldStop(d.depth(), atStatement)
+}
+
+func (d *Debugger) depth() int {
+	return len(d.machine.Frames())
+}
+
+func (d *Debugger) currentFrame() *vm.Frame {
+	frames := d.machine.Frames()
+
+	return frames[len(frames)-1]
+}
+
+func (d *Debugger) statementAt() (Location, bool) {
+	frame := d.currentFrame()
+
+	file, line, ok := frame.Function().StatementAt(frame.InstructionPointer())
+	return Location{file, line}, ok
+}
+
+// Whether the program ran to completion or failed
+func (d *Debugger) Finished() bool {
+	return d.failed || d.machine.Finished()
+}
+
+// Where execution will continue, in File
+func (d *Debugger) Position() token.Position {
+	return d.currentFrame().Position()
+}
+
+// The file execution will continue in
+func (d *Debugger) File() string {
+	return d.fileName(d.currentFrame().File())
+}
+
+// Value of the last expression statement, once the program finished
+func (d *Debugger) Result() object.Object {
+	return d.machine.LastStackTop()
+}
+
+// The call stack, innermost frame first
+func (d *Debugger) CallStack() []StackFrame {
+	frames := d.machine.Frames()
+	result := make([]StackFrame, len(frames))
+
+	for i, frame := range frames {
+		stackFrame := StackFrame{
+			Name:  frameName(frame, i),
+			frame: frame,
+		}
+
+		if i == len(frames)-1 {
+			stackFrame.File = d.fileName(frame.File())
+			stackFrame.Position = frame.Position()
+		} else {
+			stackFrame.File = d.fileName(frame.CallFile())
+			stackFrame.Position = frame.CallPosition()
+		}
+
+		result[len(frames)-1-i] = stackFrame
+	}
+
+	return result
+}
+
+func frameName(frame *vm.Frame, index int) string {
+	if index == 0 {
+		return "<main>"
+	}
+
+	if frame.Function().Name == "" {
+		return "<anonymous>"
+	}
+
+	return frame.Function().Name
+}
+
+// Local bindings of a frame, indexed like CallStack.
+// Bindings that haven't been assigned a value are left out.
+func (d *Debugger) Locals(frameIndex int) []Variable {
+	frame := d.CallStack()[frameIndex].frame
+	values := d.machine.Locals(frame)
+
+	result := []Variable{}
+	for i, name := range frame.Function().LocalNames {
+		if values[i] == nil {
+			continue
+		}
+
+		result = append(result, Variable{Name: name, Value: values[i]})
+	}
+
+	return result
+}
+
+// Variables a frame's closure captured, indexed like CallStack
+func (d *Debugger) FreeVariables(frameIndex int) []Variable {
+	frame := d.CallStack()[frameIndex].frame
+
+	result := []Variable{}
+	for i, name := range frame.Function().FreeNames {
+		result = append(result, Variable{Name: name, Value: frame.Closure().FreeVariables[i]})
+	}
+
+	return result
+}
+
+// Global bindings that have been assigned a value
+func (d *Debugger) Globals() []Variable {
+	result := []Variable{}
+
+	for i, name := range d.bytecode.GlobalNames {
+		value := d.machine.Global(i)
+		if value == nil {
+			continue
+		}
+
+		result = append(result, Variable{Name: name, Value: value})
+	}
+
+	return result
+}
+
+// Everything currently on the VM's stack, bottom first
+func (d *Debugger) Stack() []object.Object {
+	return d.machine.Stack()
+}
+
+// Resolves a name the way the frame's code would see it
+func (d *Debugger) Lookup(frameIndex int, name string) (object.Object, bool) {
+	scopes := [][]Variable{
+		d.Locals(frameIndex),
+		d.FreeVariables(frameIndex),
+		d.Globals(),
+	}
+
+	for _, variables := range scopes {
+		// Last definition wins when a name is shadowed
+		for i := len(variables) - 1; i >= 0; i-- {
+			if variables[i].Name == name {
+				return variables[i].Value, true
+			}
+		}
+	}
+
+	if builtin := object.GetBuiltinByName(name); builtin != nil {
+		return builtin, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,342 @@
+package debugger
+
+import (
+	"bytes"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const program = `let x = 10;
+let add = fn(a, b) {
+	let c = a + b;
+	c * 2
+};
+let makeAdder = fn(n) {
+	fn(m) { n + m }
+};
+let addTwo = makeAdder(2);
+let y = add(x, 5);
+addTwo(y)`
+
+func newDebugger(t *testing.T, input string) *Debugger {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	c := compiler.New()
+	err := c.Compile(program)
+	if err != nil {
+		t.Fatalf("Failed to compile: %s", err)
+	}
+
+	return New(c.Bytecode())
+}
+
+func TestStepping(t *testing.T) {
+	type action func(d *Debugger) (StopReason, error)
+
+	continueAction := (*Debugger).Continue
+	stepIn := (*Debugger).StepIn
+	stepOver := (*Debugger).StepOver
+	stepOut := (*Debugger).StepOut
+
+	tests := []struct {
+		action         action
+		expectedReason StopReason
+		expectedLine   int
+		expectedDepth  int
+	}{
+		{stepOver, Step, 2, 1},
+		{stepOver, Step, 6, 1},
+		{stepOver, Step, 9, 1},
+		{stepIn, Step, 7, 2},
+		{stepIn, Step, 9, 1}, // Back in main, after the call
+		{stepOver, Step, 10, 1},
+		{stepIn, Step, 3, 2},
+		{stepOut, Step, 10, 1},
+		{stepOver, Step, 11, 1},
+		{stepIn, Step, 7, 2},
+		{continueAction, Finished, 0, 0},
+	}
+
+	d := newDebugger(t, program)
+
+	if d.Position().Line != 1 {
+		t.Fatalf("initial line %d wrong, expected 1", d.Position().Line)
+	}
+
+	for i, test := range tests {
+		reason, err := test.action(d)
+		if err != nil {
+			t.Fatalf("test %d: execution failed: %s", i, err)
+		}
+
+		if reason != test.expectedReason {
+			t.Fatalf("test %d: stop reason %s wrong, expected %s", i, reason, test.expectedReason)
+		}
+
+		if reason == Finished {
+			continue
+		}
+
+		if line := d.Position().Line; line != test.expectedLine {
+			t.Fatalf("test %d: line %d wrong, expected %d", i, line, test.expectedLine)
+		}
+
+		if depth := len(d.CallStack()); depth != test.expectedDepth {
+			t.Fatalf("test %d: depth %d wrong, expected %d", i, depth, test.expectedDepth)
+		}
+	}
+
+	if d.Result().Inspect() != "32" {
+		t.Errorf("result %s wrong, expected 32", d.Result().Inspect())
+	}
+}
+
+func TestBreakpointsAndInspection(t *testing.T) {
+	d := newDebugger(t, program)
+
+	line, ok := d.SetBreakpoint("", 4)
+	if !ok || line != 4 {
+		t.Fatalf("breakpoint set on line %d (%t), expected 4", line, ok)
+	}
+
+	// Line 5 only closes the function, so the breakpoint moves on to line 6
+	line, ok = d.SetBreakpoint("", 5)
+	if !ok || line != 6 {
+		t.Fatalf("breakpoint set on line %d (%t), expected 6", line, ok)
+	}
+
+	_, ok = d.SetBreakpoint("", 12)
+	if ok {
+		t.Fatalf("breakpoint set past the end of the program")
+	}
+
+	reason, err := d.Continue()
+	if err != nil || reason != Breakpoint || d.Position().Line != 6 {
+		t.Fatalf("expected breakpoint on line 6, got %s on line %d (%v)", reason, d.Position().Line, err)
+	}
+
+	reason, err = d.Continue()
+	if err != nil || reason != Breakpoint || d.Position().Line != 4 {
+		t.Fatalf("expected breakpoint on line 4, got %s on line %d (%v)", reason, d.Position().Line, err)
+	}
+
+	stack := d.CallStack()
+	if len(stack) != 2 || stack[0].Name != "add" || stack[1].Name != "<main>" {
+		t.Fatalf("call stack %+v wrong", stack)
+	}
+
+	if stack[1].Position.Line != 10 {
+		t.Errorf("caller line %d wrong, expected 10", stack[1].Position.Line)
+	}
+
+	expectedLocals := map[string]string{"a": "10", "b": "5", "c": "15"}
+	locals := d.Locals(0)
+	if len(locals) != len(expectedLocals) {
+		t.Fatalf("wrong number of locals %d, expected %d", len(locals), len(expectedLocals))
+	}
+
+	for _, local := range locals {
+		if local.Value.Inspect() != expectedLocals[local.Name] {
+			t.Errorf("local %s = %s wrong, expected %s", local.Name, local.Value.Inspect(), expectedLocals[local.Name])
+		}
+	}
+
+	value, ok := d.Lookup(0, "x")
+	if !ok || value.Inspect() != "10" {
+		t.Errorf("global x not resolved from inside function")
+	}
+
+	_, ok = d.Lookup(1, "a")
+	if ok {
+		t.Errorf("local a resolved from main frame")
+	}
+
+	d.ClearBreakpoints("")
+	d.SetBreakpoint("", 7)
+
+	reason, _ = d.Continue()
+	if reason != Breakpoint || d.Position().Line != 7 {
+		t.Fatalf("expected breakpoint on line 7, got %s on line %d", reason, d.Position().Line)
+	}
+
+	free := d.FreeVariables(0)
+	if len(free) != 1 || free[0].Name != "n" || free[0].Value.Inspect() != "2" {
+		t.Errorf("free variables %+v wrong", free)
+	}
+
+	globals := d.Globals()
+	if len(globals) != 5 {
+		t.Errorf("wrong number of globals %d, expected 5", len(globals))
+	}
+}
+
+const library = `export let check = fn(x) {
+	let y = x * 2;
+	y + 1
+};`
+
+const importing = `import "lib.mk" as lib;
+let a = lib.check(1);
+let b = lib.check(a);
+b`
+
+// Writes the program importing the library to a directory of its own
+func writeImporting(t *testing.T) string {
+	dir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(dir, "lib.mk"), []byte(library), 0644)
+	if err != nil {
+		t.Fatalf("could not write library: %s", err)
+	}
+
+	return filepath.Join(dir, "main.mk")
+}
+
+func TestBreakpointOnFirstLine(t *testing.T) {
+	d := newDebugger(t, program)
+	d.SetBreakpoint("", 1)
+
+	// Nothing has executed at entry, so the breakpoint is still ahead
+	reason, err := d.Continue()
+	if err != nil || reason != Breakpoint || d.Position().Line != 1 {
+		t.Fatalf("expected breakpoint on line 1, got %s on line %d (%v)", reason, d.Position().Line, err)
+	}
+
+	reason, err = d.Continue()
+	if err != nil || reason != Finished {
+		t.Fatalf("expected to finish, got %s on line %d (%v)", reason, d.Position().Line, err)
+	}
+
+	// Stepping from entry moves on all the same
+	d = newDebugger(t, program)
+	d.SetBreakpoint("", 1)
+
+	reason, err = d.StepOver()
+	if err != nil || reason != Step || d.Position().Line != 2 {
+		t.Fatalf("expected step to line 2, got %s on line %d (%v)", reason, d.Position().Line, err)
+	}
+}
+
+func TestImportedFiles(t *testing.T) {
+	main := writeImporting(t)
+	lib := filepath.Join(filepath.Dir(main), "lib.mk")
+
+	d, err := Load(main, importing, object.NewContext())
+	if err != nil {
+		t.Fatalf("could not load: %s", err)
+	}
+
+	line, ok := d.SetBreakpoint(lib, 2)
+	if !ok || line != 2 {
+		t.Fatalf("breakpoint set on line %d (%t), expected 2", line, ok)
+	}
+
+	_, ok = d.SetBreakpoint(filepath.Join(filepath.Dir(main), "other.mk"), 2)
+	if ok {
+		t.Fatalf("breakpoint set in a file the program doesn't have")
+	}
+
+	// Line 2 of the main file has a statement too, the breakpoint mustn't stop there
+	reason, err := d.Continue()
+	if err != nil || reason != Breakpoint || d.File() != lib || d.Position().Line != 2 {
+		t.Fatalf("expected breakpoint at %s:2, got %s at %s:%d (%v)", lib, reason, d.File(), d.Position().Line, err)
+	}
+
+	stack := d.CallStack()
+	if len(stack) != 2 || stack[0].File != lib || stack[1].File != main || stack[1].Position.Line != 2 {
+		t.Fatalf("call stack %+v wrong", stack)
+	}
+
+	expected := []Location{{lib, 3}, {main, 2}, {main, 3}, {lib, 2}, {lib, 3}, {main, 3}, {main, 4}}
+	for i, location := range expected {
+		_, err := d.StepIn()
+		if err != nil {
+			t.Fatalf("step %d failed: %s", i, err)
+		}
+
+		if d.File() != location.File || d.Position().Line != location.Line {
+			t.Fatalf("step %d stopped at %s:%d, expected %s:%d", i, d.File(), d.Position().Line, location.File, location.Line)
+		}
+	}
+
+	breakpoints := d.Breakpoints()
+	if len(breakpoints) != 1 || breakpoints[0] != (Location{lib, 2}) {
+		t.Errorf("breakpoints %+v wrong", breakpoints)
+	}
+
+	d.ClearBreakpoints(main)
+	if len(d.Breakpoints()) != 1 {
+		t.Errorf("clearing the main file's breakpoints cleared the library's")
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	input := strings.NewReader("b 3\nc\nbt\np a\nn\nlocals\nc\n")
+	var out bytes.Buffer
+
+	Start(input, &out, "test.mk", program)
+
+	expected := []string{
+		"stopped (entry) at test.mk:1",
+		"breakpoint set at test.mk:3",
+		"stopped (breakpoint) at test.mk:3",
+		"* #0 add at test.mk:3",
+		"  #1 <main> at test.mk:10",
+		"a = 10",
+		"stopped (step) at test.mk:4",
+		"c = 15",
+		"program finished, result: 32",
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("output does not contain %q:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestCommandLineMacroOutput(t *testing.T) {
+	var out bytes.Buffer
+
+	Start(strings.NewReader("c\n"), &out, "test.mk", "let loud = macro(x) { puts(\"expanding\"); x };\nloud(1);")
+
+	// Expanded while loading, before the session starts
+	if !strings.HasPrefix(out.String(), "expanding\nstopped (entry)") {
+		t.Errorf("macro output not written to the session:\n%s", out.String())
+	}
+}
+
+func TestCommandLineImports(t *testing.T) {
+	main := writeImporting(t)
+	lib := filepath.Join(filepath.Dir(main), "lib.mk")
+
+	input := strings.NewReader("b " + lib + ":2\nb other.mk:1\nc\nbt\nl\n")
+	var out bytes.Buffer
+
+	Start(input, &out, main, importing)
+
+	expected := []string{
+		"breakpoint set at " + lib + ":2",
+		"other.mk isn't part of the program",
+		"stopped (breakpoint) at " + lib + ":2",
+		"* #0 check at " + lib + ":2",
+		"  #1 <main> at " + main + ":2",
+		">   2 | \tlet y = x * 2;",
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("output does not contain %q:\n%s", line, out.String())
+		}
+	}
+}
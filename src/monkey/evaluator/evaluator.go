@@ -1,15 +1,19 @@
 package evaluator
 
 import (
+	"errors"
 	"fmt"
 	"monkey/ast"
 	"monkey/object"
 )
 
+// How deep calls may nest, the VMs' limit so the engines fail alike
+const MaxFrames = 1024
+
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.Nil
+	TRUE  = object.True
+	FALSE = object.False
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
@@ -17,7 +21,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 	// Statements
 	case *ast.Program:
-		return evalProgram(node, env)
+		return evalProgram(node, env, nil)
 
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
@@ -39,9 +43,15 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		env.Set(node.Name.Value, val)
 
+	case *ast.ImportStatement:
+		return newError("imports must be at the top level")
+
+	case *ast.ExportStatement:
+		return newError("exports must be at the top level")
+
 	// Expressions
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return object.NewInteger(node.Value)
 
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
@@ -81,6 +91,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return &object.Function{Parameters: params, Env: env, Body: body}
 
 	case *ast.CallExpression:
+		if isCallTo(node, "quote") {
+			return quote(node.Arguments[0], env)
+		}
+
 		function := Eval(node.Function, env)
 		if isError(function) {
 			return function
@@ -91,7 +105,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		return applyFunction(function, args, env)
+
+	case *ast.MemberExpression:
+		return evalMemberExpression(node, env)
+
+	case *ast.MacroLiteral:
+		return newError("macros can only be defined with a top-level let")
 
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
@@ -119,11 +139,26 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	return nil
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+// Exported values go into exports, unless it's nil
+func evalProgram(program *ast.Program, env *object.Environment, exports map[string]object.Object) object.Object {
 	var result object.Object
 
 	for _, statement := range program.Statements {
-		result = Eval(statement, env)
+		// Only allowed at the top level
+		switch statement := statement.(type) {
+		case *ast.ImportStatement:
+			result = evalImportStatement(statement, env)
+
+		case *ast.ExportStatement:
+			result = Eval(statement.Statement, env)
+			if exports != nil && !isError(result) {
+				name := statement.Statement.Name.Value
+				exports[name], _ = env.Get(name)
+			}
+
+		default:
+			result = Eval(statement, env)
+		}
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
@@ -153,6 +188,11 @@ func evalBlockStatement(
 		}
 	}
 
+	// Empty blocks, or ones ending in a let, give null like in the VM
+	if result == nil {
+		return NULL
+	}
+
 	return result
 }
 
@@ -181,12 +221,12 @@ func evalInfixExpression(
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
-	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(operator, left, right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
@@ -197,16 +237,7 @@ func evalInfixExpression(
 }
 
 func evalBangOperatorExpression(right object.Object) object.Object {
-	switch right {
-	case TRUE:
-		return FALSE
-	case FALSE:
-		return TRUE
-	case NULL:
-		return TRUE
-	default:
-		return FALSE
-	}
+	return nativeBoolToBooleanObject(!object.IsTruthy(right))
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
@@ -215,7 +246,7 @@ func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 	}
 
 	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	return object.NewInteger(-value)
 }
 
 func evalIntegerInfixExpression(
@@ -227,13 +258,16 @@ func evalIntegerInfixExpression(
 
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return object.NewInteger(leftVal + rightVal)
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return object.NewInteger(leftVal - rightVal)
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return object.NewInteger(leftVal * rightVal)
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return object.NewInteger(leftVal / rightVal)
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
@@ -271,7 +305,7 @@ func evalIfExpression(
 		return condition
 	}
 
-	if isTruthy(condition) {
+	if object.IsTruthy(condition) {
 		return Eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
 		return Eval(ie.Alternative, env)
@@ -285,6 +319,9 @@ func evalIdentifier(
 	env *object.Environment,
 ) object.Object {
 	if val, ok := env.Get(node.Value); ok {
+		if _, ok := val.(*object.Module); ok {
+			return newError("module %s can only be used for its members, like %s.name", node.Value, node.Value)
+		}
 		return val
 	}
 
@@ -295,19 +332,6 @@ func evalIdentifier(
 	return newError("identifier not found: " + node.Value)
 }
 
-func isTruthy(obj object.Object) bool {
-	switch obj {
-	case NULL:
-		return false
-	case TRUE:
-		return true
-	case FALSE:
-		return false
-	default:
-		return true
-	}
-}
-
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
@@ -336,16 +360,27 @@ func evalExpressions(
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// Builtins do their IO in the caller's context
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
 	switch fn := fn.(type) {
 
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments %d, expected %d", len(args), len(fn.Parameters))
+		}
+
+		// Like the VMs' frames, the program's own counting as one
+		if !fn.Env.EnterCall(MaxFrames - 1) {
+			return newError("stack overflow (%d frames)", MaxFrames)
+		}
+		defer fn.Env.LeaveCall()
+
 		extendedEnv := extendFunctionEnv(fn, args)
 		evaluated := Eval(fn.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 
 	case *object.Builtin:
-		result := fn.Fn(args...)
+		result := fn.Fn(env.BuiltinContext(builtinCaller), args...)
 
 		if result == nil {
 			return NULL
@@ -358,6 +393,18 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	}
 }
 
+// How builtins call functions of the program evaluated in env
+func builtinCaller(env *object.Environment) func(object.Object, ...object.Object) (object.Object, error) {
+	return func(function object.Object, args ...object.Object) (object.Object, error) {
+		result := applyFunction(function, args, env)
+		if err, ok := result.(*object.Error); ok {
+			return nil, errors.New(err.Message)
+		}
+
+		return result, nil
+	}
+}
+
 func extendFunctionEnv(
 	fn *object.Function,
 	args []object.Object,
@@ -406,43 +453,42 @@ func evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
 ) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, env)
 		if isError(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
+		value := Eval(pair.Value, env)
 		if isError(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashKey, value)
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
+	key, ok := object.AsHashable(index)
 	if !ok {
 		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
 
-	return pair.Value
+	return value
 }
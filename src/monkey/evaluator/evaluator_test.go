@@ -1,9 +1,11 @@
 package evaluator
 
 import (
+	"io"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"sync"
 	"testing"
 )
 
@@ -67,6 +69,35 @@ func TestEvalBooleanExpression(t *testing.T) {
 	}
 }
 
+func TestStructuralEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`[1, [2, "a"]] == [1, [2, "a"]]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, 2] != [1, 2, 3]`, true},
+		{`[] == []`, true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{`{[1, 2]: 3} == {[1, 2]: 3}`, true},
+		{`"a" == "a"`, true},
+		{`"a" != "b"`, true},
+		{`first([]) == first([])`, true},
+		{`first([]) == 0`, false},
+		{`[1] == 1`, false},
+		{`1 != "1"`, true},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -78,6 +109,9 @@ func TestBangOperator(t *testing.T) {
 		{"!!true", true},
 		{"!!false", false},
 		{"!!5", true},
+		{"!0", true},
+		{`!""`, false},
+		{"![]", false},
 	}
 
 	for _, tt := range tests {
@@ -217,10 +251,26 @@ if (10 > 1) {
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			`{[1, [fn(x) { x }]]: 1}`,
+			"unusable as hash key: ARRAY",
+		},
 		{
 			`999[1]`,
 			"index operator not supported: INTEGER",
 		},
+		{
+			"10 / (5 - 5)",
+			"division by zero",
+		},
+		{
+			"fn(a, b) { a }(1)",
+			"wrong number of arguments 1, expected 2",
+		},
+		{
+			"let f = fn(n) { f(n + 1) }; f(0)",
+			"stack overflow (1024 frames)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -379,6 +429,23 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`rest([])`, nil},
 		{`push([], 1)`, []int{1}},
 		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		{`len("日本語")`, 3},
+		{`len(split("a b c", " "))`, 3},
+		{`index_of(upper("héllo"), "L")`, 2},
+		{`parse_int(substring("x42", 1)) + 1`, 43},
+		{`len(join(["a", "b"], repeat("-", 3)))`, 5},
+		{`if (contains(trim(" monkey "), "key")) { 1 } else { 0 }`, 1},
+		{`if (starts_with("monkey", "key") == false) { 1 } else { 0 }`, 1},
+		{`trim(1)`, "argument to `trim` must be STRING, got INTEGER"},
+		{`substring("abc", 2, 1)`, "substring range 2 to 1 out of bounds for length 3"},
+		{`json_parse(json_stringify({"a": [1, 2, 3]}))["a"][2]`, 3},
+		{`keys({"b": 1, 3: 2, true: 3})[1]`, 3},
+		{`values({"b": 1, "a": 2, "c": 3})`, []int{1, 2, 3}},
+		{`keys([])`, "argument to `keys` must be HASH, got ARRAY"},
+		{`len(json_stringify({"b": 1, "a": [true, first([])]}))`, 23},
+		{`if (json_parse("null") == first([])) { 1 } else { 0 }`, 1},
+		{`json_parse("[1,
+ 2.5]")`, "invalid JSON at 2:2: number 2.5 isn't an integer"},
 	}
 
 	for _, tt := range tests {
@@ -514,26 +581,34 @@ func TestHashLiterals(t *testing.T) {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
 	}
 
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
+	// In the order they're written
+	expected := []struct {
+		key   object.Hashable
+		value int64
+	}{
+		{&object.String{Value: "one"}, 1},
+		{&object.String{Value: "two"}, 2},
+		{&object.String{Value: "three"}, 3},
+		{&object.Integer{Value: 4}, 4},
+		{TRUE, 5},
+		{FALSE, 6},
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
 
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+	for i, pair := range result.Pairs() {
+		if pair.Key.Inspect() != expected[i].key.Inspect() {
+			t.Errorf("pair %d has wrong key. expected=%s, got=%s", i, expected[i].key.Inspect(), pair.Key.Inspect())
+		}
+
+		value, ok := result.Get(expected[i].key)
 		if !ok {
 			t.Errorf("no pair for given key in Pairs")
 		}
 
-		testIntegerObject(t, pair.Value, expectedValue)
+		testIntegerObject(t, value, expected[i].value)
 	}
 }
 
@@ -570,6 +645,18 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			`{[1, [true, "a"]]: 5}[[1, [true, "a"]]]`,
+			5,
+		},
+		{
+			`{[1, 2]: 5}[[2, 1]]`,
+			nil,
+		},
+		{
+			`{[1]: 5, 1: 6}[1]`,
+			6,
+		},
 	}
 
 	for _, tt := range tests {
@@ -582,6 +669,35 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+func TestConcurrentEvaluationsShareContext(t *testing.T) {
+	program := parser.New(lexer.New(`
+		let fail = fn() { 1 / 0 };
+		puts(assert_error(fail), assert_error(fail))`)).ParseProgram()
+
+	// Builtins calling back into each evaluation don't go through the shared context
+	context := &object.Context{Stdout: io.Discard}
+
+	var wait sync.WaitGroup
+	for range 8 {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+
+			env := object.NewEnvironment()
+			env.SetContext(context)
+
+			if result := Eval(program, env); result.Type() == object.ERROR_OBJ {
+				t.Errorf("evaluation failed: %s", result.Inspect())
+			}
+		}()
+	}
+	wait.Wait()
+
+	if context.Call != nil {
+		t.Errorf("the shared context was changed")
+	}
+}
+
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -0,0 +1,165 @@
+package evaluator
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/object"
+	"monkey/token"
+)
+
+// How often a macro's expansion may expand into another macro call
+const maxExpansionDepth = 100
+
+type MacroError struct {
+	Position token.Position
+	Message  string
+}
+
+func (e *MacroError) Error() string {
+	return e.Message
+}
+
+// Moves the top-level `let name = macro(...) { ... }` statements out of the
+// program and into env.
+func DefineMacros(program *ast.Program, env *object.Environment) {
+	statements := []ast.Statement{}
+
+	for _, statement := range program.Statements {
+		if isMacroDefinition(statement) {
+			addMacro(statement, env)
+		} else {
+			statements = append(statements, statement)
+		}
+	}
+
+	program.Statements = statements
+}
+
+func isMacroDefinition(node ast.Statement) bool {
+	letStatement, ok := node.(*ast.LetStatement)
+	if !ok {
+		return false
+	}
+
+	_, ok = letStatement.Value.(*ast.MacroLiteral)
+	return ok
+}
+
+func addMacro(stmt ast.Statement, env *object.Environment) {
+	letStatement, _ := stmt.(*ast.LetStatement)
+	macroLiteral, _ := letStatement.Value.(*ast.MacroLiteral)
+
+	macro := &object.Macro{
+		Parameters: macroLiteral.Parameters,
+		Env:        env,
+		Body:       macroLiteral.Body,
+	}
+
+	env.Set(letStatement.Name.Value, macro)
+}
+
+// Replaces every call of a macro in env with the AST the macro returns for
+// it. Macro calls in what a macro returns are expanded too.
+func ExpandMacros(program ast.Node, env *object.Environment) (ast.Node, error) {
+	return expandMacros(program, env, 0)
+}
+
+func expandMacros(program ast.Node, env *object.Environment, depth int) (ast.Node, error) {
+	var err error
+
+	expanded := ast.Modify(program, func(node ast.Node) ast.Node {
+		callExpression, ok := node.(*ast.CallExpression)
+		if err != nil || !ok {
+			return node
+		}
+
+		macro, ok := isMacroCall(callExpression, env)
+		if !ok {
+			return node
+		}
+
+		var expansion ast.Node
+		expansion, err = expandMacroCall(callExpression, macro, env, depth)
+		if err != nil {
+			return node
+		}
+
+		return expansion
+	})
+
+	return expanded, err
+}
+
+func isMacroCall(exp *ast.CallExpression, env *object.Environment) (*object.Macro, bool) {
+	identifier, ok := exp.Function.(*ast.Identifier)
+	if !ok {
+		return nil, false
+	}
+
+	obj, ok := env.Get(identifier.Value)
+	if !ok {
+		return nil, false
+	}
+
+	macro, ok := obj.(*object.Macro)
+	return macro, ok
+}
+
+func expandMacroCall(
+	call *ast.CallExpression,
+	macro *object.Macro,
+	env *object.Environment,
+	depth int,
+) (ast.Node, error) {
+	name := call.Function.String()
+	fail := func(format string, a ...interface{}) (ast.Node, error) {
+		return nil, &MacroError{call.Pos(), fmt.Sprintf("macro %s: %s", name, fmt.Sprintf(format, a...))}
+	}
+
+	if depth >= maxExpansionDepth {
+		return fail("expansion too deep")
+	}
+
+	if len(call.Arguments) != len(macro.Parameters) {
+		return fail("wrong number of arguments. got=%d, want=%d",
+			len(call.Arguments), len(macro.Parameters))
+	}
+
+	evalEnv := extendMacroEnv(macro, quoteArgs(call))
+
+	evaluated := unwrapReturnValue(Eval(macro.Body, evalEnv))
+
+	if errorObj, ok := evaluated.(*object.Error); ok {
+		return fail("%s", errorObj.Message)
+	}
+
+	quote, ok := evaluated.(*object.Quote)
+	if !ok {
+		return fail("must return a quote, not %s", evaluated.Type())
+	}
+
+	return expandMacros(quote.Node, env, depth+1)
+}
+
+func quoteArgs(exp *ast.CallExpression) []*object.Quote {
+	args := []*object.Quote{}
+
+	for _, a := range exp.Arguments {
+		args = append(args, &object.Quote{Node: a})
+	}
+
+	return args
+}
+
+func extendMacroEnv(
+	macro *object.Macro,
+	args []*object.Quote,
+) *object.Environment {
+	extended := object.NewEnclosedEnvironment(macro.Env)
+
+	for paramIdx, param := range macro.Parameters {
+		extended.Set(param.Value, args[paramIdx])
+	}
+
+	return extended
+}
@@ -0,0 +1,84 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"monkey/ast"
+	"monkey/object"
+)
+
+// Evaluates an imported file the first time it's imported, and names it
+func evalImportStatement(node *ast.ImportStatement, env *object.Environment) object.Object {
+	modules := env.Modules()
+	path := modules.Loader.Resolve(node.Path.Value)
+
+	imported, ok := modules.Imported[path]
+	if !ok {
+		var err error
+		imported, err = evalModule(path, env)
+		if err != nil {
+			return newError("import %q: %s", node.Path.Value, err)
+		}
+	}
+
+	env.Set(node.Name.Value, imported)
+
+	return nil
+}
+
+func evalModule(path string, importer *object.Environment) (*object.Module, error) {
+	modules := importer.Modules()
+
+	program, err := modules.Loader.Enter(path)
+	if err != nil {
+		return nil, err
+	}
+	defer modules.Loader.Leave()
+
+	macros := object.NewEnvironment()
+	macros.SetContext(importer.Context())
+	DefineMacros(program, macros)
+	expanded, err := ExpandMacros(program, macros)
+	switch err := err.(type) {
+	case nil:
+	case *MacroError:
+		return nil, fmt.Errorf("%s:%s: %s", path, err.Position, err.Message)
+	default:
+		return nil, err
+	}
+
+	imported := &object.Module{Path: path, Exports: map[string]object.Object{}}
+
+	result := evalProgram(expanded.(*ast.Program), object.NewModuleEnvironment(importer), imported.Exports)
+	if isError(result) {
+		return nil, errors.New(result.(*object.Error).Message)
+	}
+
+	modules.Imported[path] = imported
+
+	return imported, nil
+}
+
+func evalMemberExpression(node *ast.MemberExpression, env *object.Environment) object.Object {
+	identifier, ok := node.Module.(*ast.Identifier)
+	if !ok {
+		return newError("%s is not a module", node.Module)
+	}
+
+	value, ok := env.Get(identifier.Value)
+	if !ok {
+		return newError("identifier not found: " + identifier.Value)
+	}
+
+	imported, ok := value.(*object.Module)
+	if !ok {
+		return newError("%s is not a module", identifier.Value)
+	}
+
+	export, ok := imported.Exports[node.Member.Value]
+	if !ok {
+		return newError("%s doesn't export %s", imported.Path, node.Member.Value)
+	}
+
+	return export
+}
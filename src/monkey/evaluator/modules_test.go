@@ -0,0 +1,129 @@
+package evaluator
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/module"
+	"monkey/object"
+	"strings"
+	"testing"
+)
+
+var moduleFiles = map[string]string{
+	"lib/math.mk": `
+		let square = fn(x) { x * x };
+		export let pi = 3;
+		export let cube = fn(x) { x * square(x) };`,
+	"lib/geometry.mk": `
+		import "math.mk" as math;
+		export let area = fn(r) { math.pi * r * r };`,
+	"lib/shadowed.mk": `
+		export let x = 1;
+		let x = 2;`,
+	"lib/broken.mk": `export let x = y;`,
+	"lib/greeter.mk": `
+		puts("loading");
+		export let greet = fn(name) { puts("hello " + name) };`,
+	"cycle/a.mk": `import "b.mk" as b;`,
+	"cycle/b.mk": `import "a.mk" as a;`,
+}
+
+func testEvalWithModules(input string) object.Object {
+	loader := module.NewLoader("")
+	loader.ReadFile = func(name string) ([]byte, error) {
+		source, ok := moduleFiles[name]
+		if !ok {
+			return nil, fmt.Errorf("open %s: no such file", name)
+		}
+		return []byte(source), nil
+	}
+
+	env := object.NewEnvironment()
+	env.SetLoader(loader)
+
+	return Eval(testParseProgram(input), env)
+}
+
+func TestModules(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`import "lib/math.mk" as math; math.cube(2) + math.pi`, 11},
+		{`let square = 5; import "lib/math.mk" as m; square + m.cube(2)`, 13},
+		{`import "lib/geometry.mk" as g; import "lib/math.mk" as m; g.area(2) + m.pi`, 15},
+		{`import "lib/math.mk" as m; let f = fn() { fn() { m.pi } }; f()()`, 3},
+		{`import "lib/shadowed.mk" as s; s.x`, 1},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEvalWithModules(tt.input), tt.expected)
+	}
+}
+
+func TestModulesAreEvaluatedOnce(t *testing.T) {
+	loader := module.NewLoader("")
+	reads := 0
+	loader.ReadFile = func(name string) ([]byte, error) {
+		reads++
+		return []byte(moduleFiles["lib/math.mk"]), nil
+	}
+
+	env := object.NewEnvironment()
+	env.SetLoader(loader)
+	Eval(testParseProgram(`import "lib/math.mk" as a; import "lib/../lib/math.mk" as b;`), env)
+
+	a, _ := env.Get("a")
+	b, _ := env.Get("b")
+	if reads != 1 || a != b {
+		t.Errorf("module read %d times, a=%v b=%v", reads, a, b)
+	}
+}
+
+func TestModulesShareTheContext(t *testing.T) {
+	loader := module.NewLoader("")
+	loader.ReadFile = func(name string) ([]byte, error) {
+		return []byte(moduleFiles[name]), nil
+	}
+
+	var output bytes.Buffer
+
+	env := object.NewEnvironment()
+	env.SetLoader(loader)
+	env.SetContext(&object.Context{Stdout: &output})
+	Eval(testParseProgram(`import "lib/greeter.mk" as g; let f = fn() { g.greet("monkey") }; f();`), env)
+
+	expected := "loading\nhello monkey\n"
+	if output.String() != expected {
+		t.Errorf("wrong output. expected=%q, got=%q", expected, output.String())
+	}
+}
+
+func TestModuleErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "lib/math.mk" as m; m.square`, "lib/math.mk doesn't export square"},
+		{`import "lib/math.mk" as m; m`, "module m can only be used for its members, like m.name"},
+		{`let x = 1; x.y`, "x is not a module"},
+		{`m.y`, "identifier not found: m"},
+		{`if (true) { import "lib/math.mk" as m }`, "imports must be at the top level"},
+		{`fn() { export let x = 1 }()`, "exports must be at the top level"},
+		{`import "missing.mk" as m`, `import "missing.mk": open missing.mk: no such file`},
+		{`import "lib/broken.mk" as m`, `import "lib/broken.mk": identifier not found: y`},
+		{`import "cycle/a.mk" as a`, "import cycle: cycle/a.mk -> cycle/b.mk -> cycle/a.mk"},
+	}
+
+	for _, tt := range tests {
+		errObj, ok := testEvalWithModules(tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q", tt.input)
+			continue
+		}
+
+		if !strings.HasSuffix(errObj.Message, tt.expected) {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expected, errObj.Message)
+		}
+	}
+}
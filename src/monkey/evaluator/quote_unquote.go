@@ -0,0 +1,101 @@
+package evaluator
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/object"
+	"monkey/token"
+)
+
+func quote(node ast.Node, env *object.Environment) object.Object {
+	// Unquoting replaces nodes in place, and the same quote may be evaluated
+	// again, say by every call of a macro
+	node, err := evalUnquoteCalls(ast.Copy(node), env)
+	if err != nil {
+		return err
+	}
+
+	return &object.Quote{Node: node}
+}
+
+// Replaces every unquote(x) inside the quoted node with the AST of what x
+// evaluates to. The first error stops the replacing.
+func evalUnquoteCalls(quoted ast.Node, env *object.Environment) (ast.Node, *object.Error) {
+	var err *object.Error
+
+	node := ast.Modify(quoted, func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if err != nil || !ok || !isCallTo(call, "unquote") {
+			return node
+		}
+
+		unquoted := Eval(call.Arguments[0], env)
+		if isError(unquoted) {
+			err = unquoted.(*object.Error)
+			return node
+		}
+
+		replacement, ok := convertObjectToASTNode(unquoted, call.Pos())
+		if !ok {
+			err = newError("can't unquote %s", unquoted.Type())
+			return node
+		}
+
+		return replacement
+	})
+
+	return node, err
+}
+
+// Whether call is name(x), exactly one argument
+func isCallTo(call *ast.CallExpression, name string) bool {
+	identifier, ok := call.Function.(*ast.Identifier)
+	if !ok || identifier.Value != name {
+		return false
+	}
+
+	return len(call.Arguments) == 1
+}
+
+// The literal that evaluates to obj, placed where the unquote call was
+func convertObjectToASTNode(obj object.Object, position token.Position) (ast.Expression, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		t := token.Token{Type: token.INT, Literal: fmt.Sprintf("%d", obj.Value), Position: position}
+		return &ast.IntegerLiteral{Token: t, Value: obj.Value}, true
+
+	case *object.Boolean:
+		var t token.Token
+		if obj.Value {
+			t = token.Token{Type: token.TRUE, Literal: "true", Position: position}
+		} else {
+			t = token.Token{Type: token.FALSE, Literal: "false", Position: position}
+		}
+		return &ast.Boolean{Token: t, Value: obj.Value}, true
+
+	case *object.String:
+		t := token.Token{Type: token.STRING, Literal: obj.Value, Position: position}
+		return &ast.StringLiteral{Token: t, Value: obj.Value}, true
+
+	case *object.Array:
+		array := &ast.ArrayLiteral{
+			Token:    token.Token{Type: token.LBRACKET, Literal: "[", Position: position},
+			EndToken: token.Token{Type: token.RBRACKET, Literal: "]", Position: position},
+		}
+		for _, element := range obj.Elements {
+			expression, ok := convertObjectToASTNode(element, position)
+			if !ok {
+				return nil, false
+			}
+			array.Elements = append(array.Elements, expression)
+		}
+		return array, true
+
+	case *object.Quote:
+		expression, ok := ast.Copy(obj.Node).(ast.Expression)
+		return expression, ok
+
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,36 @@
+// Pretty printing Monkey source in the one canonical style
+package format
+
+import (
+	"fmt"
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+)
+
+const (
+	maxWidth = 80 // Lists longer than this are broken up, one item per line
+	tabWidth = 4  // How wide an indentation counts as
+)
+
+// Formats a whole program, keeping its comments and blank lines between statements.
+// Only syntactically valid programs can be formatted.
+func Source(source string) (string, error) {
+	l := lexer.New(source)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.DetailedErrors()) != 0 {
+		messages := []string{}
+		for _, err := range p.DetailedErrors() {
+			messages = append(messages, fmt.Sprintf("%s: %s", err.Position, err.Message))
+		}
+
+		return "", fmt.Errorf("%s", strings.Join(messages, "\n"))
+	}
+
+	printer := &printer{comments: l.Comments(), lines: strings.Split(source, "\n")}
+	printer.program(program)
+
+	return printer.out.String(), nil
+}
@@ -0,0 +1,169 @@
+package format
+
+import (
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"let x=1;let y   = x+2*3", "let x = 1;\nlet y = x + 2 * 3;\n"},
+		{"puts(1)", "puts(1)\n"},
+		{"puts(1);puts(2);", "puts(1);\nputs(2)\n"},
+		{"let f = fn(a,b){a+b}", "let f = fn(a, b) { a + b };\n"},
+		{"let f = fn() {\n}", "let f = fn() {};\n"},
+		{"fn(x) {\nlet y = x;\ny\n}", "fn(x) {\n\tlet y = x;\n\ty\n}\n"},
+		{"let m = macro(a,b){quote(unquote(a)+unquote(b))}", "let m = macro(a, b) { quote(unquote(a) + unquote(b)) };\n"},
+		{"import   \"lib/math.mk\" as   math\nexport let x=-math.pi;math . square(x)", "import \"lib/math.mk\" as math;\nexport let x = -math.pi;\nmath.square(x)\n"},
+		{"if (x) { y }\nm.f", "if (x) { y }\nm.f\n"},
+		{"if (x) { 1 } else {\n2 }", "if (x) { 1 } else {\n\t2\n}\n"},
+		{"if (a) { 1 }; (b)", "if (a) { 1 }\nb\n"},
+		{"if (a) { 1 }; (-b)(1)", "if (a) { 1 };\n(-b)(1)\n"},
+		{"if (a) { 1 }; (1 + 2) * 3", "if (a) { 1 };\n(1 + 2) * 3\n"},
+		{"if (a) { 1 }; [1][0]", "if (a) { 1 };\n[1][0]\n"},
+		{"if (a) { 1 }; b", "if (a) { 1 }\nb\n"},
+		{"if (a) { return 1; }", "if (a) { return 1; }\n"},
+
+		// Parentheses only where needed
+		{"(1 + 2) * 3", "(1 + 2) * 3\n"},
+		{"1 + (2 * 3)", "1 + 2 * 3\n"},
+		{"1 - (2 - 3)", "1 - (2 - 3)\n"},
+		{"(1 - 2) - 3", "1 - 2 - 3\n"},
+		{"-(1 + 2)", "-(1 + 2)\n"},
+		{"-(-x)", "-(-x)\n"},
+		{"!(!x)", "!!x\n"},
+		{"(-f)(1)", "(-f)(1)\n"},
+		{"-f(1)", "-f(1)\n"},
+		{"(a < b) == (c > d)", "a < b == c > d\n"},
+		{"a < (b == c)", "a < (b == c)\n"},
+		{"fn(x) { x }(1)", "fn(x) { x }(1)\n"},
+
+		// Comments and blank lines
+		{"// only a comment", "// only a comment\n"},
+		{"let a = 1; // one\n\n\n\n// two\nlet b = 2;", "let a = 1; // one\n\n// two\nlet b = 2;\n"},
+		{"let a = 1; let b = 2; // b", "let a = 1;\nlet b = 2; // b\n"},
+		{"fn() { // nothing\n}", "fn() { // nothing\n}\n"},
+		{"fn(x) { // why\nx\n}", "fn(x) { // why\n\tx\n}\n"},
+		{"if (x) { // yes\n1 } else { // no\n2 }", "if (x) { // yes\n\t1\n} else { // no\n\t2\n}\n"},
+		{"[ // first\n1, 2]", "[ // first\n\t1,\n\t2\n]\n"},
+		{"fn() {\n\n  a;\n\n  b\n\n}", "fn() {\n\ta;\n\n\tb\n}\n"},
+		{"[1, // one\n2]", "[\n\t1, // one\n\t2\n]\n"},
+		{"[1,\n2 // two\n]", "[\n\t1,\n\t2 // two\n]\n"},
+		{"[1,\n2] // two", "[1, 2] // two\n"},
+		{"f(fn() {\n// inside\nx\n})", "f(fn() {\n\t// inside\n\tx\n})\n"},
+		{"let x = 1 + // one\n2;", "let x = 1 + // one\n\t2;\n"},
+		{"let x = 1 +\n// two\n2;", "let x = 1 +\n\t// two\n\t2;\n"},
+		{"let x = // one\nfn() {\nx\n};", "let x = // one\n\tfn() {\n\t\tx\n\t};\n"},
+		{"f(a, [1, -// one\n2])", "f(\n\ta,\n\t[\n\t\t1,\n\t\t- // one\n\t\t\t2\n\t]\n)\n"},
+		{"let f = fn(a, // first\nb) { a + b };", "let f = fn(\n\ta, // first\n\tb\n) { a + b };\n"},
+		{"let m = macro(a // one\n) { 1 };", "let m = macro(\n\ta // one\n) { 1 };\n"},
+		{"if (x // why\n) { 1 }", "if (x) {\n\t// why\n\t1\n}\n"},
+
+		// Lists
+		{"{}", "{}\n"},
+		{`{"b": 1, "a": 2}`, "{\"b\": 1, \"a\": 2}\n"},
+		{"{\n\"b\": 1, \"a\": 2}", "{\n\t\"b\": 1,\n\t\"a\": 2\n}\n"},
+		{"[\n1\n]", "[\n\t1\n]\n"},
+		{
+			`puts("a long string that takes up", "most of the line", "and then some more", xyz)`,
+			"puts(\n\t\"a long string that takes up\",\n\t\"most of the line\",\n\t\"and then some more\",\n\txyz\n)\n",
+		},
+		{
+			`let h = {"one": 1, "two": fn(x) { x * 2 }, "three": [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]}`,
+			"let h = {\n\t\"one\": 1,\n\t\"two\": fn(x) { x * 2 },\n\t\"three\": [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]\n};\n",
+		},
+		{
+			"map([1, 2], fn(x) {\n x * 2\n})",
+			"map([1, 2], fn(x) {\n\tx * 2\n})\n",
+		},
+		{
+			"[fn(a) {\n a\n}, 2]",
+			"[\n\tfn(a) {\n\t\ta\n\t},\n\t2\n]\n",
+		},
+		{
+			"f(fn(a) { let b = a; b }, 2)",
+			"f(\n\tfn(a) {\n\t\tlet b = a;\n\t\tb\n\t},\n\t2\n)\n",
+		},
+		{"[fn(a) { a }, 2]", "[fn(a) { a }, 2]\n"},
+		{
+			"puts(fn(x) {\n x\n}, \"a long string that takes up most of the line after the function literal here\", 1)",
+			"puts(\n\tfn(x) {\n\t\tx\n\t},\n\t\"a long string that takes up most of the line after the function literal here\",\n\t1\n)\n",
+		},
+		{
+			"map([1, 2], fn(x) {\n let s = \"a long string that takes up most of the line inside the function\";\n s\n})",
+			"map([1, 2], fn(x) {\n\tlet s = \"a long string that takes up most of the line inside the function\";\n\ts\n})\n",
+		},
+	}
+
+	for _, tt := range tests {
+		actual, err := Source(tt.input)
+		if err != nil {
+			t.Errorf("formatting %q failed: %s", tt.input, err)
+			continue
+		}
+
+		if actual != tt.expected {
+			t.Errorf("formatting %q wrong.\nexpected:\n%s\ngot:\n%s", tt.input, tt.expected, actual)
+			continue
+		}
+
+		again, _ := Source(actual)
+		if again != actual {
+			t.Errorf("formatting %q not idempotent.\nfirst:\n%s\nsecond:\n%s", tt.input, actual, again)
+		}
+	}
+}
+
+// Formatting mustn't change what a program means
+func TestSameProgram(t *testing.T) {
+	inputs := []string{
+		"let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(10)",
+		"let a = -(1 - (2 - 3)) * (4 + 5) / !(6 < 7 == true); a",
+		"if (x) { 1 } else { 2 }\n-1",
+		"if (x) { 1 } else { 2 }; -1",
+		"let f = fn(g) { fn(x) { g(g(x)) } }; f(fn(y) { y + 1 })(0)",
+		"[1, [2, 3], (fn() { [4] })()][1][0]",
+		`{"a": {"b": [1, 2]}}["a"]["b"][1]`,
+		`let s = "multi
+line"; len(s)`,
+	}
+
+	for _, input := range inputs {
+		formatted, err := Source(input)
+		if err != nil {
+			t.Errorf("formatting %q failed: %s", input, err)
+			continue
+		}
+
+		if parse(t, formatted) != parse(t, input) {
+			t.Errorf("formatting changed %q into %q", input, formatted)
+		}
+	}
+}
+
+func parse(t *testing.T, input string) string {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %s", input, strings.Join(p.Errors(), ", "))
+	}
+
+	return program.String()
+}
+
+func TestParserErrors(t *testing.T) {
+	_, err := Source("let x = 1;\nlet = 2;")
+	if err == nil {
+		t.Fatalf("no error for a broken program")
+	}
+
+	if !strings.HasPrefix(err.Error(), "2:5: expected next token to be IDENT") {
+		t.Errorf("wrong error %q", err)
+	}
+}
@@ -0,0 +1,580 @@
+package format
+
+import (
+	"math"
+	"monkey/ast"
+	"monkey/parser"
+	"monkey/token"
+	"strings"
+	"unicode/utf8"
+)
+
+type printer struct {
+	out    strings.Builder
+	indent int
+	column int // Where out starts, for printers forked in the middle of a line
+
+	comments []token.Token // Not printed yet, in source order
+	lastLine int           // Source line of what was printed last, 0 if no blank line may follow
+	lines    []string      // Of the source, to tell comments after code from ones on lines of their own
+
+	// Trying out a single line layout, lists on the line can't be broken up
+	flat   bool
+	failed bool // Something had to be broken up anyway
+}
+
+func (p *printer) program(program *ast.Program) {
+	p.statements(program.Statements, token.Position{Line: math.MaxInt})
+
+	if p.out.Len() > 0 {
+		p.out.WriteString("\n")
+	}
+}
+
+// Prints statements one per line, then the comments before end
+func (p *printer) statements(statements []ast.Statement, end token.Position) {
+	for i, statement := range statements {
+		p.commentsBefore(statement.Pos())
+		p.startLine(statement.Pos().Line)
+
+		p.statement(statement)
+		if needsSemicolon(statements, i) {
+			p.out.WriteString(";")
+		}
+
+		next := end
+		if i < len(statements)-1 {
+			next = statements[i+1].Pos()
+		}
+
+		p.lastLine = statement.End().Line
+		if next.Line != p.lastLine {
+			p.trailingComment(p.lastLine, next)
+		}
+	}
+
+	p.commentsBefore(end)
+}
+
+// Every statement ends in a semicolon, except for the last expression statement of a
+// block, which is its value, and if expressions the next statement can't continue
+func needsSemicolon(statements []ast.Statement, i int) bool {
+	statement, ok := statements[i].(*ast.ExpressionStatement)
+	if !ok {
+		return true
+	}
+
+	if i == len(statements)-1 {
+		return false
+	}
+
+	if _, ok := statement.Expression.(*ast.IfExpression); !ok {
+		return true
+	}
+
+	next, ok := statements[i+1].(*ast.ExpressionStatement)
+	if !ok {
+		return false
+	}
+
+	return continuesExpression(next.Expression, parser.LOWEST)
+}
+
+// Whether expression is printed starting with a token that could continue the
+// expression before it, as a call, an index or a subtraction
+func continuesExpression(expression ast.Expression, precedence int) bool {
+	if bindingPower(expression) < precedence {
+		return true
+	}
+
+	switch expression := expression.(type) {
+	case *ast.InfixExpression:
+		return continuesExpression(expression.Left, parser.Precedence(expression.Token.Type))
+	case *ast.CallExpression:
+		return continuesExpression(expression.Function, parser.CALL)
+	case *ast.IndexExpression:
+		return continuesExpression(expression.Left, parser.INDEX)
+	case *ast.MemberExpression:
+		return continuesExpression(expression.Module, parser.INDEX)
+	case *ast.PrefixExpression:
+		return expression.Operator == "-"
+	case *ast.ArrayLiteral:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *printer) statement(statement ast.Statement) {
+	switch statement := statement.(type) {
+	case *ast.LetStatement:
+		p.out.WriteString("let " + statement.Name.Value + " = ")
+		p.expression(statement.Value, parser.LOWEST)
+
+	case *ast.ReturnStatement:
+		p.out.WriteString("return ")
+		p.expression(statement.ReturnValue, parser.LOWEST)
+
+	case *ast.ExpressionStatement:
+		p.expression(statement.Expression, parser.LOWEST)
+
+	case *ast.ImportStatement:
+		p.out.WriteString(`import "` + statement.Path.Value + `" as ` + statement.Name.Value)
+
+	case *ast.ExportStatement:
+		p.out.WriteString("export ")
+		p.statement(statement.Statement)
+	}
+}
+
+// Prints expression, in parentheses if it binds less tightly than precedence
+func (p *printer) expression(expression ast.Expression, precedence int) {
+	// Comments in the middle of an expression stay with what follows them, which
+	// continues on the next line
+	if p.hasCommentBefore(expression.Pos()) {
+		if p.flat {
+			p.failed = true
+			return
+		}
+
+		p.indent++
+		defer func() { p.indent-- }()
+
+		p.commentsWithin(expression.Pos())
+	}
+
+	if bindingPower(expression) < precedence {
+		p.out.WriteString("(")
+		p.expression(expression, parser.LOWEST)
+		p.out.WriteString(")")
+		return
+	}
+
+	switch expression := expression.(type) {
+	case *ast.Identifier:
+		p.out.WriteString(expression.Value)
+
+	case *ast.IntegerLiteral:
+		p.out.WriteString(expression.Token.Literal)
+
+	case *ast.Boolean:
+		p.out.WriteString(expression.Token.Literal)
+
+	case *ast.StringLiteral:
+		p.out.WriteString(`"` + expression.Value + `"`)
+
+	case *ast.PrefixExpression:
+		p.out.WriteString(expression.Operator)
+
+		// Not --x, which looks like a decrement
+		if right, ok := expression.Right.(*ast.PrefixExpression); ok && right.Operator == "-" && expression.Operator == "-" {
+			p.out.WriteString("(")
+			p.expression(right, parser.LOWEST)
+			p.out.WriteString(")")
+		} else {
+			p.expression(expression.Right, parser.PREFIX)
+		}
+
+	case *ast.InfixExpression:
+		// Operators are left associative
+		operator := parser.Precedence(expression.Token.Type)
+		p.expression(expression.Left, operator)
+		p.out.WriteString(" " + expression.Operator + " ")
+		p.expression(expression.Right, operator+1)
+
+	case *ast.IfExpression:
+		p.out.WriteString("if (")
+		p.expression(expression.Condition, parser.LOWEST)
+		p.out.WriteString(") ")
+		p.block(expression.Consequence)
+
+		if expression.Alternative != nil {
+			p.out.WriteString(" else ")
+			p.block(expression.Alternative)
+		}
+
+	case *ast.FunctionLiteral:
+		p.out.WriteString("fn")
+		p.parameters(expression.Token.Position, expression.Parameters, expression.Body)
+
+	case *ast.MacroLiteral:
+		p.out.WriteString("macro")
+		p.parameters(expression.Token.Position, expression.Parameters, expression.Body)
+
+	case *ast.CallExpression:
+		p.expression(expression.Function, parser.CALL)
+		p.list("(", ")", expression.Token.Position, expression.EndToken.Position, expressionItems(expression.Arguments))
+
+	case *ast.IndexExpression:
+		p.expression(expression.Left, parser.INDEX)
+		p.out.WriteString("[")
+		p.expression(expression.Index, parser.LOWEST)
+		p.out.WriteString("]")
+
+	case *ast.MemberExpression:
+		p.expression(expression.Module, parser.INDEX)
+		p.out.WriteString("." + expression.Member.Value)
+
+	case *ast.ArrayLiteral:
+		p.list("[", "]", expression.Token.Position, expression.EndToken.Position, expressionItems(expression.Elements))
+
+	case *ast.HashLiteral:
+		p.list("{", "}", expression.Token.Position, expression.EndToken.Position, pairItems(expression))
+	}
+}
+
+// Calls, indexing and literals bind tighter than any operator
+func bindingPower(expression ast.Expression) int {
+	switch expression := expression.(type) {
+	case *ast.InfixExpression:
+		return parser.Precedence(expression.Token.Type)
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	default:
+		return parser.INDEX + 1
+	}
+}
+
+// Short blocks written on a single line stay that way
+func (p *printer) block(block *ast.BlockStatement) {
+	if len(block.Statements) == 0 && !p.hasCommentBetween(block.Pos(), block.EndToken.Position) {
+		p.out.WriteString("{}")
+		return
+	}
+
+	if len(block.Statements) == 1 && block.Pos().Line == block.EndToken.Position.Line {
+		inline := p.fork()
+		inline.out.WriteString("{ ")
+		inline.statement(block.Statements[0])
+		if needsSemicolon(block.Statements, 0) {
+			inline.out.WriteString(";")
+		}
+		inline.out.WriteString(" }")
+
+		if !inline.failed && !strings.Contains(inline.out.String(), "\n") && p.fits(inline) {
+			p.adopt(inline)
+			return
+		}
+	}
+
+	// Statements get lines of their own, so their lists can be broken up again
+	flat := p.flat
+	p.flat = false
+
+	p.out.WriteString("{")
+	p.indent++
+	p.lastLine = 0
+
+	first := block.EndToken.Position
+	if len(block.Statements) > 0 {
+		first = block.Statements[0].Pos()
+	}
+	p.trailingComment(block.Pos().Line, first)
+
+	p.statements(block.Statements, block.EndToken.Position)
+
+	p.flat = flat
+	p.indent--
+	p.lastLine = 0
+	p.startLine(0)
+	p.out.WriteString("}")
+	p.lastLine = block.EndToken.Position.Line
+}
+
+// Parameter lists are only broken up for the comments in them
+func (p *printer) parameters(start token.Position, parameters []*ast.Identifier, body *ast.BlockStatement) {
+	if p.hasCommentBetween(start, body.Pos()) {
+		items := []item{}
+		for _, parameter := range parameters {
+			items = append(items, item{
+				start: parameter.Pos(),
+				end:   parameter.End(),
+				print: func(p *printer) { p.out.WriteString(parameter.Value) },
+			})
+		}
+
+		p.list("(", ")", start, body.Pos(), items)
+	} else {
+		names := []string{}
+		for _, parameter := range parameters {
+			names = append(names, parameter.Value)
+		}
+
+		p.out.WriteString("(" + strings.Join(names, ", ") + ")")
+	}
+
+	p.out.WriteString(" ")
+	p.block(body)
+}
+
+type item struct {
+	start token.Position
+	end   token.Position
+	print func(p *printer)
+}
+
+func expressionItems(expressions []ast.Expression) []item {
+	items := []item{}
+
+	for _, expression := range expressions {
+		items = append(items, item{
+			start: expression.Pos(),
+			end:   expression.End(),
+			print: func(p *printer) { p.expression(expression, parser.LOWEST) },
+		})
+	}
+
+	return items
+}
+
+// In the order they were written
+func pairItems(hash *ast.HashLiteral) []item {
+	items := []item{}
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
+
+		items = append(items, item{
+			start: key.Pos(),
+			end:   value.End(),
+			print: func(p *printer) {
+				p.expression(key, parser.LOWEST)
+				p.out.WriteString(": ")
+				p.expression(value, parser.LOWEST)
+			},
+		})
+	}
+
+	return items
+}
+
+// Prints items on one line if they fit and only the last one spans several lines. Otherwise,
+// or if the source already had the first one on a line of its own, or there are comments
+// between them, puts each on its own line.
+func (p *printer) list(open string, close string, start token.Position, end token.Position, items []item) {
+	if len(items) == 0 {
+		p.out.WriteString(open + close)
+		return
+	}
+
+	canBeFlat := items[0].start.Line == start.Line && !p.hasCommentBetweenItems(start, end, items)
+
+	// Whoever is trying out a single line checks whether it fits
+	if p.flat {
+		if canBeFlat {
+			p.flatList(open, close, items)
+		} else {
+			p.failed = true
+		}
+		return
+	}
+
+	if canBeFlat {
+		flat := p.fork()
+		flat.flatList(open, close, items)
+
+		if !flat.failed && p.fits(flat) {
+			p.adopt(flat)
+			return
+		}
+	}
+
+	p.out.WriteString(open)
+	p.indent++
+	p.lastLine = 0
+
+	p.trailingComment(start.Line, items[0].start)
+
+	for i, item := range items {
+		p.commentsBefore(item.start)
+		p.startLine(item.start.Line)
+
+		item.print(p)
+		if i < len(items)-1 {
+			p.out.WriteString(",")
+		}
+
+		next := end
+		if i < len(items)-1 {
+			next = items[i+1].start
+		}
+
+		p.lastLine = item.end.Line
+		p.trailingComment(p.lastLine, next)
+	}
+
+	p.commentsBefore(end)
+
+	p.indent--
+	p.lastLine = 0
+	p.startLine(0)
+	p.out.WriteString(close)
+	p.lastLine = end.Line
+}
+
+func (p *printer) flatList(open string, close string, items []item) {
+	p.out.WriteString(open)
+
+	for i, item := range items {
+		if i > 0 {
+			p.out.WriteString(", ")
+		}
+
+		// Only the last item may go on over several lines, a function literal's body
+		// say, anything after it would be hidden behind its closing brace
+		start := p.out.Len()
+		item.print(p)
+		if i < len(items)-1 && strings.Contains(p.out.String()[start:], "\n") {
+			p.failed = true
+		}
+	}
+
+	p.out.WriteString(close)
+}
+
+// Starts a new output line for what's on sourceLine, keeping a single blank line if
+// the source had any since lastLine
+func (p *printer) startLine(sourceLine int) {
+	if p.out.Len() > 0 {
+		p.out.WriteString("\n")
+
+		if p.lastLine > 0 && sourceLine > p.lastLine+1 {
+			p.out.WriteString("\n")
+		}
+	}
+
+	p.out.WriteString(strings.Repeat("\t", p.indent))
+}
+
+// Prints the comments before position, each on its own line
+func (p *printer) commentsBefore(position token.Position) {
+	for len(p.comments) > 0 && before(p.comments[0].Position, position) {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+
+		p.startLine(comment.Position.Line)
+		p.out.WriteString(comment.Literal)
+		p.lastLine = comment.Position.Line
+	}
+}
+
+// Prints the comments before position inside an expression, those that were after
+// code still at the end of the line, then starts a new line
+func (p *printer) commentsWithin(position token.Position) {
+	// No space left at the end of the line, after an operator say
+	if text := p.out.String(); strings.HasSuffix(text, " ") {
+		p.out.Reset()
+		p.out.WriteString(strings.TrimRight(text, " "))
+	}
+
+	for p.hasCommentBefore(position) {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+
+		if p.afterCode(comment) {
+			p.out.WriteString(" " + comment.Literal)
+		} else {
+			p.startLine(0)
+			p.out.WriteString(comment.Literal)
+		}
+	}
+
+	p.startLine(0)
+}
+
+// Whether there's something other than whitespace before comment on its line
+func (p *printer) afterCode(comment token.Token) bool {
+	line := p.lines[comment.Position.Line-1]
+
+	return strings.TrimSpace(line[:comment.Position.Column-1]) != ""
+}
+
+func (p *printer) hasCommentBefore(position token.Position) bool {
+	return len(p.comments) > 0 && before(p.comments[0].Position, position)
+}
+
+// Prints a comment that was at the end of line, after whatever is already on it,
+// unless it's inside what comes next
+func (p *printer) trailingComment(line int, next token.Position) {
+	if p.hasCommentBefore(next) && p.comments[0].Position.Line == line {
+		p.out.WriteString(" " + p.comments[0].Literal)
+		p.comments = p.comments[1:]
+	}
+}
+
+func (p *printer) hasCommentBetween(start token.Position, end token.Position) bool {
+	for _, comment := range p.comments {
+		if before(start, comment.Position) && before(comment.Position, end) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Only looks between items, comments inside them are up to the items
+func (p *printer) hasCommentBetweenItems(start token.Position, end token.Position, items []item) bool {
+	for i, item := range items {
+		if p.hasCommentBetween(start, item.start) {
+			return true
+		}
+		start = items[i].end
+	}
+
+	return p.hasCommentBetween(start, end)
+}
+
+// A printer to try out a layout with, continuing on the current line
+func (p *printer) fork() *printer {
+	return &printer{
+		indent:   p.indent,
+		column:   p.currentColumn(),
+		comments: p.comments,
+		lastLine: p.lastLine,
+		lines:    p.lines,
+		flat:     true,
+	}
+}
+
+func (p *printer) adopt(fork *printer) {
+	p.out.WriteString(fork.out.String())
+	p.comments = fork.comments
+	p.lastLine = fork.lastLine
+	p.failed = p.failed || fork.failed
+}
+
+// Whether the first line of what fork printed fits on the current line, and so do
+// the lines it goes on after the bodies of function literals. Lines inside them
+// were broken up already where they could be.
+func (p *printer) fits(fork *printer) bool {
+	lines := strings.Split(fork.out.String(), "\n")
+	if p.currentColumn()+width(lines[0]) > maxWidth {
+		return false
+	}
+
+	nested := strings.Repeat("\t", p.indent+1)
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, nested) && width(line) > maxWidth {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *printer) currentColumn() int {
+	text := p.out.String()
+
+	if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+		return width(text[i+1:])
+	}
+
+	return p.column + width(text)
+}
+
+func width(text string) int {
+	return utf8.RuneCountInString(text) + strings.Count(text, "\t")*(tabWidth-1)
+}
+
+func before(a, b token.Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
@@ -0,0 +1,27 @@
+package lexer
+
+import (
+	"monkey/token"
+	"testing"
+)
+
+// Every token but EOF consumes at least a byte, so lexing can't take more
+// tokens than that
+func FuzzNextToken(f *testing.F) {
+	f.Add(`let add = fn(x, y) { x + y; }; add(1, 2) == 3 != !true;`)
+	f.Add(`"unterminated`)
+	f.Add("// comment\n{\"a\": [1]}[\"a\"] <> m.x")
+	f.Add("\x00let\xff")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		l := New(input)
+
+		for range len(input) + 1 {
+			if l.NextToken().Type == token.EOF {
+				return
+			}
+		}
+
+		t.Fatalf("no EOF after %d tokens for %q", len(input)+1, input)
+	})
+}
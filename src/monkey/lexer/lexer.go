@@ -2,7 +2,9 @@ package lexer
 
 import (
 	"monkey/token"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
@@ -10,10 +12,15 @@ type Lexer struct {
 	position     int  // current position in input (points to current char)
 	readPosition int  // current reading position in input (after current char)
 	ch           byte // current char under examination
+
+	line   int // line of current char
+	column int // column of current char
+
+	comments []token.Token // Skipped like whitespace, but kept for the formatter
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
@@ -23,6 +30,8 @@ func (l *Lexer) NextToken() token.Token {
 
 	l.skipWhitespace()
 
+	position := token.Position{Line: l.line, Column: l.column}
+
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -31,12 +40,12 @@ func (l *Lexer) NextToken() token.Token {
 			literal := string(ch) + string(l.ch)
 			tok = token.Token{Type: token.EQ, Literal: literal}
 		} else {
-			tok = newToken(token.ASSIGN, l.ch)
+			tok = l.newToken(token.ASSIGN)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.newToken(token.PLUS)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.newToken(token.MINUS)
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -44,37 +53,39 @@ func (l *Lexer) NextToken() token.Token {
 			literal := string(ch) + string(l.ch)
 			tok = token.Token{Type: token.NOT_EQ, Literal: literal}
 		} else {
-			tok = newToken(token.BANG, l.ch)
+			tok = l.newToken(token.BANG)
 		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		tok = l.newToken(token.SLASH)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		tok = l.newToken(token.ASTERISK)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		tok = l.newToken(token.LT)
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		tok = l.newToken(token.GT)
 	case ';':
-		tok = newToken(token.SEMICOLON, l.ch)
+		tok = l.newToken(token.SEMICOLON)
 	case ':':
-		tok = newToken(token.COLON, l.ch)
+		tok = l.newToken(token.COLON)
+	case '.':
+		tok = l.newToken(token.DOT)
 	case ',':
-		tok = newToken(token.COMMA, l.ch)
+		tok = l.newToken(token.COMMA)
 	case '{':
-		tok = newToken(token.LBRACE, l.ch)
+		tok = l.newToken(token.LBRACE)
 	case '}':
-		tok = newToken(token.RBRACE, l.ch)
+		tok = l.newToken(token.RBRACE)
 	case '(':
-		tok = newToken(token.LPAREN, l.ch)
+		tok = l.newToken(token.LPAREN)
 	case ')':
-		tok = newToken(token.RPAREN, l.ch)
+		tok = l.newToken(token.RPAREN)
 	case '"':
 		tok.Type = token.STRING
 		tok.Literal = l.readString()
 	case '[':
-		tok = newToken(token.LBRACKET, l.ch)
+		tok = l.newToken(token.LBRACKET)
 	case ']':
-		tok = newToken(token.RBRACKET, l.ch)
+		tok = l.newToken(token.RBRACKET)
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -82,27 +93,72 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Position = position
 			return tok
 		} else if isDigit(l.ch) {
 			tok.Type = token.INT
 			tok.Literal = l.readNumber()
+			tok.Position = position
 			return tok
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			// All of a character that isn't ASCII, rather than its first byte
+			start := l.position
+			_, size := utf8.DecodeRuneInString(l.input[start:])
+			for range size - 1 {
+				l.readChar()
+			}
+			tok = token.Token{Type: token.ILLEGAL, Literal: l.input[start:l.readPosition]}
 		}
 	}
 
 	l.readChar()
+	tok.Position = position
 	return tok
 }
 
+// Comments read so far, in source order
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
+// Skips comments too
 func (l *Lexer) skipWhitespace() {
-	for unicode.IsSpace(rune(l.ch)) {
+	for {
+		for unicode.IsSpace(rune(l.ch)) {
+			l.readChar()
+		}
+
+		if l.ch != '/' || l.peekChar() != '/' {
+			return
+		}
+
+		l.readComment()
+	}
+}
+
+// Line comments run from // to the end of the line
+func (l *Lexer) readComment() {
+	comment := token.Token{
+		Type:     token.COMMENT,
+		Position: token.Position{Line: l.line, Column: l.column},
+	}
+
+	position := l.position
+	for l.ch != '\n' && l.ch != 0 {
 		l.readChar()
 	}
+
+	comment.Literal = strings.TrimRightFunc(l.input[position:l.position], unicode.IsSpace)
+	l.comments = append(l.comments, comment)
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -155,6 +211,7 @@ func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
-	return token.Token{Type: tokenType, Literal: string(ch)}
+// A token of the current character, as written in the input
+func (l *Lexer) newToken(tokenType token.TokenType) token.Token {
+	return token.Token{Type: tokenType, Literal: l.input[l.position:l.readPosition]}
 }
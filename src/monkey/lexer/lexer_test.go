@@ -30,6 +30,9 @@ if (5 < 10) {
 "foo bar"
 [1, 2];
 {"foo": "bar"}
+macro(x, y) { x + y; };
+import "m.mk" as m;
+export m.x
 `
 
 	tests := []struct {
@@ -122,6 +125,28 @@ if (5 < 10) {
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+		{token.MACRO, "macro"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.COMMA, ","},
+		{token.IDENT, "y"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "x"},
+		{token.PLUS, "+"},
+		{token.IDENT, "y"},
+		{token.SEMICOLON, ";"},
+		{token.RBRACE, "}"},
+		{token.SEMICOLON, ";"},
+		{token.IMPORT, "import"},
+		{token.STRING, "m.mk"},
+		{token.AS, "as"},
+		{token.IDENT, "m"},
+		{token.SEMICOLON, ";"},
+		{token.EXPORT, "export"},
+		{token.IDENT, "m"},
+		{token.DOT, "."},
+		{token.IDENT, "x"},
 		{token.EOF, ""},
 	}
 
@@ -141,3 +166,111 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let five = 5;
+let s = "a
+b";
+  five == s`
+
+	tests := []struct {
+		expectedLiteral  string
+		expectedPosition token.Position
+	}{
+		{"let", token.Position{Line: 1, Column: 1}},
+		{"five", token.Position{Line: 1, Column: 5}},
+		{"=", token.Position{Line: 1, Column: 10}},
+		{"5", token.Position{Line: 1, Column: 12}},
+		{";", token.Position{Line: 1, Column: 13}},
+		{"let", token.Position{Line: 2, Column: 1}},
+		{"s", token.Position{Line: 2, Column: 5}},
+		{"=", token.Position{Line: 2, Column: 7}},
+		{"a\nb", token.Position{Line: 2, Column: 9}},
+		{";", token.Position{Line: 3, Column: 3}},
+		{"five", token.Position{Line: 4, Column: 3}},
+		{"==", token.Position{Line: 4, Column: 8}},
+		{"s", token.Position{Line: 4, Column: 11}},
+		{"", token.Position{Line: 4, Column: 12}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Position != tt.expectedPosition {
+			t.Fatalf("tests[%d] - position wrong. expected=%s, got=%s",
+				i, tt.expectedPosition, tok.Position)
+		}
+	}
+}
+
+func TestIllegalCharacters(t *testing.T) {
+	// As written, whole characters for those that aren't ASCII
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"@", []string{"@"}},
+		{"ú", []string{"ú"}},
+		{"a€b", []string{"a", "€", "b"}},
+		{"\xfa(", []string{"\xfa", "("}},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+
+		for i, expected := range tt.expected {
+			tok := l.NextToken()
+			if tok.Literal != expected {
+				t.Errorf("%q token %d: expected=%q, got=%q", tt.input, i, expected, tok.Literal)
+			}
+		}
+
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Errorf("%q: expected EOF, got %q", tt.input, tok.Literal)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let x = 10 / 2; // trailing  
+//
+x`
+
+	expected := []token.TokenType{
+		token.LET, token.IDENT, token.ASSIGN, token.INT, token.SLASH, token.INT,
+		token.SEMICOLON, token.IDENT, token.EOF,
+	}
+
+	l := New(input)
+
+	for i, tokenType := range expected {
+		tok := l.NextToken()
+		if tok.Type != tokenType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tokenType, tok.Type)
+		}
+	}
+
+	comments := []token.Token{
+		{Type: token.COMMENT, Literal: "// leading", Position: token.Position{Line: 1, Column: 1}},
+		{Type: token.COMMENT, Literal: "// trailing", Position: token.Position{Line: 2, Column: 17}},
+		{Type: token.COMMENT, Literal: "//", Position: token.Position{Line: 3, Column: 1}},
+	}
+
+	if len(l.Comments()) != len(comments) {
+		t.Fatalf("wrong number of comments %d, expected %d", len(l.Comments()), len(comments))
+	}
+
+	for i, comment := range comments {
+		if l.Comments()[i] != comment {
+			t.Errorf("comments[%d] wrong. expected=%+v, got=%+v", i, comment, l.Comments()[i])
+		}
+	}
+}
@@ -0,0 +1,469 @@
+package lsp
+
+import (
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/module"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/token"
+	"sort"
+)
+
+// What a document's identifiers refer to, resolved with the compiler's symbol tables
+type Analysis struct {
+	Program *ast.Program
+	Errors  []Error
+
+	Definitions []*Definition // In source order, builtins aren't included
+	References  []*Reference  // Every resolved identifier in source order, definitions included
+
+	global   *scope
+	builtins []*Definition
+}
+
+type Error struct {
+	Start   token.Position
+	End     token.Position
+	Message string
+}
+
+type Definition struct {
+	Name       string
+	Scope      compiler.SymbolScope // GlobalScope, LocalScope, BuiltinScope or ModuleScope
+	Identifier *ast.Identifier      // nil for builtins
+	Value      ast.Expression       // What a let bound it to, nil otherwise
+}
+
+type Reference struct {
+	Identifier  *ast.Identifier
+	Definition  *Definition
+	Scope       compiler.SymbolScope // How the identifier resolves where it is
+	Declaration bool
+}
+
+// Mirrors a symbol table, so its symbols can be traced back to their definitions
+type scope struct {
+	parent   *scope
+	function ast.Node // The function or macro literal, nil for the global scope
+
+	definitions []*Definition // Indexed by Symbol.Index
+	self        *Definition   // The function's own name, see SymbolTable.DefineFunctionName
+	children    []*scope
+}
+
+func Analyze(source string) *Analysis {
+	return AnalyzeFile("", source)
+}
+
+// Imports are relative to filename
+func AnalyzeFile(filename string, source string) *Analysis {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+
+	analysis := &Analysis{Program: program}
+
+	for _, err := range p.DetailedErrors() {
+		analysis.addError(source, err.Position, err.Message)
+	}
+
+	// Only a complete program can be compiled, a partial one still gets resolved
+	if len(p.DetailedErrors()) == 0 {
+		switch err := compile(filename, program).(type) {
+		case nil:
+		case *compiler.Error:
+			analysis.addError(source, err.Position, err.Message)
+		case *evaluator.MacroError:
+			analysis.addError(source, err.Position, err.Message)
+		default:
+			analysis.addError(source, program.Pos(), err.Error())
+		}
+	}
+
+	r := newResolver(analysis)
+	r.walk(program)
+
+	sort.SliceStable(analysis.References, func(i, j int) bool {
+		return before(analysis.References[i].Identifier.Pos(), analysis.References[j].Identifier.Pos())
+	})
+
+	return analysis
+}
+
+func compile(filename string, program *ast.Program) error {
+	// Expanding macros rewrites the program, the resolver wants it as written
+	program = ast.Copy(program).(*ast.Program)
+
+	// The server's stdout is the protocol's, whatever macros print is dropped
+	discard := &object.Context{Stdout: io.Discard, Stderr: io.Discard}
+
+	macroEnv := object.NewEnvironment()
+	macroEnv.SetContext(discard)
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, err := evaluator.ExpandMacros(program, macroEnv)
+	if err != nil {
+		return err
+	}
+
+	c := compiler.New()
+	c.Loader = module.NewLoader(filename)
+	c.MacroContext = discard
+
+	return c.Compile(expanded)
+}
+
+// Errors cover the token they point at
+func (a *Analysis) addError(source string, position token.Position, message string) {
+	end := position
+
+	l := lexer.New(source)
+	for tok := l.NextToken(); ; tok = l.NextToken() {
+		if tok.Position == position {
+			end = tok.End()
+			break
+		}
+		if tok.Type == token.EOF || before(position, tok.Position) {
+			break
+		}
+	}
+
+	a.Errors = append(a.Errors, Error{Start: position, End: end, Message: message})
+}
+
+// The identifier at or right before position
+func (a *Analysis) ReferenceAt(position token.Position) *Reference {
+	var touching *Reference
+
+	for _, reference := range a.References {
+		start, end := reference.Identifier.Pos(), reference.Identifier.End()
+		if before(position, start) {
+			break
+		}
+
+		if before(position, end) {
+			return reference
+		}
+
+		if position == end {
+			touching = reference
+		}
+	}
+
+	return touching
+}
+
+// Every use of definition, in source order
+func (a *Analysis) ReferencesTo(definition *Definition, includeDeclaration bool) []*Reference {
+	result := []*Reference{}
+
+	for _, reference := range a.References {
+		if reference.Definition != definition {
+			continue
+		}
+
+		if reference.Declaration && !includeDeclaration {
+			continue
+		}
+
+		result = append(result, reference)
+	}
+
+	return result
+}
+
+// Everything that could be referred to at position, innermost first, then builtins
+func (a *Analysis) VisibleAt(position token.Position) []*Definition {
+	result := []*Definition{}
+	seen := map[string]bool{}
+
+	add := func(definition *Definition) {
+		if definition == nil || seen[definition.Name] {
+			return
+		}
+
+		seen[definition.Name] = true
+		result = append(result, definition)
+	}
+
+	for s := a.global.innermostAt(position); s != nil; s = s.parent {
+		// Later definitions shadow earlier ones
+		for i := len(s.definitions) - 1; i >= 0; i-- {
+			if before(s.definitions[i].Identifier.Pos(), position) {
+				add(s.definitions[i])
+			}
+		}
+
+		add(s.self)
+	}
+
+	for _, builtin := range a.builtins {
+		add(builtin)
+	}
+
+	return result
+}
+
+func (s *scope) innermostAt(position token.Position) *scope {
+	for _, child := range s.children {
+		if before(child.function.Pos(), position) && before(position, child.function.End()) {
+			return child.innermostAt(position)
+		}
+	}
+
+	return s
+}
+
+func before(a, b token.Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+// Walks the program defining and resolving symbols in the same order the compiler does
+type resolver struct {
+	analysis *Analysis
+
+	table  *compiler.SymbolTable
+	scope  *scope
+	scopes map[*compiler.SymbolTable]*scope
+
+	functionNames map[*ast.FunctionLiteral]*Definition // Functions bound by a let, by that let
+}
+
+func newResolver(analysis *Analysis) *resolver {
+	table := compiler.NewSymbolTable()
+
+	for i, value := range object.Builtins {
+		table.DefineBuiltin(i, value.Name)
+		analysis.builtins = append(analysis.builtins, &Definition{Name: value.Name, Scope: compiler.BuiltinScope})
+	}
+
+	analysis.global = &scope{}
+
+	return &resolver{
+		analysis: analysis,
+
+		table:  table,
+		scope:  analysis.global,
+		scopes: map[*compiler.SymbolTable]*scope{table: analysis.global},
+
+		functionNames: map[*ast.FunctionLiteral]*Definition{},
+	}
+}
+
+// Partially parsed programs have nil nodes all over the place
+func (r *resolver) walk(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, statement := range node.Statements {
+			r.walk(statement)
+		}
+
+	case *ast.BlockStatement:
+		for _, statement := range node.Statements {
+			r.walk(statement)
+		}
+
+	case *ast.ExpressionStatement:
+		r.walk(node.Expression)
+
+	case *ast.ReturnStatement:
+		r.walk(node.ReturnValue)
+
+	case *ast.LetStatement:
+		definition := r.define(node.Name, node.Value)
+
+		if function, ok := node.Value.(*ast.FunctionLiteral); ok {
+			r.functionNames[function] = definition
+		}
+
+		r.walk(node.Value)
+
+	case *ast.ImportStatement:
+		if node.Name != nil {
+			// Shadowed like any other global
+			definition := r.define(node.Name, nil)
+			definition.Scope = compiler.ModuleScope
+			r.analysis.References[len(r.analysis.References)-1].Scope = compiler.ModuleScope
+		}
+
+	case *ast.ExportStatement:
+		if node.Statement != nil {
+			r.walk(node.Statement)
+		}
+
+	case *ast.MemberExpression:
+		r.walk(node.Module)
+
+	case *ast.Identifier:
+		r.resolve(node)
+
+	case *ast.PrefixExpression:
+		r.walk(node.Right)
+
+	case *ast.InfixExpression:
+		r.walk(node.Left)
+		r.walk(node.Right)
+
+	case *ast.IfExpression:
+		r.walk(node.Condition)
+		if node.Consequence != nil {
+			r.walk(node.Consequence)
+		}
+		if node.Alternative != nil {
+			r.walk(node.Alternative)
+		}
+
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			r.walk(element)
+		}
+
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			r.walk(pair.Key)
+			r.walk(pair.Value)
+		}
+
+	case *ast.IndexExpression:
+		r.walk(node.Left)
+		r.walk(node.Index)
+
+	case *ast.CallExpression:
+		r.walk(node.Function)
+		for _, argument := range node.Arguments {
+			r.walk(argument)
+		}
+
+	case *ast.FunctionLiteral:
+		r.enterScope(node)
+
+		for _, parameter := range node.Parameters {
+			r.define(parameter, nil)
+		}
+
+		if node.Name != nil {
+			r.table.DefineFunctionName(*node.Name)
+			r.scope.self = r.functionNames[node]
+		}
+
+		if node.Body != nil {
+			r.walk(node.Body)
+		}
+
+		r.leaveScope()
+
+	case *ast.MacroLiteral:
+		r.enterScope(node)
+
+		for _, parameter := range node.Parameters {
+			r.define(parameter, nil)
+		}
+
+		if node.Body != nil {
+			r.walk(node.Body)
+		}
+
+		r.leaveScope()
+	}
+}
+
+func (r *resolver) enterScope(function ast.Node) {
+	r.table = compiler.NewEnclosedSymbolTable(r.table)
+
+	s := &scope{parent: r.scope, function: function}
+	r.scope.children = append(r.scope.children, s)
+	r.scope = s
+	r.scopes[r.table] = s
+}
+
+func (r *resolver) leaveScope() {
+	r.table = r.table.Parent
+	r.scope = r.scope.parent
+}
+
+func (r *resolver) define(identifier *ast.Identifier, value ast.Expression) *Definition {
+	symbol := r.table.Define(identifier.Value)
+
+	definition := &Definition{
+		Name:       identifier.Value,
+		Scope:      symbol.Scope,
+		Identifier: identifier,
+		Value:      value,
+	}
+
+	r.scope.definitions = append(r.scope.definitions, definition)
+	r.analysis.Definitions = append(r.analysis.Definitions, definition)
+	r.analysis.References = append(r.analysis.References, &Reference{
+		Identifier:  identifier,
+		Definition:  definition,
+		Scope:       symbol.Scope,
+		Declaration: true,
+	})
+
+	return definition
+}
+
+func (r *resolver) resolve(identifier *ast.Identifier) {
+	symbol, ok := r.table.Resolve(identifier.Value)
+	if !ok {
+		return
+	}
+
+	definition := r.definitionOf(r.table, symbol)
+	if definition == nil {
+		return
+	}
+
+	scope := symbol.Scope
+	if definition.Scope == compiler.ModuleScope {
+		scope = compiler.ModuleScope
+	}
+
+	r.analysis.References = append(r.analysis.References, &Reference{
+		Identifier: identifier,
+		Definition: definition,
+		Scope:      scope,
+	})
+}
+
+// Follows free symbols out to the table that defined them
+func (r *resolver) definitionOf(table *compiler.SymbolTable, symbol compiler.Symbol) *Definition {
+	switch symbol.Scope {
+	case compiler.GlobalScope:
+		return r.analysis.global.definitions[symbol.Index]
+
+	case compiler.LocalScope:
+		return r.scopes[table].definitions[symbol.Index]
+
+	case compiler.BuiltinScope:
+		return r.analysis.builtins[symbol.Index]
+
+	case compiler.CurrentFunctionScope:
+		return r.scopes[table].self
+
+	case compiler.FreeScope:
+		return r.definitionOf(table.Parent, table.FreeSymbols[symbol.Index])
+	}
+
+	return nil
+}
+
+// How hover describes a scope
+func scopeName(scope compiler.SymbolScope) string {
+	switch scope {
+	case compiler.GlobalScope:
+		return "global"
+	case compiler.LocalScope:
+		return "local"
+	case compiler.BuiltinScope:
+		return "builtin"
+	case compiler.FreeScope:
+		return "free"
+	case compiler.ModuleScope:
+		return "module"
+	default:
+		return "function"
+	}
+}
@@ -0,0 +1,179 @@
+package lsp
+
+import (
+	"monkey/compiler"
+	"monkey/token"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const source = `let x = 10;
+let add = fn(a, b) {
+	let inner = fn() { a + x };
+	add(inner(), len(b))
+};
+add(x, "é")`
+
+func TestResolution(t *testing.T) {
+	analysis := Analyze(source)
+	if len(analysis.Errors) != 0 {
+		t.Fatalf("unexpected errors %v", analysis.Errors)
+	}
+
+	tests := []struct {
+		position   token.Position
+		scope      compiler.SymbolScope
+		definition token.Position
+	}{
+		{token.Position{Line: 1, Column: 5}, compiler.GlobalScope, token.Position{Line: 1, Column: 5}},
+		{token.Position{Line: 3, Column: 21}, compiler.FreeScope, token.Position{Line: 2, Column: 14}},
+		{token.Position{Line: 3, Column: 25}, compiler.GlobalScope, token.Position{Line: 1, Column: 5}},
+		{token.Position{Line: 4, Column: 2}, compiler.CurrentFunctionScope, token.Position{Line: 2, Column: 5}},
+		{token.Position{Line: 4, Column: 6}, compiler.LocalScope, token.Position{Line: 3, Column: 6}},
+		{token.Position{Line: 4, Column: 19}, compiler.LocalScope, token.Position{Line: 2, Column: 17}},
+		{token.Position{Line: 6, Column: 4}, compiler.GlobalScope, token.Position{Line: 2, Column: 5}},
+	}
+
+	for _, tt := range tests {
+		reference := analysis.ReferenceAt(tt.position)
+		if reference == nil {
+			t.Errorf("nothing found at %s", tt.position)
+			continue
+		}
+
+		if reference.Scope != tt.scope {
+			t.Errorf("%s resolved to scope %d, expected %d", tt.position, reference.Scope, tt.scope)
+		}
+
+		if reference.Definition.Identifier.Pos() != tt.definition {
+			t.Errorf("%s defined at %s, expected %s", tt.position, reference.Definition.Identifier.Pos(), tt.definition)
+		}
+	}
+
+	builtin := analysis.ReferenceAt(token.Position{Line: 4, Column: 15})
+	if builtin == nil || builtin.Scope != compiler.BuiltinScope || builtin.Definition.Name != "len" {
+		t.Errorf("len not resolved as a builtin: %+v", builtin)
+	}
+
+	add := analysis.ReferenceAt(token.Position{Line: 2, Column: 5}).Definition
+	if references := analysis.ReferencesTo(add, true); len(references) != 3 {
+		t.Errorf("wrong number of references to add %d, expected 3", len(references))
+	}
+	if references := analysis.ReferencesTo(add, false); len(references) != 2 {
+		t.Errorf("wrong number of uses of add %d, expected 2", len(references))
+	}
+}
+
+func TestVisibleAt(t *testing.T) {
+	analysis := Analyze(source)
+
+	names := func(position token.Position) map[string]bool {
+		result := map[string]bool{}
+		for _, definition := range analysis.VisibleAt(position) {
+			result[definition.Name] = true
+		}
+		return result
+	}
+
+	inner := names(token.Position{Line: 3, Column: 21})
+	for _, name := range []string{"x", "add", "a", "b", "len", "puts"} {
+		if !inner[name] {
+			t.Errorf("%s not visible inside inner", name)
+		}
+	}
+
+	if !names(token.Position{Line: 4, Column: 2})["inner"] {
+		t.Errorf("inner not visible after its definition")
+	}
+
+	top := names(token.Position{Line: 6, Column: 1})
+	if top["a"] || top["inner"] {
+		t.Errorf("locals visible at the top level: %v", top)
+	}
+
+	start := names(token.Position{Line: 1, Column: 1})
+	if start["x"] || start["add"] {
+		t.Errorf("globals visible before their definition: %v", start)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		start   token.Position
+		end     token.Position
+		message string
+	}{
+		{"let = 5;", token.Position{Line: 1, Column: 5}, token.Position{Line: 1, Column: 6}, "expected next token to be IDENT, got = instead"},
+		{"let a = 1;\nb + a", token.Position{Line: 2, Column: 1}, token.Position{Line: 2, Column: 2}, `Symbol "b" not found`},
+		{"let m = macro(a) { 1 };\nm(1)", token.Position{Line: 2, Column: 1}, token.Position{Line: 2, Column: 2}, "macro m: must return a quote, not INTEGER"},
+		{"let m = macro() { let f = fn(n) { f(n + 1) }; f(0) };\nm()", token.Position{Line: 2, Column: 1}, token.Position{Line: 2, Column: 2}, "macro m: stack overflow (1024 frames)"},
+	}
+
+	for _, tt := range tests {
+		analysis := Analyze(tt.input)
+		if len(analysis.Errors) == 0 {
+			t.Errorf("no errors for %q", tt.input)
+			continue
+		}
+
+		err := analysis.Errors[0]
+		if err.Start != tt.start || err.End != tt.end || err.Message != tt.message {
+			t.Errorf("wrong error for %q: %+v", tt.input, err)
+		}
+	}
+
+	// Whatever did parse is still resolved
+	analysis := Analyze("let a = 1;\nlet b = a +;")
+	if analysis.ReferenceAt(token.Position{Line: 2, Column: 9}) == nil {
+		t.Errorf("partial program not resolved")
+	}
+}
+
+func TestMacros(t *testing.T) {
+	analysis := Analyze(`let unless = macro(cond, then) { quote(if (!unquote(cond)) { unquote(then) }) };
+unless(false, puts(1))`)
+	if len(analysis.Errors) != 0 {
+		t.Fatalf("unexpected errors %v", analysis.Errors)
+	}
+
+	cond := analysis.ReferenceAt(token.Position{Line: 1, Column: 55})
+	if cond == nil || cond.Definition.Identifier.Pos() != (token.Position{Line: 1, Column: 20}) {
+		t.Errorf("macro parameter not resolved: %+v", cond)
+	}
+
+	unless := analysis.ReferenceAt(token.Position{Line: 2, Column: 1})
+	if unless == nil || unless.Definition.Name != "unless" {
+		t.Errorf("macro call not resolved: %+v", unless)
+	}
+
+	// The program is left as written
+	if len(analysis.Program.Statements) != 2 {
+		t.Errorf("program was rewritten: %s", analysis.Program)
+	}
+}
+
+func TestImports(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "lib.mk"), []byte(`export let one = 1;`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	analysis := AnalyzeFile(filepath.Join(dir, "main.mk"), `import "lib.mk" as lib;
+lib.one + lib.two`)
+
+	if len(analysis.Errors) != 1 || analysis.Errors[0].Message != filepath.Join(dir, "lib.mk")+" doesn't export two" {
+		t.Fatalf("wrong errors %v", analysis.Errors)
+	}
+
+	lib := analysis.ReferenceAt(token.Position{Line: 2, Column: 11})
+	if lib == nil || lib.Scope != compiler.ModuleScope || lib.Definition.Identifier.Pos() != (token.Position{Line: 1, Column: 20}) {
+		t.Errorf("module not resolved: %+v", lib)
+	}
+
+	if analysis.ReferenceAt(token.Position{Line: 2, Column: 5}) != nil {
+		t.Errorf("member resolved like a variable")
+	}
+}
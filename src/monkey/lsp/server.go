@@ -0,0 +1,407 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/token"
+	"monkey/wire"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Serves the Language Server Protocol for open Monkey documents
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	documents map[string]*document // By URI
+}
+
+type document struct {
+	lines    []string
+	analysis *Analysis
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:  bufio.NewReader(in),
+		out: out,
+
+		documents: map[string]*document{},
+	}
+}
+
+// Handles messages until the client sends exit or closes the input
+func (s *Server) Run() error {
+	for {
+		content, err := wire.ReadMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var message Message
+		err = json.Unmarshal(content, &message)
+		if err != nil {
+			s.send(&Response{
+				JsonRpc: "2.0",
+				Id:      json.RawMessage("null"),
+				Error:   &ResponseError{Code: ParseError, Message: err.Error()},
+			})
+			continue
+		}
+
+		if message.Method == "exit" {
+			return nil
+		}
+
+		// Responses to requests we never make
+		if message.Method == "" {
+			continue
+		}
+
+		result, responseError := s.handle(&message)
+
+		if message.Id == nil {
+			continue
+		}
+
+		s.send(&Response{
+			JsonRpc: "2.0",
+			Id:      message.Id,
+			Result:  result,
+			Error:   responseError,
+		})
+	}
+}
+
+func (s *Server) send(message any) {
+	wire.WriteMessage(s.out, message)
+}
+
+func (s *Server) notify(method string, params any) {
+	s.send(&Notification{JsonRpc: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) handle(message *Message) (any, *ResponseError) {
+	switch message.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":       1, // Full document on every change
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"hoverProvider":          true,
+				"completionProvider":     map[string]any{},
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]any{"name": "monkey"},
+		}, nil
+
+	case "initialized", "shutdown":
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := decode(message, &params); err != nil {
+			return nil, err
+		}
+
+		s.update(params.TextDocument.Uri, params.TextDocument.Text)
+		return nil, nil
+
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := decode(message, &params); err != nil {
+			return nil, err
+		}
+
+		if len(params.ContentChanges) > 0 {
+			s.update(params.TextDocument.Uri, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+		return nil, nil
+
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := decode(message, &params); err != nil {
+			return nil, err
+		}
+
+		delete(s.documents, params.TextDocument.Uri)
+		s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+			Uri:         params.TextDocument.Uri,
+			Diagnostics: []Diagnostic{},
+		})
+		return nil, nil
+
+	case "textDocument/definition":
+		return s.definition(message)
+
+	case "textDocument/references":
+		return s.references(message)
+
+	case "textDocument/hover":
+		return s.hover(message)
+
+	case "textDocument/completion":
+		return s.completion(message)
+
+	case "textDocument/documentSymbol":
+		return s.documentSymbols(message)
+	}
+
+	if strings.HasPrefix(message.Method, "$/") {
+		return nil, nil
+	}
+
+	return nil, &ResponseError{Code: MethodNotFound, Message: fmt.Sprintf("unsupported method %s", message.Method)}
+}
+
+func decode(message *Message, params any) *ResponseError {
+	err := json.Unmarshal(message.Params, params)
+	if err != nil {
+		return &ResponseError{Code: InvalidParams, Message: err.Error()}
+	}
+
+	return nil
+}
+
+func (s *Server) update(uri string, text string) {
+	d := &document{
+		lines:    strings.Split(text, "\n"),
+		analysis: AnalyzeFile(filename(uri), text),
+	}
+	s.documents[uri] = d
+
+	diagnostics := []Diagnostic{}
+	for _, err := range d.analysis.Errors {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    d.toRange(err.Start, err.End),
+			Severity: SeverityError,
+			Source:   "monkey",
+			Message:  err.Message,
+		})
+	}
+
+	s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		Uri:         uri,
+		Diagnostics: diagnostics,
+	})
+}
+
+// Where a file: URI points, empty for other URIs
+func filename(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "file" {
+		return ""
+	}
+
+	return filepath.FromSlash(parsed.Path)
+}
+
+// The document and the reference at the requested position, if any
+func (s *Server) referenceAt(message *Message, params *TextDocumentPositionParams) (*document, *Reference, *ResponseError) {
+	if err := decode(message, params); err != nil {
+		return nil, nil, err
+	}
+
+	d, ok := s.documents[params.TextDocument.Uri]
+	if !ok {
+		return nil, nil, &ResponseError{Code: InvalidParams, Message: fmt.Sprintf("unknown document %s", params.TextDocument.Uri)}
+	}
+
+	return d, d.analysis.ReferenceAt(d.fromPosition(params.Position)), nil
+}
+
+func (s *Server) definition(message *Message) (any, *ResponseError) {
+	var params TextDocumentPositionParams
+	d, reference, err := s.referenceAt(message, &params)
+	if err != nil || reference == nil || reference.Definition.Identifier == nil {
+		return nil, err
+	}
+
+	return Location{
+		Uri:   params.TextDocument.Uri,
+		Range: d.rangeOf(reference.Definition.Identifier),
+	}, nil
+}
+
+func (s *Server) references(message *Message) (any, *ResponseError) {
+	var params ReferenceParams
+	d, reference, err := s.referenceAt(message, &params.TextDocumentPositionParams)
+	if err != nil {
+		return nil, err
+	}
+
+	// The embedded struct doesn't see the context
+	decode(message, &params)
+
+	locations := []Location{}
+	if reference == nil {
+		return locations, nil
+	}
+
+	for _, r := range d.analysis.ReferencesTo(reference.Definition, params.Context.IncludeDeclaration) {
+		locations = append(locations, Location{
+			Uri:   params.TextDocument.Uri,
+			Range: d.rangeOf(r.Identifier),
+		})
+	}
+
+	return locations, nil
+}
+
+func (s *Server) hover(message *Message) (any, *ResponseError) {
+	var params TextDocumentPositionParams
+	d, reference, err := s.referenceAt(message, &params)
+	if err != nil || reference == nil {
+		return nil, err
+	}
+
+	return Hover{
+		Contents: MarkupContent{
+			Kind:  "plaintext",
+			Value: scopeName(reference.Scope) + " " + describe(reference.Definition),
+		},
+		Range: d.rangeOf(reference.Identifier),
+	}, nil
+}
+
+// Like "add: fn(a, b)"
+func describe(definition *Definition) string {
+	function, ok := definition.Value.(*ast.FunctionLiteral)
+	if !ok {
+		return definition.Name
+	}
+
+	parameters := []string{}
+	for _, parameter := range function.Parameters {
+		parameters = append(parameters, parameter.Value)
+	}
+
+	return fmt.Sprintf("%s: fn(%s)", definition.Name, strings.Join(parameters, ", "))
+}
+
+func (s *Server) completion(message *Message) (any, *ResponseError) {
+	var params TextDocumentPositionParams
+	if err := decode(message, &params); err != nil {
+		return nil, err
+	}
+
+	items := []CompletionItem{}
+
+	d, ok := s.documents[params.TextDocument.Uri]
+	if !ok {
+		return items, nil
+	}
+
+	for _, definition := range d.analysis.VisibleAt(d.fromPosition(params.Position)) {
+		item := CompletionItem{
+			Label:  definition.Name,
+			Kind:   CompletionVariable,
+			Detail: scopeName(definition.Scope),
+		}
+
+		if _, isFunction := definition.Value.(*ast.FunctionLiteral); isFunction || definition.Identifier == nil {
+			item.Kind = CompletionFunction
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func (s *Server) documentSymbols(message *Message) (any, *ResponseError) {
+	var params DocumentSymbolParams
+	if err := decode(message, &params); err != nil {
+		return nil, err
+	}
+
+	symbols := []DocumentSymbol{}
+
+	d, ok := s.documents[params.TextDocument.Uri]
+	if !ok {
+		return symbols, nil
+	}
+
+	for _, statement := range d.analysis.Program.Statements {
+		let, ok := statement.(*ast.LetStatement)
+		if !ok {
+			continue
+		}
+
+		symbol := DocumentSymbol{
+			Name:           let.Name.Value,
+			Kind:           SymbolVariable,
+			Range:          d.toRange(let.Pos(), let.End()),
+			SelectionRange: d.rangeOf(let.Name),
+		}
+
+		if _, isFunction := let.Value.(*ast.FunctionLiteral); isFunction {
+			symbol.Kind = SymbolFunction
+			symbol.Detail = describe(&Definition{Name: let.Name.Value, Value: let.Value})
+		}
+
+		symbols = append(symbols, symbol)
+	}
+
+	return symbols, nil
+}
+
+func (d *document) rangeOf(node ast.Node) Range {
+	return d.toRange(node.Pos(), node.End())
+}
+
+func (d *document) toRange(start, end token.Position) Range {
+	return Range{Start: d.toPosition(start), End: d.toPosition(end)}
+}
+
+// Our columns count bytes from one, LSP's characters count UTF-16 code units from zero
+func (d *document) toPosition(position token.Position) Position {
+	line := position.Line - 1
+	if line < 0 || line >= len(d.lines) {
+		return Position{Line: max(line, 0)}
+	}
+
+	text := d.lines[line]
+	end := min(max(position.Column-1, 0), len(text))
+
+	character := 0
+	for _, r := range text[:end] {
+		character += utf16Length(r)
+	}
+
+	return Position{Line: line, Character: character}
+}
+
+func (d *document) fromPosition(position Position) token.Position {
+	if position.Line < 0 || position.Line >= len(d.lines) {
+		return token.Position{Line: position.Line + 1, Column: 1}
+	}
+
+	text := d.lines[position.Line]
+
+	offset, character := 0, 0
+	for offset < len(text) && character < position.Character {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		character += utf16Length(r)
+		offset += size
+	}
+
+	return token.Position{Line: position.Line + 1, Column: offset + 1}
+}
+
+func utf16Length(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
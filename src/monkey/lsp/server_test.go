@@ -0,0 +1,231 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"monkey/wire"
+	"os"
+	"testing"
+)
+
+const uri = "file:///test.mk"
+
+type client struct {
+	t   *testing.T
+	in  io.Writer
+	out *bufio.Reader
+	id  int
+}
+
+func startSession(t *testing.T) *client {
+	serverOut, serverToClient := io.Pipe()
+	return startSessionOn(t, serverOut, serverToClient)
+}
+
+// The server writes to serverToClient, and the client reads it from serverOut
+func startSessionOn(t *testing.T, serverOut io.Reader, serverToClient io.WriteCloser) *client {
+	clientToServer, serverIn := io.Pipe()
+
+	server := NewServer(clientToServer, serverToClient)
+	go func() {
+		err := server.Run()
+		if err != nil {
+			t.Errorf("server failed: %s", err)
+		}
+		serverToClient.Close()
+	}()
+
+	return &client{t: t, in: serverIn, out: bufio.NewReader(serverOut)}
+}
+
+func (c *client) notify(method string, params any) {
+	err := wire.WriteMessage(c.in, map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+	if err != nil {
+		c.t.Fatalf("could not send %s: %s", method, err)
+	}
+}
+
+// Sends a request and decodes the result of its response into result
+func (c *client) request(method string, params any, result any) {
+	c.id++
+
+	err := wire.WriteMessage(c.in, map[string]any{"jsonrpc": "2.0", "id": c.id, "method": method, "params": params})
+	if err != nil {
+		c.t.Fatalf("could not send %s: %s", method, err)
+	}
+
+	var response struct {
+		Id     int             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *ResponseError  `json:"error"`
+	}
+	c.read(&response)
+
+	if response.Id != c.id {
+		c.t.Fatalf("response to %d, expected %d", response.Id, c.id)
+	}
+	if response.Error != nil {
+		c.t.Fatalf("%s failed: %s", method, response.Error.Message)
+	}
+
+	err = json.Unmarshal(response.Result, result)
+	if err != nil {
+		c.t.Fatalf("invalid result %s: %s", response.Result, err)
+	}
+}
+
+func (c *client) read(message any) {
+	content, err := wire.ReadMessage(c.out)
+	if err != nil {
+		c.t.Fatalf("could not read message: %s", err)
+	}
+
+	err = json.Unmarshal(content, message)
+	if err != nil {
+		c.t.Fatalf("invalid message %s: %s", content, err)
+	}
+}
+
+func (c *client) expectDiagnostics() []Diagnostic {
+	var notification struct {
+		Method string                   `json:"method"`
+		Params PublishDiagnosticsParams `json:"params"`
+	}
+	c.read(&notification)
+
+	if notification.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expected diagnostics, got %s", notification.Method)
+	}
+
+	return notification.Params.Diagnostics
+}
+
+func at(line, character int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     Position{Line: line, Character: character},
+	}
+}
+
+func TestSession(t *testing.T) {
+	c := startSession(t)
+
+	var capabilities map[string]any
+	c.request("initialize", map[string]any{}, &capabilities)
+	c.notify("initialized", map[string]any{})
+
+	c.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "monkey", "version": 1, "text": "let s = \"日本\"; let = 1;"},
+	})
+	diagnostics := c.expectDiagnostics()
+	if len(diagnostics) == 0 {
+		t.Fatalf("no diagnostics for a broken document")
+	}
+	// The multibyte string counts as four UTF-16 code units
+	expected := Range{Start: Position{Line: 0, Character: 18}, End: Position{Line: 0, Character: 19}}
+	if diagnostics[0].Range != expected {
+		t.Errorf("diagnostic range %+v wrong, expected %+v", diagnostics[0].Range, expected)
+	}
+
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []any{map[string]any{"text": source}},
+	})
+	if diagnostics := c.expectDiagnostics(); len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics %v", diagnostics)
+	}
+
+	var location Location
+	c.request("textDocument/definition", at(2, 20), &location)
+	expected = Range{Start: Position{Line: 1, Character: 13}, End: Position{Line: 1, Character: 14}}
+	if location.Uri != uri || location.Range != expected {
+		t.Errorf("definition %+v wrong, expected %+v", location, expected)
+	}
+
+	var locations []Location
+	params := at(0, 4)
+	params["context"] = map[string]any{"includeDeclaration": false}
+	c.request("textDocument/references", params, &locations)
+	if len(locations) != 2 || locations[0].Range.Start.Line != 2 || locations[1].Range.Start.Line != 5 {
+		t.Errorf("references to x %+v wrong", locations)
+	}
+
+	var hover Hover
+	c.request("textDocument/hover", at(3, 1), &hover)
+	if hover.Contents.Value != "function add: fn(a, b)" {
+		t.Errorf("hover %q wrong", hover.Contents.Value)
+	}
+
+	c.request("textDocument/hover", at(2, 24), &hover)
+	if hover.Contents.Value != "global x" {
+		t.Errorf("hover %q wrong", hover.Contents.Value)
+	}
+
+	var nothing any
+	c.request("textDocument/hover", at(5, 8), &nothing)
+	if nothing != nil {
+		t.Errorf("hover on a string literal returned %v", nothing)
+	}
+
+	var items []CompletionItem
+	c.request("textDocument/completion", at(3, 1), &items)
+	labels := map[string]CompletionItem{}
+	for _, item := range items {
+		labels[item.Label] = item
+	}
+	if labels["inner"].Detail != "local" || labels["add"].Kind != CompletionFunction || labels["len"].Detail != "builtin" {
+		t.Errorf("completions %+v wrong", items)
+	}
+
+	var symbols []DocumentSymbol
+	c.request("textDocument/documentSymbol", map[string]any{"textDocument": map[string]any{"uri": uri}}, &symbols)
+	if len(symbols) != 2 {
+		t.Fatalf("wrong number of symbols %d, expected 2", len(symbols))
+	}
+	if symbols[0].Name != "x" || symbols[0].Kind != SymbolVariable || symbols[1].Name != "add" || symbols[1].Kind != SymbolFunction {
+		t.Errorf("symbols %+v wrong", symbols)
+	}
+	if symbols[1].Range.End != (Position{Line: 4, Character: 1}) {
+		t.Errorf("add ends at %+v, expected 4:1", symbols[1].Range.End)
+	}
+
+	c.notify("textDocument/didClose", map[string]any{"textDocument": map[string]any{"uri": uri}})
+	if diagnostics := c.expectDiagnostics(); len(diagnostics) != 0 {
+		t.Errorf("diagnostics not cleared on close")
+	}
+
+	c.request("shutdown", nil, &nothing)
+	c.notify("exit", nil)
+}
+
+// Like when it's run, the server writes the protocol to stdout, which what
+// macros print mustn't end up in
+func TestMacroOutput(t *testing.T) {
+	serverOut, serverToClient, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = serverToClient
+	defer func() { os.Stdout = stdout }()
+
+	c := startSessionOn(t, serverOut, serverToClient)
+
+	var capabilities map[string]any
+	c.request("initialize", map[string]any{}, &capabilities)
+	c.notify("initialized", map[string]any{})
+
+	c.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "monkey", "version": 1,
+			"text": "let loud = macro() { puts(\"noise\"); quote(1) };\nloud();"},
+	})
+	if diagnostics := c.expectDiagnostics(); len(diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics %v", diagnostics)
+	}
+
+	var nothing any
+	c.request("shutdown", nil, &nothing)
+	c.notify("exit", nil)
+}
@@ -1,13 +1,383 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/dap"
+	"monkey/debugger"
+	"monkey/evaluator"
+	"monkey/format"
+	"monkey/lexer"
+	"monkey/lsp"
+	"monkey/module"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/profiler"
+	"monkey/regvm"
 	"monkey/repl"
+	"monkey/vm"
 	"os"
 	"os/user"
+	"strings"
 )
 
+const USAGE = `Usage:
+  monkey                 start the REPL
+  monkey run [-allow dir] [-stdin] [-engine vm|register] [-profile out]
+             [-profile-report] <file>
+                         run a script, giving it access to the files
+                         under dir and to stdin with read_line, on the
+                         stack or the register VM, writing a pprof
+                         profile to out or printing a report
+  monkey ast <file>      print a script's syntax tree as JSON
+  monkey debug <file>    debug a script
+  monkey test [-v] [-format text|tap|junit] [-cover] [-coverprofile out]
+              [-coverhtml out] paths
+                         run the test_ functions of scripts, and of the
+                         *_test.mk files in directories, each in a VM
+                         of its own; with -cover, reporting which lines
+                         and if branches of the scripts and the files
+                         they import ran, as LCOV in out or annotated
+                         source in an HTML page
+  monkey fmt [-w] files  format scripts, printing the result unless -w
+                         rewrites them in place; stdin without files
+  monkey dap             serve the Debug Adapter Protocol over stdio
+  monkey lsp             serve the Language Server Protocol over stdio
+`
+
 func main() {
+	if len(os.Args) < 2 {
+		startRepl()
+		return
+	}
+
+	switch os.Args[1] {
+	case "run":
+		runFile(os.Args[2:])
+
+	case "debug":
+		if len(os.Args) != 3 {
+			fmt.Fprint(os.Stderr, USAGE)
+			os.Exit(2)
+		}
+
+		source := readSource(os.Args[2])
+		debugger.Start(os.Stdin, os.Stdout, os.Args[2], source)
+
+	case "ast":
+		if len(os.Args) != 3 {
+			fmt.Fprint(os.Stderr, USAGE)
+			os.Exit(2)
+		}
+
+		printAst(os.Args[2])
+
+	case "test":
+		testFiles(os.Args[2:])
+
+	case "fmt":
+		formatFiles(os.Args[2:])
+
+	case "dap":
+		serveDap()
+
+	case "lsp":
+		err := lsp.NewServer(os.Stdin, os.Stdout).Run()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "lsp: %s\n", err)
+			os.Exit(1)
+		}
+
+	default:
+		fmt.Fprint(os.Stderr, USAGE)
+		os.Exit(2)
+	}
+}
+
+func runFile(arguments []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	allow := flags.String("allow", "", "directory the script may read and write files under")
+	stdin := flags.Bool("stdin", false, "let the script read lines from stdin")
+	profile := flags.String("profile", "", "write a pprof profile of the run to this file")
+	report := flags.Bool("profile-report", false, "print where the run spent its time to stderr")
+	engine := flags.String("engine", "vm", "run on the stack vm or the register vm")
+	flags.Parse(arguments)
+
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, USAGE)
+		os.Exit(2)
+	}
+	filename := flags.Arg(0)
+
+	context := object.NewContext()
+	if *allow != "" {
+		files, err := object.NewSandbox(*allow)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "run: %s\n", err)
+			os.Exit(1)
+		}
+		context.Files = files
+	}
+	if *stdin {
+		context.Stdin = os.Stdin
+	}
+
+	var err error
+	switch *engine {
+	case "vm":
+		err = runStack(filename, context, *profile, *report)
+	case "register":
+		if *profile != "" || *report {
+			fmt.Fprintln(os.Stderr, "run: only the vm engine can be profiled")
+			os.Exit(2)
+		}
+		err = runRegisters(filename, context)
+	default:
+		fmt.Fprintf(os.Stderr, "run: unknown engine %q, expected vm or register\n", *engine)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err)
+		os.Exit(1)
+	}
+}
+
+func runStack(filename string, context *object.Context, profile string, report bool) error {
+	bytecode, err := compileFile(filename, readSource(filename))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	machine := vm.New(bytecode)
+	machine.SetContext(context)
+
+	if profile == "" && !report {
+		return machine.Execute()
+	}
+
+	return runProfiled(&machine, filename, profile, report)
+}
+
+func runRegisters(filename string, context *object.Context) error {
+	bytecode, err := compileRegisters(filename, readSource(filename))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	machine := regvm.New(bytecode)
+	machine.SetContext(context)
+
+	return machine.Execute()
+}
+
+// Parses, expands macros in and compiles a script, errors are prefixed with
+// the file and position they're at
+func compileFile(filename string, source string) (*compiler.Bytecode, error) {
+	program, err := expandFile(filename, source)
+	if err != nil {
+		return nil, err
+	}
+
+	c := compiler.New()
+	c.Loader = module.NewLoader(filename)
+	err = c.Compile(program)
+	if err != nil {
+		return nil, positioned(filename, err)
+	}
+
+	return c.Bytecode(), nil
+}
+
+// Like compileFile, for the register VM
+func compileRegisters(filename string, source string) (*regvm.Bytecode, error) {
+	program, err := expandFile(filename, source)
+	if err != nil {
+		return nil, err
+	}
+
+	c := regvm.NewCompiler()
+	c.Loader = module.NewLoader(filename)
+	err = c.Compile(program)
+	if err != nil {
+		return nil, positioned(filename, err)
+	}
+
+	return c.Bytecode(), nil
+}
+
+func expandFile(filename string, source string) (*ast.Program, error) {
+	p := parser.New(lexer.New(source))
+
+	program := p.ParseProgram()
+	if len(p.DetailedErrors()) != 0 {
+		messages := []string{}
+		for _, err := range p.DetailedErrors() {
+			messages = append(messages, fmt.Sprintf("%s:%s: %s", filename, err.Position, err.Message))
+		}
+		return nil, errors.New(strings.Join(messages, "\n"))
+	}
+
+	macros := object.NewEnvironment()
+	evaluator.DefineMacros(program, macros)
+	expanded, err := evaluator.ExpandMacros(program, macros)
+	if err != nil {
+		return nil, positioned(filename, err)
+	}
+
+	return expanded.(*ast.Program), nil
+}
+
+// Prefixes an error with the file and, for compiler and macro errors, the
+// position in it
+func positioned(filename string, err error) error {
+	var compilerErr *compiler.Error
+	var macroErr *evaluator.MacroError
+
+	switch {
+	case errors.As(err, &compilerErr):
+		return fmt.Errorf("%s:%s: %s", filename, compilerErr.Position, err)
+	case errors.As(err, &macroErr):
+		return fmt.Errorf("%s:%s: %s", filename, macroErr.Position, err)
+	default:
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+}
+
+// Profiles are written even when the script fails
+func runProfiled(machine *vm.VM, filename string, profile string, report bool) error {
+	p := profiler.New(machine, filename)
+	runErr := p.Run()
+
+	if report {
+		p.WriteReport(os.Stderr)
+	}
+
+	if profile != "" {
+		out, err := os.Create(profile)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		err = p.WritePprof(out)
+		if err != nil {
+			return err
+		}
+	}
+
+	return runErr
+}
+
+func printAst(filename string) {
+	p := parser.New(lexer.New(readSource(filename)))
+
+	program := p.ParseProgram()
+	if len(p.DetailedErrors()) != 0 {
+		for _, err := range p.DetailedErrors() {
+			fmt.Fprintf(os.Stderr, "%s:%s: %s\n", filename, err.Position, err.Message)
+		}
+		os.Exit(1)
+	}
+
+	encoded, err := ast.Encode(program)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ast: %s\n", err)
+		os.Exit(1)
+	}
+
+	var out bytes.Buffer
+	json.Indent(&out, encoded, "", "  ")
+	out.WriteString("\n")
+	out.WriteTo(os.Stdout)
+}
+
+func formatFiles(arguments []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result to the files instead of printing it")
+	flags.Parse(arguments)
+
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "fmt: -w needs files to write to")
+			os.Exit(2)
+		}
+
+		source, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fmt: %s\n", err)
+			os.Exit(1)
+		}
+
+		formatted, err := format.Source(string(source))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "<stdin>:%s\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Print(formatted)
+		return
+	}
+
+	failed := false
+	for _, filename := range flags.Args() {
+		err := formatFile(filename, *write)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func formatFile(filename string, write bool) error {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	formatted, err := format.Source(string(source))
+	if err != nil {
+		// One error per line, each prefixed with the file
+		return fmt.Errorf("%s:%s", filename, strings.ReplaceAll(err.Error(), "\n", "\n"+filename+":"))
+	}
+
+	if !write {
+		fmt.Print(formatted)
+		return nil
+	}
+
+	if formatted == string(source) {
+		return nil
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, []byte(formatted), info.Mode())
+}
+
+func serveDap() {
+	err := dap.NewServer(os.Stdin, os.Stdout).Run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dap: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func startRepl() {
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -17,3 +387,13 @@ func main() {
 	fmt.Printf("Feel free to type in commands\n")
 	repl.Start(os.Stdin, os.Stdout)
 }
+
+func readSource(filename string) string {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not read %s: %s\n", filename, err)
+		os.Exit(1)
+	}
+
+	return string(source)
+}
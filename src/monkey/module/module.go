@@ -0,0 +1,89 @@
+// Finding, reading and parsing the files a program imports
+package module
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/parser"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Keeps track of which files are being imported, by whom, to catch cycles
+type Loader struct {
+	ReadFile func(name string) ([]byte, error) // os.ReadFile unless replaced
+	Sources  map[string]string                 // Of the files entered so far, by path
+
+	importing []string // The main file first, the one being imported last
+}
+
+// The main file's path is what its imports are relative to, empty for
+// code that isn't in a file, like the REPL's
+func NewLoader(path string) *Loader {
+	if path != "" {
+		path = filepath.Clean(path)
+	}
+
+	return &Loader{ReadFile: os.ReadFile, Sources: map[string]string{}, importing: []string{path}}
+}
+
+type CycleError struct {
+	Cycle []string // Paths in the order they import each other, the first one last again
+}
+
+func (e *CycleError) Error() string {
+	return "import cycle: " + strings.Join(e.Cycle, " -> ")
+}
+
+// The path of the file being compiled or evaluated
+func (l *Loader) Current() string {
+	return l.importing[len(l.importing)-1]
+}
+
+// Imported paths are relative to the directory of the importing file
+func (l *Loader) Resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+
+	return filepath.Join(filepath.Dir(l.Current()), path)
+}
+
+// Reads and parses the file at a resolved path, which becomes the current
+// one until Leave
+func (l *Loader) Enter(path string) (*ast.Program, error) {
+	for i, importing := range l.importing {
+		if importing == path {
+			cycle := append([]string{}, l.importing[i:]...)
+			return nil, &CycleError{Cycle: append(cycle, path)}
+		}
+	}
+
+	source, err := l.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if len(p.DetailedErrors()) != 0 {
+		messages := []string{}
+		for _, err := range p.DetailedErrors() {
+			messages = append(messages, fmt.Sprintf("%s:%s: %s", path, err.Position, err.Message))
+		}
+
+		return nil, fmt.Errorf("parser errors:\n\t%s", strings.Join(messages, "\n\t"))
+	}
+
+	l.Sources[path] = string(source)
+	l.importing = append(l.importing, path)
+
+	return program, nil
+}
+
+// Goes back to the importing file
+func (l *Loader) Leave() {
+	l.importing = l.importing[:len(l.importing)-1]
+}
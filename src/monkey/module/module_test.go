@@ -0,0 +1,89 @@
+package module
+
+import (
+	"fmt"
+	"testing"
+)
+
+func files(contents map[string]string) func(string) ([]byte, error) {
+	return func(name string) ([]byte, error) {
+		source, ok := contents[name]
+		if !ok {
+			return nil, fmt.Errorf("open %s: no such file", name)
+		}
+		return []byte(source), nil
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		main     string
+		path     string
+		expected string
+	}{
+		{"", "lib.mk", "lib.mk"},
+		{"", "./lib/../lib.mk", "lib.mk"},
+		{"src/main.mk", "lib.mk", "src/lib.mk"},
+		{"src/main.mk", "../lib.mk", "lib.mk"},
+		{"src/main.mk", "/usr/lib.mk", "/usr/lib.mk"},
+	}
+
+	for _, tt := range tests {
+		if resolved := NewLoader(tt.main).Resolve(tt.path); resolved != tt.expected {
+			t.Errorf("%q from %q resolved to %q, expected %q", tt.path, tt.main, resolved, tt.expected)
+		}
+	}
+}
+
+func TestEnterAndLeave(t *testing.T) {
+	l := NewLoader("main.mk")
+	l.ReadFile = files(map[string]string{
+		"lib/a.mk": `import "b.mk" as b;`,
+		"lib/b.mk": `let x = ;`,
+	})
+
+	program, err := l.Enter(l.Resolve("lib/a.mk"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if program.String() != `import "b.mk" as b;` {
+		t.Errorf("wrong program %q", program.String())
+	}
+
+	if l.Current() != "lib/a.mk" || l.Resolve("b.mk") != "lib/b.mk" {
+		t.Errorf("imports not relative to the imported file")
+	}
+
+	_, err = l.Enter(l.Resolve("b.mk"))
+	if err == nil || err.Error() != "parser errors:\n\tlib/b.mk:1:9: no prefix parse function for ; found" {
+		t.Errorf("wrong error %v", err)
+	}
+
+	_, err = l.Enter(l.Resolve("c.mk"))
+	if err == nil || err.Error() != "open lib/c.mk: no such file" {
+		t.Errorf("wrong error %v", err)
+	}
+
+	l.Leave()
+	if l.Current() != "main.mk" {
+		t.Errorf("back in %q, expected main.mk", l.Current())
+	}
+}
+
+func TestCycles(t *testing.T) {
+	l := NewLoader("main.mk")
+	l.ReadFile = files(map[string]string{"a.mk": ``, "b.mk": ``})
+
+	l.Enter("a.mk")
+	l.Enter("b.mk")
+
+	_, err := l.Enter("a.mk")
+	if err == nil || err.Error() != "import cycle: a.mk -> b.mk -> a.mk" {
+		t.Errorf("wrong error %v", err)
+	}
+
+	_, err = l.Enter("main.mk")
+	if err == nil || err.Error() != "import cycle: main.mk -> a.mk -> b.mk -> main.mk" {
+		t.Errorf("wrong error %v", err)
+	}
+}
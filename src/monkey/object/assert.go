@@ -0,0 +1,126 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Failed assertions are runtime errors, so they stop the program, or the
+// test they're in under monkey test
+var assertBuiltins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		Name: "assert",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if len(args) != 1 && len(args) != 2 {
+					return &Error{
+						fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args)),
+					}
+				}
+
+				if IsTruthy(args[0]) {
+					return nil
+				}
+
+				return assertionFailed(args[1:], "")
+			},
+		},
+	},
+	{
+		Name: "assert_eq",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if len(args) != 2 && len(args) != 3 {
+					return &Error{
+						fmt.Sprintf("wrong number of arguments. got=%d, want=2 or 3", len(args)),
+					}
+				}
+
+				if Equal(args[0], args[1]) {
+					return nil
+				}
+
+				return assertionFailed(args[2:], fmt.Sprintf("expected %s, got %s", describe(args[1]), describe(args[0])))
+			},
+		},
+	},
+	{
+		// Calls the function, which has to fail, and returns the error's message.
+		// With a second argument, the message has to contain it.
+		Name: "assert_error",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if len(args) != 1 && len(args) != 2 {
+					return &Error{
+						fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args)),
+					}
+				}
+
+				if args[0].Type() != FUNCTION_OBJ && args[0].Type() != BUILTIN_OBJ {
+					return &Error{
+						fmt.Sprintf("argument 1 to `assert_error` must be FUNCTION, got %s", args[0].Type()),
+					}
+				}
+
+				var expected *String
+				if len(args) == 2 {
+					var ok bool
+					expected, ok = args[1].(*String)
+					if !ok {
+						return &Error{
+							fmt.Sprintf("argument 2 to `assert_error` must be STRING, got %s", args[1].Type()),
+						}
+					}
+				}
+
+				if ctx.Call == nil {
+					return &Error{"`assert_error` can't call functions here"}
+				}
+
+				result, err := ctx.Call(args[0])
+				if err == nil {
+					return &Error{fmt.Sprintf("assertion failed: expected an error, got %s", describe(result))}
+				}
+
+				if expected != nil && !strings.Contains(err.Error(), expected.Value) {
+					return &Error{
+						fmt.Sprintf("assertion failed: expected an error containing %q, got %q", expected.Value, err.Error()),
+					}
+				}
+
+				return &String{Value: err.Error()}
+			},
+		},
+	},
+}
+
+// The optional message argument goes first, then what went wrong
+func assertionFailed(message []Object, details string) *Error {
+	parts := []string{"assertion failed"}
+
+	if len(message) > 0 {
+		if str, ok := message[0].(*String); ok {
+			parts = append(parts, str.Value)
+		} else {
+			parts = append(parts, message[0].Inspect())
+		}
+	}
+
+	if details != "" {
+		parts = append(parts, details)
+	}
+
+	return &Error{strings.Join(parts, ": ")}
+}
+
+// Strings are quoted, so "1" and 1 can be told apart
+func describe(value Object) string {
+	if str, ok := value.(*String); ok {
+		return fmt.Sprintf("%q", str.Value)
+	}
+
+	return value.Inspect()
+}
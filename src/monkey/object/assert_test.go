@@ -0,0 +1,63 @@
+package object
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAssertBuiltins(t *testing.T) {
+	str := func(value string) *String { return &String{Value: value} }
+	integer := func(value int64) *Integer { return &Integer{Value: value} }
+
+	// Stands in for an engine, where calling fails fails and anything else returns 1
+	fails := &Builtin{}
+	context := NewContext()
+	context.Call = func(function Object, args ...Object) (Object, error) {
+		if function == fails {
+			return nil, errors.New("division by zero")
+		}
+		return integer(1), nil
+	}
+
+	tests := []struct {
+		name     string
+		args     []Object
+		expected Object
+	}{
+		{"assert", []Object{True}, Nil},
+		{"assert", []Object{integer(2), str("message")}, Nil},
+		{"assert", []Object{integer(0)}, &Error{"assertion failed"}},
+		{"assert", []Object{Nil, str("not set")}, &Error{"assertion failed: not set"}},
+		{"assert", []Object{False, integer(3)}, &Error{"assertion failed: 3"}},
+		{"assert", []Object{}, &Error{"wrong number of arguments. got=0, want=1 or 2"}},
+
+		{"assert_eq", []Object{&Array{Elements: []Object{integer(1)}}, &Array{Elements: []Object{integer(1)}}}, Nil},
+		{"assert_eq", []Object{integer(1), str("1")}, &Error{`assertion failed: expected "1", got 1`}},
+		{"assert_eq", []Object{str("a"), str("b"), str("letters")}, &Error{`assertion failed: letters: expected "b", got "a"`}},
+		{"assert_eq", []Object{integer(1)}, &Error{"wrong number of arguments. got=1, want=2 or 3"}},
+
+		{"assert_error", []Object{fails}, str("division by zero")},
+		{"assert_error", []Object{fails, str("zero")}, str("division by zero")},
+		{"assert_error", []Object{fails, str("overflow")}, &Error{`assertion failed: expected an error containing "overflow", got "division by zero"`}},
+		{"assert_error", []Object{&Builtin{}}, &Error{"assertion failed: expected an error, got 1"}},
+		{"assert_error", []Object{integer(1)}, &Error{"argument 1 to `assert_error` must be FUNCTION, got INTEGER"}},
+		{"assert_error", []Object{fails, integer(1)}, &Error{"argument 2 to `assert_error` must be STRING, got INTEGER"}},
+	}
+
+	for _, tt := range tests {
+		result := GetBuiltinByName(tt.name).Fn(context, tt.args...)
+		if result == nil {
+			result = Nil
+		}
+
+		if result.Type() != tt.expected.Type() || result.Inspect() != tt.expected.Inspect() {
+			t.Errorf("%s returned %s %s, expected %s %s",
+				tt.name, result.Type(), result.Inspect(), tt.expected.Type(), tt.expected.Inspect())
+		}
+	}
+
+	result := GetBuiltinByName("assert_error").Fn(NewContext(), fails)
+	if err, ok := result.(*Error); !ok || err.Message != "`assert_error` can't call functions here" {
+		t.Errorf("expected an error without a way to call functions, got %s", result.Inspect())
+	}
+}
@@ -1,15 +1,22 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+	"unicode/utf8"
+)
 
-var Builtins = []struct {
+// Compiled code refers to builtins by their index in here
+var Builtins = slices.Concat(coreBuiltins, stringBuiltins, jsonBuiltins, ioBuiltins, assertBuiltins)
+
+var coreBuiltins = []struct {
 	Name    string
 	Builtin *Builtin
 }{
 	{
 		Name: "len",
 		Builtin: &Builtin{
-			func(args ...Object) Object {
+			func(ctx *Context, args ...Object) Object {
 				if len(args) != 1 {
 					return &Error{
 						fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args)),
@@ -18,10 +25,10 @@ var Builtins = []struct {
 
 				switch arg := args[0].(type) {
 				case *Array:
-					return &Integer{Value: int64(len(arg.Elements))}
+					return NewInteger(int64(len(arg.Elements)))
 
 				case *String:
-					return &Integer{Value: int64(len(arg.Value))}
+					return NewInteger(int64(utf8.RuneCountInString(arg.Value)))
 
 				default:
 					return &Error{
@@ -34,9 +41,9 @@ var Builtins = []struct {
 	{
 		Name: "puts",
 		Builtin: &Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *Context, args ...Object) Object {
 				for _, arg := range args {
-					fmt.Println(arg.Inspect())
+					fmt.Fprintln(ctx.Stdout, arg.Inspect())
 				}
 
 				return nil
@@ -46,7 +53,7 @@ var Builtins = []struct {
 	{
 		Name: "first",
 		Builtin: &Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *Context, args ...Object) Object {
 				if len(args) != 1 {
 					return &Error{
 						fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args)),
@@ -70,7 +77,7 @@ var Builtins = []struct {
 	{
 		Name: "last",
 		Builtin: &Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *Context, args ...Object) Object {
 				if len(args) != 1 {
 					return &Error{
 						fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args)),
@@ -95,7 +102,7 @@ var Builtins = []struct {
 	{
 		Name: "rest",
 		Builtin: &Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *Context, args ...Object) Object {
 				if len(args) != 1 {
 					return &Error{
 						fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args)),
@@ -122,7 +129,7 @@ var Builtins = []struct {
 	{
 		Name: "push",
 		Builtin: &Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx *Context, args ...Object) Object {
 				if len(args) != 2 {
 					return &Error{
 						fmt.Sprintf("wrong number of arguments. got=%d, want=2", len(args)),
@@ -145,6 +152,44 @@ var Builtins = []struct {
 			},
 		},
 	},
+	{
+		Name: "keys",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("keys", args, HASH_OBJ); err != nil {
+					return err
+				}
+
+				pairs := args[0].(*Hash).Pairs()
+
+				keys := make([]Object, len(pairs))
+				for i, pair := range pairs {
+					keys[i] = pair.Key
+				}
+
+				return &Array{Elements: keys}
+			},
+		},
+	},
+	{
+		Name: "values",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("values", args, HASH_OBJ); err != nil {
+					return err
+				}
+
+				pairs := args[0].(*Hash).Pairs()
+
+				values := make([]Object, len(pairs))
+				for i, pair := range pairs {
+					values[i] = pair.Value
+				}
+
+				return &Array{Elements: values}
+			},
+		},
+	},
 }
 
 func GetBuiltinByName(name string) *Builtin {
@@ -1,5 +1,7 @@
 package object
 
+import "monkey/module"
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
@@ -11,9 +13,29 @@ func NewEnvironment() *Environment {
 	return &Environment{store: s, outer: nil}
 }
 
+// The environment of an imported file's top level, sharing the importing
+// program's modules and context
+func NewModuleEnvironment(importer *Environment) *Environment {
+	env := NewEnvironment()
+	env.modules = importer.Modules()
+	env.context = importer.Context()
+	return env
+}
+
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+
+	modules        *Modules // Only set in the outermost environment, see Modules
+	context        *Context // Likewise, see Context
+	builtinContext *Context // Likewise, see BuiltinContext
+	calls          int      // Likewise, see EnterCall
+}
+
+// What the files of a program imported, so each is only evaluated once
+type Modules struct {
+	Loader   *module.Loader
+	Imported map[string]*Module // By resolved path
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -28,3 +50,83 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// The program's modules, importing relative to the working directory
+// unless SetLoader said otherwise
+func (e *Environment) Modules() *Modules {
+	for e.outer != nil {
+		e = e.outer
+	}
+
+	if e.modules == nil {
+		e.modules = &Modules{Loader: module.NewLoader(""), Imported: map[string]*Module{}}
+	}
+
+	return e.modules
+}
+
+// Makes imports relative to the file being evaluated in env
+func (e *Environment) SetLoader(loader *module.Loader) {
+	e.Modules().Loader = loader
+}
+
+// Where builtins called in env do their IO, the process's stdout and stderr
+// unless SetContext said otherwise
+func (e *Environment) Context() *Context {
+	for e.outer != nil {
+		e = e.outer
+	}
+
+	if e.context == nil {
+		e.context = NewContext()
+	}
+
+	return e.context
+}
+
+func (e *Environment) SetContext(context *Context) {
+	for e.outer != nil {
+		e = e.outer
+	}
+
+	e.context = context
+	e.builtinContext = nil
+}
+
+// What builtins called in env get, the context with a Call made by makeCall
+// for the outermost environment. Made once, the context may be shared so it
+// isn't changed.
+func (e *Environment) BuiltinContext(makeCall func(*Environment) func(Object, ...Object) (Object, error)) *Context {
+	for e.outer != nil {
+		e = e.outer
+	}
+
+	if e.builtinContext == nil {
+		e.builtinContext = e.Context().WithCall(makeCall(e))
+	}
+
+	return e.builtinContext
+}
+
+// Counts a call of a function defined in env, unless limit calls already
+// haven't returned. Each EnterCall that succeeds is followed by a LeaveCall.
+func (e *Environment) EnterCall(limit int) bool {
+	for e.outer != nil {
+		e = e.outer
+	}
+
+	if e.calls >= limit {
+		return false
+	}
+
+	e.calls++
+	return true
+}
+
+func (e *Environment) LeaveCall() {
+	for e.outer != nil {
+		e = e.outer
+	}
+
+	e.calls--
+}
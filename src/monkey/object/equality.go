@@ -0,0 +1,95 @@
+package object
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// Deep equality for values, identity for functions and everything else
+func Equal(a, b Object) bool {
+	if a == b {
+		return true
+	}
+
+	switch a := a.(type) {
+	case *Integer:
+		b, ok := b.(*Integer)
+		return ok && a.Value == b.Value
+
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+
+		for i := range a.Elements {
+			if !Equal(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+
+		return true
+
+	case *Hash:
+		// The order of the pairs doesn't matter
+		b, ok := b.(*Hash)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+
+		for _, pair := range a.pairs {
+			value, ok := b.Get(pair.Key.(Hashable))
+			if !ok || !Equal(pair.Value, value) {
+				return false
+			}
+		}
+
+		return true
+
+	default:
+		return false
+	}
+}
+
+// Whether obj can be used as a hash key, arrays only can when all their
+// elements can
+func AsHashable(obj Object) (Hashable, bool) {
+	if array, ok := obj.(*Array); ok {
+		for _, element := range array.Elements {
+			if _, ok := AsHashable(element); !ok {
+				return nil, false
+			}
+		}
+	}
+
+	hashable, ok := obj.(Hashable)
+	return hashable, ok
+}
+
+// Combines the elements' keys, only meaningful if AsHashable accepts the array
+func (ao *Array) HashKey() HashKey {
+	h := fnv.New64a()
+
+	var buffer [8]byte
+	for _, element := range ao.Elements {
+		key := element.(Hashable).HashKey()
+
+		h.Write([]byte(key.Type))
+		binary.LittleEndian.PutUint64(buffer[:], key.Value)
+		h.Write(buffer[:])
+	}
+
+	return HashKey{Type: ao.Type(), Value: h.Sum64()}
+}
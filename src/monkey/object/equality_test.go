@@ -0,0 +1,68 @@
+package object
+
+import "testing"
+
+func TestArrayHashKey(t *testing.T) {
+	array := func(elements ...Object) *Array { return &Array{Elements: elements} }
+
+	a1 := array(&Integer{Value: 1}, array(&String{Value: "a"}, True))
+	a2 := array(&Integer{Value: 1}, array(&String{Value: "a"}, True))
+	diff := array(array(&String{Value: "a"}, True), &Integer{Value: 1})
+
+	if a1.HashKey() != a2.HashKey() {
+		t.Errorf("arrays with same content have different hash keys")
+	}
+
+	if a1.HashKey() == diff.HashKey() {
+		t.Errorf("arrays with different content have same hash keys")
+	}
+
+	if array(&Integer{Value: 1}).HashKey() == array(True).HashKey() {
+		t.Errorf("arrays with elements of different types have same hash keys")
+	}
+
+	if _, ok := AsHashable(array(&Integer{Value: 1}, array(&Builtin{}))); ok {
+		t.Errorf("array of a builtin is hashable")
+	}
+
+	if _, ok := AsHashable(&Hash{}); ok {
+		t.Errorf("hash is hashable")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	hash := func(pairs ...Object) *Hash {
+		h := NewHash()
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i].(Hashable), pairs[i+1])
+		}
+		return h
+	}
+	a, b := &String{Value: "a"}, &String{Value: "b"}
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	builtin := &Builtin{}
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{one, &Integer{Value: 1}, true},
+		{one, two, false},
+		{one, True, false},
+		{Nil, &Null{}, true},
+		{Nil, False, false},
+		{&Array{Elements: []Object{one, a}}, &Array{Elements: []Object{one, a}}, true},
+		{&Array{Elements: []Object{one, a}}, &Array{Elements: []Object{one}}, false},
+		{hash(a, one, b, two), hash(b, two, a, one), true},
+		{hash(a, one), hash(a, two), false},
+		{hash(a, one), hash(a, one, b, two), false},
+		{builtin, builtin, true},
+		{builtin, &Builtin{}, false},
+	}
+
+	for i, tt := range tests {
+		if Equal(tt.a, tt.b) != tt.expected {
+			t.Errorf("tests[%d] - Equal(%s, %s) wasn't %t", i, tt.a.Inspect(), tt.b.Inspect(), tt.expected)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package object
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Where builtins write output and read input, and what files they may
+// touch. Any number of VMs and evaluations can share one, builtins get a
+// copy of it made with WithCall, which is never changed once made.
+type Context struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  io.Reader // nil denies read_line
+
+	Files *Sandbox // nil denies file access
+
+	// Calls a function of the running program, for builtins taking functions.
+	// A runtime error in the call is returned rather than stopping the program.
+	Call func(function Object, args ...Object) (Object, error)
+
+	stdinOnce sync.Once
+	stdin     *lineReader // Shared with the copies made by WithCall
+}
+
+// Stdin buffered across read_line calls
+type lineReader struct {
+	mutex  sync.Mutex
+	reader *bufio.Reader
+}
+
+// Writes to the process's stdout and stderr, denying input and files
+func NewContext() *Context {
+	return &Context{Stdout: os.Stdout, Stderr: os.Stderr}
+}
+
+// A copy of the context, for an engine to pass its builtins. They call
+// functions with call, and read the same buffered input as c.
+func (c *Context) WithCall(call func(function Object, args ...Object) (Object, error)) *Context {
+	return &Context{
+		Stdout: c.Stdout,
+		Stderr: c.Stderr,
+		Stdin:  c.Stdin,
+		Files:  c.Files,
+		Call:   call,
+		stdin:  c.lineReader(),
+	}
+}
+
+func (c *Context) lineReader() *lineReader {
+	c.stdinOnce.Do(func() {
+		if c.stdin == nil {
+			c.stdin = &lineReader{reader: bufio.NewReader(c.Stdin)}
+		}
+	})
+
+	return c.stdin
+}
+
+func (c *Context) readLine() (string, error) {
+	stdin := c.lineReader()
+
+	stdin.mutex.Lock()
+	defer stdin.mutex.Unlock()
+
+	return stdin.reader.ReadString('\n')
+}
+
+// The files under a directory, symlinks included as long as they don't lead
+// out of it
+type Sandbox struct {
+	root string
+}
+
+func NewSandbox(root string) (*Sandbox, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	return &Sandbox{root: root}, nil
+}
+
+// Paths are relative to the root, absolute ones must be under it
+func (s *Sandbox) resolve(name, path string) (string, *Error) {
+	if s == nil {
+		return "", &Error{fmt.Sprintf("`%s` denied, no files are accessible", name)}
+	}
+
+	resolved := path
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(s.root, resolved)
+	}
+
+	// Symlinks mustn't lead out either, for a new file its directory is checked.
+	// A broken symlink would have the file created wherever it points.
+	evaluated, err := filepath.EvalSymlinks(resolved)
+	if errors.Is(err, fs.ErrNotExist) {
+		if info, lstatErr := os.Lstat(resolved); lstatErr == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return "", &Error{fmt.Sprintf("`%s` denied, %q is a broken symlink", name, path)}
+		}
+
+		var dir string
+		dir, err = filepath.EvalSymlinks(filepath.Dir(resolved))
+		evaluated = filepath.Join(dir, filepath.Base(resolved))
+	}
+	if err == nil {
+		resolved = evaluated
+	}
+
+	if !s.contains(resolved) {
+		return "", &Error{fmt.Sprintf("`%s` denied, %q is outside the accessible directory", name, path)}
+	}
+
+	return resolved, nil
+}
+
+func (s *Sandbox) contains(path string) bool {
+	relative, err := filepath.Rel(s.root, path)
+	if err != nil {
+		return false
+	}
+
+	return relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+var ioBuiltins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		Name: "read_file",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("read_file", args, STRING_OBJ); err != nil {
+					return err
+				}
+
+				content, err := readFile(ctx, "read_file", args[0].(*String).Value)
+				if err != nil {
+					return err
+				}
+
+				return &String{Value: content}
+			},
+		},
+	},
+	{
+		Name: "read_lines",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("read_lines", args, STRING_OBJ); err != nil {
+					return err
+				}
+
+				content, err := readFile(ctx, "read_lines", args[0].(*String).Value)
+				if err != nil {
+					return err
+				}
+
+				lines := []Object{}
+				for _, line := range strings.SplitAfter(content, "\n") {
+					if line == "" {
+						continue
+					}
+
+					lines = append(lines, &String{Value: trimNewline(line)})
+				}
+
+				return &Array{Elements: lines}
+			},
+		},
+	},
+	{
+		Name: "write_file",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("write_file", args, STRING_OBJ, STRING_OBJ); err != nil {
+					return err
+				}
+
+				path := args[0].(*String).Value
+
+				resolved, denied := ctx.Files.resolve("write_file", path)
+				if denied != nil {
+					return denied
+				}
+
+				err := os.WriteFile(resolved, []byte(args[1].(*String).Value), 0o644)
+				if err != nil {
+					return fileError("write", path, err)
+				}
+
+				return nil
+			},
+		},
+	},
+	{
+		Name: "list_dir",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("list_dir", args, STRING_OBJ); err != nil {
+					return err
+				}
+
+				path := args[0].(*String).Value
+
+				resolved, denied := ctx.Files.resolve("list_dir", path)
+				if denied != nil {
+					return denied
+				}
+
+				entries, err := os.ReadDir(resolved)
+				if err != nil {
+					return fileError("list", path, err)
+				}
+
+				// ReadDir sorts by name already, directories end in a slash
+				names := make([]Object, len(entries))
+				for i, entry := range entries {
+					name := entry.Name()
+					if entry.IsDir() {
+						name += "/"
+					}
+
+					names[i] = &String{Value: name}
+				}
+
+				return &Array{Elements: names}
+			},
+		},
+	},
+	{
+		Name: "read_line",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("read_line", args); err != nil {
+					return err
+				}
+
+				if ctx.Stdin == nil {
+					return &Error{"`read_line` denied, input isn't accessible"}
+				}
+
+				line, err := ctx.readLine()
+				if err == io.EOF && line == "" {
+					// No more input
+					return nil
+				}
+				if err != nil && err != io.EOF {
+					return &Error{fmt.Sprintf("can't read a line: %s", err)}
+				}
+
+				return &String{Value: trimNewline(line)}
+			},
+		},
+	},
+}
+
+func readFile(ctx *Context, name, path string) (string, *Error) {
+	resolved, denied := ctx.Files.resolve(name, path)
+	if denied != nil {
+		return "", denied
+	}
+
+	content, err := os.ReadFile(resolved)
+	if err != nil {
+		return "", fileError("read", path, err)
+	}
+
+	return string(content), nil
+}
+
+// Reports the path as the script wrote it, not where it resolved to
+func fileError(action, path string, err error) *Error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+
+	return &Error{fmt.Sprintf("can't %s %q: %s", action, path, err)}
+}
+
+func trimNewline(line string) string {
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r")
+}
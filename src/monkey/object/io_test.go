@@ -0,0 +1,153 @@
+package object
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContextDeniesByDefault(t *testing.T) {
+	context := NewContext()
+
+	tests := []struct {
+		name     string
+		args     []Object
+		expected string
+	}{
+		{"read_file", []Object{&String{Value: "a.txt"}}, "`read_file` denied, no files are accessible"},
+		{"read_lines", []Object{&String{Value: "a.txt"}}, "`read_lines` denied, no files are accessible"},
+		{"write_file", []Object{&String{Value: "a.txt"}, &String{Value: ""}}, "`write_file` denied, no files are accessible"},
+		{"list_dir", []Object{&String{Value: "."}}, "`list_dir` denied, no files are accessible"},
+		{"read_line", []Object{}, "`read_line` denied, input isn't accessible"},
+	}
+
+	for _, tt := range tests {
+		testBuiltinError(t, context, tt.name, tt.args, tt.expected)
+	}
+}
+
+func TestSandboxFiles(t *testing.T) {
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644)
+
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "sub"), 0o755)
+	os.WriteFile(filepath.Join(root, "sub", "lines.txt"), []byte("one\r\ntwo\n\nfour"), 0o644)
+	os.Symlink(outside, filepath.Join(root, "escape"))
+	os.Symlink(filepath.Join(outside, "planted.txt"), filepath.Join(root, "dangling"))
+
+	files, err := NewSandbox(root)
+	if err != nil {
+		t.Fatalf("NewSandbox failed: %s", err)
+	}
+	context := &Context{Files: files}
+
+	str := func(value string) Object { return &String{Value: value} }
+
+	result := GetBuiltinByName("write_file").Fn(context, str("sub/new.txt"), str("héllo\n"))
+	if result != nil {
+		t.Fatalf("write_file failed: %s", result.Inspect())
+	}
+
+	tests := []struct {
+		name     string
+		args     []Object
+		expected string
+	}{
+		{"read_file", []Object{str("sub/new.txt")}, "héllo\n"},
+		{"read_file", []Object{str(filepath.Join(root, "sub", "new.txt"))}, "héllo\n"},
+		{"read_file", []Object{str("./sub/../sub/new.txt")}, "héllo\n"},
+		{"read_lines", []Object{str("sub/lines.txt")}, "[one, two, , four]"},
+		{"list_dir", []Object{str(".")}, "[dangling, escape, sub/]"},
+		{"list_dir", []Object{str("sub")}, "[lines.txt, new.txt]"},
+	}
+
+	for _, tt := range tests {
+		result := GetBuiltinByName(tt.name).Fn(context, tt.args...)
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s returned %q, expected %q", tt.name, result.Inspect(), tt.expected)
+		}
+	}
+
+	errors := []struct {
+		name     string
+		args     []Object
+		expected string
+	}{
+		{"read_file", []Object{str("../secret.txt")}, "`read_file` denied, \"../secret.txt\" is outside the accessible directory"},
+		{"read_file", []Object{str(filepath.Join(outside, "secret.txt"))}, "is outside the accessible directory"},
+		{"read_file", []Object{str("escape/secret.txt")}, "`read_file` denied, \"escape/secret.txt\" is outside the accessible directory"},
+		{"write_file", []Object{str("escape/new.txt"), str("")}, "`write_file` denied, \"escape/new.txt\" is outside the accessible directory"},
+		{"write_file", []Object{str("dangling"), str("")}, "`write_file` denied, \"dangling\" is a broken symlink"},
+		{"list_dir", []Object{str("escape")}, "`list_dir` denied, \"escape\" is outside the accessible directory"},
+		{"read_file", []Object{str("missing.txt")}, "can't read \"missing.txt\": no such file or directory"},
+		{"list_dir", []Object{str("sub/new.txt")}, "can't list \"sub/new.txt\": not a directory"},
+		{"write_file", []Object{str("sub"), str("")}, "can't write \"sub\": is a directory"},
+		{"read_file", []Object{&Integer{Value: 1}}, "argument to `read_file` must be STRING, got INTEGER"},
+	}
+
+	for _, tt := range errors {
+		testBuiltinError(t, context, tt.name, tt.args, tt.expected)
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "planted.txt")); err == nil {
+		t.Errorf("file written outside the accessible directory")
+	}
+}
+
+func TestContextStdin(t *testing.T) {
+	context := &Context{Stdin: strings.NewReader("first\r\nsecond\nlast")}
+
+	readLine := GetBuiltinByName("read_line")
+	for _, expected := range []string{"first", "second", "last"} {
+		result := readLine.Fn(context)
+		if result == nil || result.Inspect() != expected {
+			t.Fatalf("read_line returned %v, expected %q", result, expected)
+		}
+	}
+
+	if result := readLine.Fn(context); result != nil {
+		t.Errorf("read_line returned %s at the end of input, expected null", result.Inspect())
+	}
+}
+
+func TestContextWithCall(t *testing.T) {
+	context := &Context{Stdin: strings.NewReader("first\nsecond\nlast\n")}
+	readLine := GetBuiltinByName("read_line")
+
+	readLine.Fn(context)
+
+	// Copies read on from where the context got to, and leave it as it was
+	call := func(function Object, args ...Object) (Object, error) { return function, nil }
+	first, second := context.WithCall(call), context.WithCall(call)
+
+	for _, tt := range []struct {
+		context  *Context
+		expected string
+	}{{first, "second"}, {second, "last"}} {
+		if result := readLine.Fn(tt.context); result == nil || result.Inspect() != tt.expected {
+			t.Errorf("read_line returned %v, expected %q", result, tt.expected)
+		}
+	}
+
+	if context.Call != nil || first.Call == nil {
+		t.Errorf("Call not only set on the copies")
+	}
+}
+
+func testBuiltinError(t *testing.T, context *Context, name string, args []Object, expected string) {
+	t.Helper()
+
+	result := GetBuiltinByName(name).Fn(context, args...)
+
+	err, ok := result.(*Error)
+	if !ok {
+		t.Errorf("%s didn't fail, got %v", name, result)
+		return
+	}
+
+	if !strings.HasSuffix(err.Message, expected) {
+		t.Errorf("wrong error from %s. expected=%q, got=%q", name, expected, err.Message)
+	}
+}
@@ -0,0 +1,268 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"monkey/token"
+	"strconv"
+	"strings"
+)
+
+// The most spaces json_stringify indents with, larger counts are capped like
+// JavaScript's JSON.stringify does
+const maxIndent = 10
+
+// JSON objects become hashes with string keys, there are no floats yet so
+// numbers must be integers
+var jsonBuiltins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		Name: "json_parse",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("json_parse", args, STRING_OBJ); err != nil {
+					return err
+				}
+
+				return parseJSON(args[0].(*String).Value)
+			},
+		},
+	},
+	{
+		Name: "json_stringify",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				// The indent is optional, either a number of spaces or the string to indent with
+				if len(args) != 1 && len(args) != 2 {
+					return &Error{
+						fmt.Sprintf("wrong number of arguments. got=%d, want=1 or 2", len(args)),
+					}
+				}
+
+				indent := ""
+				if len(args) == 2 {
+					switch arg := args[1].(type) {
+					case *Integer:
+						if arg.Value < 0 {
+							return &Error{fmt.Sprintf("negative indent for `json_stringify`: %d", arg.Value)}
+						}
+						indent = strings.Repeat(" ", int(min(arg.Value, maxIndent)))
+
+					case *String:
+						indent = arg.Value
+
+					default:
+						return &Error{
+							fmt.Sprintf("argument 2 to `json_stringify` must be INTEGER or STRING, got %s", arg.Type()),
+						}
+					}
+				}
+
+				var out bytes.Buffer
+				if err := writeJSON(&out, args[0]); err != nil {
+					return err
+				}
+
+				if indent == "" {
+					return &String{Value: out.String()}
+				}
+
+				var indented bytes.Buffer
+				json.Indent(&indented, out.Bytes(), "", indent)
+
+				return &String{Value: indented.String()}
+			},
+		},
+	},
+}
+
+func parseJSON(input string) Object {
+	decoder := json.NewDecoder(strings.NewReader(input))
+	decoder.UseNumber()
+
+	value, err := decodeJSON(input, decoder)
+	if err != nil {
+		return err
+	}
+
+	// Only whitespace may follow the value
+	offset := int(decoder.InputOffset())
+	rest := strings.TrimLeft(input[offset:], " \t\r\n")
+	if rest != "" {
+		return jsonError(input, len(input)-len(rest), "unexpected data after the value")
+	}
+
+	return value
+}
+
+func decodeJSON(input string, decoder *json.Decoder) (Object, *Error) {
+	tok, err := decoder.Token()
+	if err != nil {
+		return nil, decoderError(input, decoder, err)
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			elements := []Object{}
+			for decoder.More() {
+				element, err := decodeJSON(input, decoder)
+				if err != nil {
+					return nil, err
+				}
+
+				elements = append(elements, element)
+			}
+
+			if _, err := decoder.Token(); err != nil {
+				return nil, decoderError(input, decoder, err)
+			}
+
+			return &Array{Elements: elements}, nil
+		}
+
+		hash := NewHash()
+		for decoder.More() {
+			name, err := decoder.Token()
+			if err != nil {
+				return nil, decoderError(input, decoder, err)
+			}
+
+			value, decodeErr := decodeJSON(input, decoder)
+			if decodeErr != nil {
+				return nil, decodeErr
+			}
+
+			hash.Set(&String{Value: name.(string)}, value)
+		}
+
+		if _, err := decoder.Token(); err != nil {
+			return nil, decoderError(input, decoder, err)
+		}
+
+		return hash, nil
+
+	case string:
+		return &String{Value: tok}, nil
+
+	case json.Number:
+		value, err := strconv.ParseInt(tok.String(), 10, 64)
+		if err == nil {
+			return NewInteger(value), nil
+		}
+
+		offset := int(decoder.InputOffset()) - len(tok.String())
+		if strings.ContainsAny(tok.String(), ".eE") {
+			return nil, jsonError(input, offset, fmt.Sprintf("number %s isn't an integer", tok))
+		}
+
+		return nil, jsonError(input, offset, fmt.Sprintf("number %s is out of range", tok))
+
+	case bool:
+		return nativeBoolean(tok), nil
+
+	default:
+		return Nil, nil
+	}
+}
+
+func decoderError(input string, decoder *json.Decoder, err error) *Error {
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr) && int(syntaxErr.Offset) >= len(input):
+		return jsonError(input, len(input), "unexpected end of JSON input")
+
+	case errors.As(err, &syntaxErr):
+		// The offset is just past the offending byte
+		return jsonError(input, int(syntaxErr.Offset)-1, syntaxErr.Error())
+
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return jsonError(input, len(input), "unexpected end of JSON input")
+
+	default:
+		return jsonError(input, int(decoder.InputOffset()), err.Error())
+	}
+}
+
+// Errors are positioned like the lexer's tokens, counting bytes from one
+func jsonError(input string, offset int, message string) *Error {
+	before := input[:offset]
+
+	position := token.Position{Line: 1, Column: offset + 1}
+	if lastNewline := strings.LastIndexByte(before, '\n'); lastNewline >= 0 {
+		position.Line += strings.Count(before, "\n")
+		position.Column = offset - lastNewline
+	}
+
+	return &Error{fmt.Sprintf("invalid JSON at %s: %s", position, message)}
+}
+
+// Writes compact JSON, hash keys in the hash's order
+func writeJSON(out *bytes.Buffer, obj Object) *Error {
+	switch obj := obj.(type) {
+	case *Integer:
+		out.WriteString(strconv.FormatInt(obj.Value, 10))
+
+	case *Boolean:
+		out.WriteString(strconv.FormatBool(obj.Value))
+
+	case *Null:
+		out.WriteString("null")
+
+	case *String:
+		writeJSONString(out, obj.Value)
+
+	case *Array:
+		out.WriteByte('[')
+		for i, element := range obj.Elements {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+
+			if err := writeJSON(out, element); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+
+	case *Hash:
+		out.WriteByte('{')
+		for i, pair := range obj.Pairs() {
+			key, ok := pair.Key.(*String)
+			if !ok {
+				return &Error{fmt.Sprintf("can't stringify hash key of type %s, keys must be STRING", pair.Key.Type())}
+			}
+
+			if i > 0 {
+				out.WriteByte(',')
+			}
+
+			writeJSONString(out, key.Value)
+			out.WriteByte(':')
+
+			if err := writeJSON(out, pair.Value); err != nil {
+				return err
+			}
+		}
+		out.WriteByte('}')
+
+	default:
+		return &Error{fmt.Sprintf("can't stringify %s", obj.Type())}
+	}
+
+	return nil
+}
+
+func writeJSONString(out *bytes.Buffer, value string) {
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+
+	// Encode ends with a newline
+	out.Truncate(out.Len() - 1)
+}
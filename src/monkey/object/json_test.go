@@ -0,0 +1,110 @@
+package object
+
+import "testing"
+
+func TestJSONParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": [1, -2, {"c": null}], "a": true}`, `{"b":[1,-2,{"c":null}],"a":true}`},
+		{`{"a": 1, "b": 2, "a": 3}`, `{"a":3,"b":2}`},
+		{` "line\nbreak é" `, `"line\nbreak é"`},
+		{`[]`, `[]`},
+		{`{}`, `{}`},
+		{`false`, `false`},
+		{`{"a": 1, "a": 2}`, `{"a":2}`},
+	}
+
+	for _, tt := range tests {
+		parsed := parseJSON(tt.input)
+		if err, ok := parsed.(*Error); ok {
+			t.Errorf("parsing %q failed: %s", tt.input, err.Message)
+			continue
+		}
+
+		stringified := GetBuiltinByName("json_stringify").Fn(NewContext(), parsed)
+		if stringified.Inspect() != tt.expected {
+			t.Errorf("parsing %q gave %s, expected %s", tt.input, stringified.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestJSONParseErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{``, "invalid JSON at 1:1: unexpected end of JSON input"},
+		{`[1, 2`, "invalid JSON at 1:6: unexpected end of JSON input"},
+		{`{"a" 1}`, "invalid JSON at 1:6: invalid character '1' after object key"},
+		{"[1,\n  2.5]", "invalid JSON at 2:3: number 2.5 isn't an integer"},
+		{`[99999999999999999999]`, "invalid JSON at 1:2: number 99999999999999999999 is out of range"},
+		{"1\n 2", "invalid JSON at 2:2: unexpected data after the value"},
+	}
+
+	for _, tt := range tests {
+		parsed := parseJSON(tt.input)
+
+		err, ok := parsed.(*Error)
+		if !ok {
+			t.Errorf("parsing %q didn't fail, got %s", tt.input, parsed.Inspect())
+			continue
+		}
+
+		if err.Message != tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, tt.expected, err.Message)
+		}
+	}
+}
+
+func TestJSONStringify(t *testing.T) {
+	hash := parseJSON(`{"z": 1, "a": ["<b>", null], "m": {}}`)
+
+	integerKeys := NewHash()
+	integerKeys.Set(&Integer{Value: 1}, True)
+
+	tests := []struct {
+		args     []Object
+		expected string
+	}{
+		{[]Object{hash}, `{"z":1,"a":["<b>",null],"m":{}}`},
+		{[]Object{hash, &Integer{Value: 2}}, "{\n  \"z\": 1,\n  \"a\": [\n    \"<b>\",\n    null\n  ],\n  \"m\": {}\n}"},
+		{[]Object{&Array{Elements: []Object{True}}, &String{Value: "\t"}}, "[\n\ttrue\n]"},
+		{[]Object{&Array{Elements: []Object{True}}, &Integer{Value: 9223372036854775807}}, "[\n          true\n]"},
+		{[]Object{&String{Value: "say \"hi\""}}, `"say \"hi\""`},
+	}
+
+	stringify := GetBuiltinByName("json_stringify")
+
+	for _, tt := range tests {
+		result := stringify.Fn(NewContext(), tt.args...)
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong JSON. expected=%q, got=%q", tt.expected, result.Inspect())
+		}
+	}
+
+	errors := []struct {
+		args     []Object
+		expected string
+	}{
+		{[]Object{}, "wrong number of arguments. got=0, want=1 or 2"},
+		{[]Object{&Builtin{}}, "can't stringify BUILTIN"},
+		{[]Object{integerKeys}, "can't stringify hash key of type INTEGER, keys must be STRING"},
+		{[]Object{True, True}, "argument 2 to `json_stringify` must be INTEGER or STRING, got BOOLEAN"},
+	}
+
+	for _, tt := range errors {
+		result := stringify.Fn(NewContext(), tt.args...)
+
+		err, ok := result.(*Error)
+		if !ok {
+			t.Errorf("json_stringify didn't fail, got %s", result.Inspect())
+			continue
+		}
+
+		if err.Message != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, err.Message)
+		}
+	}
+}
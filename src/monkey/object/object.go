@@ -6,10 +6,11 @@ import (
 	"hash/fnv"
 	"monkey/ast"
 	"monkey/opcode"
+	"monkey/token"
 	"strings"
 )
 
-type BuiltinFunction func(args ...Object) Object
+type BuiltinFunction func(ctx *Context, args ...Object) Object
 
 type ObjectType string
 
@@ -30,6 +31,11 @@ const (
 
 	ARRAY_OBJ = "ARRAY"
 	HASH_OBJ  = "HASH"
+
+	QUOTE_OBJ = "QUOTE"
+	MACRO_OBJ = "MACRO"
+
+	MODULE_OBJ = "MODULE"
 )
 
 type HashKey struct {
@@ -38,9 +44,13 @@ type HashKey struct {
 }
 
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
 
+// Objects are never changed once scripts can see them, operations make new
+// ones instead. That lets constants and other shared objects be used by any
+// number of VMs at once.
 type Object interface {
 	Type() ObjectType
 	Inspect() string
@@ -56,10 +66,43 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// Small integers are made once and shared, integers are never changed once
+// made. Most arithmetic results are small, so they don't allocate.
+const (
+	SmallIntegerMin = -256
+	SmallIntegerMax = 1023
+)
+
+var smallIntegers = func() *[SmallIntegerMax - SmallIntegerMin + 1]Integer {
+	result := &[SmallIntegerMax - SmallIntegerMin + 1]Integer{}
+	for i := range result {
+		result[i].Value = int64(i + SmallIntegerMin)
+	}
+
+	return result
+}()
+
+func NewInteger(value int64) *Integer {
+	if value >= SmallIntegerMin && value <= SmallIntegerMax {
+		return &smallIntegers[value-SmallIntegerMin]
+	}
+
+	return &Integer{Value: value}
+}
+
 type Boolean struct {
 	Value bool
 }
 
+// Shared instances so returning a boolean or null doesn't allocate. Both engines
+// compare them by type and value (see Equal and IsTruthy), so a fresh Boolean or
+// Null from a builtin is still correct, just wasteful
+var (
+	True  = &Boolean{Value: true}
+	False = &Boolean{Value: false}
+	Nil   = &Null{}
+)
+
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
 func (b *Boolean) HashKey() HashKey {
@@ -122,6 +165,13 @@ type CompiledFunction struct {
 	Instructions       opcode.Instructions
 	NumberOfLocals     int
 	NumberOfParameters int
+
+	// Debug information, not needed for execution
+	Name          string // Empty for anonymous functions
+	Lines         opcode.LineTable
+	ImportedLines map[string]opcode.LineTable // Of functions defined in imported files, by path
+	LocalNames    []string                    // Indexed like OpGetLocal
+	FreeNames     []string                    // Indexed like OpGetFree
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -129,12 +179,45 @@ func (cf *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CompiledFunction[%p]", cf)
 }
 
+// The file and position of the instruction at offset, the file empty for the main
+// one. In the importer's lines an imported file's code counts as the import's, its
+// own lines start later and so are the ones that cover it.
+func (cf *CompiledFunction) SourceFor(offset int) (string, token.Position) {
+	file := ""
+	latest, found := cf.Lines.EntryFor(offset)
+
+	for path, lines := range cf.ImportedLines {
+		entry, ok := lines.EntryFor(offset)
+		if ok && entry.Position.IsValid() && (!found || entry.Offset > latest.Offset) {
+			file, latest, found = path, entry, true
+		}
+	}
+
+	return file, latest.Position
+}
+
+// Whether a statement starts at exactly offset, and in which file and on which
+// line. The first statement of an imported file also starts the import, the
+// imported file's is the one given.
+func (cf *CompiledFunction) StatementAt(offset int) (string, int, bool) {
+	for path, lines := range cf.ImportedLines {
+		if line, ok := lines.StatementAt(offset); ok {
+			return path, line, true
+		}
+	}
+
+	line, ok := cf.Lines.StatementAt(offset)
+	return "", line, ok
+}
+
 type Closure struct {
 	Function      *CompiledFunction
 	FreeVariables []Object
+	Instructions  opcode.Instructions // The VM's copy of the function's, which it quickens
 }
 
-func (c *Closure) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+// To scripts it's a function, whichever engine runs them
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
 func (c *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", c)
 }
@@ -184,8 +267,67 @@ type HashPair struct {
 	Value Object
 }
 
+// Pairs stay in the order their keys were first set, which is the order
+// they're written in for literals
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	pairs []HashPair
+	index map[HashKey][]int // Into pairs, keys with the same hash key share a bucket
+
+	hasher Hasher
+}
+
+// Picks the bucket for a key
+type Hasher func(key Hashable) HashKey
+
+func NewHash() *Hash {
+	return NewHashWithHasher(Hashable.HashKey)
+}
+
+// For tests, which force keys to collide
+func NewHashWithHasher(hasher Hasher) *Hash {
+	return &Hash{index: map[HashKey][]int{}, hasher: hasher}
+}
+
+// Only for building a hash, replacing a key's value keeps its place
+func (h *Hash) Set(key Hashable, value Object) {
+	hashKey := h.hasher(key)
+
+	if i, ok := h.find(hashKey, key); ok {
+		h.pairs[i].Value = value
+		return
+	}
+
+	h.index[hashKey] = append(h.index[hashKey], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+}
+
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	i, ok := h.find(h.hasher(key), key)
+	if !ok {
+		return nil, false
+	}
+
+	return h.pairs[i].Value, true
+}
+
+// Hash keys can collide, so the keys themselves are compared
+func (h *Hash) find(hashKey HashKey, key Hashable) (int, bool) {
+	for _, i := range h.index[hashKey] {
+		if Equal(h.pairs[i].Key, key) {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+func (h *Hash) Len() int {
+	return len(h.pairs)
+}
+
+// In order, not to be modified
+func (h *Hash) Pairs() []HashPair {
+	return h.pairs
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
@@ -193,7 +335,7 @@ func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.pairs {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			pair.Key.Inspect(), pair.Value.Inspect()))
 	}
@@ -204,3 +346,62 @@ func (h *Hash) Inspect() string {
 
 	return out.String()
 }
+
+type Quote struct {
+	Node ast.Node
+}
+
+func (q *Quote) Type() ObjectType { return QUOTE_OBJ }
+func (q *Quote) Inspect() string {
+	return "QUOTE(" + q.Node.String() + ")"
+}
+
+type Macro struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (m *Macro) Type() ObjectType { return MACRO_OBJ }
+func (m *Macro) Inspect() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range m.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("macro")
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(m.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
+type Module struct {
+	Path    string
+	Exports map[string]Object
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return fmt.Sprintf("module(%q)", m.Path) }
+
+// null, false and 0 are falsy, everything else is truthy
+func IsTruthy(value Object) bool {
+	switch value := value.(type) {
+	case *Boolean:
+		return value.Value
+
+	case *Null:
+		return false
+
+	case *Integer:
+		return value.Value != 0
+
+	default:
+		return true
+	}
+}
@@ -40,6 +40,20 @@ func TestBooleanHashKey(t *testing.T) {
 	}
 }
 
+func TestNewInteger(t *testing.T) {
+	for _, value := range []int64{SmallIntegerMin, -1, 0, 1, SmallIntegerMax} {
+		if NewInteger(value) != NewInteger(value) || NewInteger(value).Value != value {
+			t.Errorf("small integer %d isn't shared", value)
+		}
+	}
+
+	for _, value := range []int64{SmallIntegerMin - 1, SmallIntegerMax + 1} {
+		if NewInteger(value) == NewInteger(value) || NewInteger(value).Value != value {
+			t.Errorf("integer %d is shared", value)
+		}
+	}
+}
+
 func TestIntegerHashKey(t *testing.T) {
 	one1 := &Integer{Value: 1}
 	one2 := &Integer{Value: 1}
@@ -58,3 +72,89 @@ func TestIntegerHashKey(t *testing.T) {
 		t.Errorf("integers with twoerent content have same hash keys")
 	}
 }
+
+func TestHashOrder(t *testing.T) {
+	hash := NewHash()
+	hash.Set(&String{Value: "b"}, &Integer{Value: 1})
+	hash.Set(&Integer{Value: 2}, &Integer{Value: 2})
+	hash.Set(&String{Value: "a"}, &Integer{Value: 3})
+	hash.Set(&String{Value: "b"}, &Integer{Value: 4})
+
+	expected := "{b: 4, 2: 2, a: 3}"
+	if hash.Inspect() != expected {
+		t.Errorf("wrong order. expected=%q, got=%q", expected, hash.Inspect())
+	}
+
+	value, ok := hash.Get(&String{Value: "a"})
+	if !ok || value.Inspect() != "3" {
+		t.Errorf("wrong value for a, got %v", value)
+	}
+
+	if _, ok := hash.Get(&String{Value: "c"}); ok {
+		t.Errorf("found a value for c")
+	}
+}
+
+func TestHashCollisions(t *testing.T) {
+	hashers := map[string]Hasher{
+		// Every key in one bucket
+		"constant": func(key Hashable) HashKey { return HashKey{Type: STRING_OBJ, Value: 0} },
+		// A few buckets, regardless of type
+		"mod 3": func(key Hashable) HashKey { return HashKey{Value: key.HashKey().Value % 3} },
+	}
+
+	keys := []Hashable{
+		&String{Value: "a"}, &String{Value: "b"}, &Integer{Value: 0}, &Integer{Value: 3},
+		True, False, &Array{Elements: []Object{&String{Value: "a"}}}, &Array{},
+	}
+
+	for name, hasher := range hashers {
+		hash := NewHashWithHasher(hasher)
+		for i, key := range keys {
+			hash.Set(key, &Integer{Value: int64(i)})
+		}
+
+		// Replacing one key's value mustn't touch the others in its bucket
+		hash.Set(&String{Value: "b"}, &Integer{Value: 100})
+
+		if hash.Len() != len(keys) {
+			t.Errorf("%s: wrong number of pairs %d, expected %d", name, hash.Len(), len(keys))
+		}
+
+		for i, key := range keys {
+			expected := int64(i)
+			if i == 1 {
+				expected = 100
+			}
+
+			value, ok := hash.Get(key)
+			if !ok {
+				t.Errorf("%s: no value for %s", name, key.Inspect())
+				continue
+			}
+
+			if value.(*Integer).Value != expected {
+				t.Errorf("%s: wrong value for %s. expected=%d, got=%s", name, key.Inspect(), expected, value.Inspect())
+			}
+
+			if hash.Pairs()[i].Key != key {
+				t.Errorf("%s: pair %d has key %s, expected %s", name, i, hash.Pairs()[i].Key.Inspect(), key.Inspect())
+			}
+		}
+
+		for _, missing := range []Hashable{&String{Value: "c"}, &Integer{Value: 6}, &Array{Elements: []Object{&Integer{Value: 0}}}} {
+			if value, ok := hash.Get(missing); ok {
+				t.Errorf("%s: found %s for missing key %s", name, value.Inspect(), missing.Inspect())
+			}
+		}
+
+		// Equality doesn't depend on the buckets either
+		plain := NewHash()
+		for _, pair := range hash.Pairs() {
+			plain.Set(pair.Key.(Hashable), pair.Value)
+		}
+		if !Equal(hash, plain) || !Equal(plain, hash) {
+			t.Errorf("%s: hash isn't equal to the same pairs in a plain hash", name)
+		}
+	}
+}
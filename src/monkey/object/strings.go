@@ -0,0 +1,306 @@
+package object
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The longest string repeat makes, in bytes, so a wrong count is an error
+// rather than running out of memory
+const MaxRepeatLength = 1 << 24
+
+// String functions work on runes, not bytes, indices included
+var stringBuiltins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		Name: "split",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("split", args, STRING_OBJ, STRING_OBJ); err != nil {
+					return err
+				}
+
+				parts := strings.Split(args[0].(*String).Value, args[1].(*String).Value)
+
+				elements := make([]Object, len(parts))
+				for i, part := range parts {
+					elements[i] = &String{Value: part}
+				}
+
+				return &Array{Elements: elements}
+			},
+		},
+	},
+	{
+		Name: "join",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("join", args, ARRAY_OBJ, STRING_OBJ); err != nil {
+					return err
+				}
+
+				elements := args[0].(*Array).Elements
+
+				parts := make([]string, len(elements))
+				for i, element := range elements {
+					str, ok := element.(*String)
+					if !ok {
+						return &Error{
+							fmt.Sprintf("elements joined by `join` must be STRING, got %s at %d", element.Type(), i),
+						}
+					}
+
+					parts[i] = str.Value
+				}
+
+				return &String{Value: strings.Join(parts, args[1].(*String).Value)}
+			},
+		},
+	},
+	{
+		Name: "trim",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("trim", args, STRING_OBJ); err != nil {
+					return err
+				}
+
+				return &String{Value: strings.TrimSpace(args[0].(*String).Value)}
+			},
+		},
+	},
+	{
+		Name: "replace",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("replace", args, STRING_OBJ, STRING_OBJ, STRING_OBJ); err != nil {
+					return err
+				}
+
+				replaced := strings.ReplaceAll(args[0].(*String).Value, args[1].(*String).Value, args[2].(*String).Value)
+
+				return &String{Value: replaced}
+			},
+		},
+	},
+	{
+		Name: "contains",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("contains", args, STRING_OBJ, STRING_OBJ); err != nil {
+					return err
+				}
+
+				return nativeBoolean(strings.Contains(args[0].(*String).Value, args[1].(*String).Value))
+			},
+		},
+	},
+	{
+		Name: "starts_with",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("starts_with", args, STRING_OBJ, STRING_OBJ); err != nil {
+					return err
+				}
+
+				return nativeBoolean(strings.HasPrefix(args[0].(*String).Value, args[1].(*String).Value))
+			},
+		},
+	},
+	{
+		Name: "ends_with",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("ends_with", args, STRING_OBJ, STRING_OBJ); err != nil {
+					return err
+				}
+
+				return nativeBoolean(strings.HasSuffix(args[0].(*String).Value, args[1].(*String).Value))
+			},
+		},
+	},
+	{
+		Name: "upper",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("upper", args, STRING_OBJ); err != nil {
+					return err
+				}
+
+				return &String{Value: strings.ToUpper(args[0].(*String).Value)}
+			},
+		},
+	},
+	{
+		Name: "lower",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("lower", args, STRING_OBJ); err != nil {
+					return err
+				}
+
+				return &String{Value: strings.ToLower(args[0].(*String).Value)}
+			},
+		},
+	},
+	{
+		Name: "index_of",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("index_of", args, STRING_OBJ, STRING_OBJ); err != nil {
+					return err
+				}
+
+				str := args[0].(*String).Value
+
+				index := strings.Index(str, args[1].(*String).Value)
+				if index < 0 {
+					return NewInteger(-1)
+				}
+
+				return NewInteger(int64(utf8.RuneCountInString(str[:index])))
+			},
+		},
+	},
+	{
+		Name: "substring",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				// The end is optional
+				if len(args) != 2 && len(args) != 3 {
+					return &Error{
+						fmt.Sprintf("wrong number of arguments. got=%d, want=2 or 3", len(args)),
+					}
+				}
+
+				types := []ObjectType{STRING_OBJ, INTEGER_OBJ, INTEGER_OBJ}
+				if err := checkArguments("substring", args, types[:len(args)]...); err != nil {
+					return err
+				}
+
+				runes := []rune(args[0].(*String).Value)
+
+				start, end := args[1].(*Integer).Value, int64(len(runes))
+
+				// Without an end there's only the start to report
+				if len(args) == 2 {
+					if start < 0 || start > end {
+						return &Error{
+							fmt.Sprintf("substring start %d out of bounds for length %d", start, len(runes)),
+						}
+					}
+				} else {
+					end = args[2].(*Integer).Value
+
+					if start < 0 || end < start || end > int64(len(runes)) {
+						return &Error{
+							fmt.Sprintf("substring range %d to %d out of bounds for length %d", start, end, len(runes)),
+						}
+					}
+				}
+
+				return &String{Value: string(runes[start:end])}
+			},
+		},
+	},
+	{
+		Name: "repeat",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("repeat", args, STRING_OBJ, INTEGER_OBJ); err != nil {
+					return err
+				}
+
+				str := args[0].(*String).Value
+				count := args[1].(*Integer).Value
+				if count < 0 {
+					return &Error{fmt.Sprintf("negative count for `repeat`: %d", count)}
+				}
+
+				// Divided rather than multiplied, which could overflow
+				if len(str) > 0 && count > MaxRepeatLength/int64(len(str)) {
+					return &Error{fmt.Sprintf("`repeat` result too long: %d times %d bytes, at most %d bytes", count, len(str), MaxRepeatLength)}
+				}
+
+				return &String{Value: strings.Repeat(str, int(count))}
+			},
+		},
+	},
+	{
+		Name: "parse_int",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if err := checkArguments("parse_int", args, STRING_OBJ); err != nil {
+					return err
+				}
+
+				str := args[0].(*String).Value
+
+				value, err := strconv.ParseInt(str, 10, 64)
+				if err != nil {
+					return &Error{fmt.Sprintf("can't parse %q as an integer", str)}
+				}
+
+				return NewInteger(value)
+			},
+		},
+	},
+	{
+		Name: "to_string",
+		Builtin: &Builtin{
+			Fn: func(ctx *Context, args ...Object) Object {
+				if len(args) != 1 {
+					return &Error{
+						fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args)),
+					}
+				}
+
+				if str, ok := args[0].(*String); ok {
+					return str
+				}
+
+				return &String{Value: args[0].Inspect()}
+			},
+		},
+	},
+}
+
+// Returns an error for the first argument that isn't of the expected type,
+// or if there are more or less arguments than types
+func checkArguments(name string, args []Object, types ...ObjectType) *Error {
+	if len(args) != len(types) {
+		return &Error{
+			fmt.Sprintf("wrong number of arguments. got=%d, want=%d", len(args), len(types)),
+		}
+	}
+
+	for i, arg := range args {
+		if arg.Type() == types[i] {
+			continue
+		}
+
+		if len(types) == 1 {
+			return &Error{
+				fmt.Sprintf("argument to `%s` must be %s, got %s", name, types[i], arg.Type()),
+			}
+		}
+
+		return &Error{
+			fmt.Sprintf("argument %d to `%s` must be %s, got %s", i+1, name, types[i], arg.Type()),
+		}
+	}
+
+	return nil
+}
+
+func nativeBoolean(value bool) *Boolean {
+	if value {
+		return True
+	}
+
+	return False
+}
@@ -0,0 +1,72 @@
+package object
+
+import "testing"
+
+func TestStringBuiltins(t *testing.T) {
+	str := func(value string) *String { return &String{Value: value} }
+	integer := func(value int64) *Integer { return &Integer{Value: value} }
+	array := func(elements ...Object) *Array { return &Array{Elements: elements} }
+
+	tests := []struct {
+		name     string
+		args     []Object
+		expected Object
+	}{
+		{"split", []Object{str("a,b,,c"), str(",")}, array(str("a"), str("b"), str(""), str("c"))},
+		{"split", []Object{str("héllo"), str("")}, array(str("h"), str("é"), str("l"), str("l"), str("o"))},
+		{"join", []Object{array(str("a"), str("b")), str(", ")}, str("a, b")},
+		{"join", []Object{array(), str(",")}, str("")},
+		{"trim", []Object{str(" \t hi \n")}, str("hi")},
+		{"replace", []Object{str("a-b-c"), str("-"), str("+")}, str("a+b+c")},
+		{"contains", []Object{str("monkey"), str("key")}, True},
+		{"contains", []Object{str("monkey"), str("ape")}, False},
+		{"starts_with", []Object{str("monkey"), str("mon")}, True},
+		{"ends_with", []Object{str("monkey"), str("mon")}, False},
+		{"upper", []Object{str("héllo")}, str("HÉLLO")},
+		{"lower", []Object{str("ÄB")}, str("äb")},
+		{"index_of", []Object{str("日本語"), str("語")}, integer(2)},
+		{"index_of", []Object{str("abc"), str("d")}, integer(-1)},
+		{"substring", []Object{str("日本語です"), integer(1), integer(3)}, str("本語")},
+		{"substring", []Object{str("日本語"), integer(1)}, str("本語")},
+		{"substring", []Object{str("abc"), integer(3)}, str("")},
+		{"repeat", []Object{str("ab"), integer(3)}, str("ababab")},
+		{"repeat", []Object{str("ab"), integer(0)}, str("")},
+		{"repeat", []Object{str(""), integer(9223372036854775807)}, str("")},
+		{"parse_int", []Object{str("-42")}, integer(-42)},
+		{"to_string", []Object{integer(42)}, str("42")},
+		{"to_string", []Object{str("hi")}, str("hi")},
+		{"to_string", []Object{array(integer(1), True)}, str("[1, true]")},
+
+		{"split", []Object{str("a")}, &Error{"wrong number of arguments. got=1, want=2"}},
+		{"split", []Object{integer(1), str(",")}, &Error{"argument 1 to `split` must be STRING, got INTEGER"}},
+		{"join", []Object{array(str("a"), integer(1)), str(",")}, &Error{"elements joined by `join` must be STRING, got INTEGER at 1"}},
+		{"trim", []Object{integer(1)}, &Error{"argument to `trim` must be STRING, got INTEGER"}},
+		{"substring", []Object{str("abc")}, &Error{"wrong number of arguments. got=1, want=2 or 3"}},
+		{"substring", []Object{str("abc"), str("1")}, &Error{"argument 2 to `substring` must be INTEGER, got STRING"}},
+		{"substring", []Object{str("日本語"), integer(2), integer(4)}, &Error{"substring range 2 to 4 out of bounds for length 3"}},
+		{"substring", []Object{str("abc"), integer(2), integer(1)}, &Error{"substring range 2 to 1 out of bounds for length 3"}},
+		{"substring", []Object{str("abc"), integer(5)}, &Error{"substring start 5 out of bounds for length 3"}},
+		{"repeat", []Object{str("a"), integer(-1)}, &Error{"negative count for `repeat`: -1"}},
+		{"repeat", []Object{str("ab"), integer(9223372036854775807)}, &Error{"`repeat` result too long: 9223372036854775807 times 2 bytes, at most 16777216 bytes"}},
+		{"parse_int", []Object{str("12a")}, &Error{`can't parse "12a" as an integer`}},
+		{"to_string", []Object{}, &Error{"wrong number of arguments. got=0, want=1"}},
+	}
+
+	for _, tt := range tests {
+		builtin := GetBuiltinByName(tt.name)
+		if builtin == nil {
+			t.Fatalf("builtin %s not found", tt.name)
+		}
+
+		result := builtin.Fn(NewContext(), tt.args...)
+		if result == nil {
+			t.Errorf("%s returned nil", tt.name)
+			continue
+		}
+
+		if result.Type() != tt.expected.Type() || result.Inspect() != tt.expected.Inspect() {
+			t.Errorf("%s returned %s %s, expected %s %s",
+				tt.name, result.Type(), result.Inspect(), tt.expected.Type(), tt.expected.Inspect())
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package opcode
+
+import (
+	"monkey/token"
+	"sort"
+)
+
+// Maps instruction offsets back to the source, sorted by offset.
+// An entry covers every instruction from its offset up to the next entry.
+type LineTable []LineEntry
+
+type LineEntry struct {
+	Offset   int
+	Position token.Position
+
+	// Whether a statement starts at this offset, as opposed to resuming a statement
+	// after a nested one finished. Breakpoints and line coverage only care about these.
+	IsStatement bool
+}
+
+// Position of the instruction at offset, zero if nothing is known about it
+func (lt LineTable) PositionFor(offset int) token.Position {
+	entry, _ := lt.EntryFor(offset)
+
+	return entry.Position
+}
+
+// The entry covering the instruction at offset, false if there's none
+func (lt LineTable) EntryFor(offset int) (LineEntry, bool) {
+	i := sort.Search(len(lt), func(i int) bool {
+		return lt[i].Offset > offset
+	})
+
+	if i == 0 {
+		return LineEntry{}, false
+	}
+
+	return lt[i-1], true
+}
+
+// Whether a statement starts at exactly this offset, and on which line
+func (lt LineTable) StatementAt(offset int) (int, bool) {
+	i := sort.Search(len(lt), func(i int) bool {
+		return lt[i].Offset >= offset
+	})
+
+	for ; i < len(lt) && lt[i].Offset == offset; i++ {
+		if lt[i].IsStatement {
+			return lt[i].Position.Line, true
+		}
+	}
+
+	return 0, false
+}
+
+// All lines on which a statement starts
+func (lt LineTable) StatementLines() []int {
+	result := []int{}
+	seen := map[int]bool{}
+
+	for _, entry := range lt {
+		if entry.IsStatement && !seen[entry.Position.Line] {
+			seen[entry.Position.Line] = true
+			result = append(result, entry.Position.Line)
+		}
+	}
+
+	sort.Ints(result)
+
+	return result
+}
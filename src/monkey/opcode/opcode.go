@@ -70,6 +70,7 @@ const (
 	OpEquals
 	OpNotEquals
 	OpGreaterThan
+	OpLessThan
 
 	OpPushTrue
 	OpPushFalse
@@ -96,6 +97,17 @@ const (
 	OpGetBuiltin
 	OpMakeClosure
 	OpRecurse
+
+	// Never compiled, the VM rewrites a binary operation that has only seen
+	// integers to one of these, and back when it sees something else
+	OpAddInt
+	OpSubtractInt
+	OpMultiplyInt
+	OpDivideInt
+	OpEqualsInt
+	OpNotEqualsInt
+	OpGreaterThanInt
+	OpLessThanInt
 )
 
 type OpDefinition struct {
@@ -117,6 +129,7 @@ var definitions = map[OpCode]*OpDefinition{
 	OpEquals:      {"OpEquals", []int{}},
 	OpNotEquals:   {"OpNotEquals", []int{}},
 	OpGreaterThan: {"OpGreaterThan", []int{}},
+	OpLessThan:    {"OpLessThan", []int{}},
 
 	OpPushTrue:  {"OpPushTrue", []int{}},
 	OpPushFalse: {"OpPushFalse", []int{}},
@@ -143,6 +156,30 @@ var definitions = map[OpCode]*OpDefinition{
 	OpGetBuiltin:  {"OpGetBuiltin", []int{1}},
 	OpMakeClosure: {"OpMakeClosure", []int{2, 1}},
 	OpRecurse:     {"OpRecurse", []int{}},
+
+	OpAddInt:         {"OpAddInt", []int{}},
+	OpSubtractInt:    {"OpSubtractInt", []int{}},
+	OpMultiplyInt:    {"OpMultiplyInt", []int{}},
+	OpDivideInt:      {"OpDivideInt", []int{}},
+	OpEqualsInt:      {"OpEqualsInt", []int{}},
+	OpNotEqualsInt:   {"OpNotEqualsInt", []int{}},
+	OpGreaterThanInt: {"OpGreaterThanInt", []int{}},
+	OpLessThanInt:    {"OpLessThanInt", []int{}},
+}
+
+// The integer version of a binary operation, OpAdd to OpAddInt and so on
+func Quickened(code OpCode) OpCode {
+	return OpAddInt + (code - OpAdd)
+}
+
+// Whether code is the integer version of a binary operation
+func IsQuickened(code OpCode) bool {
+	return code >= OpAddInt && code <= OpLessThanInt
+}
+
+// The binary operation an integer version stands in for, OpAddInt to OpAdd
+func Generic(code OpCode) OpCode {
+	return OpAdd + (code - OpAddInt)
 }
 
 // Book passes a byte as code, I pass the OpCode
@@ -100,3 +100,19 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestQuickened(t *testing.T) {
+	generic := []OpCode{OpAdd, OpSubtract, OpMultiply, OpDivide, OpEquals, OpNotEquals, OpGreaterThan, OpLessThan}
+	quickened := []OpCode{OpAddInt, OpSubtractInt, OpMultiplyInt, OpDivideInt, OpEqualsInt, OpNotEqualsInt, OpGreaterThanInt, OpLessThanInt}
+
+	for i, code := range generic {
+		if Quickened(code) != quickened[i] || Generic(quickened[i]) != code {
+			t.Errorf("%s and %s don't go together", Lookup(code).Name, Lookup(quickened[i]).Name)
+		}
+
+		// Rewritten in place, so they have to be as wide
+		if len(MakeInstruction(quickened[i])) != len(MakeInstruction(code)) {
+			t.Errorf("%s has a different width than %s", Lookup(quickened[i]).Name, Lookup(code).Name)
+		}
+	}
+}
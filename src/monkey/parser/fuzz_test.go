@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"encoding/json"
+	"monkey/ast"
+	"monkey/lexer"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Parsing never panics, and programs that parse print as source that parses
+// back to the same AST
+func FuzzParseProgram(f *testing.F) {
+	sources, err := filepath.Glob(filepath.Join("testdata", "*.mk"))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, source := range sources {
+		input, err := os.ReadFile(source)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(input))
+	}
+	f.Add(`if (a) { b } else { c }; (d)(e); -f[0]; {"g": [h]}`)
+	f.Add(`let m = macro(x) { quote(unquote(x) + 1) }; m(2)`)
+	f.Add("fn(\xfa){")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			return
+		}
+
+		printed := program.String()
+
+		p = New(lexer.New(printed))
+		reparsed := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%q printed as %q, which doesn't parse: %v", input, printed, p.Errors())
+		}
+
+		if reparsed.String() != printed {
+			t.Fatalf("%q printed as %q, then as %q", input, printed, reparsed.String())
+		}
+
+		if !reflect.DeepEqual(withoutSpans(t, program), withoutSpans(t, reparsed)) {
+			t.Fatalf("%q printed as %q, which parses to a different AST", input, printed)
+		}
+	})
+}
+
+// The AST as JSON values, without positions, which differ once printed
+func withoutSpans(t *testing.T, program *ast.Program) interface{} {
+	encoded, err := ast.Encode(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded interface{}
+	err = json.Unmarshal(encoded, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var strip func(value interface{})
+	strip = func(value interface{}) {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			delete(value, "span")
+			for _, child := range value {
+				strip(child)
+			}
+		case []interface{}:
+			for _, child := range value {
+				strip(child)
+			}
+		}
+	}
+	strip(decoded)
+
+	return decoded
+}
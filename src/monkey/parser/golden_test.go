@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"monkey/ast"
+	"monkey/lexer"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// Compares the JSON encoding of each testdata/*.mk's AST to the .json next to it
+func TestGoldenFiles(t *testing.T) {
+	sources, err := filepath.Glob(filepath.Join("testdata", "*.mk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, source := range sources {
+		input, err := os.ReadFile(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := New(lexer.New(string(input)))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		encoded, err := ast.Encode(program)
+		if err != nil {
+			t.Fatalf("%s: could not encode: %s", source, err)
+		}
+
+		var actual bytes.Buffer
+		json.Indent(&actual, encoded, "", "  ")
+		actual.WriteString("\n")
+
+		golden := strings.TrimSuffix(source, ".mk") + ".json"
+		if *update {
+			err := os.WriteFile(golden, actual.Bytes(), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expected, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("%s: %s, run the tests with -update to create it", source, err)
+		}
+
+		if actual.String() != string(expected) {
+			t.Errorf("%s: AST differs from %s, run the tests with -update if that's expected.\ngot:\n%s",
+				source, golden, actual.String())
+		}
+
+		// Whatever is decoded encodes the same again
+		decoded, err := ast.Decode(encoded)
+		if err != nil {
+			t.Fatalf("%s: could not decode: %s", source, err)
+		}
+
+		again, _ := ast.Encode(decoded)
+		if !bytes.Equal(again, encoded) {
+			t.Errorf("%s: decoding changed the AST.\nbefore: %s\nafter: %s", source, encoded, again)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"monkey/ast"
 	"monkey/lexer"
+	"monkey/token"
+	"reflect"
 	"testing"
 )
 
@@ -275,7 +277,7 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		},
 		{
 			"3 + 4; -5 * 5",
-			"(3 + 4)((-5) * 5)",
+			"(3 + 4);((-5) * 5)",
 		},
 		{
 			"5 > 4 == 3 < 4",
@@ -613,6 +615,36 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestInvalidFunctionLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(1) { 2 }", "expected next token to be IDENT, got INT instead"},
+		{"fn(@) { 1 }", "expected next token to be IDENT, got ILLEGAL instead"},
+		{"fn(a, 1) { a }", "expected next token to be IDENT, got INT instead"},
+		{"macro(a, b c) { a }", "expected next token to be ), got IDENT instead"},
+		{"fn(a) {", "expected next token to be }, got EOF instead"},
+		{"if (a) { b } else { c", "expected next token to be }, got EOF instead"},
+		{"fn(\xfa){", "expected next token to be IDENT, got ILLEGAL instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("%q parsed, expected %q", tt.input, tt.expected)
+			continue
+		}
+
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -832,14 +864,15 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
 			continue
 		}
 
-		expectedValue := expected[literal.String()]
+		expectedValue := expected[literal.Value]
 		testIntegerLiteral(t, value, expectedValue)
 	}
 }
@@ -867,7 +900,8 @@ func TestParsingHashLiteralsBooleanKeys(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		boolean, ok := key.(*ast.Boolean)
 		if !ok {
 			t.Errorf("key is not ast.BooleanLiteral. got=%T", key)
@@ -903,7 +937,8 @@ func TestParsingHashLiteralsIntegerKeys(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		integer, ok := key.(*ast.IntegerLiteral)
 		if !ok {
 			t.Errorf("key is not ast.IntegerLiteral. got=%T", key)
@@ -946,16 +981,17 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		},
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
 			continue
 		}
 
-		testFunc, ok := tests[literal.String()]
+		testFunc, ok := tests[literal.Value]
 		if !ok {
-			t.Errorf("No test function for key %q found", literal.String())
+			t.Errorf("No test function for key %q found", literal.Value)
 			continue
 		}
 
@@ -963,6 +999,212 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 	}
 }
 
+func TestMacroLiteralParsing(t *testing.T) {
+	input := `macro(x, y) { x + y; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MacroLiteral. got=%T",
+			stmt.Expression)
+	}
+
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("macro literal parameters wrong. want 2, got=%d\n",
+			len(macro.Parameters))
+	}
+
+	testLiteralExpression(t, macro.Parameters[0], "x")
+	testLiteralExpression(t, macro.Parameters[1], "y")
+
+	if len(macro.Body.Statements) != 1 {
+		t.Fatalf("macro.Body.Statements has not 1 statements. got=%d\n",
+			len(macro.Body.Statements))
+	}
+
+	bodyStmt, ok := macro.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("macro body stmt is not ast.ExpressionStatement. got=%T",
+			macro.Body.Statements[0])
+	}
+
+	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
+}
+
+func TestImportStatements(t *testing.T) {
+	input := `import "lib/math.mk" as math;
+import "strings.mk" as s`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	tests := []struct {
+		path string
+		name string
+	}{
+		{"lib/math.mk", "math"},
+		{"strings.mk", "s"},
+	}
+
+	if len(program.Statements) != len(tests) {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d",
+			len(tests), len(program.Statements))
+	}
+
+	for i, tt := range tests {
+		stmt, ok := program.Statements[i].(*ast.ImportStatement)
+		if !ok {
+			t.Fatalf("program.Statements[%d] is not *ast.ImportStatement. got=%T", i, program.Statements[i])
+		}
+
+		if stmt.Path.Value != tt.path {
+			t.Errorf("stmt.Path.Value not %q. got=%q", tt.path, stmt.Path.Value)
+		}
+
+		if !testIdentifier(t, stmt.Name, tt.name) {
+			return
+		}
+	}
+
+	if program.String() != `import "lib/math.mk" as math;import "strings.mk" as s;` {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+}
+
+func TestExportStatements(t *testing.T) {
+	input := `export let square = fn(x) { x * x };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExportStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.ExportStatement. got=%T", program.Statements[0])
+	}
+
+	if !testLetStatement(t, stmt.Statement, "square") {
+		return
+	}
+
+	function, ok := stmt.Statement.Value.(*ast.FunctionLiteral)
+	if !ok || function.Name == nil || *function.Name != "square" {
+		t.Errorf("exported function not named after its let: %s", stmt.Statement.Value)
+	}
+}
+
+func TestMemberExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"m.x", "(m.x)"},
+		{"m.f(1, 2)", "(m.f)(1, 2)"},
+		{"m.list[0]", "((m.list)[0])"},
+		{"-m.x * 2", "((-(m.x)) * 2)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	p := New(lexer.New("m.1"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("no errors for a member that isn't an identifier")
+	}
+}
+
+func TestNodeExtents(t *testing.T) {
+	input := `let f = fn(x) {
+	[x, {"a": x}][0]
+};
+f(1)`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	let := program.Statements[0].(*ast.LetStatement)
+	index := let.Value.(*ast.FunctionLiteral).Body.Statements[0].(*ast.ExpressionStatement).Expression
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression
+
+	tests := []struct {
+		node  ast.Node
+		start token.Position
+		end   token.Position
+	}{
+		{let, token.Position{Line: 1, Column: 1}, token.Position{Line: 3, Column: 2}},
+		{index, token.Position{Line: 2, Column: 2}, token.Position{Line: 2, Column: 18}},
+		{index.(*ast.IndexExpression).Left, token.Position{Line: 2, Column: 2}, token.Position{Line: 2, Column: 15}},
+		{call, token.Position{Line: 4, Column: 1}, token.Position{Line: 4, Column: 5}},
+		{program, token.Position{Line: 1, Column: 1}, token.Position{Line: 4, Column: 5}},
+	}
+
+	for _, tt := range tests {
+		if tt.node.Pos() != tt.start || tt.node.End() != tt.end {
+			t.Errorf("%q spans %s-%s, expected %s-%s",
+				tt.node.String(), tt.node.Pos(), tt.node.End(), tt.start, tt.end)
+		}
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	l := lexer.New("let x = 1;\nlet = 2;")
+	p := New(l)
+	program := p.ParseProgram()
+
+	errors := p.DetailedErrors()
+	if len(errors) == 0 {
+		t.Fatalf("no errors")
+	}
+
+	expected := token.Position{Line: 2, Column: 5}
+	if errors[0].Position != expected {
+		t.Errorf("error at %s, expected %s", errors[0].Position, expected)
+	}
+
+	if errors[0].Message != p.Errors()[0] {
+		t.Errorf("detailed message %q differs from %q", errors[0].Message, p.Errors()[0])
+	}
+
+	for _, statement := range program.Statements {
+		if statement == nil || reflect.ValueOf(statement).IsNil() {
+			t.Errorf("nil statement in %v", program.Statements)
+		}
+	}
+}
+
 func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 	if s.TokenLiteral() != "let" {
 		t.Errorf("s.TokenLiteral not 'let'. got=%q", s.TokenLiteral())
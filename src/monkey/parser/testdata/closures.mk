@@ -0,0 +1,7 @@
+let newAdder = fn(x) {
+	fn(y) {
+		x + y
+	}
+};
+let addTwo = newAdder(2);
+addTwo(3);
@@ -0,0 +1,6 @@
+-a * (b + c) == !true != false;
+if (x < y) { x } else { y };
+add(1, 2 * 3)[0];
+"a string";
+[1, "two", fn() {}];
+{"one": 1, true: 2, 3: "three"}[true];
@@ -0,0 +1,5 @@
+import "lib/math.mk" as math;
+export let cube = fn(x) {
+	x * math.square(x)
+};
+math.pi
@@ -0,0 +1,4 @@
+let x = 5;
+return x;
+x;
+let add = fn(a, b) { a + b };
@@ -0,0 +1,199 @@
+package profiler
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"monkey/object"
+	"monkey/opcode"
+	"sort"
+)
+
+// Writes the stack samples as a gzipped profile.proto, for go tool pprof.
+// Samples have the instructions and nanoseconds since the previous one as
+// values, and the opcode executing when they were taken as an "opcode" label.
+//
+// Only the fields pprof needs are written, encoded by hand to keep the module
+// free of dependencies.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	table := newStringTable()
+
+	var profile protoBuffer
+
+	// Sample types
+	profile.message(1, valueType(table, "instructions", "count"))
+	profile.message(1, valueType(table, "time", "nanoseconds"))
+
+	// Functions and locations are numbered from 1 in the order they're met. A
+	// location is a line, the instructions sampled on it share it.
+	type line struct {
+		function *object.CompiledFunction
+		number   int
+	}
+
+	functionIDs := map[*object.CompiledFunction]uint64{}
+	locationIDs := map[line]uint64{}
+	var functions, locations protoBuffer
+
+	for _, s := range p.sortedSamples() {
+		ids := []uint64{}
+
+		for _, frame := range s.frames {
+			functionID, ok := functionIDs[frame.function]
+			if !ok {
+				functionID = uint64(len(functionIDs) + 1)
+				functionIDs[frame.function] = functionID
+
+				stats := p.function(frame.function)
+				var function protoBuffer
+				function.uint64(1, functionID)
+				function.uint64(2, table.index(stats.Name))
+				function.uint64(3, table.index(stats.Name))
+				function.uint64(4, table.index(stats.File))
+				function.uint64(5, uint64(startLine(stats)))
+				functions.message(5, function)
+			}
+
+			// A function's lines are all in its own file, where pprof looks for them
+			_, position := frame.function.SourceFor(frame.offset)
+			key := line{frame.function, position.Line}
+
+			locationID, ok := locationIDs[key]
+			if !ok {
+				locationID = uint64(len(locationIDs) + 1)
+				locationIDs[key] = locationID
+
+				var line protoBuffer
+				line.uint64(1, functionID)
+				line.uint64(2, uint64(position.Line))
+
+				var location protoBuffer
+				location.uint64(1, locationID)
+				location.message(4, line)
+				locations.message(4, location)
+			}
+
+			ids = append(ids, locationID)
+		}
+
+		var label protoBuffer
+		label.uint64(1, table.index("opcode"))
+		label.uint64(2, table.index(opcode.Lookup(s.opcode).Name))
+
+		var sample protoBuffer
+		sample.packed(1, ids)
+		sample.packed(2, []uint64{uint64(s.instructions), uint64(s.time.Nanoseconds())})
+		sample.message(3, label)
+		profile.message(2, sample)
+	}
+
+	profile.bytes = append(profile.bytes, locations.bytes...)
+	profile.bytes = append(profile.bytes, functions.bytes...)
+
+	for _, s := range table.strings {
+		profile.string(6, s)
+	}
+
+	profile.uint64(9, uint64(p.start.UnixNano()))
+	profile.uint64(10, uint64(p.duration.Nanoseconds()))
+	profile.message(11, valueType(table, "instructions", "count"))
+	profile.uint64(12, uint64(p.SampleEvery))
+
+	compressed := gzip.NewWriter(w)
+	_, err := compressed.Write(profile.bytes)
+	if err != nil {
+		return err
+	}
+
+	return compressed.Close()
+}
+
+// So the same run gives the same file
+func (p *Profiler) sortedSamples() []*sample {
+	keys := []string{}
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := []*sample{}
+	for _, key := range keys {
+		result = append(result, p.samples[key])
+	}
+
+	return result
+}
+
+func valueType(table *stringTable, kind, unit string) protoBuffer {
+	var result protoBuffer
+	result.uint64(1, table.index(kind))
+	result.uint64(2, table.index(unit))
+
+	return result
+}
+
+// The first string is always the empty one
+type stringTable struct {
+	strings []string
+	indexes map[string]uint64
+}
+
+func newStringTable() *stringTable {
+	return &stringTable{strings: []string{""}, indexes: map[string]uint64{"": 0}}
+}
+
+func (t *stringTable) index(s string) uint64 {
+	index, ok := t.indexes[s]
+	if !ok {
+		index = uint64(len(t.strings))
+		t.strings = append(t.strings, s)
+		t.indexes[s] = index
+	}
+
+	return index
+}
+
+// Protocol buffer encoding of the few field types profile.proto uses
+type protoBuffer struct {
+	bytes []byte
+}
+
+const (
+	varintType = 0
+	lengthType = 2
+)
+
+func (b *protoBuffer) key(field int, wireType int) {
+	b.bytes = binary.AppendUvarint(b.bytes, uint64(field<<3|wireType))
+}
+
+// Zero is the default, so it's left out
+func (b *protoBuffer) uint64(field int, value uint64) {
+	if value == 0 {
+		return
+	}
+
+	b.key(field, varintType)
+	b.bytes = binary.AppendUvarint(b.bytes, value)
+}
+
+func (b *protoBuffer) string(field int, value string) {
+	b.key(field, lengthType)
+	b.bytes = binary.AppendUvarint(b.bytes, uint64(len(value)))
+	b.bytes = append(b.bytes, value...)
+}
+
+func (b *protoBuffer) message(field int, message protoBuffer) {
+	b.key(field, lengthType)
+	b.bytes = binary.AppendUvarint(b.bytes, uint64(len(message.bytes)))
+	b.bytes = append(b.bytes, message.bytes...)
+}
+
+func (b *protoBuffer) packed(field int, values []uint64) {
+	var packed protoBuffer
+	for _, value := range values {
+		packed.bytes = binary.AppendUvarint(packed.bytes, value)
+	}
+
+	b.message(field, packed)
+}
@@ -0,0 +1,358 @@
+// Counts and times what a VM executes, per opcode and per function, and
+// samples its call stack
+package profiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"monkey/object"
+	"monkey/opcode"
+	"monkey/vm"
+	"path/filepath"
+	"time"
+)
+
+// How many instructions run between stack samples, unless changed
+const DefaultSampleEvery = 100
+
+type OpcodeStats struct {
+	Opcode opcode.OpCode
+	Count  int64
+	Time   time.Duration
+}
+
+type FunctionStats struct {
+	Function *object.CompiledFunction
+	Name     string // "main" for the main program, anonymous@line for anonymous functions
+	File     string // Where the function is defined
+
+	Calls        int64
+	Instructions int64
+	Self         time.Duration // Executing the function's own instructions
+	Total        time.Duration // From being called to returning, including what it called
+
+	id      int           // In the order functions are met, for sample keys
+	active  int           // Activations on the call stack, recursion only counts once towards Total
+	entered time.Duration // The profiler's clock at the outermost activation
+}
+
+// Drives a VM one instruction at a time, like the debugger, timing every one.
+// Only the instructions are timed, the profiler's clock is the time spent in
+// them, so the bookkeeping around them doesn't count.
+type Profiler struct {
+	machine  *vm.VM
+	filename string // Of the main file, imported ones are named by their paths
+
+	SampleEvery int
+
+	// Indexed by opcode. The integer versions the VM quickens binary operations
+	// to are counted as the operation they stand in for, which is what was written.
+	opcodes   [256]OpcodeStats
+	functions map[*object.CompiledFunction]*FunctionStats
+	main      *object.CompiledFunction
+	stack     []*FunctionStats // Entered and not left yet, like the VM's frames as of the last step
+	offsets   []int            // Of the instruction each of those frames last executed, a call for outer ones
+
+	clock     time.Duration
+	overhead  time.Duration // Of timing an instruction, taken off each one
+	operation opcode.OpCode // Executed by the last step
+
+	stepping bool          // In a step, so a step now is of a function a builtin called
+	nested   time.Duration // Spent in such steps, which count for themselves
+
+	samples       map[string]*sample
+	key           []byte // Reused to look samples up, a key is only made for a new one
+	sinceSample   int64
+	timeSinceLast time.Duration
+
+	start    time.Time
+	duration time.Duration
+}
+
+// The call stack at a sample, innermost frame first, and what ran since the
+// previous sample
+type sample struct {
+	frames       []sampleFrame
+	opcode       opcode.OpCode // Executing when the sample was taken
+	instructions int64
+	time         time.Duration
+}
+
+// Positions are only looked up when the profile is written
+type sampleFrame struct {
+	function *object.CompiledFunction
+	offset   int
+}
+
+// Positions in the main program are in filename, imported files keep their paths
+func New(machine *vm.VM, filename string) *Profiler {
+	p := &Profiler{
+		machine:  machine,
+		filename: filename,
+
+		SampleEvery: DefaultSampleEvery,
+
+		functions: map[*object.CompiledFunction]*FunctionStats{},
+		samples:   map[string]*sample{},
+	}
+
+	for i := range p.opcodes {
+		p.opcodes[i].Opcode = opcode.OpCode(i)
+	}
+
+	return p
+}
+
+// Executes the program to the end, or to the runtime error stopping it,
+// which is returned
+func (p *Profiler) Run() error {
+	p.start = time.Now()
+	p.overhead = timingOverhead()
+
+	p.main = p.currentFrame().Function()
+	p.stack = []*FunctionStats{p.enter(p.main)}
+	p.offsets = []int{0}
+
+	// Including the functions builtins call
+	p.machine.SetStepper(p.step)
+	defer p.machine.SetStepper(nil)
+
+	for !p.machine.Finished() {
+		err := p.step()
+		if err != nil {
+			p.finish()
+			return err
+		}
+	}
+
+	p.finish()
+	return nil
+}
+
+// An error is for whoever called the function running into it, a builtin
+// gets those of the functions it calls
+func (p *Profiler) step() error {
+	var called time.Time
+	if p.stepping {
+		called = time.Now()
+	}
+
+	// Builtins push the frames of the functions they call between steps
+	p.sync()
+
+	frame := p.currentFrame()
+	offset := frame.InstructionPointer()
+	operation := opcode.OpCode((*frame.Instructions())[offset])
+	if opcode.IsQuickened(operation) {
+		operation = opcode.Generic(operation)
+	}
+
+	current := p.stack[len(p.stack)-1]
+	p.offsets[len(p.offsets)-1] = offset
+	p.operation = operation
+
+	if p.sinceSample >= int64(p.SampleEvery) {
+		p.sample(operation)
+	}
+
+	stepping, nested := p.stepping, p.nested
+	p.stepping, p.nested = true, 0
+
+	start := time.Now()
+	err := p.machine.Step()
+	elapsed := max(time.Since(start)-p.nested-p.overhead, 0)
+
+	p.count(operation, current, elapsed)
+
+	p.stepping, p.nested = stepping, nested
+	if stepping {
+		p.nested += time.Since(called)
+	}
+
+	return err
+}
+
+// Enters the functions of frames pushed since the last step and leaves those
+// of frames popped, by returning or by a runtime error in a builtin's call
+func (p *Profiler) sync() {
+	frames := p.machine.Frames()
+
+	for len(p.stack) > len(frames) {
+		p.leave(p.stack[len(p.stack)-1])
+		p.stack = p.stack[:len(p.stack)-1]
+		p.offsets = p.offsets[:len(p.offsets)-1]
+	}
+
+	for len(p.stack) < len(frames) {
+		p.stack = append(p.stack, p.enter(frames[len(p.stack)].Function()))
+		p.offsets = append(p.offsets, 0)
+	}
+}
+
+// The least time measured around nothing, reading the clock takes longer
+// than most instructions
+func timingOverhead() time.Duration {
+	least := time.Duration(math.MaxInt64)
+	for i := 0; i < 1000; i++ {
+		start := time.Now()
+		least = min(least, time.Since(start))
+	}
+
+	return least
+}
+
+func (p *Profiler) currentFrame() *vm.Frame {
+	frames := p.machine.Frames()
+	return frames[len(frames)-1]
+}
+
+func (p *Profiler) count(operation opcode.OpCode, function *FunctionStats, elapsed time.Duration) {
+	p.opcodes[operation].Count++
+	p.opcodes[operation].Time += elapsed
+
+	function.Instructions++
+	function.Self += elapsed
+
+	p.clock += elapsed
+	p.sinceSample++
+	p.timeSinceLast += elapsed
+}
+
+func (p *Profiler) function(function *object.CompiledFunction) *FunctionStats {
+	stats, ok := p.functions[function]
+	if !ok {
+		file, _ := function.SourceFor(0)
+		stats = &FunctionStats{
+			Function: function,
+			Name:     p.functionName(function),
+			File:     p.fileName(file),
+			id:       len(p.functions),
+		}
+		p.functions[function] = stats
+	}
+
+	return stats
+}
+
+func (p *Profiler) functionName(function *object.CompiledFunction) string {
+	switch {
+	case function == p.main:
+		return "main"
+	case function.Name != "":
+		return function.Name
+	}
+
+	// Not <anonymous>, pprof drops anything in angle brackets
+	file, position := function.SourceFor(0)
+	if file != "" {
+		return fmt.Sprintf("anonymous@%s:%d", filepath.Base(file), position.Line)
+	}
+
+	return fmt.Sprintf("anonymous@%d", position.Line)
+}
+
+// The path of a file keyed like in the line tables
+func (p *Profiler) fileName(file string) string {
+	if file == "" {
+		return p.filename
+	}
+
+	return file
+}
+
+func (p *Profiler) enter(function *object.CompiledFunction) *FunctionStats {
+	stats := p.function(function)
+	stats.Calls++
+
+	if stats.active == 0 {
+		stats.entered = p.clock
+	}
+	stats.active++
+
+	return stats
+}
+
+func (p *Profiler) leave(stats *FunctionStats) {
+	stats.active--
+	if stats.active == 0 {
+		stats.Total += p.clock - stats.entered
+	}
+}
+
+// Frames still on the stack, after a runtime error or for the main program,
+// end when the program does
+func (p *Profiler) finish() {
+	if p.sinceSample > 0 {
+		p.sample(p.operation)
+	}
+
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		p.leave(p.stack[i])
+	}
+	p.stack = nil
+	p.offsets = nil
+
+	p.duration = p.clock
+}
+
+// Of the stack as the profiler last saw it. What ran since the last sample
+// is put on it, and samples with the same stack and opcode are merged.
+func (p *Profiler) sample(operation opcode.OpCode) {
+	p.key = append(p.key[:0], byte(operation))
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		p.key = binary.AppendUvarint(p.key, uint64(p.stack[i].id))
+		p.key = binary.AppendUvarint(p.key, uint64(p.offsets[i]))
+	}
+
+	s, ok := p.samples[string(p.key)]
+	if !ok {
+		s = &sample{opcode: operation}
+
+		for i := len(p.stack) - 1; i >= 0; i-- {
+			s.frames = append(s.frames, sampleFrame{function: p.stack[i].Function, offset: p.offsets[i]})
+		}
+
+		p.samples[string(p.key)] = s
+	}
+
+	s.instructions += p.sinceSample
+	s.time += p.timeSinceLast
+	p.sinceSample = 0
+	p.timeSinceLast = 0
+}
+
+// By time spent, most first. Only those executed.
+func (p *Profiler) Opcodes() []*OpcodeStats {
+	result := []*OpcodeStats{}
+	for i := range p.opcodes {
+		if p.opcodes[i].Count > 0 {
+			result = append(result, &p.opcodes[i])
+		}
+	}
+
+	sortByTime(result, func(stats *OpcodeStats) (time.Duration, string) {
+		return stats.Time, opcode.Lookup(stats.Opcode).Name
+	})
+
+	return result
+}
+
+// By time spent in their own instructions, most first
+func (p *Profiler) Functions() []*FunctionStats {
+	result := []*FunctionStats{}
+	for _, stats := range p.functions {
+		result = append(result, stats)
+	}
+
+	sortByTime(result, func(stats *FunctionStats) (time.Duration, string) {
+		return stats.Self, stats.Name
+	})
+
+	return result
+}
+
+// How long the profiled program ran for
+func (p *Profiler) Duration() time.Duration {
+	return p.duration
+}
@@ -0,0 +1,299 @@
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/opcode"
+	"monkey/parser"
+	"monkey/vm"
+	"strings"
+	"testing"
+	"time"
+)
+
+const fibonacci = `
+let fibonacci = fn(x) {
+  if (x < 2) { x } else { fibonacci(x - 1) + fibonacci(x - 2) }
+};
+let twice = fn(f) { fn(x) { f(f(x)) } };
+twice(fn(x) { x + 1 })(fibonacci(10));
+`
+
+func profile(t *testing.T, input string) (*Profiler, error) {
+	t.Helper()
+
+	return profileWith(t, compiler.New(), input)
+}
+
+func profileWith(t *testing.T, c *compiler.Compiler, input string) (*Profiler, error) {
+	t.Helper()
+
+	err := c.Compile(parser.New(lexer.New(input)).ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	machine := vm.New(c.Bytecode())
+	p := New(&machine, "fibonacci.mk")
+	p.SampleEvery = 10
+
+	return p, p.Run()
+}
+
+func TestCounts(t *testing.T) {
+	p, err := profile(t, fibonacci)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+
+	calls := map[string]int64{}
+	var instructions int64
+	for _, stats := range p.Functions() {
+		calls[stats.Name] = stats.Calls
+		instructions += stats.Instructions
+
+		if stats.Total < stats.Self {
+			t.Errorf("%s has less total time, %s, than self time, %s", stats.Name, stats.Total, stats.Self)
+		}
+	}
+
+	expected := map[string]int64{"main": 1, "fibonacci": 177, "twice": 1, "anonymous@5": 1, "anonymous@6": 2}
+	for name, count := range expected {
+		if calls[name] != count {
+			t.Errorf("wrong number of calls to %s. expected=%d, got=%d", name, count, calls[name])
+		}
+	}
+
+	var executed int64
+	opcodes := map[opcode.OpCode]int64{}
+	for _, stats := range p.Opcodes() {
+		executed += stats.Count
+		opcodes[stats.Opcode] = stats.Count
+	}
+
+	if executed != instructions {
+		t.Errorf("opcodes add up to %d instructions, functions to %d", executed, instructions)
+	}
+
+	// One call per function call, apart from the main program's
+	if opcodes[opcode.OpCall] != 177+1+1+2 {
+		t.Errorf("wrong number of OpCalls, got %d", opcodes[opcode.OpCall])
+	}
+
+	var sampled int64
+	for _, s := range p.samples {
+		sampled += s.instructions
+	}
+
+	if sampled != instructions {
+		t.Errorf("samples add up to %d instructions, expected %d", sampled, instructions)
+	}
+
+	for _, stats := range p.Functions() {
+		if stats.Name == "main" && stats.Total != p.Duration() {
+			t.Errorf("main ran for %s, but the program for %s", stats.Total, p.Duration())
+		}
+	}
+
+	// Every tick's time goes to the opcode that noticed it
+	var elapsed time.Duration
+	for _, stats := range p.Opcodes() {
+		elapsed += stats.Time
+	}
+
+	if elapsed != p.Duration() {
+		t.Errorf("opcodes ran for %s, but the program for %s", elapsed, p.Duration())
+	}
+}
+
+func TestQuickenedOpcodes(t *testing.T) {
+	p, err := profile(t, `let sum = fn(n) { if (n < 1) { 0 } else { n + sum(n - 1) } }; sum(50);`)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+
+	opcodes := map[opcode.OpCode]int64{}
+	for _, stats := range p.Opcodes() {
+		opcodes[stats.Opcode] = stats.Count
+
+		if opcode.IsQuickened(stats.Opcode) {
+			t.Errorf("%s counted apart from %s", opcode.Lookup(stats.Opcode).Name,
+				opcode.Lookup(opcode.Generic(stats.Opcode)).Name)
+		}
+	}
+
+	// Each comparison and addition, whichever form the VM ran it in
+	if opcodes[opcode.OpAdd] != 50 || opcodes[opcode.OpLessThan] != 51 {
+		t.Errorf("wrong counts, %d OpAdd and %d OpLessThan", opcodes[opcode.OpAdd], opcodes[opcode.OpLessThan])
+	}
+}
+
+func TestImportedFunctions(t *testing.T) {
+	c := compiler.New()
+	c.Loader.ReadFile = func(name string) ([]byte, error) {
+		if name != "lib.mk" {
+			return nil, fs.ErrNotExist
+		}
+
+		return []byte("\nexport let double = fn(x) {\n  x * 2\n};\nexport let apply = fn(x) { fn() { x } };"), nil
+	}
+
+	p, err := profileWith(t, c, "import \"lib.mk\" as lib;\nlib.double(2);\nlib.apply(1)();")
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+
+	files := map[string]string{}
+	for _, stats := range p.Functions() {
+		files[stats.Name] = stats.File
+	}
+
+	expected := map[string]string{"main": "fibonacci.mk", "double": "lib.mk", "apply": "lib.mk", "anonymous@lib.mk:5": "lib.mk"}
+	for name, file := range expected {
+		if files[name] != file {
+			t.Errorf("wrong file for %s. expected=%q, got=%q (all: %v)", name, file, files[name], files)
+		}
+	}
+
+	var out bytes.Buffer
+	err = p.WriteReport(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "double (lib.mk:3)") {
+		t.Errorf("report doesn't put double in lib.mk:\n%s", out.String())
+	}
+
+	out.Reset()
+	err = p.WritePprof(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(encoded, []byte("lib.mk")) {
+		t.Errorf("profile doesn't mention lib.mk")
+	}
+}
+
+func TestRuntimeError(t *testing.T) {
+	p, err := profile(t, `let f = fn(x) { x + true }; f(1)`)
+	if err == nil || err.Error() != "type mismatch: INTEGER + BOOLEAN" {
+		t.Fatalf("wrong error, got %v", err)
+	}
+
+	// Frames still on the stack are ended with the program
+	for _, stats := range p.Functions() {
+		if stats.active != 0 || stats.Total == 0 {
+			t.Errorf("%s wasn't ended, %d activations and %s total", stats.Name, stats.active, stats.Total)
+		}
+	}
+}
+
+func TestBuiltinCallbacks(t *testing.T) {
+	p, err := profile(t, `let boom = fn(x) { let y = x * 2; y + true };
+assert_error(fn() { boom(1) });
+assert_error(fn() { boom(2) });
+puts("after");`)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+
+	// The functions assert_error calls run their instructions too, and end
+	// with the runtime error it expects
+	found := false
+	for _, stats := range p.Functions() {
+		if stats.Name == "boom" {
+			found = true
+			if stats.Calls != 2 || stats.Instructions == 0 {
+				t.Errorf("boom called %d times, with %d instructions", stats.Calls, stats.Instructions)
+			}
+		}
+
+		if stats.active != 0 {
+			t.Errorf("%s wasn't ended, %d activations", stats.Name, stats.active)
+		}
+	}
+
+	if !found {
+		t.Fatalf("boom not profiled")
+	}
+
+	sampled := false
+	for _, s := range p.samples {
+		for _, frame := range s.frames {
+			sampled = sampled || frame.function.Name == "boom"
+		}
+	}
+	if !sampled {
+		t.Errorf("no samples in boom")
+	}
+}
+
+func TestReport(t *testing.T) {
+	p, err := profile(t, fibonacci)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+
+	var out bytes.Buffer
+	err = p.WriteReport(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"OpCall", "fibonacci (line 3)", "anonymous@6", "main"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("report doesn't mention %s:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestPprof(t *testing.T) {
+	p, err := profile(t, fibonacci)
+	if err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+
+	var out bytes.Buffer
+	err = p.WritePprof(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("profile isn't gzipped: %s", err)
+	}
+
+	encoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The string table, the rest is checked by go tool pprof reading it
+	for _, expected := range []string{"fibonacci", "fibonacci.mk", "instructions", "nanoseconds", "opcode", "OpCall"} {
+		if !bytes.Contains(encoded, []byte(expected)) {
+			t.Errorf("profile doesn't mention %s", expected)
+		}
+	}
+
+	// Starts with the first sample type, a message in field 1
+	if encoded[0] != 1<<3|lengthType {
+		t.Errorf("unexpected first byte %#x", encoded[0])
+	}
+}
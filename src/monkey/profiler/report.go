@@ -0,0 +1,81 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"monkey/opcode"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Ties are broken by name, so reports are stable
+func sortByTime[T any](stats []T, key func(T) (time.Duration, string)) {
+	sort.Slice(stats, func(i, j int) bool {
+		a, aName := key(stats[i])
+		b, bName := key(stats[j])
+
+		if a != b {
+			return a > b
+		}
+		return aName < bName
+	})
+}
+
+// A flat report of the opcodes and functions, most time spent first
+func (p *Profiler) WriteReport(w io.Writer) error {
+	var instructions int64
+	for _, stats := range p.opcodes {
+		instructions += stats.Count
+	}
+
+	fmt.Fprintf(w, "Total: %s, %d instructions\n\n", p.duration, instructions)
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+
+	// Names come after the last tab, so they aren't right aligned
+	fmt.Fprintln(table, "count\ttime\ttime%\t  opcode")
+	for _, stats := range p.Opcodes() {
+		fmt.Fprintf(table, "%d\t%s\t%s\t  %s\n",
+			stats.Count, stats.Time, p.percent(stats.Time), opcode.Lookup(stats.Opcode).Name)
+	}
+	table.Flush()
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(table, "calls\tinstructions\tself\tself%\ttotal\ttotal%\t  function")
+	for _, stats := range p.Functions() {
+		fmt.Fprintf(table, "%d\t%d\t%s\t%s\t%s\t%s\t  %s\n",
+			stats.Calls, stats.Instructions,
+			stats.Self, p.percent(stats.Self), stats.Total, p.percent(stats.Total),
+			p.describe(stats))
+	}
+
+	return table.Flush()
+}
+
+func (p *Profiler) percent(part time.Duration) string {
+	if p.duration == 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%.1f%%", float64(part)/float64(p.duration)*100)
+}
+
+// With where it starts, which anonymous functions' names already have
+func (p *Profiler) describe(stats *FunctionStats) string {
+	if stats.Function == p.main || stats.Function.Name == "" {
+		return stats.Name
+	}
+
+	if stats.File != p.filename {
+		return fmt.Sprintf("%s (%s:%d)", stats.Name, stats.File, startLine(stats))
+	}
+
+	return fmt.Sprintf("%s (line %d)", stats.Name, startLine(stats))
+}
+
+// In the function's own file
+func startLine(stats *FunctionStats) int {
+	_, position := stats.Function.SourceFor(0)
+	return position.Line
+}
@@ -0,0 +1,808 @@
+package regvm
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/module"
+	"monkey/object"
+	"slices"
+)
+
+// Limits from the widths of operands
+const (
+	MaxConstants    = 1 << 16 // LOADK's operand
+	MaxFunctions    = 1 << 16 // CLOSURE's operand
+	MaxInstructions = 1 << 16 // Per function, jump targets are operands
+)
+
+// Compiles syntax trees to register machine code. Symbols are resolved with
+// the stack VM compiler's tables, so both compilers accept the same programs
+// and name globals the same way.
+type Compiler struct {
+	constants       []object.Object
+	constantIndexes map[constantKey]int // Literals are only added once
+	functions       []*Function
+
+	symbols *compiler.SymbolTable
+	scope   *scope
+
+	// Reads imported files, relative to the working directory unless replaced
+	Loader *module.Loader
+
+	// Where the macros of imported files print, the process's stdout and
+	// stderr when nil
+	MacroContext *object.Context
+
+	exports map[string]compiler.Symbol // What the file being compiled exports
+}
+
+type constantKey struct {
+	kind  object.ObjectType
+	value any
+}
+
+// A function being compiled. Registers are handed out like a stack: locals
+// keep theirs, temporaries are freed after every statement.
+type scope struct {
+	parent       *scope
+	instructions []Instruction
+
+	next  int // First free register
+	floor int // Registers below are locals, or temporaries still in use below them
+	size  int // Registers used at most
+
+	locals []int    // Registers of the locals, by symbol index
+	names  []string // Of the locals, by register
+}
+
+type Bytecode struct {
+	Main      *Function
+	Functions []*Function // Indexed like CLOSURE
+	Constants []object.Object
+
+	// Debug information, not needed for execution
+	GlobalNames []string // Indexed like GETGLOBAL
+}
+
+func NewCompiler() *Compiler {
+	symbols := compiler.NewSymbolTable()
+
+	for i, value := range object.Builtins {
+		symbols.DefineBuiltin(i, value.Name)
+	}
+
+	// The main program's register 0 holds the value of the last expression statement
+	main := &scope{}
+	main.allocate()
+
+	return &Compiler{
+		constants:       []object.Object{},
+		constantIndexes: map[constantKey]int{},
+
+		symbols: symbols,
+		scope:   main,
+
+		Loader:  module.NewLoader(""),
+		exports: map[string]compiler.Symbol{},
+	}
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	instructions := slices.Clip(c.scope.instructions)
+
+	return &Bytecode{
+		Main: &Function{
+			Instructions:      append(instructions, Instruction{Op: OpReturn}),
+			NumberOfRegisters: c.scope.size,
+			RegisterNames:     c.scope.names,
+		},
+		Functions: c.functions,
+		Constants: c.constants,
+
+		GlobalNames: c.symbols.DefinedNames(),
+	}
+}
+
+func (c *Compiler) Compile(program *ast.Program) error {
+	for _, statement := range program.Statements {
+		mark := c.scope.next
+
+		var err error
+
+		// Only allowed at the top level
+		switch statement := statement.(type) {
+		case *ast.ImportStatement:
+			err = c.compileImport(statement)
+		case *ast.ExportStatement:
+			err = c.compileExport(statement)
+		case *ast.ExpressionStatement:
+			err = c.expressionInto(statement.Expression, 0)
+		default:
+			err = c.statement(statement)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		c.scope.free(mark)
+	}
+
+	return c.checkLimits(program)
+}
+
+func (c *Compiler) checkLimits(node ast.Node) error {
+	if c.scope.size > MaxRegisters {
+		return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("too many registers, %d is the most", MaxRegisters)}
+	}
+	if len(c.scope.instructions) > MaxInstructions {
+		return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("too many instructions, %d is the most", MaxInstructions)}
+	}
+	if len(c.constants) > MaxConstants {
+		return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("too many constants, %d is the most", MaxConstants)}
+	}
+	if len(c.functions) > MaxFunctions {
+		return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("too many functions, %d is the most", MaxFunctions)}
+	}
+
+	return nil
+}
+
+// Compiles a statement for its effects
+func (c *Compiler) statement(node ast.Statement) error {
+	mark := c.scope.next
+	defer c.scope.free(mark)
+
+	switch node := node.(type) {
+	case *ast.ExpressionStatement:
+		// Into a register of its own, reading a local that isn't set is an error
+		return c.expressionInto(node.Expression, c.scope.allocate())
+
+	case *ast.LetStatement:
+		return c.let(node)
+
+	case *ast.ReturnStatement:
+		value, err := c.operand(node.ReturnValue)
+		if err != nil {
+			return err
+		}
+
+		c.emit(OpReturn, value, 0, 0)
+
+	case *ast.ImportStatement:
+		return &compiler.Error{Position: node.Pos(), Message: "imports must be at the top level"}
+
+	case *ast.ExportStatement:
+		return &compiler.Error{Position: node.Pos(), Message: "exports must be at the top level"}
+
+	default:
+		return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("can't compile %T", node)}
+	}
+
+	return nil
+}
+
+func (c *Compiler) let(node *ast.LetStatement) error {
+	symbol := c.symbols.Define(node.Name.Value)
+
+	if symbol.Scope != compiler.LocalScope {
+		value, err := c.operand(node.Value)
+		if err != nil {
+			return err
+		}
+
+		c.emit(OpSetGlobal, value, symbol.Index, 0)
+		return nil
+	}
+
+	return c.expressionInto(node.Value, c.local(symbol))
+}
+
+// Gives a local defined in the current function a register for good
+func (c *Compiler) local(symbol compiler.Symbol) int {
+	register := c.scope.allocate()
+	c.scope.floor = c.scope.next
+
+	c.scope.locals = append(c.scope.locals, register)
+	c.scope.names[register] = symbol.Name
+
+	return register
+}
+
+// Compiles the statements of a block, the value of the last one going to target
+func (c *Compiler) block(node *ast.BlockStatement, target int) error {
+	if len(node.Statements) == 0 {
+		c.loadConstant(target, object.Nil)
+		return nil
+	}
+
+	last := len(node.Statements) - 1
+	for _, statement := range node.Statements[:last] {
+		err := c.statement(statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch statement := node.Statements[last].(type) {
+	case *ast.ExpressionStatement:
+		return c.expressionInto(statement.Expression, target)
+
+	case *ast.LetStatement:
+		err := c.statement(statement)
+		if err != nil {
+			return err
+		}
+
+		// A let has no value, but the block needs one
+		c.loadConstant(target, object.Nil)
+		return nil
+
+	default:
+		return c.statement(statement)
+	}
+}
+
+// The register holding the expression's value, a local's own or a new temporary
+func (c *Compiler) expression(node ast.Expression) (int, error) {
+	if identifier, ok := node.(*ast.Identifier); ok {
+		symbol, ok := c.symbols.Resolve(identifier.Value)
+		if ok && symbol.Scope == compiler.LocalScope {
+			return c.scope.locals[symbol.Index], nil
+		}
+	}
+
+	register := c.scope.allocate()
+	return register, c.expressionInto(node, register)
+}
+
+// Like expression, but literals are operands of their own, as constants
+func (c *Compiler) operand(node ast.Expression) (int, error) {
+	var value object.Object
+
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		value = &object.Integer{Value: node.Value}
+	case *ast.StringLiteral:
+		value = &object.String{Value: node.Value}
+	case *ast.Boolean:
+		value = toBoolObject(node.Value)
+	default:
+		return c.expression(node)
+	}
+
+	index := c.addConstant(value)
+	if index >= constantBit {
+		register := c.scope.allocate()
+		c.emit(OpLoadConstant, register, index, 0)
+		return register, nil
+	}
+
+	return index | constantBit, nil
+}
+
+// Compiles the expression so its value ends up in target
+func (c *Compiler) expressionInto(node ast.Expression, target int) error {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		c.loadConstant(target, &object.Integer{Value: node.Value})
+
+	case *ast.StringLiteral:
+		c.loadConstant(target, &object.String{Value: node.Value})
+
+	case *ast.Boolean:
+		c.loadConstant(target, toBoolObject(node.Value))
+
+	case *ast.Identifier:
+		symbol, ok := c.symbols.Resolve(node.Value)
+		if !ok {
+			return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("Symbol %q not found", node.Value)}
+		}
+
+		switch symbol.Scope {
+		case compiler.GlobalScope:
+			c.emit(OpGetGlobal, target, symbol.Index, 0)
+
+		case compiler.LocalScope:
+			c.emit(OpMove, target, c.scope.locals[symbol.Index], 0)
+
+		case compiler.BuiltinScope:
+			c.emit(OpGetBuiltin, target, symbol.Index, 0)
+
+		case compiler.FreeScope:
+			c.emit(OpGetFree, target, symbol.Index, 0)
+
+		case compiler.CurrentFunctionScope:
+			c.emit(OpCurrentClosure, target, 0, 0)
+
+		case compiler.ModuleScope:
+			return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("module %s can only be used for its members, like %s.name", node.Value, node.Value)}
+
+		default:
+			return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("invalid symbol scope: %d", symbol.Scope)}
+		}
+
+	case *ast.MemberExpression:
+		export, err := c.member(node)
+		if err != nil {
+			return err
+		}
+
+		c.emit(OpGetGlobal, target, export.Index, 0)
+
+	case *ast.PrefixExpression:
+		right, err := c.expression(node.Right)
+		if err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "-":
+			c.emit(OpNegate, target, right, 0)
+		case "!":
+			c.emit(OpNot, target, right, 0)
+		default:
+			return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("unknown operator: %s", node.Operator)}
+		}
+
+	case *ast.InfixExpression:
+		op, ok := infixOperators[node.Operator]
+		if !ok {
+			return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("unknown operator: %s", node.Operator)}
+		}
+
+		left, err := c.operand(node.Left)
+		if err != nil {
+			return err
+		}
+
+		right, err := c.operand(node.Right)
+		if err != nil {
+			return err
+		}
+
+		c.emit(op, target, left, right)
+
+	case *ast.IfExpression:
+		return c.ifExpression(node, target)
+
+	case *ast.ArrayLiteral:
+		first := c.scope.allocateRange(len(node.Elements))
+
+		for i, element := range node.Elements {
+			err := c.expressionInto(element, first+i)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(OpArray, target, first, len(node.Elements))
+
+	case *ast.HashLiteral:
+		first := c.scope.allocateRange(2 * len(node.Pairs))
+
+		for i, pair := range node.Pairs {
+			err := c.expressionInto(pair.Key, first+2*i)
+			if err != nil {
+				return err
+			}
+
+			err = c.expressionInto(pair.Value, first+2*i+1)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(OpHash, target, first, len(node.Pairs))
+
+	case *ast.IndexExpression:
+		left, err := c.expression(node.Left)
+		if err != nil {
+			return err
+		}
+
+		index, err := c.operand(node.Index)
+		if err != nil {
+			return err
+		}
+
+		c.emit(OpIndex, target, left, index)
+
+	case *ast.FunctionLiteral:
+		return c.function(node, target)
+
+	case *ast.CallExpression:
+		return c.call(node, target)
+
+	case *ast.MacroLiteral:
+		// Left over by evaluator.ExpandMacros, which only takes top-level lets
+		return &compiler.Error{Position: node.Pos(), Message: "macros can only be defined with a top-level let"}
+
+	default:
+		return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("can't compile %T", node)}
+	}
+
+	return nil
+}
+
+var infixOperators = map[string]Opcode{
+	"+":  OpAdd,
+	"-":  OpSubtract,
+	"*":  OpMultiply,
+	"/":  OpDivide,
+	"==": OpEqual,
+	"!=": OpNotEqual,
+	">":  OpGreater,
+	"<":  OpLess,
+}
+
+// Comparisons in conditions jump on their own, without making a boolean
+var conditionJumps = map[string]Opcode{
+	"==": OpJumpIfNotEqual,
+	"!=": OpJumpIfEqual,
+	">":  OpJumpIfNotGreater,
+	"<":  OpJumpIfNotLess,
+}
+
+func (c *Compiler) ifExpression(node *ast.IfExpression, target int) error {
+	jumpIfFalse, err := c.condition(node.Condition)
+	if err != nil {
+		return err
+	}
+
+	err = c.block(node.Consequence, target)
+	if err != nil {
+		return err
+	}
+
+	jump := c.emit(OpJump, 0, 0, 0)
+	c.patchJump(jumpIfFalse)
+
+	if node.Alternative == nil {
+		c.loadConstant(target, object.Nil)
+	} else {
+		err = c.block(node.Alternative, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.patchJump(jump)
+
+	return nil
+}
+
+// Emits a jump, to be patched, that's taken when the condition is falsy
+func (c *Compiler) condition(node ast.Expression) (int, error) {
+	if infix, ok := node.(*ast.InfixExpression); ok {
+		if op, ok := conditionJumps[infix.Operator]; ok {
+			left, err := c.operand(infix.Left)
+			if err != nil {
+				return 0, err
+			}
+
+			right, err := c.operand(infix.Right)
+			if err != nil {
+				return 0, err
+			}
+
+			return c.emit(op, 0, left, right), nil
+		}
+	}
+
+	condition, err := c.expression(node)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.emit(OpJumpIfFalsy, 0, condition, 0), nil
+}
+
+// Makes the jump at index go to the next instruction emitted
+func (c *Compiler) patchJump(index int) {
+	c.scope.instructions[index].A = uint16(len(c.scope.instructions))
+}
+
+// The function goes in a register with the arguments in the ones after it,
+// where the callee finds them as its first registers
+func (c *Compiler) call(node *ast.CallExpression, target int) error {
+	if len(node.Arguments) > compiler.MaxArguments {
+		return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("too many arguments, %d is the most", compiler.MaxArguments)}
+	}
+
+	// A temporary on top can take the function itself, a local can't
+	// before the arguments have been evaluated
+	function := target
+	if target != c.scope.next-1 || c.scope.names[target] != "" {
+		function = c.scope.allocate()
+	}
+	arguments := c.scope.allocateRange(len(node.Arguments))
+
+	err := c.expressionInto(node.Function, function)
+	if err != nil {
+		return err
+	}
+
+	for i, argument := range node.Arguments {
+		err = c.expressionInto(argument, arguments+i)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.emit(OpCall, function, len(node.Arguments), 0)
+
+	if function != target {
+		c.emit(OpMove, target, function, 0)
+	}
+
+	return nil
+}
+
+func (c *Compiler) function(node *ast.FunctionLiteral, target int) error {
+	c.symbols = compiler.NewEnclosedSymbolTable(c.symbols)
+	c.scope = &scope{parent: c.scope}
+
+	for _, parameter := range node.Parameters {
+		c.local(c.symbols.Define(parameter.TokenLiteral()))
+	}
+
+	if node.Name != nil {
+		c.symbols.DefineFunctionName(*node.Name)
+	}
+
+	err := c.body(node.Body)
+	if err == nil {
+		err = c.checkLimits(node)
+	}
+
+	freeSymbols := c.symbols.FreeSymbols
+	scope := c.scope
+
+	c.symbols = c.symbols.Parent
+	c.scope = scope.parent
+
+	if err != nil {
+		return err
+	}
+
+	captures := make([]Capture, len(freeSymbols))
+	freeNames := make([]string, len(freeSymbols))
+	for i, freeSymbol := range freeSymbols {
+		switch freeSymbol.Scope {
+		case compiler.LocalScope:
+			captures[i] = Capture{Local: true, Index: c.scope.locals[freeSymbol.Index]}
+
+		case compiler.FreeScope:
+			captures[i] = Capture{Index: freeSymbol.Index}
+
+		default:
+			return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("free symbol %s has scope %d, that can't be right", freeSymbol.Name, freeSymbol.Scope)}
+		}
+
+		freeNames[i] = freeSymbol.Name
+	}
+
+	name := ""
+	if node.Name != nil {
+		name = *node.Name
+	}
+
+	c.functions = append(c.functions, &Function{
+		Instructions:       scope.instructions,
+		NumberOfParameters: len(node.Parameters),
+		NumberOfRegisters:  scope.size,
+		Captures:           captures,
+
+		Name:          name,
+		RegisterNames: scope.names,
+		FreeNames:     freeNames,
+	})
+	c.emit(OpClosure, target, len(c.functions)-1, 0)
+
+	return nil
+}
+
+// Like a block, but the value of the last statement is returned
+func (c *Compiler) body(node *ast.BlockStatement) error {
+	if len(node.Statements) == 0 {
+		c.emit(OpReturn, c.addConstant(object.Nil)|constantBit, 0, 0)
+		return nil
+	}
+
+	last := len(node.Statements) - 1
+	for _, statement := range node.Statements[:last] {
+		err := c.statement(statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch statement := node.Statements[last].(type) {
+	case *ast.ExpressionStatement:
+		value, err := c.operand(statement.Expression)
+		if err != nil {
+			return err
+		}
+
+		c.emit(OpReturn, value, 0, 0)
+
+	case *ast.ReturnStatement:
+		return c.statement(statement)
+
+	default:
+		err := c.statement(statement)
+		if err != nil {
+			return err
+		}
+
+		c.emit(OpReturn, c.addConstant(object.Nil)|constantBit, 0, 0)
+	}
+
+	return nil
+}
+
+// The export a module's member names
+func (c *Compiler) member(node *ast.MemberExpression) (compiler.Symbol, error) {
+	identifier, ok := node.Module.(*ast.Identifier)
+	if !ok {
+		return compiler.Symbol{}, &compiler.Error{Position: node.Module.Pos(), Message: fmt.Sprintf("%s is not a module", node.Module)}
+	}
+
+	symbol, ok := c.symbols.Resolve(identifier.Value)
+	if !ok {
+		return compiler.Symbol{}, &compiler.Error{Position: identifier.Pos(), Message: fmt.Sprintf("Symbol %q not found", identifier.Value)}
+	}
+	if symbol.Scope != compiler.ModuleScope {
+		return compiler.Symbol{}, &compiler.Error{Position: identifier.Pos(), Message: fmt.Sprintf("%s is not a module", identifier.Value)}
+	}
+
+	imported := c.symbols.Module(symbol)
+	export, ok := imported.Exports[node.Member.Value]
+	if !ok {
+		return compiler.Symbol{}, &compiler.Error{Position: node.Member.Pos(), Message: fmt.Sprintf("%s doesn't export %s", imported.Path, node.Member.Value)}
+	}
+
+	return export, nil
+}
+
+// Compiles an imported file into the main program, the first time it's
+// imported, and names it
+func (c *Compiler) compileImport(node *ast.ImportStatement) error {
+	path := c.Loader.Resolve(node.Path.Value)
+
+	imported := c.symbols.ImportedModule(path)
+	if imported == nil {
+		var err error
+		imported, err = c.compileModule(path)
+
+		switch err := err.(type) {
+		case nil:
+		case *compiler.Error:
+			return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("import %q: %s:%s: %s", node.Path.Value, path, err.Position, err.Message)}
+		default:
+			return &compiler.Error{Position: node.Pos(), Message: fmt.Sprintf("import %q: %s", node.Path.Value, err)}
+		}
+	}
+
+	c.symbols.DefineModule(node.Name.Value, imported)
+
+	return nil
+}
+
+func (c *Compiler) compileModule(path string) (*compiler.Module, error) {
+	program, err := c.Loader.Enter(path)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Loader.Leave()
+
+	macros := object.NewEnvironment()
+	if c.MacroContext != nil {
+		macros.SetContext(c.MacroContext)
+	}
+	evaluator.DefineMacros(program, macros)
+	expanded, err := evaluator.ExpandMacros(program, macros)
+	switch err := err.(type) {
+	case nil:
+	case *evaluator.MacroError:
+		return nil, &compiler.Error{Position: err.Position, Message: err.Message}
+	default:
+		return nil, err
+	}
+
+	symbols, exports := c.symbols, c.exports
+
+	c.symbols = compiler.NewModuleSymbolTable(symbols, path+":")
+	for i, value := range object.Builtins {
+		c.symbols.DefineBuiltin(i, value.Name)
+	}
+	c.exports = map[string]compiler.Symbol{}
+
+	err = c.Compile(expanded.(*ast.Program))
+	imported := &compiler.Module{Path: path, Exports: c.exports}
+
+	c.symbols, c.exports = symbols, exports
+
+	return imported, err
+}
+
+func (c *Compiler) compileExport(node *ast.ExportStatement) error {
+	err := c.statement(node.Statement)
+	if err != nil {
+		return err
+	}
+
+	symbol, _ := c.symbols.Resolve(node.Statement.Name.Value)
+	c.exports[symbol.Name] = symbol
+
+	return nil
+}
+
+func (c *Compiler) emit(op Opcode, a, b, cc int) int {
+	c.scope.instructions = append(c.scope.instructions, Instruction{
+		Op: op,
+		A:  uint16(a),
+		B:  uint16(b),
+		C:  uint16(cc),
+	})
+
+	return len(c.scope.instructions) - 1
+}
+
+func (c *Compiler) loadConstant(target int, value object.Object) {
+	c.emit(OpLoadConstant, target, c.addConstant(value), 0)
+}
+
+// Literals are immutable, so equal ones share a constant
+func (c *Compiler) addConstant(value object.Object) int {
+	var key constantKey
+	switch value := value.(type) {
+	case *object.Integer:
+		key = constantKey{value.Type(), value.Value}
+	case *object.String:
+		key = constantKey{value.Type(), value.Value}
+	case *object.Boolean:
+		key = constantKey{value.Type(), value.Value}
+	default:
+		key = constantKey{value.Type(), nil}
+	}
+
+	if index, ok := c.constantIndexes[key]; ok {
+		return index
+	}
+
+	c.constants = append(c.constants, value)
+	c.constantIndexes[key] = len(c.constants) - 1
+
+	return len(c.constants) - 1
+}
+
+func (s *scope) allocate() int {
+	register := s.next
+
+	s.next++
+	s.size = max(s.size, s.next)
+	if len(s.names) < s.next {
+		s.names = append(s.names, "")
+	}
+
+	return register
+}
+
+// Consecutive registers, giving the first
+func (s *scope) allocateRange(count int) int {
+	first := s.next
+	for range count {
+		s.allocate()
+	}
+
+	return first
+}
+
+// Frees the temporaries allocated since mark
+func (s *scope) free(mark int) {
+	s.next = max(mark, s.floor)
+}
@@ -0,0 +1,130 @@
+package regvm
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+func parse(input string) *ast.Program {
+	return parser.New(lexer.New(input)).ParseProgram()
+}
+
+func compile(t *testing.T, input string) *Bytecode {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	c := NewCompiler()
+	err := c.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	return c.Bytecode()
+}
+
+func testListing(t *testing.T, name string, expected []string, function *Function) {
+	t.Helper()
+
+	listing := strings.TrimSuffix(function.String(), "\n")
+	if listing != strings.Join(expected, "\n") {
+		t.Errorf("wrong instructions for %s.\nexpected:\n%s\ngot:\n%s", name, strings.Join(expected, "\n"), listing)
+	}
+}
+
+func TestCompileArithmetic(t *testing.T) {
+	bytecode := compile(t, `let x = 2; -x * (x + 1)`)
+
+	testListing(t, "main", []string{
+		"0000 SETGLOBAL k0 0",
+		"0001 GETGLOBAL r2 0",
+		"0002 NEG r1 r2",
+		"0003 GETGLOBAL r4 0",
+		"0004 ADD r3 r4 k1",
+		"0005 MUL r0 r1 r3",
+		"0006 RETURN r0",
+	}, bytecode.Main)
+
+	// Literals are only added once
+	if len(bytecode.Constants) != 2 {
+		t.Errorf("expected 2 constants, got %d", len(bytecode.Constants))
+	}
+}
+
+func TestCompileFunctions(t *testing.T) {
+	bytecode := compile(t, `
+		let fibonacci = fn(x) {
+			if (x < 2) { x } else { fibonacci(x - 1) + fibonacci(x - 2) }
+		};
+		let adder = fn(a) { let b = a + 1; fn(c) { a + b + c } };`)
+
+	// Comparisons in conditions jump themselves, arguments are computed
+	// right where the callee finds its parameters
+	testListing(t, "fibonacci", []string{
+		"0000 JUMPIFNOTLT 3 r0 k0",
+		"0001 MOVE r1 r0",
+		"0002 JUMP 10",
+		"0003 CURRENT r2",
+		"0004 SUB r3 r0 k1",
+		"0005 CALL r2 1",
+		"0006 CURRENT r4",
+		"0007 SUB r5 r0 k0",
+		"0008 CALL r4 1",
+		"0009 ADD r1 r2 r4",
+		"0010 RETURN r1",
+	}, bytecode.Functions[0])
+
+	testListing(t, "adder", []string{
+		"0000 ADD r1 r0 k1",
+		"0001 CLOSURE r2 1",
+		"0002 RETURN r2",
+	}, bytecode.Functions[2])
+
+	inner := bytecode.Functions[1]
+	if fmt.Sprint(inner.Captures) != "[{true 0} {true 1}]" || fmt.Sprint(inner.FreeNames) != "[a b]" {
+		t.Errorf("wrong captures %v of %v", inner.Captures, inner.FreeNames)
+	}
+
+	if fmt.Sprint(bytecode.Functions[2].RegisterNames) != "[a b ]" {
+		t.Errorf("wrong register names %q", bytecode.Functions[2].RegisterNames)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`x`, `Symbol "x" not found`},
+		{`fn() { import "a.mk" as a; }`, "imports must be at the top level"},
+		{`import "a.mk" as a; a`, "module a can only be used for its members, like a.name"},
+		{`import "a.mk" as a; a.y`, "a.mk doesn't export y"},
+		{`let m = macro() { quote(1) }; fn() { macro() { 1 } }`, "macros can only be defined with a top-level let"},
+		{`let f = fn(x) { fn() { f(x) } }`, "free symbol f has scope 4, that can't be right"},
+		{"f(" + strings.Repeat("1, ", compiler.MaxArguments) + "1)", "too many arguments, 255 is the most"},
+	}
+
+	for _, tt := range tests {
+		c := NewCompiler()
+		c.Loader.ReadFile = func(string) ([]byte, error) { return []byte(`export let x = 1;`), nil }
+
+		err := c.Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("%q compiled, expected %q", tt.input, tt.expected)
+			continue
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
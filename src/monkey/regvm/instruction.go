@@ -0,0 +1,166 @@
+// A register machine running the same programs as the stack VM, compiled
+// from the same syntax trees. Instructions name the registers they read and
+// write, so values stay where they are instead of going through a stack, and
+// every local binding is a register of its own.
+package regvm
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Opcode byte
+
+// R[x] is register x of the running function, K[x] constant x, and RK[x]
+// either, constants having the top bit of the operand set
+const (
+	OpMove           Opcode = iota // R[A] = R[B]
+	OpLoadConstant                 // R[A] = K[B]
+	OpGetGlobal                    // R[A] = global B
+	OpSetGlobal                    // global B = RK[A]
+	OpGetFree                      // R[A] = free variable B of the running closure
+	OpGetBuiltin                   // R[A] = builtin B
+	OpCurrentClosure               // R[A] = the running closure
+	OpClosure                      // R[A] = a closure of function B
+
+	OpAdd      // R[A] = RK[B] + RK[C]
+	OpSubtract // R[A] = RK[B] - RK[C]
+	OpMultiply // R[A] = RK[B] * RK[C]
+	OpDivide   // R[A] = RK[B] / RK[C]
+	OpEqual    // R[A] = RK[B] == RK[C]
+	OpNotEqual // R[A] = RK[B] != RK[C]
+	OpGreater  // R[A] = RK[B] > RK[C]
+	OpLess     // R[A] = RK[B] < RK[C]
+	OpNegate   // R[A] = -R[B]
+	OpNot      // R[A] = !R[B]
+
+	OpJump             // Continue at A
+	OpJumpIfFalsy      // Continue at A if R[B] is falsy
+	OpJumpIfNotEqual   // Continue at A unless RK[B] == RK[C]
+	OpJumpIfEqual      // Continue at A unless RK[B] != RK[C]
+	OpJumpIfNotGreater // Continue at A unless RK[B] > RK[C]
+	OpJumpIfNotLess    // Continue at A unless RK[B] < RK[C]
+
+	OpArray // R[A] = [R[B], ..., R[B+C-1]]
+	OpHash  // R[A] = {R[B]: R[B+1], ...}, with C pairs
+	OpIndex // R[A] = R[B][RK[C]]
+
+	OpCall   // R[A] = R[A](R[A+1], ..., R[A+B])
+	OpReturn // Returns RK[A]
+)
+
+// Operands with this bit set are constants, the others registers
+const constantBit = 1 << 15
+
+// Registers a function can have, the rest of the operand width is the constant bit
+const MaxRegisters = constantBit
+
+type Instruction struct {
+	Op      Opcode
+	A, B, C uint16
+}
+
+// What an operand stands for, to print it
+type operandKind int
+
+const (
+	none     operandKind = iota
+	register             // r1
+	either               // r1, or k1 for a constant
+	number               // 1, like global and function indexes, counts and jump targets
+)
+
+type definition struct {
+	name     string
+	operands [3]operandKind
+}
+
+var definitions = map[Opcode]definition{
+	OpMove:           {"MOVE", [3]operandKind{register, register}},
+	OpLoadConstant:   {"LOADK", [3]operandKind{register, number}},
+	OpGetGlobal:      {"GETGLOBAL", [3]operandKind{register, number}},
+	OpSetGlobal:      {"SETGLOBAL", [3]operandKind{either, number}},
+	OpGetFree:        {"GETFREE", [3]operandKind{register, number}},
+	OpGetBuiltin:     {"GETBUILTIN", [3]operandKind{register, number}},
+	OpCurrentClosure: {"CURRENT", [3]operandKind{register}},
+	OpClosure:        {"CLOSURE", [3]operandKind{register, number}},
+
+	OpAdd:      {"ADD", [3]operandKind{register, either, either}},
+	OpSubtract: {"SUB", [3]operandKind{register, either, either}},
+	OpMultiply: {"MUL", [3]operandKind{register, either, either}},
+	OpDivide:   {"DIV", [3]operandKind{register, either, either}},
+	OpEqual:    {"EQ", [3]operandKind{register, either, either}},
+	OpNotEqual: {"NE", [3]operandKind{register, either, either}},
+	OpGreater:  {"GT", [3]operandKind{register, either, either}},
+	OpLess:     {"LT", [3]operandKind{register, either, either}},
+	OpNegate:   {"NEG", [3]operandKind{register, register}},
+	OpNot:      {"NOT", [3]operandKind{register, register}},
+
+	OpJump:             {"JUMP", [3]operandKind{number}},
+	OpJumpIfFalsy:      {"JUMPIFFALSY", [3]operandKind{number, register}},
+	OpJumpIfNotEqual:   {"JUMPIFNOTEQ", [3]operandKind{number, either, either}},
+	OpJumpIfEqual:      {"JUMPIFEQ", [3]operandKind{number, either, either}},
+	OpJumpIfNotGreater: {"JUMPIFNOTGT", [3]operandKind{number, either, either}},
+	OpJumpIfNotLess:    {"JUMPIFNOTLT", [3]operandKind{number, either, either}},
+
+	OpArray: {"ARRAY", [3]operandKind{register, register, number}},
+	OpHash:  {"HASH", [3]operandKind{register, register, number}},
+	OpIndex: {"INDEX", [3]operandKind{register, register, either}},
+
+	OpCall:   {"CALL", [3]operandKind{register, number}},
+	OpReturn: {"RETURN", [3]operandKind{either}},
+}
+
+// Like ADD r2 r0 k1
+func (ins Instruction) String() string {
+	def, ok := definitions[ins.Op]
+	if !ok {
+		return fmt.Sprintf("UNKNOWN %d", ins.Op)
+	}
+
+	parts := []string{def.name}
+	for i, operand := range []uint16{ins.A, ins.B, ins.C} {
+		switch def.operands[i] {
+		case register:
+			parts = append(parts, fmt.Sprintf("r%d", operand))
+		case either:
+			if operand&constantBit != 0 {
+				parts = append(parts, fmt.Sprintf("k%d", operand&^constantBit))
+			} else {
+				parts = append(parts, fmt.Sprintf("r%d", operand))
+			}
+		case number:
+			parts = append(parts, fmt.Sprintf("%d", operand))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// What a closure holds on to, from the function creating it
+type Capture struct {
+	Local bool // One of the creating function's registers, else one of its free variables
+	Index int
+}
+
+type Function struct {
+	Instructions       []Instruction
+	NumberOfParameters int // In the first registers
+	NumberOfRegisters  int
+	Captures           []Capture // Become the closure's free variables, in order
+
+	// Debug information, not needed for execution
+	Name          string   // Empty for anonymous functions
+	RegisterNames []string // Of the local bindings, "" for temporaries
+	FreeNames     []string
+}
+
+// One instruction per line, with its offset
+func (f *Function) String() string {
+	var out strings.Builder
+	for i, ins := range f.Instructions {
+		fmt.Fprintf(&out, "%04d %s\n", i, ins)
+	}
+
+	return out.String()
+}
@@ -0,0 +1,595 @@
+package regvm
+
+import (
+	"errors"
+	"fmt"
+	"monkey/object"
+)
+
+const MaxFrames = 1024                 // Like the stack VM
+const StackSize = MaxFrames * 64       // Registers of every frame together, at most
+const initialStackSize = StackSize / 8 // Grown when deep calls need more
+
+var True = object.True
+var False = object.False
+var Null = object.Nil
+
+func toBoolObject(b bool) *object.Boolean {
+	if b {
+		return True
+	}
+
+	return False
+}
+
+type Closure struct {
+	Function      *Function
+	FreeVariables []object.Object
+}
+
+// To scripts it's a function, whichever engine runs them
+func (c *Closure) Type() object.ObjectType { return object.FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+type frame struct {
+	closure *Closure
+	pc      int // Next instruction to execute
+	base    int // Where the frame's registers start in the VM's
+}
+
+type VM struct {
+	constants []object.Object
+	functions []*Function
+
+	globals     []object.Object
+	globalNames []string // For errors about globals read before they're set
+
+	// The registers of every frame, each frame's after its caller's. A
+	// function's result goes in the register just below its own, where the
+	// caller had the function.
+	registers  []object.Object
+	frames     [MaxFrames]frame
+	frameIndex int // -1 once the program has finished
+
+	executed int64 // Instructions, for the benchmarks to compare engines by
+
+	context        *object.Context // Where builtins do their IO
+	builtinContext *object.Context // context calling back with callFromBuiltin, made on the first builtin call
+}
+
+func New(bytecode *Bytecode) *VM {
+	vm := &VM{
+		constants: bytecode.Constants,
+		functions: bytecode.Functions,
+
+		globals:     make([]object.Object, len(bytecode.GlobalNames)),
+		globalNames: bytecode.GlobalNames,
+
+		registers: make([]object.Object, max(initialStackSize, 1+bytecode.Main.NumberOfRegisters)),
+
+		context: object.NewContext(),
+	}
+
+	// The main program's result register, until an expression statement sets it
+	vm.registers[1] = Null
+	vm.frames[0] = frame{closure: &Closure{Function: bytecode.Main}, base: 1}
+
+	return vm
+}
+
+// Replaces the default context, writing to the process's stdout and stderr
+func (vm *VM) SetContext(context *object.Context) {
+	vm.context = context
+	vm.builtinContext = nil
+}
+
+// Runs the program to the end, or to the runtime error stopping it
+func (vm *VM) Execute() error {
+	if vm.frameIndex < 0 {
+		return nil
+	}
+
+	return vm.run(0)
+}
+
+// The value of the last top-level expression statement executed, or what
+// the program returned
+func (vm *VM) Result() object.Object {
+	return vm.registers[0]
+}
+
+// How many instructions have been executed so far, builtins calling back included
+func (vm *VM) Executed() int64 {
+	return vm.executed
+}
+
+// Executes instructions until the frame at depth returns
+func (vm *VM) run(depth int) error {
+	f := &vm.frames[vm.frameIndex]
+	instructions := f.closure.Function.Instructions
+	registers := vm.registers[f.base:]
+	constants := vm.constants
+
+	for {
+		ins := instructions[f.pc]
+		f.pc++
+		vm.executed++
+
+		switch ins.Op {
+		case OpMove:
+			value := registers[ins.B]
+			if value == nil {
+				return vm.unsetError(ins.B)
+			}
+
+			registers[ins.A] = value
+
+		case OpLoadConstant:
+			registers[ins.A] = constants[ins.B]
+
+		case OpGetGlobal:
+			value := vm.globals[ins.B]
+			if value == nil {
+				return unsetError(vm.globalNames, int(ins.B))
+			}
+
+			registers[ins.A] = value
+
+		case OpSetGlobal:
+			value := load(registers, constants, ins.A)
+			if value == nil {
+				return vm.unsetError(ins.A)
+			}
+
+			vm.globals[ins.B] = value
+
+		case OpGetFree:
+			registers[ins.A] = f.closure.FreeVariables[ins.B]
+
+		case OpGetBuiltin:
+			registers[ins.A] = object.Builtins[ins.B].Builtin
+
+		case OpCurrentClosure:
+			registers[ins.A] = f.closure
+
+		case OpClosure:
+			closure, err := vm.makeClosure(f, registers, vm.functions[ins.B])
+			if err != nil {
+				return err
+			}
+
+			registers[ins.A] = closure
+
+		case OpAdd, OpSubtract, OpMultiply, OpDivide, OpEqual, OpNotEqual, OpGreater, OpLess:
+			left := load(registers, constants, ins.B)
+			right := load(registers, constants, ins.C)
+
+			result, err := vm.binaryOperation(ins.Op, left, right, ins.B, ins.C)
+			if err != nil {
+				return err
+			}
+
+			registers[ins.A] = result
+
+		case OpNegate:
+			operand := registers[ins.B]
+
+			value, ok := operand.(*object.Integer)
+			if !ok {
+				if operand == nil {
+					return vm.unsetError(ins.B)
+				}
+
+				return fmt.Errorf("unknown operator: -%s", operand.Type())
+			}
+
+			registers[ins.A] = object.NewInteger(-value.Value)
+
+		case OpNot:
+			operand := registers[ins.B]
+			if operand == nil {
+				return vm.unsetError(ins.B)
+			}
+
+			registers[ins.A] = toBoolObject(!object.IsTruthy(operand))
+
+		case OpJump:
+			f.pc = int(ins.A)
+
+		case OpJumpIfFalsy:
+			condition := registers[ins.B]
+			if condition == nil {
+				return vm.unsetError(ins.B)
+			}
+
+			if !object.IsTruthy(condition) {
+				f.pc = int(ins.A)
+			}
+
+		case OpJumpIfNotEqual, OpJumpIfEqual, OpJumpIfNotGreater, OpJumpIfNotLess:
+			left := load(registers, constants, ins.B)
+			right := load(registers, constants, ins.C)
+
+			holds, err := vm.comparison(conditions[ins.Op], left, right, ins.B, ins.C)
+			if err != nil {
+				return err
+			}
+
+			if !holds {
+				f.pc = int(ins.A)
+			}
+
+		case OpArray:
+			elements := registers[ins.B : ins.B+ins.C]
+
+			registers[ins.A] = &object.Array{Elements: append([]object.Object(nil), elements...)}
+
+		case OpHash:
+			result := object.NewHash()
+
+			for i := range int(ins.C) {
+				key := registers[int(ins.B)+2*i]
+				value := registers[int(ins.B)+2*i+1]
+
+				hashKey, ok := object.AsHashable(key)
+				if !ok {
+					return fmt.Errorf("unusable as hash key: %s", key.Type())
+				}
+
+				result.Set(hashKey, value)
+			}
+
+			registers[ins.A] = result
+
+		case OpIndex:
+			indexee := registers[ins.B]
+			if indexee == nil {
+				return vm.unsetError(ins.B)
+			}
+
+			index := load(registers, constants, ins.C)
+			if index == nil {
+				return vm.unsetError(ins.C)
+			}
+
+			result, err := indexExpression(indexee, index)
+			if err != nil {
+				return err
+			}
+
+			registers[ins.A] = result
+
+		case OpCall:
+			pushed, err := vm.call(f.base+int(ins.A), int(ins.B))
+			if err != nil {
+				return err
+			}
+
+			// Builtins can call back into the program, which can move the registers
+			if pushed {
+				f = &vm.frames[vm.frameIndex]
+				instructions = f.closure.Function.Instructions
+			}
+			registers = vm.registers[f.base:]
+
+		case OpReturn:
+			value := load(registers, constants, ins.A)
+			if value == nil {
+				return vm.unsetError(ins.A)
+			}
+
+			vm.registers[f.base-1] = value
+
+			vm.frameIndex--
+			if vm.frameIndex < depth {
+				return nil
+			}
+
+			f = &vm.frames[vm.frameIndex]
+			instructions = f.closure.Function.Instructions
+			registers = vm.registers[f.base:]
+
+		default:
+			return fmt.Errorf("invalid opcode %d", ins.Op)
+		}
+	}
+}
+
+// The register or constant an operand names
+func load(registers []object.Object, constants []object.Object, operand uint16) object.Object {
+	if operand&constantBit != 0 {
+		return constants[operand&^constantBit]
+	}
+
+	return registers[operand]
+}
+
+// Calls the function in register slot with the arguments in the ones after
+// it. Closures get a frame, which is pushed for run to execute, builtins run
+// right away.
+func (vm *VM) call(slot int, numberOfArguments int) (pushed bool, err error) {
+	switch callee := vm.registers[slot].(type) {
+	case *Closure:
+		function := callee.Function
+		if numberOfArguments != function.NumberOfParameters {
+			return false, fmt.Errorf("wrong number of arguments %d, expected %d", numberOfArguments, function.NumberOfParameters)
+		}
+
+		if vm.frameIndex+1 >= MaxFrames {
+			return false, fmt.Errorf("stack overflow (%d frames)", MaxFrames)
+		}
+
+		base := slot + 1
+		top := base + function.NumberOfRegisters
+		if top > len(vm.registers) {
+			err := vm.grow(top)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		// Parameters are the first registers, the others start out unset
+		clear(vm.registers[base+numberOfArguments : top])
+
+		vm.frameIndex++
+		vm.frames[vm.frameIndex] = frame{closure: callee, base: base}
+
+		return true, nil
+
+	case *object.Builtin:
+		arguments := vm.registers[slot+1 : slot+1+numberOfArguments]
+
+		// Made once, rather than allocating on every call. The context set
+		// may be shared with other VMs, so it isn't changed.
+		if vm.builtinContext == nil {
+			vm.builtinContext = vm.context.WithCall(vm.callFromBuiltin)
+		}
+
+		result := callee.Fn(vm.builtinContext, arguments...)
+
+		// Like any other runtime error, stops the program
+		if err, ok := result.(*object.Error); ok {
+			return false, errors.New(err.Message)
+		}
+
+		if result == nil {
+			result = Null
+		}
+		vm.registers[slot] = result
+
+		return false, nil
+
+	default:
+		return false, fmt.Errorf("not a function: %s", callee.Type())
+	}
+}
+
+// For builtins calling back into the program, with registers past the
+// calling frame's. The program goes on after a runtime error in the call,
+// which the builtin gets instead.
+func (vm *VM) callFromBuiltin(function object.Object, args ...object.Object) (object.Object, error) {
+	frameIndex := vm.frameIndex
+	f := &vm.frames[frameIndex]
+
+	slot := f.base + f.closure.Function.NumberOfRegisters
+	if slot+1+len(args) > len(vm.registers) {
+		err := vm.grow(slot + 1 + len(args))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vm.registers[slot] = function
+	copy(vm.registers[slot+1:], args)
+
+	pushed, err := vm.call(slot, len(args))
+	if err == nil && pushed {
+		err = vm.run(frameIndex + 1)
+	}
+
+	if err != nil {
+		vm.frameIndex = frameIndex
+		return nil, err
+	}
+
+	return vm.registers[slot], nil
+}
+
+// Makes room for at least size registers
+func (vm *VM) grow(size int) error {
+	if size > StackSize {
+		return fmt.Errorf("stack overflow (size %d)", StackSize)
+	}
+
+	registers := make([]object.Object, min(max(size, 2*len(vm.registers)), StackSize))
+	copy(registers, vm.registers)
+	vm.registers = registers
+
+	return nil
+}
+
+func (vm *VM) makeClosure(f *frame, registers []object.Object, function *Function) (*Closure, error) {
+	freeVariables := make([]object.Object, len(function.Captures))
+
+	for i, capture := range function.Captures {
+		if !capture.Local {
+			freeVariables[i] = f.closure.FreeVariables[capture.Index]
+			continue
+		}
+
+		value := registers[capture.Index]
+		if value == nil {
+			return nil, vm.unsetError(uint16(capture.Index))
+		}
+
+		freeVariables[i] = value
+	}
+
+	return &Closure{Function: function, FreeVariables: freeVariables}, nil
+}
+
+// A local that's read before it's set, like x in let x = x, or after a let
+// in a branch that wasn't taken. Only locals' registers can be unset.
+func (vm *VM) unsetError(register uint16) error {
+	return unsetError(vm.frames[vm.frameIndex].closure.Function.RegisterNames, int(register))
+}
+
+func unsetError(names []string, index int) error {
+	if index < len(names) && names[index] != "" {
+		return fmt.Errorf("identifier not found: %s", names[index])
+	}
+
+	return fmt.Errorf("identifier not found")
+}
+
+// Operators as written in the source, for errors
+var operators = map[Opcode]string{
+	OpAdd:      "+",
+	OpSubtract: "-",
+	OpMultiply: "*",
+	OpDivide:   "/",
+	OpEqual:    "==",
+	OpNotEqual: "!=",
+	OpGreater:  ">",
+	OpLess:     "<",
+}
+
+// The comparison each conditional jump makes
+var conditions = [...]Opcode{
+	OpJumpIfNotEqual:   OpEqual,
+	OpJumpIfEqual:      OpNotEqual,
+	OpJumpIfNotGreater: OpGreater,
+	OpJumpIfNotLess:    OpLess,
+}
+
+// Operands are the left and right operands of the instruction, to name them
+// in errors
+func (vm *VM) binaryOperation(op Opcode, left, right object.Object, leftOperand, rightOperand uint16) (object.Object, error) {
+	if left, ok := left.(*object.Integer); ok {
+		if right, ok := right.(*object.Integer); ok {
+			return binaryOperationInteger(op, left.Value, right.Value)
+		}
+	}
+
+	if left == nil {
+		return nil, vm.unsetError(leftOperand)
+	}
+	if right == nil {
+		return nil, vm.unsetError(rightOperand)
+	}
+
+	// Everything else is compared structurally
+	switch op {
+	case OpEqual:
+		return toBoolObject(object.Equal(left, right)), nil
+
+	case OpNotEqual:
+		return toBoolObject(!object.Equal(left, right)), nil
+	}
+
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ && op == OpAdd {
+		return &object.String{
+			Value: left.(*object.String).Value + right.(*object.String).Value,
+		}, nil
+	}
+
+	if left.Type() != right.Type() {
+		return nil, fmt.Errorf("type mismatch: %s %s %s", left.Type(), operators[op], right.Type())
+	}
+
+	return nil, fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+}
+
+func binaryOperationInteger(op Opcode, left, right int64) (object.Object, error) {
+	switch op {
+	case OpAdd:
+		return object.NewInteger(left + right), nil
+
+	case OpSubtract:
+		return object.NewInteger(left - right), nil
+
+	case OpMultiply:
+		return object.NewInteger(left * right), nil
+
+	case OpDivide:
+		if right == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+
+		return object.NewInteger(left / right), nil
+
+	case OpEqual:
+		return toBoolObject(left == right), nil
+
+	case OpNotEqual:
+		return toBoolObject(left != right), nil
+
+	case OpGreater:
+		return toBoolObject(left > right), nil
+
+	case OpLess:
+		return toBoolObject(left < right), nil
+
+	default:
+		return nil, fmt.Errorf("unknown operator: INTEGER %s INTEGER", operators[op])
+	}
+}
+
+// Whether a comparison holds, without making a boolean for integers
+func (vm *VM) comparison(op Opcode, left, right object.Object, leftOperand, rightOperand uint16) (bool, error) {
+	if left, ok := left.(*object.Integer); ok {
+		if right, ok := right.(*object.Integer); ok {
+			switch op {
+			case OpEqual:
+				return left.Value == right.Value, nil
+			case OpNotEqual:
+				return left.Value != right.Value, nil
+			case OpGreater:
+				return left.Value > right.Value, nil
+			case OpLess:
+				return left.Value < right.Value, nil
+			}
+		}
+	}
+
+	result, err := vm.binaryOperation(op, left, right, leftOperand, rightOperand)
+	if err != nil {
+		return false, err
+	}
+
+	return object.IsTruthy(result), nil
+}
+
+func indexExpression(indexee, index object.Object) (object.Object, error) {
+	switch indexee := indexee.(type) {
+	case *object.Array:
+		convertedIndex, ok := index.(*object.Integer)
+		if !ok {
+			return nil, fmt.Errorf("index operator not supported: %s", indexee.Type())
+		}
+
+		if convertedIndex.Value < 0 || convertedIndex.Value >= int64(len(indexee.Elements)) {
+			return Null, nil
+		}
+
+		return indexee.Elements[convertedIndex.Value], nil
+
+	case *object.Hash:
+		convertedIndex, ok := object.AsHashable(index)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+
+		result, ok := indexee.Get(convertedIndex)
+		if !ok {
+			return Null, nil
+		}
+
+		return result, nil
+
+	default:
+		return nil, fmt.Errorf("index operator not supported: %s", indexee.Type())
+	}
+}
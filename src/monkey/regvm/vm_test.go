@@ -0,0 +1,242 @@
+package regvm
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/object"
+	"strings"
+	"testing"
+)
+
+type vmTestCase struct {
+	input    string
+	expected string // Inspected result, or the runtime error
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		vm := New(compile(t, tt.input))
+		vm.SetContext(&object.Context{Stdout: &bytes.Buffer{}})
+
+		err := vm.Execute()
+
+		result := ""
+		if err != nil {
+			result = err.Error()
+		} else {
+			result = vm.Result().Inspect()
+		}
+
+		if result != tt.expected {
+			t.Errorf("wrong result for %q. expected=%q, got=%q", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", "50"},
+		{"1 < 2 == true", "true"},
+		{"!(1 > 2) != false", "true"},
+		{`"mon" + "key"`, "monkey"},
+		{`[1, 2] == [1, 2]`, "true"},
+		{`{"a": [1]} != {"a": [1]}`, "false"},
+		{`[1, 2 * 2, 3][1]`, "4"},
+		{`[1][5]`, "null"},
+		{`{1: "one", true: "yes"}[true]`, "yes"},
+		{`{"a": 1}["b"]`, "null"},
+		{"let a = 1; let b = a + 1; a + b", "3"},
+		{"", "null"},
+	})
+}
+
+func TestConditionals(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"if (1 < 2) { 10 } else { 20 }", "10"},
+		{"if (1 > 2) { 10 }", "null"},
+		{"if (0) { 10 } else { 20 }", "20"},
+		{`if ("a" == "a") { 10 }`, "10"},
+		{"if (1 != 1) { 10 } else { if (false) { 1 } else { 30 } }", "30"},
+		{"if (true) { let x = 5; }", "null"},
+		{"if (if (false) { 1 }) { 10 } else { 20 }", "20"},
+	})
+}
+
+func TestFunctions(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"let f = fn() { 5 + 10 }; f()", "15"},
+		{"let f = fn(a, b) { let c = a + b; c * 2 }; f(1, 2) + f(3, 4)", "20"},
+		{"let f = fn() { return 1; 2 }; f()", "1"},
+		{"let f = fn() { }; f()", "null"},
+		{"let f = fn() { let x = 1; }; f()", "null"},
+		{"let g = fn() { fn(x) { x * 2 } }; g()(3)", "6"},
+		{"let apply = fn(f, x) { f(f(x)) }; apply(fn(x) { x + 1 }, 1)", "3"},
+		{"let f = fn(x) { if (x > 0) { f(x - 1) } else { x } }; f(10)", "0"},
+		{"return 5; 10", "5"},
+	})
+}
+
+func TestClosures(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"let adder = fn(a) { fn(b) { a + b } }; adder(1)(2)", "3"},
+		{"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)", "6"},
+		{"let f = fn(a) { let b = a * 2; let g = fn() { let c = 1; fn() { a + b + c } }; g()() }; f(2)", "7"},
+		{`let count = fn(n) { if (n == 0) { "done" } else { count(n - 1) } };
+		  let wrapper = fn() { count(3) }; wrapper()`, "done"},
+	})
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"1 + true", "type mismatch: INTEGER + BOOLEAN"},
+		{`"a" < "b"`, "unknown operator: STRING < STRING"},
+		{`if ("a" < "b") { 1 }`, "unknown operator: STRING < STRING"},
+		{"-true", "unknown operator: -BOOLEAN"},
+		{"10 / (5 - 5)", "division by zero"},
+		{"5(1)", "not a function: INTEGER"},
+		{"999[1]", "index operator not supported: INTEGER"},
+		{`{"a": 1}[fn(x) { x }]`, "unusable as hash key: FUNCTION"},
+		{`let x = len(1); 5`, "argument to `len` not supported, got INTEGER"},
+		{"fn() { 1 }(2)", "wrong number of arguments 1, expected 0"},
+		{"fn(a, b) { a; b }(1)", "wrong number of arguments 1, expected 2"},
+		{"let f = fn(n) { f(n + 1) }; f(0)", "stack overflow (1024 frames)"},
+	})
+}
+
+func TestUnsetBindings(t *testing.T) {
+	// A let in a branch that wasn't taken leaves its local unset, whatever reads it
+	reads := []string{"x", "x + 1", "-x", "!x", "[x]", "x[0]", "if (x) { 1 }", "if (x < 1) { 1 }", "fn() { x }", "len(x)"}
+
+	tests := []vmTestCase{
+		{"let f = fn() { let x = x; x }; f()", "identifier not found: x"},
+		{"let f = fn() { if (false) { let x = 1; }; return x; }; f()", "identifier not found: x"},
+		{"let y = fn() { if (false) { let x = 1; }; x }(); let z = y", "identifier not found: x"},
+		{"let x = x;", "identifier not found: x"},
+	}
+	for _, read := range reads {
+		tests = append(tests, vmTestCase{
+			fmt.Sprintf("let f = fn() { if (false) { let x = 1; }; %s; 1 }; f()", read),
+			"identifier not found: x",
+		})
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltins(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{`len("four")`, "4"},
+		{`let f = fn(a) { push(a, len(a)) }; f([1, 2])`, "[1, 2, 2]"},
+		{`puts("hello")`, "null"},
+		{`let fail = fn(depth) { if (depth == 0) { 1 / 0 } else { fail(depth - 1) } };
+		  let message = assert_error(fn() { fail(20) });
+		  [message, 1 + 2]`, "[division by zero, 3]"},
+		{`assert_error(fn() { assert_error(fn() { 1 / 0 }, "zero") })`, `assertion failed: expected an error, got "division by zero"`},
+		{`assert_eq(1, 2)`, "assertion failed: expected 2, got 1"},
+	})
+}
+
+func TestDeepCalls(t *testing.T) {
+	// More registers than the VM starts with
+	input := `
+		let down = fn(n) {
+			let a = n; let b = n; let c = n; let d = n; let e = n; let f = n; let g = n; let h = n;
+			let i = n; let j = n; let k = n; let l = n; let m = n; let o = n; let p = n; let q = n;
+			if (n == 0) { 0 } else { 1 + down(n - 1) }
+		};
+		down(1000)`
+
+	runVmTests(t, []vmTestCase{{input, "1000"}})
+
+	locals := []string{}
+	for i := range 200 {
+		locals = append(locals, fmt.Sprintf("let x%c%c = n;", 'a'+i/26, 'a'+i%26))
+	}
+
+	runVmTests(t, []vmTestCase{{
+		fmt.Sprintf("let f = fn(n) { %s if (n == 0) { 0 } else { f(n - 1) } }; f(1000)", strings.Join(locals, " ")),
+		fmt.Sprintf("stack overflow (size %d)", StackSize),
+	}})
+}
+
+func TestCallsWithoutAllocations(t *testing.T) {
+	allocations := func(n int) float64 {
+		bytecode := compile(t, fmt.Sprintf(`
+			let fibonacci = fn(x) { if (x < 2) { x } else { fibonacci(x - 1) + fibonacci(x - 2) } };
+			fibonacci(%d) == -fibonacci(%d) * -1`, n, n))
+
+		return testing.AllocsPerRun(10, func() {
+			err := New(bytecode).Execute()
+			if err != nil {
+				t.Fatalf("runtime error: %s", err)
+			}
+		})
+	}
+
+	if few, many := allocations(1), allocations(12); few != many {
+		t.Errorf("calls allocate, %v allocations for fibonacci(1) but %v for fibonacci(12)", few, many)
+	}
+}
+
+func TestModules(t *testing.T) {
+	files := map[string]string{
+		"lib/math.mk": `
+			let square = fn(x) { x * x };
+			export let pi = 3;
+			export let cube = fn(x) { x * square(x) };`,
+		"lib/geometry.mk": `
+			import "math.mk" as math;
+			export let area = fn(r) { math.pi * r * r };`,
+	}
+
+	tests := []vmTestCase{
+		{`import "lib/math.mk" as math; math.cube(2) + math.pi`, "11"},
+		{`let square = 5; import "lib/math.mk" as m; square + m.cube(2)`, "13"},
+		{`import "lib/geometry.mk" as g; import "lib/math.mk" as m; g.area(2) + m.pi`, "15"},
+		{`import "lib/math.mk" as m; let f = fn() { fn() { m.pi } }; f()()`, "3"},
+	}
+
+	for _, tt := range tests {
+		c := NewCompiler()
+		c.Loader.ReadFile = func(name string) ([]byte, error) {
+			source, ok := files[name]
+			if !ok {
+				return nil, fmt.Errorf("open %s: no such file", name)
+			}
+			return []byte(source), nil
+		}
+
+		err := c.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(c.Bytecode())
+		err = vm.Execute()
+		if err != nil {
+			t.Fatalf("runtime error: %s", err)
+		}
+
+		if vm.Result().Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. expected=%s, got=%s", tt.input, tt.expected, vm.Result().Inspect())
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	var out bytes.Buffer
+
+	vm := New(compile(t, `let greet = fn(name) { puts("hello " + name) }; greet("monkey");`))
+	vm.SetContext(&object.Context{Stdout: &out})
+
+	err := vm.Execute()
+	if err != nil {
+		t.Fatalf("runtime error: %s", err)
+	}
+
+	if out.String() != "hello monkey\n" {
+		t.Errorf("wrong output %q", out.String())
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"monkey/ast"
 	"monkey/compiler"
+	"monkey/evaluator"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
@@ -20,6 +22,13 @@ func Start(in io.Reader, out io.Writer) {
 	globals := &[vm.GlobalsSize]object.Object{}
 	symbolTable := compiler.NewSymbolTable()
 
+	// Scripts print between the results, read_line would compete with the prompt
+	context := &object.Context{Stdout: out, Stderr: out}
+
+	// Macros print like the rest of the script
+	macroEnv := object.NewEnvironment()
+	macroEnv.SetContext(context)
+
 	for i, value := range object.Builtins {
 		symbolTable.DefineBuiltin(i, value.Name)
 	}
@@ -46,8 +55,19 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
+		evaluator.DefineMacros(program, macroEnv)
+		if len(program.Statements) == 0 {
+			continue
+		}
+
+		expanded, err := evaluator.ExpandMacros(program, macroEnv)
+		if err != nil {
+			fmt.Fprintf(out, "Macro expansion failed:\n%s\n", err)
+			continue
+		}
+
 		c := compiler.NewWithState(constants, symbolTable)
-		err := c.Compile(program)
+		err = c.Compile(expanded.(*ast.Program))
 		if err != nil {
 			fmt.Fprintf(out, "Compilation failed:\n%s\n", err)
 			continue
@@ -57,6 +77,7 @@ func Start(in io.Reader, out io.Writer) {
 
 		// Don't we have to yeet over the stack? Is that not part of a VM's state?
 		machine := vm.NewWithState(c.Bytecode(), globals)
+		machine.SetContext(context)
 		err = machine.Execute()
 		if err != nil {
 			fmt.Fprintf(out, "Execution failed:\n%s\n", err)
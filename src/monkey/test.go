@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"monkey/coverage"
+	"monkey/testrunner"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+func testFiles(arguments []string) {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	format := flags.String("format", "text", "report as text, tap or junit")
+	verbose := flags.Bool("v", false, "list the tests that passed too, in the text format")
+	cover := flags.Bool("cover", false, "report which lines and branches of the scripts ran")
+	profile := flags.String("coverprofile", "", "write the coverage as LCOV to this file, implies -cover")
+	page := flags.String("coverhtml", "", "write the coverage as annotated source to this HTML file, implies -cover")
+	flags.Parse(arguments)
+
+	if flags.NArg() == 0 {
+		fmt.Fprint(os.Stderr, USAGE)
+		os.Exit(2)
+	}
+
+	var reporter testrunner.Reporter
+	switch *format {
+	case "text":
+		reporter = testrunner.NewTextReporter(os.Stdout, *verbose)
+	case "tap":
+		reporter = testrunner.NewTAPReporter(os.Stdout)
+	case "junit":
+		reporter = testrunner.NewJUnitReporter(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "test: unknown format %q, expected text, tap or junit\n", *format)
+		os.Exit(2)
+	}
+
+	filenames, err := testFilenames(flags.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "test: %s\n", err)
+		os.Exit(1)
+	}
+
+	covering := *cover || *profile != "" || *page != ""
+	profiles := []*coverage.Profile{}
+	failed := false
+
+	for _, filename := range filenames {
+		source := readSource(filename)
+
+		var suite *testrunner.Suite
+
+		bytecode, err := compileFile(filename, source)
+		if err != nil {
+			suite = testrunner.Broken(filename, err)
+		} else {
+			var p *coverage.Profile
+			if covering {
+				p = coverage.New(bytecode, filename, source)
+				profiles = append(profiles, p)
+			}
+
+			suite = testrunner.Run(filename, bytecode, p)
+		}
+
+		if !suite.Passed() {
+			failed = true
+		}
+
+		err = reporter.Report(suite)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "test: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	err = reporter.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "test: %s\n", err)
+		os.Exit(1)
+	}
+
+	if *profile != "" {
+		err = writeCoverage(*profile, profiles, coverage.WriteLCOV)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "test: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *page != "" {
+		err = writeCoverage(*page, profiles, coverage.WriteHTML)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "test: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// Files are taken as they are, directories for the *_test.mk files in them
+func testFilenames(paths []string) ([]string, error) {
+	result := []string{}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			result = append(result, path)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(path, "*_test.mk"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+
+		result = append(result, matches...)
+	}
+
+	return result, nil
+}
+
+func writeCoverage(filename string, profiles []*coverage.Profile, write func(io.Writer, ...*coverage.Profile) error) error {
+	out, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = write(out, profiles...)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,271 @@
+package testrunner
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Reports suites as they finish, some formats only once all of them have
+type Reporter interface {
+	Report(suite *Suite) error
+	Close() error
+}
+
+// Where a frame was, with the file it's in, filename unless it was imported
+func (f Frame) Location(filename string) string {
+	if f.File != "" {
+		filename = f.File
+	}
+
+	if !f.Position.IsValid() {
+		return filename
+	}
+
+	return fmt.Sprintf("%s:%s", filename, f.Position)
+}
+
+func (f Frame) String(filename string) string {
+	return fmt.Sprintf("at %s (%s)", f.Function, f.Location(filename))
+}
+
+func seconds(duration time.Duration) string {
+	return fmt.Sprintf("%.3fs", duration.Seconds())
+}
+
+// Like go test, a line per script, and what went wrong in failed tests.
+// Verbose also lists the tests that passed.
+type textReporter struct {
+	w       io.Writer
+	verbose bool
+}
+
+func NewTextReporter(w io.Writer, verbose bool) Reporter {
+	return &textReporter{w: w, verbose: verbose}
+}
+
+func (r *textReporter) Report(suite *Suite) error {
+	for _, result := range suite.Results {
+		if result.Passed() {
+			if r.verbose {
+				fmt.Fprintf(r.w, "--- PASS: %s (%s)\n", result.Name, seconds(result.Duration))
+			}
+			continue
+		}
+
+		fmt.Fprintf(r.w, "--- FAIL: %s (%s)\n", result.Name, seconds(result.Duration))
+
+		// Compile errors say where they are themselves
+		if len(result.Trace) == 0 {
+			fmt.Fprintf(r.w, "    %s\n", result.Message)
+		} else {
+			fmt.Fprintf(r.w, "    %s: %s\n", result.Trace[0].Location(suite.Filename), result.Message)
+		}
+
+		for _, frame := range result.Trace {
+			fmt.Fprintf(r.w, "        %s\n", frame.String(suite.Filename))
+		}
+
+		if result.Output != "" {
+			fmt.Fprintln(r.w, "    output:")
+			fmt.Fprint(r.w, indent(result.Output, "        "))
+		}
+	}
+
+	status := "ok"
+	if !suite.Passed() {
+		status = "FAIL"
+	}
+
+	fmt.Fprintf(r.w, "%s\t%s\t%s", status, suite.Filename, seconds(suite.Duration))
+	if suite.Coverage != nil {
+		fmt.Fprintf(r.w, "\tcoverage: %s", suite.Coverage.Summary())
+	}
+	_, err := fmt.Fprintln(r.w)
+
+	return err
+}
+
+func (r *textReporter) Close() error {
+	return nil
+}
+
+// The Test Anything Protocol, version 13, with failures described in YAML
+type tapReporter struct {
+	w     io.Writer
+	count int
+}
+
+func NewTAPReporter(w io.Writer) Reporter {
+	fmt.Fprintln(w, "TAP version 13")
+	return &tapReporter{w: w}
+}
+
+func (r *tapReporter) Report(suite *Suite) error {
+	for _, result := range suite.Results {
+		r.count++
+
+		description := suite.Filename
+		if result.Name != suite.Filename {
+			description += " " + result.Name
+		}
+
+		if result.Passed() {
+			fmt.Fprintf(r.w, "ok %d - %s\n", r.count, description)
+			continue
+		}
+
+		fmt.Fprintf(r.w, "not ok %d - %s\n", r.count, description)
+		fmt.Fprintln(r.w, "  ---")
+		fmt.Fprintf(r.w, "  message: %s\n", yamlString(result.Message))
+
+		if len(result.Trace) > 0 {
+			fmt.Fprintf(r.w, "  at: %s\n", yamlString(result.Trace[0].Location(suite.Filename)))
+
+			fmt.Fprintln(r.w, "  stack: |")
+			for _, frame := range result.Trace {
+				fmt.Fprintf(r.w, "    %s\n", frame.String(suite.Filename))
+			}
+		}
+
+		if result.Output != "" {
+			fmt.Fprintln(r.w, "  output: |")
+			fmt.Fprint(r.w, indent(result.Output, "    "))
+		}
+
+		fmt.Fprintln(r.w, "  ...")
+	}
+
+	if suite.Coverage != nil {
+		fmt.Fprintf(r.w, "# coverage of %s: %s\n", suite.Filename, suite.Coverage.Summary())
+	}
+
+	return nil
+}
+
+// The plan goes last, the number of tests isn't known before they've run
+func (r *tapReporter) Close() error {
+	_, err := fmt.Fprintf(r.w, "1..%d\n", r.count)
+	return err
+}
+
+// JSON strings are valid YAML
+func yamlString(s string) string {
+	encoded, _ := json.Marshal(s)
+	return string(encoded)
+}
+
+func indent(text string, prefix string) string {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix) + "\n"
+}
+
+// JUnit XML as CI servers read it, written once every suite has run.
+// Failed assertions are failures, other runtime errors errors.
+type junitReporter struct {
+	w      io.Writer
+	suites junitSuites
+	total  time.Duration
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	Output    string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Trace   string `xml:",chardata"`
+}
+
+func NewJUnitReporter(w io.Writer) Reporter {
+	return &junitReporter{w: w}
+}
+
+func (r *junitReporter) Report(suite *Suite) error {
+	junit := junitSuite{Name: suite.Filename, Tests: len(suite.Results), Time: junitTime(suite.Duration)}
+
+	for _, result := range suite.Results {
+		testCase := junitCase{
+			Name:      result.Name,
+			Classname: suite.Filename,
+			Time:      junitTime(result.Duration),
+			Output:    result.Output,
+		}
+
+		if !result.Passed() {
+			trace := []string{}
+			for _, frame := range result.Trace {
+				trace = append(trace, frame.String(suite.Filename))
+			}
+
+			failure := &junitFailure{Message: result.Message, Trace: strings.Join(trace, "\n")}
+			if result.AssertionFailed() {
+				failure.Type = "assertion"
+				testCase.Failure = failure
+				junit.Failures++
+			} else {
+				failure.Type = "error"
+				testCase.Error = failure
+				junit.Errors++
+			}
+		}
+
+		junit.Cases = append(junit.Cases, testCase)
+	}
+
+	r.suites.Suites = append(r.suites.Suites, junit)
+	r.suites.Tests += junit.Tests
+	r.suites.Failures += junit.Failures
+	r.suites.Errors += junit.Errors
+	r.total += suite.Duration
+
+	return nil
+}
+
+func (r *junitReporter) Close() error {
+	r.suites.Time = junitTime(r.total)
+
+	fmt.Fprint(r.w, xml.Header)
+
+	encoder := xml.NewEncoder(r.w)
+	encoder.Indent("", "  ")
+	err := encoder.Encode(r.suites)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(r.w)
+	return err
+}
+
+// In seconds, without a unit
+func junitTime(duration time.Duration) string {
+	return fmt.Sprintf("%.3f", duration.Seconds())
+}
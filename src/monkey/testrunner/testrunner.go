@@ -0,0 +1,217 @@
+// Runs the test functions of Monkey scripts, each in a VM of its own
+package testrunner
+
+import (
+	"bytes"
+	"monkey/compiler"
+	"monkey/coverage"
+	"monkey/object"
+	"monkey/token"
+	"monkey/vm"
+	"strings"
+	"time"
+)
+
+// Test functions are the top level functions whose names start with this
+const Prefix = "test_"
+
+// The tests of one script and how they went
+type Suite struct {
+	Filename string
+	Results  []*Result
+	Duration time.Duration
+
+	Coverage *coverage.Profile // nil unless coverage is recorded
+}
+
+type Result struct {
+	Name     string // Of the test function, or the script's for the script itself
+	Message  string // Of the runtime error failing the test, empty if it passed
+	Trace    []Frame
+	Output   string // Written by puts and the like
+	Duration time.Duration
+}
+
+// A function on the call stack when a test failed
+type Frame struct {
+	Function string
+	File     string // Of the imported file the frame was in, empty for the script's own
+	Position token.Position
+}
+
+func (r *Result) Passed() bool {
+	return r.Message == ""
+}
+
+// As opposed to any other runtime error
+func (r *Result) AssertionFailed() bool {
+	return strings.HasPrefix(r.Message, "assertion failed")
+}
+
+func (s *Suite) Passed() bool {
+	for _, result := range s.Results {
+		if !result.Passed() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// A script that couldn't be compiled, as a single failed test
+func Broken(filename string, err error) *Suite {
+	return &Suite{
+		Filename: filename,
+		Results:  []*Result{{Name: filename, Message: err.Error()}},
+	}
+}
+
+// Runs the script's top level, then each test function in a new VM that has
+// run the top level too, so tests can't affect each other. A script without
+// test functions is a test of its own, passing if it runs without errors.
+// With a profile, every VM's execution is added to its coverage.
+func Run(filename string, bytecode *compiler.Bytecode, profile *coverage.Profile) *Suite {
+	suite := &Suite{Filename: filename, Coverage: profile}
+	start := time.Now()
+	defer func() { suite.Duration = time.Since(start) }()
+
+	setup := newTest(filename, bytecode, profile)
+	if !setup.run() {
+		suite.Results = append(suite.Results, setup.result)
+		return suite
+	}
+
+	tests := setup.testNames()
+	if len(tests) == 0 {
+		suite.Results = append(suite.Results, setup.result)
+		return suite
+	}
+
+	for _, name := range tests {
+		test := newTest(name, bytecode, profile)
+		if test.run() {
+			test.runFunction(name)
+		}
+
+		suite.Results = append(suite.Results, test.result)
+	}
+
+	return suite
+}
+
+type test struct {
+	machine     *vm.VM
+	globalNames []string
+	profile     *coverage.Profile
+
+	calling bool // The top level has run, and the test function was called
+
+	output bytes.Buffer
+	start  time.Time
+	result *Result
+}
+
+func newTest(name string, bytecode *compiler.Bytecode, profile *coverage.Profile) *test {
+	machine := vm.New(bytecode)
+	t := &test{
+		machine:     &machine,
+		globalNames: bytecode.GlobalNames,
+		profile:     profile,
+		start:       time.Now(),
+		result:      &Result{Name: name},
+	}
+
+	context := object.NewContext()
+	context.Stdout = &t.output
+	context.Stderr = &t.output
+	t.machine.SetContext(context)
+
+	return t
+}
+
+func (t *test) runFunction(name string) {
+	t.calling = true
+
+	err := t.machine.PushCall(t.global(name))
+	if err != nil {
+		t.fail(err)
+		return
+	}
+
+	t.run()
+}
+
+// Whether it ran without errors
+func (t *test) run() bool {
+	var err error
+	if t.profile != nil {
+		err = t.profile.Run(t.machine)
+	} else {
+		err = t.machine.Execute()
+	}
+
+	if err != nil {
+		t.fail(err)
+		return false
+	}
+
+	t.result.Output = t.output.String()
+	t.result.Duration = time.Since(t.start)
+	return true
+}
+
+// The stack is traced innermost frame first. Frames have moved past the
+// instruction they're on, the failing one or a call.
+func (t *test) fail(err error) {
+	t.result.Message = err.Error()
+	t.result.Output = t.output.String()
+	t.result.Duration = time.Since(t.start)
+
+	frames := t.machine.Frames()
+	for i := len(frames) - 1; i >= 0; i-- {
+		name := frames[i].Function().Name
+		switch {
+		case i == 0 && t.calling:
+			// Finished, it's where the test function was called from
+			continue
+		case i == 0:
+			name = "main"
+		case name == "":
+			name = "anonymous"
+		}
+
+		t.result.Trace = append(t.result.Trace, Frame{
+			Function: name,
+			File:     frames[i].CallFile(),
+			Position: frames[i].CallPosition(),
+		})
+	}
+}
+
+// Functions named like tests, in the order they're defined. Imported
+// modules' globals are named path:name, and aren't tests of this script.
+func (t *test) testNames() []string {
+	result := []string{}
+
+	for _, name := range t.globalNames {
+		if !strings.HasPrefix(name, Prefix) || strings.Contains(name, ":") {
+			continue
+		}
+
+		if function := t.global(name); function != nil && function.Type() == object.FUNCTION_OBJ {
+			result = append(result, name)
+		}
+	}
+
+	return result
+}
+
+func (t *test) global(name string) object.Object {
+	for index, globalName := range t.globalNames {
+		if globalName == name {
+			return t.machine.Global(index)
+		}
+	}
+
+	return nil
+}
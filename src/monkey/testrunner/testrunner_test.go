@@ -0,0 +1,299 @@
+package testrunner
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io/fs"
+	"monkey/compiler"
+	"monkey/coverage"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/token"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const tests = `let add = fn(a, b) { a + b };
+let test_data = [1, 2];
+
+let test_add = fn() {
+  puts("adding");
+  assert_eq(add(2, 2), 4);
+};
+
+let test_broken = fn() {
+  puts("about to fail");
+  assert_eq(add(2, 2), 5, "sums");
+};
+
+let test_error = fn() {
+  let divide = fn(a, b) { a / b };
+  assert_error(fn() { divide(1, 0) }, "zero");
+  divide(1, 0);
+};
+`
+
+func run(t *testing.T, input string, profile bool) *Suite {
+	t.Helper()
+
+	c := compiler.New()
+	err := c.Compile(parser.New(lexer.New(input)).ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var p *coverage.Profile
+	if profile {
+		p = coverage.New(c.Bytecode(), "math_test.mk", input)
+	}
+
+	return Run("math_test.mk", c.Bytecode(), p)
+}
+
+func TestRun(t *testing.T) {
+	suite := run(t, tests, false)
+
+	expected := []Result{
+		{Name: "test_add", Output: "adding\n"},
+		{
+			Name:    "test_broken",
+			Message: "assertion failed: sums: expected 5, got 4",
+			Trace:   []Frame{{"test_broken", "", token.Position{Line: 11, Column: 3}}},
+			Output:  "about to fail\n",
+		},
+		{
+			Name:    "test_error",
+			Message: "division by zero",
+			Trace: []Frame{
+				{"divide", "", token.Position{Line: 15, Column: 27}},
+				{"test_error", "", token.Position{Line: 17, Column: 3}},
+			},
+		},
+	}
+
+	if len(suite.Results) != len(expected) {
+		t.Fatalf("wrong number of results. expected=%d, got=%d", len(expected), len(suite.Results))
+	}
+
+	for i, result := range suite.Results {
+		testResult(t, expected[i], result)
+	}
+
+	if suite.Passed() {
+		t.Errorf("suite passed with failing tests")
+	}
+
+	if !suite.Results[1].AssertionFailed() || suite.Results[2].AssertionFailed() {
+		t.Errorf("assertions told apart from errors wrongly")
+	}
+}
+
+func TestRunWithoutTests(t *testing.T) {
+	suite := run(t, `puts("ran"); let test_value = 1;`, false)
+	testResult(t, Result{Name: "math_test.mk", Output: "ran\n"}, suite.Results[0])
+
+	suite = run(t, `let test_never = fn() { 1 }; let x = fn() { 1 + true }; x();`, false)
+	if len(suite.Results) != 1 {
+		t.Fatalf("tests ran after the top level failed, got %d results", len(suite.Results))
+	}
+
+	testResult(t, Result{
+		Name:    "math_test.mk",
+		Message: "type mismatch: INTEGER + BOOLEAN",
+		Trace: []Frame{
+			{"x", "", token.Position{Line: 1, Column: 45}},
+			{"main", "", token.Position{Line: 1, Column: 57}},
+		},
+	}, suite.Results[0])
+}
+
+func TestRunIsolated(t *testing.T) {
+	// Each test runs the top level in a VM of its own
+	suite := run(t, `
+		puts("top level");
+		let test_one = fn() { puts("one") };
+		let test_two = fn() { puts("two") };`, false)
+
+	testResult(t, Result{Name: "test_one", Output: "top level\none\n"}, suite.Results[0])
+	testResult(t, Result{Name: "test_two", Output: "top level\ntwo\n"}, suite.Results[1])
+}
+
+func TestRunCoverage(t *testing.T) {
+	suite := run(t, tests, true)
+
+	// divide only runs in test_error, the first time through assert_error
+	for _, line := range suite.Coverage.Files[0].Lines() {
+		if line.Number == 15 && line.Hits == 0 {
+			t.Errorf("calls made by assert_error weren't covered")
+		}
+	}
+
+	hit, found := suite.Coverage.LineCoverage()
+	if hit != found {
+		t.Errorf("expected every line to run, got %d/%d", hit, found)
+	}
+}
+
+func TestRunImportedHelper(t *testing.T) {
+	c := compiler.New()
+	c.Loader.ReadFile = func(name string) ([]byte, error) {
+		if name != "lib2.mk" {
+			return nil, fs.ErrNotExist
+		}
+
+		return []byte("export let check = fn(x) {\n  assert_eq(x, 2);\n};"), nil
+	}
+
+	input := "import \"lib2.mk\" as other;\n\nlet test_check = fn() {\n  other.check(1);\n};"
+	err := c.Compile(parser.New(lexer.New(input)).ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	suite := Run("imp_test.mk", c.Bytecode(), nil)
+
+	testResult(t, Result{
+		Name:    "test_check",
+		Message: "assertion failed: expected 2, got 1",
+		Trace: []Frame{
+			{"check", "lib2.mk", token.Position{Line: 2, Column: 3}},
+			{"test_check", "", token.Position{Line: 4, Column: 3}},
+		},
+	}, suite.Results[0])
+
+	var out bytes.Buffer
+	tap := report(t, NewTAPReporter(&out), &out, suite)
+	if !strings.Contains(tap, `at: "lib2.mk:2:3"`) || !strings.Contains(tap, "at test_check (imp_test.mk:4:3)") {
+		t.Errorf("wrong files in report:\n%s", tap)
+	}
+}
+
+func testResult(t *testing.T, expected Result, result *Result) {
+	t.Helper()
+
+	if result.Name != expected.Name || result.Message != expected.Message || result.Output != expected.Output {
+		t.Errorf("wrong result. expected=%+v, got=%+v", expected, *result)
+	}
+
+	if len(result.Trace) != len(expected.Trace) {
+		t.Fatalf("wrong trace for %s. expected=%v, got=%v", expected.Name, expected.Trace, result.Trace)
+	}
+
+	for i, frame := range result.Trace {
+		if frame != expected.Trace[i] {
+			t.Errorf("wrong frame %d for %s. expected=%v, got=%v", i, expected.Name, expected.Trace[i], frame)
+		}
+	}
+}
+
+var durations = regexp.MustCompile(`\d+\.\d{3}s?`)
+
+func report(t *testing.T, reporter Reporter, out *bytes.Buffer, suites ...*Suite) string {
+	t.Helper()
+
+	for _, suite := range suites {
+		err := reporter.Report(suite)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := reporter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return durations.ReplaceAllString(out.String(), "T")
+}
+
+func TestTextReporter(t *testing.T) {
+	var out bytes.Buffer
+	text := report(t, NewTextReporter(&out, true), &out, run(t, tests, false))
+
+	expected := `--- PASS: test_add (T)
+--- FAIL: test_broken (T)
+    math_test.mk:11:3: assertion failed: sums: expected 5, got 4
+        at test_broken (math_test.mk:11:3)
+    output:
+        about to fail
+--- FAIL: test_error (T)
+    math_test.mk:15:27: division by zero
+        at divide (math_test.mk:15:27)
+        at test_error (math_test.mk:17:3)
+FAIL	math_test.mk	T
+`
+	if text != expected {
+		t.Errorf("wrong report. expected=\n%s\ngot=\n%s", expected, text)
+	}
+}
+
+func TestTAPReporter(t *testing.T) {
+	var out bytes.Buffer
+	broken := Broken("broken_test.mk", errors.New("broken_test.mk:1:5: expected next token to be =, got ; instead"))
+	tap := report(t, NewTAPReporter(&out), &out, run(t, tests, false), broken)
+
+	expected := `TAP version 13
+ok 1 - math_test.mk test_add
+not ok 2 - math_test.mk test_broken
+  ---
+  message: "assertion failed: sums: expected 5, got 4"
+  at: "math_test.mk:11:3"
+  stack: |
+    at test_broken (math_test.mk:11:3)
+  output: |
+    about to fail
+  ...
+not ok 3 - math_test.mk test_error
+  ---
+  message: "division by zero"
+  at: "math_test.mk:15:27"
+  stack: |
+    at divide (math_test.mk:15:27)
+    at test_error (math_test.mk:17:3)
+  ...
+not ok 4 - broken_test.mk
+  ---
+  message: "broken_test.mk:1:5: expected next token to be =, got ; instead"
+  ...
+1..4
+`
+	if tap != expected {
+		t.Errorf("wrong report. expected=\n%s\ngot=\n%s", expected, tap)
+	}
+}
+
+func TestJUnitReporter(t *testing.T) {
+	var out bytes.Buffer
+	report(t, NewJUnitReporter(&out), &out, run(t, tests, false))
+
+	if !strings.HasPrefix(out.String(), xml.Header) {
+		t.Errorf("no XML header")
+	}
+
+	var parsed junitSuites
+	err := xml.Unmarshal(out.Bytes(), &parsed)
+	if err != nil {
+		t.Fatalf("invalid XML: %s", err)
+	}
+
+	if parsed.Tests != 3 || parsed.Failures != 1 || parsed.Errors != 1 || len(parsed.Suites) != 1 {
+		t.Fatalf("wrong totals: %+v", parsed)
+	}
+
+	cases := parsed.Suites[0].Cases
+	if cases[0].Failure != nil || cases[0].Error != nil || cases[0].Output != "adding\n" {
+		t.Errorf("wrong passing test case: %+v", cases[0])
+	}
+
+	failure := cases[1].Failure
+	if failure == nil || failure.Type != "assertion" || failure.Trace != "at test_broken (math_test.mk:11:3)" {
+		t.Errorf("wrong failed test case: %+v", cases[1])
+	}
+
+	errored := cases[2].Error
+	if errored == nil || errored.Message != "division by zero" || errored.Trace != "at divide (math_test.mk:15:27)\nat test_error (math_test.mk:17:3)" {
+		t.Errorf("wrong test case with an error: %+v", cases[2])
+	}
+}
@@ -1,10 +1,16 @@
 package token
 
+import (
+	"fmt"
+	"strings"
+)
+
 type TokenType string
 
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	COMMENT = "COMMENT" // Never returned by the lexer, see Lexer.Comments
 
 	// Identifiers + literals
 	IDENT  = "IDENT"  // add, foobar, x, y, ...
@@ -29,6 +35,7 @@ const (
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
+	DOT       = "."
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -45,11 +52,49 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	MACRO    = "MACRO"
+	IMPORT   = "IMPORT"
+	AS       = "AS"
+	EXPORT   = "EXPORT"
 )
 
+// Where a token starts in the source, both one-based
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// A zero position means the token wasn't produced by the lexer
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
 type Token struct {
-	Type    TokenType
-	Literal string
+	Type     TokenType
+	Literal  string
+	Position Position
+}
+
+// Position just past the token's source text
+func (t Token) End() Position {
+	text := t.Literal
+	if t.Type == STRING {
+		text = `"` + text + `"`
+	}
+
+	lastNewline := strings.LastIndexByte(text, '\n')
+	if lastNewline < 0 {
+		return Position{Line: t.Position.Line, Column: t.Position.Column + len(text)}
+	}
+
+	return Position{
+		Line:   t.Position.Line + strings.Count(text, "\n"),
+		Column: len(text) - lastNewline,
+	}
 }
 
 var keywords = map[string]TokenType{
@@ -60,6 +105,10 @@ var keywords = map[string]TokenType{
 	"if":     IF,
 	"else":   ELSE,
 	"return": RETURN,
+	"macro":  MACRO,
+	"import": IMPORT,
+	"as":     AS,
+	"export": EXPORT,
 }
 
 func LookupIdent(ident string) TokenType {
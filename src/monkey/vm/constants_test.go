@@ -0,0 +1,135 @@
+package vm
+
+import (
+	"monkey/compiler"
+	"monkey/object"
+	"sync"
+	"testing"
+)
+
+// Programs whose constants the VM could be tempted to change in place
+var constantPrograms = []vmTestCase{
+	{"-5", -5},
+	{"let f = fn() { -5 }; f() + f()", -10},
+	{"let negate = fn(x) { -x }; negate(5) + negate(negate(5))", 0},
+	{"let x = 3; -x + -x + x", -3},
+	{"let count = fn(n) { if (n > 0) { -1 + count(n - 1) } else { 0 } }; count(10)", -10},
+	{`let a = [1, -2]; let b = push(a, 3); len(a) + len(b) + -a[1]`, 7},
+	{`let h = {"k": -1}; -h["k"] + -h["k"]`, 2},
+}
+
+func TestConstantsAreNotChanged(t *testing.T) {
+	for _, tt := range constantPrograms {
+		bytecode := compileForTest(t, tt.input)
+		before := inspectConstants(bytecode.Constants)
+
+		// The same bytecode gives the same result however often it runs
+		for range 20 {
+			vm := New(bytecode)
+			err := vm.Execute()
+			if err != nil {
+				t.Fatalf("Failed to execute: %s\n", err)
+			}
+
+			testExpectedObject(t, tt.expected, vm.LastStackTop())
+		}
+
+		after := inspectConstants(bytecode.Constants)
+		for i := range before {
+			if before[i] != after[i] {
+				t.Errorf("%q changed constant %d from %s to %s", tt.input, i, before[i], after[i])
+			}
+		}
+	}
+}
+
+func TestConcurrentVMsShareConstants(t *testing.T) {
+	for _, tt := range constantPrograms {
+		bytecode := compileForTest(t, tt.input)
+
+		results := make([]object.Object, 16)
+
+		var wait sync.WaitGroup
+		for i := range results {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+
+				for range 20 {
+					vm := New(bytecode)
+					err := vm.Execute()
+					if err != nil {
+						t.Errorf("Failed to execute: %s\n", err)
+						return
+					}
+
+					results[i] = vm.LastStackTop()
+				}
+			}()
+		}
+		wait.Wait()
+
+		for _, result := range results {
+			testExpectedObject(t, tt.expected, result)
+		}
+	}
+}
+
+// Like the REPL, which keeps its constants and globals between lines
+func TestReplStateIsNotChanged(t *testing.T) {
+	globals := &[GlobalsSize]object.Object{}
+	constants := []object.Object{}
+	symbols := compiler.NewSymbolTable()
+	for i, value := range object.Builtins {
+		symbols.DefineBuiltin(i, value.Name)
+	}
+
+	lines := []vmTestCase{
+		{"let minus = fn() { -7 };", nil},
+		{"minus()", -7},
+		{"minus()", -7},
+		{"minus() + minus()", -14},
+		{"-minus()", 7},
+		{"minus()", -7},
+	}
+
+	for _, line := range lines {
+		c := compiler.NewWithState(constants, symbols)
+		err := c.Compile(parse(line.input))
+		if err != nil {
+			t.Fatalf("Failed to compile: %s\n", err)
+		}
+		constants = c.Bytecode().Constants
+
+		vm := NewWithState(c.Bytecode(), globals)
+		err = vm.Execute()
+		if err != nil {
+			t.Fatalf("Failed to execute: %s\n", err)
+		}
+
+		if line.expected != nil {
+			testExpectedObject(t, line.expected, vm.LastStackTop())
+		}
+	}
+}
+
+func compileForTest(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	c := compiler.New()
+	err := c.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("Failed to compile: %s\n", err)
+	}
+
+	return c.Bytecode()
+}
+
+func inspectConstants(constants []object.Object) []string {
+	inspected := make([]string, len(constants))
+	for i, constant := range constants {
+		inspected[i] = constant.Inspect()
+	}
+
+	return inspected
+}
@@ -3,6 +3,7 @@ package vm
 import (
 	"monkey/object"
 	"monkey/opcode"
+	"monkey/token"
 )
 
 type Frame struct {
@@ -19,6 +20,46 @@ func NewFrame(closure *object.Closure, returnPointer int) *Frame {
 	}
 }
 
+// As executed, so binary operations may have been quickened
 func (frame *Frame) Instructions() *opcode.Instructions {
-	return &frame.closure.Function.Instructions
+	return &frame.closure.Instructions
+}
+
+func (frame *Frame) Closure() *object.Closure {
+	return frame.closure
+}
+
+func (frame *Frame) Function() *object.CompiledFunction {
+	return frame.closure.Function
+}
+
+// Offset of the next instruction to execute
+func (frame *Frame) InstructionPointer() int {
+	return frame.instructionPointer
+}
+
+// Source position of the next instruction to execute, in File
+func (frame *Frame) Position() token.Position {
+	_, position := frame.closure.Function.SourceFor(frame.instructionPointer)
+	return position
+}
+
+// The file the next instruction to execute was compiled from, empty for the main one
+func (frame *Frame) File() string {
+	file, _ := frame.closure.Function.SourceFor(frame.instructionPointer)
+	return file
+}
+
+// Source position of the call this frame is waiting on, in CallFile.
+// Only makes sense for frames that aren't the innermost one,
+// their instruction pointer has already moved past the OpCall.
+func (frame *Frame) CallPosition() token.Position {
+	_, position := frame.closure.Function.SourceFor(frame.instructionPointer - 1)
+	return position
+}
+
+// The file of the call this frame is waiting on, empty for the main one
+func (frame *Frame) CallFile() string {
+	file, _ := frame.closure.Function.SourceFor(frame.instructionPointer - 1)
+	return file
 }
@@ -0,0 +1,49 @@
+package vm
+
+import (
+	"io"
+	"io/fs"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"testing"
+)
+
+// Enough for the seeds to finish, fuzzed programs may well never finish
+const fuzzInstructionBudget = 100_000
+
+// Compiled programs run, or stop with an error, without panicking
+func FuzzExecute(f *testing.F) {
+	f.Add(`let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(10)`)
+	f.Add(`let f = fn() { f() }; f()`)
+	f.Add(`if (false) { let x = 1 }; -x`)
+	f.Add(`let h = {"a": [1, 2]}; h["a"][1] / 0`)
+	f.Add(`puts(len("abc"), first([]), rest([1]), json_parse("[1]"), 1 + true)`)
+	f.Add(`let x = fn(a) { a }; x(1, 2) + x`)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			return
+		}
+
+		c := compiler.New()
+		c.Loader.ReadFile = func(name string) ([]byte, error) { return nil, fs.ErrNotExist }
+
+		err := c.Compile(program)
+		if err != nil {
+			return
+		}
+
+		vm := New(c.Bytecode())
+		vm.SetContext(&object.Context{Stdout: io.Discard, Stderr: io.Discard})
+
+		for range fuzzInstructionBudget {
+			if vm.Finished() || vm.Step() != nil {
+				return
+			}
+		}
+	})
+}
@@ -2,19 +2,21 @@ package vm
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"monkey/compiler"
 	"monkey/object"
 	"monkey/opcode"
+	"slices"
 )
 
 const StackSize = 2048
 const GlobalsSize = 65536 // Matching sixteen-bit operand of OpSetGlobal/OpGetGlobal
 const MaxFrames = 1024
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
-var Null = &object.Null{}
+var True = object.True
+var False = object.False
+var Null = object.Nil
 
 func toBoolObject(b bool) *object.Boolean {
 	if b {
@@ -30,20 +32,33 @@ type VM struct {
 	stack        [StackSize]object.Object
 	stackPointer int // Next *free* slot in the stack, i.e. current length
 
-	globals    *[GlobalsSize]object.Object
-	numGlobals int
+	globals     *[GlobalsSize]object.Object
+	numGlobals  int
+	globalNames []string // For errors about globals read before they're set
 
 	frames     [MaxFrames]*Frame
+	frameStore *[MaxFrames]Frame // Reused by calls as deep, so calls don't allocate
 	frameIndex int
+
+	instructions map[*object.CompiledFunction]opcode.Instructions // See instructionsOf
+
+	context        *object.Context // Where builtins do their IO
+	builtinContext *object.Context // context calling back with callFromBuiltin, made on the first builtin call
+	stepper        func() error    // See SetStepper
 }
 
 func New(bytecode *compiler.Bytecode) VM {
-	mainFunction := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFunction := &object.CompiledFunction{
+		Instructions:  bytecode.Instructions,
+		Lines:         bytecode.Lines,
+		ImportedLines: bytecode.ImportedLines,
+	}
 	mainClosure := &object.Closure{
 		Function:      mainFunction,
 		FreeVariables: []object.Object{},
+		Instructions:  slices.Clone(bytecode.Instructions),
 	}
-	mainFrame := NewFrame(mainClosure, 0)
+	frameStore := &[MaxFrames]Frame{0: {closure: mainClosure}}
 
 	return VM{
 		constants: bytecode.Constants,
@@ -51,21 +66,32 @@ func New(bytecode *compiler.Bytecode) VM {
 		stack:        [StackSize]object.Object{nil},
 		stackPointer: 0,
 
-		globals:    &[GlobalsSize]object.Object{nil},
-		numGlobals: 0,
+		globals:     &[GlobalsSize]object.Object{nil},
+		numGlobals:  0,
+		globalNames: bytecode.GlobalNames,
 
-		frames:     [MaxFrames]*Frame{0: mainFrame},
+		frames:     [MaxFrames]*Frame{0: &frameStore[0]},
+		frameStore: frameStore,
 		frameIndex: 0,
+
+		instructions: map[*object.CompiledFunction]opcode.Instructions{},
+
+		context: object.NewContext(),
 	}
 }
 
 func NewWithState(bytecode *compiler.Bytecode, state *[GlobalsSize]object.Object) VM {
-	mainFunction := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFunction := &object.CompiledFunction{
+		Instructions:  bytecode.Instructions,
+		Lines:         bytecode.Lines,
+		ImportedLines: bytecode.ImportedLines,
+	}
 	mainClosure := &object.Closure{
 		Function:      mainFunction,
 		FreeVariables: []object.Object{},
+		Instructions:  slices.Clone(bytecode.Instructions),
 	}
-	mainFrame := NewFrame(mainClosure, 0)
+	frameStore := &[MaxFrames]Frame{0: {closure: mainClosure}}
 
 	return VM{
 		constants: bytecode.Constants,
@@ -73,291 +99,461 @@ func NewWithState(bytecode *compiler.Bytecode, state *[GlobalsSize]object.Object
 		stack:        [StackSize]object.Object{},
 		stackPointer: 0,
 
-		globals:    state,
-		numGlobals: 0,
+		globals:     state,
+		numGlobals:  0,
+		globalNames: bytecode.GlobalNames,
 
-		frames:     [MaxFrames]*Frame{0: mainFrame},
+		frames:     [MaxFrames]*Frame{0: &frameStore[0]},
+		frameStore: frameStore,
 		frameIndex: 0,
+
+		instructions: map[*object.CompiledFunction]opcode.Instructions{},
+
+		context: object.NewContext(),
 	}
 }
 
+// Replaces the default context, writing to the process's stdout and stderr
+func (vm *VM) SetContext(context *object.Context) {
+	vm.context = context
+	vm.builtinContext = nil
+}
+
 func (vm *VM) Execute() error {
-	var instructionPointer int
-	var instructions opcode.Instructions
-	var operation opcode.OpCode
+	for !vm.Finished() {
+		err := vm.Step()
+		if err != nil {
+			return err
+		}
+	}
 
-	for vm.currentFrame().instructionPointer < len(*vm.currentFrame().Instructions()) {
-		instructionPointer = vm.currentFrame().instructionPointer
-		instructions = *vm.currentFrame().Instructions()
+	return nil
+}
 
-		// Determine current instruction, then increment
-		vm.currentFrame().instructionPointer++
+// Has the functions builtins call, like assert_error's, executed by step
+// instead of Step, so whatever drives the VM a step at a time sees them too.
+// nil goes back to Step.
+func (vm *VM) SetStepper(step func() error) {
+	vm.stepper = step
+}
 
-		// Fetch
-		operation = opcode.OpCode(instructions[instructionPointer])
+// Whether there are no more instructions to execute
+func (vm *VM) Finished() bool {
+	return vm.currentFrame().instructionPointer >= len(*vm.currentFrame().Instructions())
+}
 
-		// Decode & Execute
-		switch operation {
-		case opcode.OpGetConstant:
-			// Index of an OpConstant is two bytes wide
-			// Don't look up width using opcode.Lookup, that is a lot of operations,
-			// Hardcode that we know how big it is
-			index := binary.BigEndian.Uint16(instructions[instructionPointer+1:])
+// Executes a single instruction
+func (vm *VM) Step() error {
+	instructionPointer := vm.currentFrame().instructionPointer
+	instructions := *vm.currentFrame().Instructions()
 
-			err := vm.push(vm.constants[index])
-			if err != nil {
-				return err
-			}
+	// Determine current instruction, then increment
+	vm.currentFrame().instructionPointer++
 
-			vm.currentFrame().instructionPointer += 2
+	// Fetch
+	operation := opcode.OpCode(instructions[instructionPointer])
 
-		case opcode.OpPushTrue:
-			err := vm.push(True)
-			if err != nil {
-				return err
-			}
+	// Decode & Execute
+	switch operation {
+	case opcode.OpGetConstant:
+		// Index of an OpConstant is two bytes wide
+		// Don't look up width using opcode.Lookup, that is a lot of operations,
+		// Hardcode that we know how big it is
+		index := binary.BigEndian.Uint16(instructions[instructionPointer+1:])
+
+		err := vm.push(vm.constants[index])
+		if err != nil {
+			return err
+		}
 
-		case opcode.OpPushFalse:
-			err := vm.push(False)
-			if err != nil {
-				return err
-			}
+		vm.currentFrame().instructionPointer += 2
 
-		case opcode.OpPushNull:
-			err := vm.push(Null)
-			if err != nil {
-				return nil
-			}
+	case opcode.OpPushTrue:
+		err := vm.push(True)
+		if err != nil {
+			return err
+		}
 
-		case opcode.OpNegate:
-			err := vm.executeNegate()
-			if err != nil {
-				return nil
-			}
+	case opcode.OpPushFalse:
+		err := vm.push(False)
+		if err != nil {
+			return err
+		}
 
-		case opcode.OpLogicalNot:
-			err := vm.executeLogicalNot()
-			if err != nil {
-				return nil
-			}
+	case opcode.OpPushNull:
+		err := vm.push(Null)
+		if err != nil {
+			return err
+		}
 
-		case opcode.OpAdd, opcode.OpSubtract, opcode.OpMultiply, opcode.OpDivide,
-			opcode.OpEquals, opcode.OpNotEquals, opcode.OpGreaterThan:
-			err := vm.executeBinaryOperation(operation)
+	case opcode.OpNegate:
+		err := vm.executeNegate()
+		if err != nil {
+			return err
+		}
 
-			if err != nil {
-				return err
-			}
+	case opcode.OpLogicalNot:
+		err := vm.executeLogicalNot()
+		if err != nil {
+			return err
+		}
+
+	case opcode.OpAdd, opcode.OpSubtract, opcode.OpMultiply, opcode.OpDivide,
+		opcode.OpEquals, opcode.OpNotEquals, opcode.OpGreaterThan, opcode.OpLessThan:
+		// Integers are likely to be all it ever sees, they get the quickened version
+		if _, _, ok := vm.integerOperands(); ok {
+			instructions[instructionPointer] = byte(opcode.Quickened(operation))
+		}
+
+		err := vm.executeBinaryOperation(operation)
+
+		if err != nil {
+			return err
+		}
+
+	case opcode.OpAddInt, opcode.OpSubtractInt, opcode.OpMultiplyInt, opcode.OpDivideInt,
+		opcode.OpEqualsInt, opcode.OpNotEqualsInt, opcode.OpGreaterThanInt, opcode.OpLessThanInt:
+		operation = opcode.Generic(operation)
+
+		left, right, ok := vm.integerOperands()
+		if !ok {
+			// Something else after all, back to the version for any operands
+			instructions[instructionPointer] = byte(operation)
+
+			return vm.executeBinaryOperation(operation)
+		}
+
+		vm.stackPointer -= 2
+
+		err := vm.executeBinaryOperationInteger(operation, left, right)
+		if err != nil {
+			return err
+		}
+
+	case opcode.OpJump:
+		newPosition := int(binary.BigEndian.Uint16(instructions[instructionPointer+1:]))
 
-		case opcode.OpJump:
+		vm.currentFrame().instructionPointer = newPosition
+
+	case opcode.OpJumpNotTruthy:
+		condition := vm.pop()
+
+		if !object.IsTruthy(condition) {
 			newPosition := int(binary.BigEndian.Uint16(instructions[instructionPointer+1:]))
 
 			vm.currentFrame().instructionPointer = newPosition
+		} else {
+			// Skip jump target
+			vm.currentFrame().instructionPointer += 2
+		}
 
-		case opcode.OpJumpNotTruthy:
-			condition := vm.pop()
+	case opcode.OpSetGlobal:
+		index := int(binary.BigEndian.Uint16(instructions[instructionPointer+1:]))
 
-			if !isTruthy(condition) {
-				newPosition := int(binary.BigEndian.Uint16(instructions[instructionPointer+1:]))
+		vm.globals[index] = vm.pop()
 
-				vm.currentFrame().instructionPointer = newPosition
-			} else {
-				// Skip jump target
-				vm.currentFrame().instructionPointer += 2
-			}
+		vm.currentFrame().instructionPointer += 2
 
-		case opcode.OpSetGlobal:
-			index := int(binary.BigEndian.Uint16(instructions[instructionPointer+1:]))
+	case opcode.OpGetGlobal:
+		index := int(binary.BigEndian.Uint16(instructions[instructionPointer+1:]))
 
-			vm.globals[index] = vm.pop()
+		value := vm.globals[index]
+		if value == nil {
+			return unsetError(vm.globalNames, index)
+		}
 
-			vm.currentFrame().instructionPointer += 2
+		err := vm.push(value)
+		if err != nil {
+			return err
+		}
 
-		case opcode.OpGetGlobal:
-			index := int(binary.BigEndian.Uint16(instructions[instructionPointer+1:]))
+		vm.currentFrame().instructionPointer += 2
 
-			err := vm.push(vm.globals[index])
-			if err != nil {
-				return err
-			}
+	case opcode.OpPop:
+		vm.pop()
 
-			vm.currentFrame().instructionPointer += 2
+	case opcode.OpArray:
+		length := int(binary.BigEndian.Uint16(instructions[instructionPointer+1:]))
 
-		case opcode.OpPop:
-			vm.pop()
+		result := &object.Array{}
 
-		case opcode.OpArray:
-			length := int(binary.BigEndian.Uint16(instructions[instructionPointer+1:]))
+		for i := range length {
+			result.Elements = append(result.Elements, vm.stack[vm.stackPointer-length+i])
+		}
 
-			result := &object.Array{}
+		vm.stackPointer -= length
 
-			for i := range length {
-				result.Elements = append(result.Elements, vm.stack[vm.stackPointer-length+i])
-			}
+		err := vm.push(result)
+		if err != nil {
+			return err
+		}
+
+		vm.currentFrame().instructionPointer += 2
+
+	case opcode.OpHash:
+		length := int(binary.BigEndian.Uint16(instructions[instructionPointer+1:]))
 
-			vm.stackPointer -= length
+		result := object.NewHash()
 
-			err := vm.push(result)
-			if err != nil {
-				return err
+		for i := range length {
+			key := vm.stack[vm.stackPointer-length*2+2*i]
+			value := vm.stack[vm.stackPointer-length*2+2*i+1]
+
+			hashKey, ok := object.AsHashable(key)
+			if !ok {
+				return fmt.Errorf("unusable as hash key: %s", key.Type())
 			}
 
-			vm.currentFrame().instructionPointer += 2
+			result.Set(hashKey, value)
+		}
 
-		case opcode.OpHash:
-			length := int(binary.BigEndian.Uint16(instructions[instructionPointer+1:]))
+		vm.stackPointer -= length * 2
 
-			result := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		err := vm.push(result)
+		if err != nil {
+			return err
+		}
 
-			for i := range length {
-				key := vm.stack[vm.stackPointer-length*2+2*i]
-				value := vm.stack[vm.stackPointer-length*2+2*i+1]
+		vm.currentFrame().instructionPointer += 2
 
-				hashKey, ok := key.(object.Hashable)
-				if !ok {
-					return fmt.Errorf("INVALID HASH KEY: %s", key.Type())
-				}
+	case opcode.OpIndex:
+		index := vm.pop()
+		indexee := vm.pop()
 
-				result.Pairs[hashKey.HashKey()] = object.HashPair{
-					Key:   key,
-					Value: value,
-				}
-			}
+		err := vm.executeIndexExpression(indexee, index)
+		if err != nil {
+			return err
+		}
 
-			vm.stackPointer -= length * 2
+	case opcode.OpCall:
+		numberOfArguments := int(instructions[instructionPointer+1])
+		vm.currentFrame().instructionPointer++
 
-			err := vm.push(result)
-			if err != nil {
-				return err
-			}
+		err := vm.executeCall(numberOfArguments)
+		if err != nil {
+			return err
+		}
 
-			vm.currentFrame().instructionPointer += 2
+	case opcode.OpSetLocal:
+		index := int(instructions[instructionPointer+1])
+		vm.currentFrame().instructionPointer += 1
 
-		case opcode.OpIndex:
-			index := vm.pop()
-			indexee := vm.pop()
+		value := vm.pop()
 
-			err := vm.executeIndexExpression(indexee, index)
-			if err != nil {
-				return err
-			}
+		vm.stack[vm.currentFrame().basePointer+index] = value
 
-		case opcode.OpCall:
-			numberOfArguments := int(instructions[instructionPointer+1])
-			vm.currentFrame().instructionPointer++
+	case opcode.OpGetLocal:
+		index := int(instructions[instructionPointer+1])
+		vm.currentFrame().instructionPointer += 1
 
-			basePointer := vm.stackPointer - numberOfArguments
-			function := vm.stack[basePointer-1]
+		value := vm.stack[vm.currentFrame().basePointer+index]
+		if value == nil {
+			return unsetError(vm.currentFrame().Function().LocalNames, index)
+		}
 
-			switch callee := function.(type) {
-			case *object.Closure:
-				if numberOfArguments != callee.Function.NumberOfParameters {
-					return fmt.Errorf("wrong number of arguments %d, expected %d", numberOfArguments, callee.Function.NumberOfParameters)
-				}
+		err := vm.push(value)
+		if err != nil {
+			return err
+		}
 
-				frame := NewFrame(callee, basePointer)
-				vm.pushFrame(frame)
+	case opcode.OpReturnValue:
+		// Returning from the main program ends it, with the value as the result
+		if vm.frameIndex == 0 {
+			vm.pop()
+			vm.currentFrame().instructionPointer = len(instructions)
+			return nil
+		}
 
-				vm.stackPointer += callee.Function.NumberOfLocals
+		frame := vm.popFrame()
 
-			case *object.Builtin:
-				arguments := vm.stack[basePointer:vm.stackPointer]
+		returnValue := vm.pop()
 
-				result := callee.Fn(arguments...)
-				vm.stackPointer = basePointer - 1
+		vm.stackPointer = frame.basePointer
 
-				if result == nil {
-					vm.push(Null)
-				} else {
-					vm.push(result)
-				}
+		vm.stack[vm.stackPointer-1] = returnValue
 
-			default:
-				return fmt.Errorf("TRIED CALLING NON-FUNCTION")
-			}
+	case opcode.OpReturn:
+		frame := vm.popFrame()
 
-		case opcode.OpSetLocal:
-			index := int(instructions[instructionPointer+1])
-			vm.currentFrame().instructionPointer += 1
+		vm.stackPointer = frame.basePointer
 
-			value := vm.pop()
+		vm.stack[vm.stackPointer-1] = Null
 
-			vm.stack[vm.currentFrame().basePointer+index] = value
+	case opcode.OpGetBuiltin:
+		index := int(instructions[instructionPointer+1])
+		vm.currentFrame().instructionPointer++
 
-		case opcode.OpGetLocal:
-			index := int(instructions[instructionPointer+1])
-			vm.currentFrame().instructionPointer += 1
+		definition := object.Builtins[index]
 
-			value := vm.stack[vm.currentFrame().basePointer+index]
+		err := vm.push(definition.Builtin)
+		if err != nil {
+			return err
+		}
 
-			err := vm.push(value)
-			if err != nil {
-				return err
-			}
+	case opcode.OpMakeClosure:
+		index := binary.BigEndian.Uint16(instructions[instructionPointer+1:])
+		numberOfFreeVariables := int(instructions[instructionPointer+3])
+		vm.currentFrame().instructionPointer += 3
 
-		case opcode.OpReturnValue:
-			frame := vm.popFrame()
+		err := vm.pushClosure(int(index), numberOfFreeVariables)
+		if err != nil {
+			return err
+		}
 
-			returnValue := vm.pop()
+	case opcode.OpGetFree:
+		index := int(instructions[instructionPointer+1])
+		vm.currentFrame().instructionPointer++
 
-			vm.stackPointer = frame.basePointer
+		variable := vm.currentFrame().closure.FreeVariables[index]
+		if variable == nil {
+			return unsetError(vm.currentFrame().Function().FreeNames, index)
+		}
 
-			vm.stack[vm.stackPointer-1] = returnValue
+		err := vm.push(variable)
+		if err != nil {
+			return err
+		}
 
-		case opcode.OpReturn:
-			frame := vm.popFrame()
+	case opcode.OpRecurse:
+		currentClosure := vm.currentFrame().closure
+		err := vm.push(currentClosure)
+		if err != nil {
+			return err
+		}
 
-			vm.stackPointer = frame.basePointer
+	default:
+		return fmt.Errorf("invalid opcode %d", operation)
+	}
 
-			vm.stack[vm.stackPointer-1] = Null
+	return nil
+}
 
-		case opcode.OpGetBuiltin:
-			index := int(instructions[instructionPointer+1])
-			vm.currentFrame().instructionPointer++
+// Calls the function below the arguments on the stack. Closures get a frame
+// that later steps execute, builtins run right away.
+func (vm *VM) executeCall(numberOfArguments int) error {
+	basePointer := vm.stackPointer - numberOfArguments
+	function := vm.stack[basePointer-1]
 
-			definition := object.Builtins[index]
+	switch callee := function.(type) {
+	case *object.Closure:
+		if numberOfArguments != callee.Function.NumberOfParameters {
+			return fmt.Errorf("wrong number of arguments %d, expected %d", numberOfArguments, callee.Function.NumberOfParameters)
+		}
 
-			err := vm.push(definition.Builtin)
-			if err != nil {
-				return nil
-			}
+		if vm.frameIndex+1 >= MaxFrames {
+			return fmt.Errorf("stack overflow (%d frames)", MaxFrames)
+		}
 
-		case opcode.OpMakeClosure:
-			index := binary.BigEndian.Uint16(instructions[instructionPointer+1:])
-			numberOfFreeVariables := int(instructions[instructionPointer+3])
-			vm.currentFrame().instructionPointer += 3
+		frame := &vm.frameStore[vm.frameIndex+1]
+		*frame = Frame{closure: callee, basePointer: basePointer}
+		vm.pushFrame(frame)
 
-			err := vm.pushClosure(int(index), numberOfFreeVariables)
-			if err != nil {
-				return err
-			}
+		// Parameters are the first locals, the others start out unset
+		newStackPointer := basePointer + callee.Function.NumberOfLocals
+		if newStackPointer > StackSize {
+			return fmt.Errorf("stack overflow (size %d)", StackSize)
+		}
 
-		case opcode.OpGetFree:
-			index := int(instructions[instructionPointer+1])
-			vm.currentFrame().instructionPointer++
+		clear(vm.stack[vm.stackPointer:newStackPointer])
+		vm.stackPointer = newStackPointer
 
-			variable := vm.currentFrame().closure.FreeVariables[index]
+	case *object.Builtin:
+		arguments := vm.stack[basePointer:vm.stackPointer]
 
-			err := vm.push(variable)
-			if err != nil {
-				return err
-			}
+		// Made once, rather than allocating on every call. The context set
+		// may be shared with other VMs, so it isn't changed.
+		if vm.builtinContext == nil {
+			vm.builtinContext = vm.context.WithCall(vm.callFromBuiltin)
+		}
 
-		case opcode.OpRecurse:
-			currentClosure := vm.currentFrame().closure
-			err := vm.push(currentClosure)
-			if err != nil {
-				return err
-			}
+		result := callee.Fn(vm.builtinContext, arguments...)
+		vm.stackPointer = basePointer - 1
+
+		// Like any other runtime error, stops the program
+		if err, ok := result.(*object.Error); ok {
+			return errors.New(err.Message)
+		}
 
-		default:
-			panic(fmt.Sprintf("Invalid opcode %q", opcode.Lookup(operation).Name))
+		if result == nil {
+			vm.push(Null)
+		} else {
+			vm.push(result)
 		}
+
+	default:
+		return fmt.Errorf("not a function: %s", function.Type())
 	}
 
 	return nil
 }
 
+// Calls a function, once the program has finished. The next steps execute
+// the call, leaving its result as the stack top, and the VM finishes again
+// when it returns. Builtins are called right away.
+func (vm *VM) PushCall(function object.Object, args ...object.Object) error {
+	err := vm.push(function)
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range args {
+		err = vm.push(arg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return vm.executeCall(len(args))
+}
+
+// Calls a function and runs it until it returns. After a runtime error the
+// call stack is left as it was when it happened.
+func (vm *VM) call(function object.Object, args ...object.Object) (object.Object, error) {
+	depth := vm.frameIndex
+
+	err := vm.PushCall(function, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	step := vm.Step
+	if vm.stepper != nil {
+		step = vm.stepper
+	}
+
+	for vm.frameIndex > depth {
+		err = step()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return vm.pop(), nil
+}
+
+// For builtins calling back into the program. The program goes on after a
+// runtime error in the call, which the builtin gets instead.
+func (vm *VM) callFromBuiltin(function object.Object, args ...object.Object) (object.Object, error) {
+	frameIndex, stackPointer := vm.frameIndex, vm.stackPointer
+
+	result, err := vm.call(function, args...)
+	if err != nil {
+		vm.frameIndex, vm.stackPointer = frameIndex, stackPointer
+	}
+
+	return result, err
+}
+
+// A binding that's read before it's set, like x in let x = x, or after a let
+// in a branch that wasn't taken
+func unsetError(names []string, index int) error {
+	if index < len(names) {
+		return fmt.Errorf("identifier not found: %s", names[index])
+	}
+
+	return fmt.Errorf("identifier not found")
+}
+
 func (vm *VM) push(object object.Object) error {
 	if vm.stackPointer >= len(vm.stack) {
 		return fmt.Errorf("stack overflow (size %d)", cap(vm.stack))
@@ -389,6 +585,29 @@ func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.stackPointer-1]
 }
 
+// Everything currently on the stack, bottom first
+func (vm *VM) Stack() []object.Object {
+	return vm.stack[:vm.stackPointer]
+}
+
+// The call stack, from the main program to the innermost function
+func (vm *VM) Frames() []*Frame {
+	return vm.frames[:vm.frameIndex+1]
+}
+
+// Local bindings of a frame on the call stack, indexed like OpGetLocal.
+// Bindings that haven't been assigned yet may hold garbage.
+func (vm *VM) Locals(frame *Frame) []object.Object {
+	numberOfLocals := frame.closure.Function.NumberOfLocals
+
+	return vm.stack[frame.basePointer : frame.basePointer+numberOfLocals]
+}
+
+// Value of a global binding, nil if it hasn't been set
+func (vm *VM) Global(index int) object.Object {
+	return vm.globals[index]
+}
+
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.frameIndex]
 }
@@ -420,11 +639,25 @@ func (vm *VM) pushClosure(index int, numberOfFreeVariables int) error {
 	closure := &object.Closure{
 		Function:      converted,
 		FreeVariables: freeVariables,
+		Instructions:  vm.instructionsOf(converted),
 	}
 
 	return vm.push(closure)
 }
 
+// The VM's own copy of a function's instructions, where it quickens binary
+// operations. Bytecode may be shared with VMs running at the same time, so it
+// isn't changed.
+func (vm *VM) instructionsOf(function *object.CompiledFunction) opcode.Instructions {
+	instructions, ok := vm.instructions[function]
+	if !ok {
+		instructions = slices.Clone(function.Instructions)
+		vm.instructions[function] = instructions
+	}
+
+	return instructions
+}
+
 // For tests
 func (vm *VM) LastStackTop() object.Object {
 	return vm.stack[vm.stackPointer]
@@ -434,41 +667,75 @@ func (vm *VM) executeNegate() error {
 	operand := vm.pop()
 
 	value, ok := operand.(*object.Integer)
-
 	if !ok {
-		panic(fmt.Sprintf("Object %v not an integer", operand))
+		return fmt.Errorf("unknown operator: -%s", operand.Type())
 	}
 
-	value.Value *= -1
-
-	return vm.push(value)
+	// The operand may well be a constant, so it's never changed in place
+	return vm.push(object.NewInteger(-value.Value))
 }
 
 func (vm *VM) executeLogicalNot() error {
 	operand := vm.pop()
 
-	result := toBoolObject(!isTruthy(operand))
+	result := toBoolObject(!object.IsTruthy(operand))
 
 	return vm.push(result)
 }
 
+// Operators as written in the source, for errors
+var operators = map[opcode.OpCode]string{
+	opcode.OpAdd:         "+",
+	opcode.OpSubtract:    "-",
+	opcode.OpMultiply:    "*",
+	opcode.OpDivide:      "/",
+	opcode.OpEquals:      "==",
+	opcode.OpNotEquals:   "!=",
+	opcode.OpGreaterThan: ">",
+	opcode.OpLessThan:    "<",
+}
+
+// The two operands of a binary operation on top of the stack, if both are integers
+func (vm *VM) integerOperands() (left, right *object.Integer, ok bool) {
+	left, ok = vm.stack[vm.stackPointer-2].(*object.Integer)
+	if !ok {
+		return nil, nil, false
+	}
+
+	right, ok = vm.stack[vm.stackPointer-1].(*object.Integer)
+	return left, right, ok
+}
+
 func (vm *VM) executeBinaryOperation(operation opcode.OpCode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
-		return vm.executeBinaryOperationInteger(operation, left.(*object.Integer), right.(*object.Integer))
+	if left, ok := left.(*object.Integer); ok {
+		if right, ok := right.(*object.Integer); ok {
+			return vm.executeBinaryOperationInteger(operation, left, right)
+		}
+	}
+
+	// Everything else is compared structurally
+	switch operation {
+	case opcode.OpEquals:
+		return vm.push(toBoolObject(object.Equal(left, right)))
+
+	case opcode.OpNotEquals:
+		return vm.push(toBoolObject(!object.Equal(left, right)))
 	}
 
-	if left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ {
-		return vm.executeBinaryOperationBoolean(operation, left.(*object.Boolean), right.(*object.Boolean))
+	if left, ok := left.(*object.String); ok && operation == opcode.OpAdd {
+		if right, ok := right.(*object.String); ok {
+			return vm.push(&object.String{Value: left.Value + right.Value})
+		}
 	}
 
-	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
-		return vm.executeBinaryOperationString(operation, left.(*object.String), right.(*object.String))
+	if left.Type() != right.Type() {
+		return fmt.Errorf("type mismatch: %s %s %s", left.Type(), operators[operation], right.Type())
 	}
 
-	panic(fmt.Sprintf("Invalid operand types %T, %T", left, right))
+	return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[operation], right.Type())
 }
 
 func (vm *VM) executeBinaryOperationInteger(operation opcode.OpCode, left, right *object.Integer) error {
@@ -476,25 +743,21 @@ func (vm *VM) executeBinaryOperationInteger(operation opcode.OpCode, left, right
 
 	switch operation {
 	case opcode.OpAdd:
-		result = &object.Integer{
-			Value: left.Value + right.Value,
-		}
+		result = object.NewInteger(left.Value + right.Value)
 
 	case opcode.OpSubtract:
-		result = &object.Integer{
-			Value: left.Value - right.Value,
-		}
+		result = object.NewInteger(left.Value - right.Value)
 
 	case opcode.OpMultiply:
-		result = &object.Integer{
-			Value: left.Value * right.Value,
-		}
+		result = object.NewInteger(left.Value * right.Value)
 
 	case opcode.OpDivide:
-		result = &object.Integer{
-			Value: left.Value / right.Value,
+		if right.Value == 0 {
+			return fmt.Errorf("division by zero")
 		}
 
+		result = object.NewInteger(left.Value / right.Value)
+
 	case opcode.OpEquals:
 		result = toBoolObject(left.Value == right.Value)
 
@@ -504,47 +767,11 @@ func (vm *VM) executeBinaryOperationInteger(operation opcode.OpCode, left, right
 	case opcode.OpGreaterThan:
 		result = toBoolObject(left.Value > right.Value)
 
-	default:
-		panic(fmt.Sprintf("Invalid opcode %q", opcode.Lookup(operation).Name))
-	}
-
-	return vm.push(result)
-}
-
-func (vm *VM) executeBinaryOperationBoolean(operation opcode.OpCode, left, right *object.Boolean) error {
-	var result object.Object
-
-	switch operation {
-	case opcode.OpEquals:
-		result = toBoolObject(left.Value == right.Value)
-
-	case opcode.OpNotEquals:
-		result = toBoolObject(left.Value != right.Value)
+	case opcode.OpLessThan:
+		result = toBoolObject(left.Value < right.Value)
 
 	default:
-		panic(fmt.Sprintf("Invalid opcode %q", opcode.Lookup(operation).Name))
-	}
-
-	return vm.push(result)
-}
-
-func (vm *VM) executeBinaryOperationString(operation opcode.OpCode, left, right *object.String) error {
-	var result object.Object
-
-	switch operation {
-	case opcode.OpAdd:
-		result = &object.String{
-			Value: left.Value + right.Value,
-		}
-
-	case opcode.OpEquals:
-		result = toBoolObject(left.Value == right.Value)
-
-	case opcode.OpNotEquals:
-		result = toBoolObject(left.Value != right.Value)
-
-	default:
-		panic(fmt.Sprintf("Invalid opcode %q", opcode.Lookup(operation).Name))
+		return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[operation], right.Type())
 	}
 
 	return vm.push(result)
@@ -555,7 +782,7 @@ func (vm *VM) executeIndexExpression(indexee, index object.Object) error {
 	case *object.Array:
 		convertedIndex, ok := index.(*object.Integer)
 		if !ok {
-			return fmt.Errorf("INVALID ARRAY INDEX: %v", index)
+			return fmt.Errorf("index operator not supported: %s", indexee.Type())
 		}
 
 		if convertedIndex.Value < 0 || convertedIndex.Value >= int64(len(indexee.Elements)) {
@@ -565,43 +792,20 @@ func (vm *VM) executeIndexExpression(indexee, index object.Object) error {
 		return vm.push(indexee.Elements[convertedIndex.Value])
 
 	case *object.Hash:
-		convertedIndex, ok := index.(object.Hashable)
+		convertedIndex, ok := object.AsHashable(index)
 		if !ok {
-			return fmt.Errorf("INVALID HASH INDEX: %v", index)
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
 		}
 
-		result, ok := indexee.Pairs[convertedIndex.HashKey()]
+		result, ok := indexee.Get(convertedIndex)
 
 		if !ok {
 			return vm.push(Null)
 		}
 
-		return vm.push(result.Value)
+		return vm.push(result)
 
 	default:
-		panic(fmt.Sprintf("unexpected object.Object: %#v", indexee))
-	}
-}
-
-func isTruthy(value object.Object) bool {
-	if value == True {
-		return true
-	}
-
-	if value == False {
-		return false
-	}
-
-	if value == Null {
-		return false
+		return fmt.Errorf("index operator not supported: %s", indexee.Type())
 	}
-
-	integer, ok := value.(*object.Integer)
-	if ok {
-		// 0 is falsy, other integers are truthy
-		// Deviating from the book here, which treats everything that isn't a boolean truthy
-		return integer.Value != 0
-	}
-
-	panic(fmt.Sprintf("Object %v not booleanish", value))
 }
@@ -1,12 +1,17 @@
 package vm
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"monkey/ast"
 	"monkey/compiler"
+	"monkey/evaluator"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -66,6 +71,29 @@ func TestBooleanExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestStructuralEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`[1, [2, "a"]] == [1, [2, "a"]]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, 2] != [1, 2, 3]`, true},
+		{`[] == []`, true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{`{[1, 2]: 3} == {[1, 2]: 3}`, true},
+		{`"a" == "a"`, true},
+		{`"a" != "b"`, true},
+		{`first([]) == first([])`, true},
+		{`first([]) == 0`, false},
+		{`[1] == 1`, false},
+		{`1 != "1"`, true},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []vmTestCase{
 		{"if (true) {}", Null},
@@ -81,6 +109,10 @@ func TestConditionals(t *testing.T) {
 
 		{"if (if (true) {}) { 69 } else { 420 }", 420},
 		{"if (!if (true) {}) { 69 } else { 420 }", 69},
+
+		{`if ("") { 69 } else { 420 }`, 69},
+		{"if ([]) { 69 } else { 420 }", 69},
+		{"!{}", false},
 	}
 
 	runVmTests(t, tests)
@@ -147,6 +179,9 @@ func TestIndexExpressions(t *testing.T) {
 		{"{1: 2, 3: 4}[1]", 2},
 		{"{}[0]", Null},
 		{"{1: 2, 3: 4}[0]", Null},
+		{`{[1, [true, "a"]]: 5}[[1, [true, "a"]]]`, 5},
+		{"{[1, 2]: 5}[[2, 1]]", Null},
+		{"{[1]: 5, 1: 6}[1]", 6},
 	}
 
 	runVmTests(t, tests)
@@ -275,6 +310,21 @@ func TestCallingFunctionsWithArgumentsAndBindings(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestRuntimeErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 + true", &object.Error{Message: "type mismatch: INTEGER + BOOLEAN"}},
+		{`"a" < "b"`, &object.Error{Message: "unknown operator: STRING < STRING"}},
+		{"-true", &object.Error{Message: "unknown operator: -BOOLEAN"}},
+		{"10 / (5 - 5)", &object.Error{Message: "division by zero"}},
+		{"5(1)", &object.Error{Message: "not a function: INTEGER"}},
+		{"999[1]", &object.Error{Message: "index operator not supported: INTEGER"}},
+		{`{"a": 1}[fn(x) { x }]`, &object.Error{Message: "unusable as hash key: FUNCTION"}},
+		{`let x = len(1); 5`, &object.Error{Message: "argument to `len` not supported, got INTEGER"}},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -424,6 +474,31 @@ func TestBuiltins(t *testing.T) {
 				Message: "argument to `push` must be ARRAY, got INTEGER",
 			},
 		},
+		{`len("日本語")`, 3},
+		{`split("a b", " ")[1]`, "b"},
+		{`join(["a", "b"], repeat("-", 3))`, "a---b"},
+		{`to_string(parse_int(substring("x42", 1)) + 1)`, "43"},
+		{`replace(lower("A-B"), "-", "+")`, "a+b"},
+		{`contains(trim(" monkey "), "key") == true`, true},
+		{`ends_with("monkey", "mon")`, false},
+		{`index_of("日本語", "語")`, 2},
+		{`json_parse(json_stringify({"a": [1, 2, 3]}))["a"][2]`, 3},
+		{`to_string({"b": 1, 3: [2], true: {"z": 1, "y": 2}})`, `{b: 1, 3: [2], true: {z: 1, y: 2}}`},
+		{`keys({"b": 1, 3: 2})[1]`, 3},
+		{`values({"b": 1, "a": 2, "c": 3})`, []int{1, 2, 3}},
+		{`json_stringify({"b": 1, "a": [true, first([])]})`, `{"b":1,"a":[true,null]}`},
+		{`json_stringify(json_parse("[null]"), 1)`, "[\n null\n]"},
+		{`if (json_parse("null")) { 1 } else { 2 }`, 2},
+		{`json_parse("[1")`,
+			&object.Error{
+				Message: "invalid JSON at 1:3: unexpected end of JSON input",
+			},
+		},
+		{`parse_int("x")`,
+			&object.Error{
+				Message: `can't parse "x" as an integer`,
+			},
+		},
 	}
 
 	runVmTests(t, tests)
@@ -571,6 +646,340 @@ func TestRecursiveFibonacci(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestCallsWithoutAllocations(t *testing.T) {
+	allocations := func(n int) float64 {
+		compiler := compiler.New()
+		err := compiler.Compile(parse(fmt.Sprintf(`
+			let fibonacci = fn(x) { if (x < 2) { x } else { fibonacci(x - 1) + fibonacci(x - 2) } };
+			fibonacci(%d) == -fibonacci(%d) * -1`, n, n)))
+		if err != nil {
+			t.Fatalf("Failed to compile: %s\n", err)
+		}
+		bytecode := compiler.Bytecode()
+
+		return testing.AllocsPerRun(10, func() {
+			vm := New(bytecode)
+			err := vm.Execute()
+			if err != nil {
+				t.Fatalf("Failed to execute: %s\n", err)
+			}
+		})
+	}
+
+	// Frames are reused and the results small enough to be shared
+	if few, many := allocations(1), allocations(12); few != many {
+		t.Errorf("calls allocate, %v allocations for fibonacci(1) but %v for fibonacci(12)", few, many)
+	}
+}
+
+func TestQuickening(t *testing.T) {
+	// Operations that have seen integers still handle whatever comes next
+	runVmTests(t, []vmTestCase{
+		{`let add = fn(a, b) { a + b }; add(1, 2); add("a", "b")`, "ab"},
+		{`let add = fn(a, b) { a + b }; add("a", "b"); add(1, 2); add(3, 4)`, 7},
+		{`let add = fn(a, b) { a + b }; add(1, 2); add("a", "b"); add(3, 4)`, 7},
+		{`let less = fn(a, b) { a < b }; less(1, 2); less(2, 1)`, false},
+		{`let same = fn(a, b) { a == b }; same(1, 1); same([1], [1])`, true},
+		{`let add = fn(a, b) { a + b }; add(1, 2); add(1, true)`, &object.Error{Message: "type mismatch: INTEGER + BOOLEAN"}},
+		{`let divide = fn(a, b) { a / b }; divide(4, 2); divide(4, 0)`, &object.Error{Message: "division by zero"}},
+	})
+
+	bytecode := compileForTest(t, `let add = fn(a, b) { a * b + 1 }; add(2, 3); add`)
+	var function *object.CompiledFunction
+	for _, constant := range bytecode.Constants {
+		if compiled, ok := constant.(*object.CompiledFunction); ok {
+			function = compiled
+		}
+	}
+	compiled := function.Instructions.String()
+
+	vm := New(bytecode)
+	err := vm.Execute()
+	if err != nil {
+		t.Fatalf("Failed to execute: %s\n", err)
+	}
+
+	executed := vm.LastStackTop().(*object.Closure).Instructions.String()
+	if !strings.Contains(executed, "OpMultiplyInt") || !strings.Contains(executed, "OpAddInt") {
+		t.Errorf("operations weren't quickened:\n%s", executed)
+	}
+
+	// Only the VM's own copy is rewritten, the bytecode may be shared
+	if function.Instructions.String() != compiled {
+		t.Errorf("bytecode changed to:\n%s", function.Instructions.String())
+	}
+}
+
+func TestMacros(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`
+			let unless = macro(condition, consequence, alternative) {
+				quote(if (!(unquote(condition))) { unquote(consequence) } else { unquote(alternative) });
+			};
+			unless(10 > 5, 1, 2);
+			`,
+			2,
+		},
+		{
+			`
+			let swap = macro(a, b) { quote([unquote(b), unquote(a)]) };
+			let x = 1;
+			swap(x, x + 1)[0] + swap(3, 4)[1]
+			`,
+			5,
+		},
+		{
+			`
+			let double = macro(x) { quote(unquote(x) * 2) };
+			double(double(3))
+			`,
+			12,
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		macroEnv := object.NewEnvironment()
+		evaluator.DefineMacros(program, macroEnv)
+		expanded, err := evaluator.ExpandMacros(program, macroEnv)
+		if err != nil {
+			t.Fatalf("Failed to expand macros: %s\n", err)
+		}
+
+		compiler := compiler.New()
+		err = compiler.Compile(expanded)
+		if err != nil {
+			t.Fatalf("Failed to compile: %s\n", err)
+		}
+
+		vm := New(compiler.Bytecode())
+		err = vm.Execute()
+		if err != nil {
+			t.Fatalf("Failed to execute: %s\n", err)
+		}
+
+		testExpectedObject(t, tt.expected, vm.LastStackTop())
+	}
+}
+
+func TestModules(t *testing.T) {
+	files := map[string]string{
+		"lib/math.mk": `
+			let square = fn(x) { x * x };
+			export let pi = 3;
+			export let cube = fn(x) { x * square(x) };`,
+		"lib/geometry.mk": `
+			import "math.mk" as math;
+			export let area = fn(r) { math.pi * r * r };`,
+		"lib/counter.mk": `
+			let count = fn(n) { if (n > 0) { count(n - 1) } else { n } };
+			export let zero = count(10);`,
+	}
+
+	tests := []vmTestCase{
+		{`import "lib/math.mk" as math; math.cube(2) + math.pi`, 11},
+		{`let square = 5; import "lib/math.mk" as m; square + m.cube(2)`, 13},
+		{`import "lib/geometry.mk" as g; import "lib/math.mk" as m; g.area(2) + m.pi`, 15},
+		{`import "lib/math.mk" as m; let f = fn() { fn() { m.pi } }; f()()`, 3},
+		{`import "lib/counter.mk" as c; import "lib/counter.mk" as d; c.zero + d.zero`, 0},
+	}
+
+	for _, tt := range tests {
+		compiler := compiler.New()
+		compiler.Loader.ReadFile = func(name string) ([]byte, error) {
+			source, ok := files[name]
+			if !ok {
+				return nil, fmt.Errorf("open %s: no such file", name)
+			}
+			return []byte(source), nil
+		}
+
+		err := compiler.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("Failed to compile: %s\n", err)
+		}
+
+		vm := New(compiler.Bytecode())
+		err = vm.Execute()
+		if err != nil {
+			t.Fatalf("Failed to execute: %s\n", err)
+		}
+
+		testExpectedObject(t, tt.expected, vm.LastStackTop())
+	}
+}
+
+func TestContext(t *testing.T) {
+	input := `
+		let greet = fn(name) { puts("hello " + name); name };
+		greet(read_line());
+		greet(read_line());`
+
+	// Every VM writes to and reads from its own context, even when they run at once
+	outputs := make([]bytes.Buffer, 8)
+
+	var wait sync.WaitGroup
+	for i := range outputs {
+		compiler := compiler.New()
+		err := compiler.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("Failed to compile: %s\n", err)
+		}
+
+		vm := New(compiler.Bytecode())
+		vm.SetContext(&object.Context{
+			Stdout: &outputs[i],
+			Stdin:  strings.NewReader(fmt.Sprintf("a%d\nb%d\n", i, i)),
+		})
+
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+
+			err := vm.Execute()
+			if err != nil {
+				t.Errorf("Failed to execute: %s\n", err)
+			}
+		}()
+	}
+	wait.Wait()
+
+	for i, output := range outputs {
+		expected := fmt.Sprintf("hello a%d\nhello b%d\n", i, i)
+		if output.String() != expected {
+			t.Errorf("wrong output from VM %d. expected=%q, got=%q", i, expected, output.String())
+		}
+	}
+}
+
+func TestConcurrentVMsShareContext(t *testing.T) {
+	compiler := compiler.New()
+	err := compiler.Compile(parse(`
+		let fail = fn() { 1 / 0 };
+		puts(assert_error(fail), assert_error(fail))`))
+	if err != nil {
+		t.Fatalf("Failed to compile: %s\n", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	// Builtins calling back into each VM don't go through the shared context
+	context := &object.Context{Stdout: io.Discard}
+
+	var wait sync.WaitGroup
+	for range 8 {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+
+			vm := New(bytecode)
+			vm.SetContext(context)
+
+			err := vm.Execute()
+			if err != nil {
+				t.Errorf("Failed to execute: %s\n", err)
+			}
+		}()
+	}
+	wait.Wait()
+
+	if context.Call != nil {
+		t.Errorf("the shared context was changed")
+	}
+}
+
+func TestPushCall(t *testing.T) {
+	compiler := compiler.New()
+	err := compiler.Compile(parse(`let offset = 10; let add = fn(a, b) { a + b + offset };`))
+	if err != nil {
+		t.Fatalf("Failed to compile: %s\n", err)
+	}
+
+	vm := New(compiler.Bytecode())
+	err = vm.Execute()
+	if err != nil {
+		t.Fatalf("Failed to execute: %s\n", err)
+	}
+
+	// The VM finishes again once the call returns
+	err = vm.PushCall(vm.Global(1), &object.Integer{Value: 1}, &object.Integer{Value: 2})
+	if err != nil {
+		t.Fatalf("Failed to call: %s\n", err)
+	}
+
+	if vm.Finished() {
+		t.Fatalf("finished before executing the call")
+	}
+
+	err = vm.Execute()
+	if err != nil {
+		t.Fatalf("Failed to execute the call: %s\n", err)
+	}
+
+	testExpectedObject(t, 13, vm.StackTop())
+
+	// Builtins are called right away
+	err = vm.PushCall(object.GetBuiltinByName("len"), &object.String{Value: "abc"})
+	if err != nil {
+		t.Fatalf("Failed to call: %s\n", err)
+	}
+
+	if !vm.Finished() {
+		t.Fatalf("builtin call left instructions to execute")
+	}
+
+	testExpectedObject(t, 3, vm.StackTop())
+
+	err = vm.PushCall(vm.Global(1))
+	if err == nil || err.Error() != "wrong number of arguments 0, expected 2" {
+		t.Fatalf("wrong error, got %v", err)
+	}
+}
+
+func TestBuiltinsCallingFunctions(t *testing.T) {
+	input := `
+		let fail = fn(depth) { if (depth == 0) { 1 / 0 } else { fail(depth - 1) } };
+		let message = assert_error(fn() { fail(20) });
+		[message, 1 + 2]`
+
+	compiler := compiler.New()
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("Failed to compile: %s\n", err)
+	}
+
+	vm := New(compiler.Bytecode())
+
+	steps := 0
+	vm.SetStepper(func() error {
+		steps++
+		return vm.Step()
+	})
+
+	err = vm.Execute()
+	if err != nil {
+		t.Fatalf("Failed to execute: %s\n", err)
+	}
+
+	// The program goes on where the builtin was called, with the frames and
+	// stack of the failed call gone
+	result, ok := vm.LastStackTop().(*object.Array)
+	if !ok || len(result.Elements) != 2 {
+		t.Fatalf("wrong result %s", vm.LastStackTop().Inspect())
+	}
+	testExpectedObject(t, "division by zero", result.Elements[0])
+	testExpectedObject(t, 3, result.Elements[1])
+
+	if len(vm.Frames()) != 1 || vm.stackPointer != 0 {
+		t.Errorf("call left %d frames and %d values behind", len(vm.Frames()), vm.stackPointer)
+	}
+
+	if steps == 0 {
+		t.Errorf("the stepper didn't execute the call")
+	}
+}
+
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	for _, test := range tests {
 		program := parse(test.input)
@@ -585,6 +994,20 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 		vm := New(compiler.Bytecode())
 
 		err = vm.Execute()
+
+		// Runtime errors stop the program
+		if expected, ok := test.expected.(*object.Error); ok {
+			if err == nil {
+				t.Fatalf("%q didn't fail, expected %q", test.input, expected.Message)
+			}
+
+			if err.Error() != expected.Message {
+				t.Fatalf("wrong error for %q. expected=%q, got=%q", test.input, expected.Message, err)
+			}
+
+			continue
+		}
+
 		if err != nil {
 			t.Fatalf("Failed to execute: %s\n", err)
 		}
@@ -645,19 +1068,19 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 			t.Fatalf("Object %v not hash but %T", actual, actual)
 		}
 
-		if len(hash.Pairs) != len(expected) {
-			t.Errorf("Wrong number of elements %d, expected %d", len(hash.Pairs), len(expected))
+		if hash.Len() != len(expected) {
+			t.Errorf("Wrong number of elements %d, expected %d", hash.Len(), len(expected))
 		}
 
 		for key, value := range expected {
-			pair, ok := hash.Pairs[(&object.Integer{Value: int64(key)}).HashKey()]
+			actual, ok := hash.Get(&object.Integer{Value: int64(key)})
 
 			if !ok {
 				t.Errorf("Key %v not found in hash", key)
 				continue
 			}
 
-			err := testIntegerObject(int64(value), pair.Value)
+			err := testIntegerObject(int64(value), actual)
 			if err != nil {
 				t.Errorf("testIntegerObject failed: %s\n", err)
 			}